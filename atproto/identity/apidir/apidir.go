@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -24,6 +25,7 @@ type APIDirectory struct {
 }
 
 var _ identity.Directory = (*APIDirectory)(nil)
+var _ identity.BatchDirectory = (*APIDirectory)(nil)
 var _ identity.Resolver = (*APIDirectory)(nil)
 
 type identityBody struct {
@@ -222,3 +224,134 @@ func (dir *APIDirectory) Purge(ctx context.Context, atid syntax.AtIdentifier) er
 
 	return nil
 }
+
+// maximum number of identifiers per batch request to the identity server
+const batchMaxIdentifiers = 500
+
+type batchRequestBody struct {
+	Identifiers []string `json:"identifiers"`
+}
+
+type batchResultBody struct {
+	Identifier string        `json:"identifier"`
+	Identity   *identityBody `json:"identity,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Message    string        `json:"message,omitempty"`
+}
+
+type batchResponseBody struct {
+	Results []batchResultBody `json:"results"`
+}
+
+// maps error names from the identity server back to identity package errors
+func batchError(name, msg string) error {
+	var base error
+	switch name {
+	case "HandleNotFound":
+		base = identity.ErrHandleNotFound
+	case "DidNotFound":
+		base = identity.ErrDIDNotFound
+	case "HandleMismatch":
+		base = identity.ErrHandleMismatch
+	case "HandleResolutionFailed":
+		base = identity.ErrHandleResolutionFailed
+	default:
+		base = identity.ErrDIDResolutionFailed
+	}
+	if msg == "" {
+		return fmt.Errorf("%w: %s", base, name)
+	}
+	return fmt.Errorf("%w: %s: %s", base, name, msg)
+}
+
+// Looks up many identities using the identity server's batch endpoint (`POST /batch/resolveIdentity`), in chunks. If the server doesn't support batch requests, falls back to individual lookups.
+func (dir *APIDirectory) BatchLookup(ctx context.Context, atids []syntax.AtIdentifier) map[syntax.AtIdentifier]identity.BatchLookupResult {
+	out := make(map[syntax.AtIdentifier]identity.BatchLookupResult, len(atids))
+
+	// normalize and de-duplicate
+	pending := make([]syntax.AtIdentifier, 0, len(atids))
+	for _, atid := range atids {
+		if h, err := atid.AsHandle(); err == nil {
+			atid = h.Normalize().AtIdentifier()
+		}
+		if _, ok := out[atid]; ok {
+			continue
+		}
+		out[atid] = identity.BatchLookupResult{}
+		pending = append(pending, atid)
+	}
+
+	for len(pending) > 0 {
+		n := min(len(pending), batchMaxIdentifiers)
+		chunk := pending[:n]
+		pending = pending[n:]
+
+		err := dir.batchLookupChunk(ctx, chunk, out)
+		if errors.Is(err, errBatchNotSupported) {
+			for k, v := range identity.LookupEach(ctx, dir, append(chunk, pending...), identity.DefaultBatchConcurrency) {
+				out[k] = v
+			}
+			return out
+		}
+		if err != nil {
+			for _, atid := range chunk {
+				out[atid] = identity.BatchLookupResult{Err: err}
+			}
+		}
+	}
+	return out
+}
+
+var errBatchNotSupported = errors.New("identity service does not support batch requests")
+
+func (dir *APIDirectory) batchLookupChunk(ctx context.Context, chunk []syntax.AtIdentifier, out map[syntax.AtIdentifier]identity.BatchLookupResult) error {
+	input := batchRequestBody{Identifiers: make([]string, len(chunk))}
+	for i, atid := range chunk {
+		input.Identifiers[i] = atid.String()
+	}
+	reqBody, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	var body batchResponseBody
+	u := dir.Host + "/batch/resolveIdentity"
+
+	start := time.Now()
+	if err := dir.apiPost(ctx, u, reqBody, &body, identity.ErrDIDResolutionFailed, errBatchNotSupported); err != nil {
+		return err
+	}
+	// match results by identifier (not position), and treat any missing results as errors
+	pending := make(map[string]syntax.AtIdentifier, len(chunk))
+	for _, atid := range chunk {
+		pending[atid.String()] = atid
+	}
+	for _, res := range body.Results {
+		atid, ok := pending[res.Identifier]
+		if !ok {
+			continue
+		}
+		delete(pending, res.Identifier)
+		if res.Error != "" || res.Identity == nil {
+			identityResolution.WithLabelValues("apidir", "error").Inc()
+			out[atid] = identity.BatchLookupResult{Err: batchError(res.Error, res.Message)}
+			continue
+		}
+		var doc identity.DIDDocument
+		if err := json.Unmarshal(res.Identity.DIDDoc, &doc); err != nil {
+			identityResolution.WithLabelValues("apidir", "error").Inc()
+			out[atid] = identity.BatchLookupResult{Err: fmt.Errorf("%w: JSON DID document parse: %w", identity.ErrDIDResolutionFailed, err)}
+			continue
+		}
+		ident := identity.ParseIdentity(&doc)
+		ident.Handle = res.Identity.Handle
+		identityResolution.WithLabelValues("apidir", "success").Inc()
+		out[atid] = identity.BatchLookupResult{Identity: &ident}
+	}
+	for _, atid := range pending {
+		identityResolution.WithLabelValues("apidir", "error").Inc()
+		out[atid] = identity.BatchLookupResult{Err: fmt.Errorf("%w: identity service batch response missing result for %s", identity.ErrDIDResolutionFailed, atid)}
+	}
+	identityResolutionDuration.WithLabelValues("apidir", "batch").Observe(time.Since(start).Seconds())
+	return nil
+}
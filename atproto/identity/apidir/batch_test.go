@@ -0,0 +1,76 @@
+package apidir
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchLookup(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/batch/resolveIdentity" {
+			http.NotFound(w, r)
+			return
+		}
+		requests++
+		var req batchRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := batchResponseBody{}
+		// results in reverse order, and skipping one identifier
+		for i := len(req.Identifiers) - 1; i >= 0; i-- {
+			raw := req.Identifiers[i]
+			if raw == "did:plc:abc333" {
+				continue
+			}
+			res := batchResultBody{Identifier: raw}
+			switch raw {
+			case "did:plc:abc111":
+				res.Identity = &identityBody{
+					DID:    syntax.DID("did:plc:abc111"),
+					Handle: syntax.Handle("alice.example.com"),
+					DIDDoc: json.RawMessage(`{"id":"did:plc:abc111","alsoKnownAs":["at://alice.example.com"]}`),
+				}
+			case "bob.example.com":
+				res.Error = "HandleNotFound"
+				res.Message = "no such handle"
+			default:
+				res.Error = "DidNotFound"
+			}
+			resp.Results = append(resp.Results, res)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	dir := NewAPIDirectory(srv.URL)
+	res := dir.BatchLookup(ctx, []syntax.AtIdentifier{
+		syntax.DID("did:plc:abc111").AtIdentifier(),
+		syntax.Handle("Bob.example.com").AtIdentifier(),
+		syntax.DID("did:plc:abc222").AtIdentifier(),
+		syntax.DID("did:plc:abc111").AtIdentifier(),
+		syntax.DID("did:plc:abc333").AtIdentifier(),
+	})
+	assert.Equal(1, requests)
+	assert.Equal(4, len(res))
+
+	r := res[syntax.DID("did:plc:abc111").AtIdentifier()]
+	assert.NoError(r.Err)
+	assert.Equal(syntax.Handle("alice.example.com"), r.Identity.Handle)
+	assert.ErrorIs(res[syntax.Handle("bob.example.com").AtIdentifier()].Err, identity.ErrHandleNotFound)
+	assert.ErrorIs(res[syntax.DID("did:plc:abc222").AtIdentifier()].Err, identity.ErrDIDNotFound)
+	assert.ErrorIs(res[syntax.DID("did:plc:abc333").AtIdentifier()].Err, identity.ErrDIDResolutionFailed)
+}
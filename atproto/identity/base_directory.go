@@ -17,12 +17,16 @@ type BaseDirectory struct {
 	PLCURL string
 	// If not nil, this limiter will be used to rate-limit requests to the PLCURL
 	PLCLimiter *rate.Limiter
+	// If not nil, did:plc resolution fetches the full operation audit log (instead of the current DID document), and this function verifies the log and derives the DID document from it. Resolution fails if the log does not verify. See the `identity/plc` package for an implementation (`plc.VerifyAuditLogJSON`)
+	PLCLogVerifier func(did syntax.DID, auditLog []byte) (*DIDDocument, error)
 	// If not nil, this function will be called inline with DID Web lookups, and can be used to limit the number of requests to a given hostname
 	DIDWebLimitFunc func(ctx context.Context, hostname string) error
 	// HTTP client used for did:web, did:plc, and HTTP (well-known) handle resolution
 	HTTPClient http.Client
 	// DNS resolver used for DNS handle resolution. Calling code can use a custom Dialer to query against a specific DNS server, or re-implement the interface for even more control over the resolution process
 	Resolver net.Resolver
+	// If not nil, used for DNS handle resolution instead of `Resolver`. See `DoHTransport` (DNS-over-HTTPS) and `MockDNSTransport` (for tests)
+	DNSTransport DNSTransport
 	// when doing DNS handle resolution, should this resolver attempt re-try against an authoritative nameserver if the first TXT lookup fails?
 	TryAuthoritativeDNS bool
 	// set of handle domain suffixes for for which DNS handle resolution will be skipped
@@ -0,0 +1,86 @@
+package identity
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Result of looking up a single identifier, as part of a batch
+type BatchLookupResult struct {
+	Identity *Identity
+	Err      error
+}
+
+// Optional interface for Directory implementations which can look up many identities at once, more efficiently than individual calls to `Lookup` (eg, with a single network request, or with concurrency control).
+type BatchDirectory interface {
+	Directory
+
+	// Looks up all the identifiers, and returns a result (identity or error) for every distinct identifier. Handles are normalized in the returned map keys.
+	BatchLookup(ctx context.Context, atids []syntax.AtIdentifier) map[syntax.AtIdentifier]BatchLookupResult
+}
+
+// Default number of concurrent lookups used by BatchLookup, for directories which don't implement BatchDirectory
+var DefaultBatchConcurrency = 16
+
+// Looks up many identities at once, returning a result (identity or error) for every distinct identifier. Handles are normalized in the returned map keys.
+//
+// If the directory implements BatchDirectory, that is used. Otherwise identifiers are looked up individually, with DefaultBatchConcurrency concurrent lookups.
+func BatchLookup(ctx context.Context, dir Directory, atids []syntax.AtIdentifier) map[syntax.AtIdentifier]BatchLookupResult {
+	if bd, ok := dir.(BatchDirectory); ok {
+		return bd.BatchLookup(ctx, atids)
+	}
+	return LookupEach(ctx, dir, atids, DefaultBatchConcurrency)
+}
+
+// normalizes and de-duplicates identifiers
+func uniqueIdentifiers(atids []syntax.AtIdentifier) []syntax.AtIdentifier {
+	seen := make(map[syntax.AtIdentifier]bool, len(atids))
+	out := make([]syntax.AtIdentifier, 0, len(atids))
+	for _, atid := range atids {
+		if h, err := atid.AsHandle(); err == nil {
+			atid = h.Normalize().AtIdentifier()
+		}
+		if seen[atid] {
+			continue
+		}
+		seen[atid] = true
+		out = append(out, atid)
+	}
+	return out
+}
+
+// Looks up each identifier individually (with `Lookup`), with up to `concurrency` lookups in flight at a time. This is the fallback implementation of BatchLookup, and can be used by BatchDirectory implementations.
+func LookupEach(ctx context.Context, dir Directory, atids []syntax.AtIdentifier, concurrency int) map[syntax.AtIdentifier]BatchLookupResult {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	atids = uniqueIdentifiers(atids)
+	out := make(map[syntax.AtIdentifier]BatchLookupResult, len(atids))
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for _, atid := range atids {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			mtx.Lock()
+			out[atid] = BatchLookupResult{Err: ctx.Err()}
+			mtx.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(atid syntax.AtIdentifier) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ident, err := dir.Lookup(ctx, atid)
+			mtx.Lock()
+			out[atid] = BatchLookupResult{Identity: ident, Err: err}
+			mtx.Unlock()
+		}(atid)
+	}
+	wg.Wait()
+	return out
+}
@@ -0,0 +1,150 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wraps MockDirectory, with a delay on every lookup or resolution, and tracking of call counts and concurrency (by host)
+type slowDirectory struct {
+	MockDirectory
+	delay time.Duration
+
+	calls     atomic.Int64
+	lk        sync.Mutex
+	hostCalls map[string]int
+	active    map[string]int
+	maxActive map[string]int
+}
+
+func (d *slowDirectory) track(atid syntax.AtIdentifier) func() {
+	d.calls.Add(1)
+	host := lookupHost(atid)
+	d.lk.Lock()
+	d.hostCalls[host]++
+	d.active[host]++
+	if d.active[host] > d.maxActive[host] {
+		d.maxActive[host] = d.active[host]
+	}
+	d.lk.Unlock()
+	time.Sleep(d.delay)
+	return func() {
+		d.lk.Lock()
+		d.active[host]--
+		d.lk.Unlock()
+	}
+}
+
+func (d *slowDirectory) Lookup(ctx context.Context, atid syntax.AtIdentifier) (*Identity, error) {
+	defer d.track(atid)()
+	return d.MockDirectory.Lookup(ctx, atid)
+}
+
+func (d *slowDirectory) ResolveHandle(ctx context.Context, h syntax.Handle) (syntax.DID, error) {
+	defer d.track(h.AtIdentifier())()
+	return d.MockDirectory.ResolveHandle(ctx, h)
+}
+
+func (d *slowDirectory) ResolveDIDRaw(ctx context.Context, did syntax.DID) (json.RawMessage, error) {
+	defer d.track(did.AtIdentifier())()
+	return d.MockDirectory.ResolveDIDRaw(ctx, did)
+}
+
+func TestLookupHost(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("did:plc", lookupHost(syntax.DID("did:plc:abc111").AtIdentifier()))
+	assert.Equal("did:web:example.com", lookupHost(syntax.DID("did:web:Example.com").AtIdentifier()))
+	assert.Equal("did:web:example.com", lookupHost(syntax.DID("did:web:example.com%3A8080").AtIdentifier()))
+	assert.Equal("handle:bsky.social", lookupHost(syntax.Handle("Alice.bsky.social").AtIdentifier()))
+	assert.Equal("handle:example.com", lookupHost(syntax.Handle("example.com").AtIdentifier()))
+}
+
+func TestBatchLookup(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	inner := slowDirectory{
+		MockDirectory: NewMockDirectory(),
+		delay:         20 * time.Millisecond,
+		hostCalls:     make(map[string]int),
+		active:        make(map[string]int),
+		maxActive:     make(map[string]int),
+	}
+	inner.Insert(Identity{DID: syntax.DID("did:plc:abc111"), Handle: syntax.Handle("alice.example.com"), AlsoKnownAs: []string{"at://alice.example.com"}})
+	inner.Insert(Identity{DID: syntax.DID("did:plc:abc222"), Handle: syntax.Handle("bob.example.com"), AlsoKnownAs: []string{"at://bob.example.com"}})
+	inner.Insert(Identity{DID: syntax.DID("did:web:example.com"), Handle: syntax.Handle("example.com"), AlsoKnownAs: []string{"at://example.com"}})
+
+	dir := NewThrottledDirectory(&inner, 0, 0)
+	dir.PLCConcurrency = 1
+
+	atids := []syntax.AtIdentifier{
+		syntax.DID("did:plc:abc111").AtIdentifier(),
+		syntax.DID("did:plc:abc222").AtIdentifier(),
+		syntax.DID("did:plc:abc111").AtIdentifier(),
+		syntax.DID("did:plc:abc333").AtIdentifier(),
+		syntax.DID("did:web:example.com").AtIdentifier(),
+		syntax.Handle("Alice.Example.com").AtIdentifier(),
+		syntax.Handle("alice.example.com").AtIdentifier(),
+	}
+	res := BatchLookup(ctx, dir, atids)
+	assert.Equal(5, len(res))
+	// the handle lookup is a handle resolution (limited as the handle's host) and a DID resolution (limited as the PLC directory)
+	assert.Equal(int64(6), inner.calls.Load())
+	assert.Equal(1, inner.hostCalls["handle:example.com"])
+	assert.Equal(4, inner.hostCalls["did:plc"])
+	assert.Equal(1, inner.maxActive["did:plc"])
+
+	r := res[syntax.DID("did:plc:abc111").AtIdentifier()]
+	assert.NoError(r.Err)
+	assert.Equal(syntax.Handle("alice.example.com"), r.Identity.Handle)
+	r = res[syntax.Handle("alice.example.com").AtIdentifier()]
+	assert.NoError(r.Err)
+	assert.Equal(syntax.DID("did:plc:abc111"), r.Identity.DID)
+	r = res[syntax.DID("did:plc:abc333").AtIdentifier()]
+	assert.ErrorIs(r.Err, ErrDIDNotFound)
+	assert.Nil(r.Identity)
+
+	// concurrent lookups of the same identifier are coalesced
+	inner.calls.Store(0)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := dir.LookupDID(ctx, syntax.DID("did:web:example.com"))
+			assert.NoError(err)
+		}()
+	}
+	wg.Wait()
+	assert.Less(inner.calls.Load(), int64(10))
+
+	// handle lookups check the handle against the DID document
+	inner.Handles[syntax.Handle("eve.example.com")] = syntax.DID("did:plc:abc111")
+	_, err := dir.LookupHandle(ctx, syntax.Handle("eve.example.com"))
+	assert.ErrorIs(err, ErrHandleMismatch)
+
+	// low-level resolution passes through to the inner directory
+	did, err := dir.ResolveHandle(ctx, syntax.Handle("Bob.Example.com"))
+	assert.NoError(err)
+	assert.Equal(syntax.DID("did:plc:abc222"), did)
+	doc, err := dir.ResolveDID(ctx, syntax.DID("did:plc:abc111"))
+	assert.NoError(err)
+	assert.Equal(syntax.DID("did:plc:abc111"), doc.DID)
+	_, err = dir.ResolveDIDRaw(ctx, syntax.DID("did:plc:abc333"))
+	assert.ErrorIs(err, ErrDIDNotFound)
+
+	// fallback for directories without batch support
+	inner.calls.Store(0)
+	res = BatchLookup(ctx, &inner, atids)
+	assert.Equal(5, len(res))
+	assert.Equal(int64(5), inner.calls.Load())
+}
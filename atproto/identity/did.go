@@ -143,7 +143,11 @@ func (d *BaseDirectory) resolveDIDPLC(ctx context.Context, did syntax.DID) ([]by
 		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", plcURL+"/"+did.String(), nil)
+	reqURL := plcURL + "/" + did.String()
+	if d.PLCLogVerifier != nil {
+		reqURL += "/log/audit"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("constructing HTTP request for did:plc resolution: %w", err)
 	}
@@ -165,5 +169,17 @@ func (d *BaseDirectory) resolveDIDPLC(ctx context.Context, did syntax.DID) ([]by
 		return nil, fmt.Errorf("%w: PLC directory status %d", ErrDIDResolutionFailed, resp.StatusCode)
 	}
 
-	return io.ReadAll(resp.Body)
+	b, err := io.ReadAll(resp.Body)
+	if err != nil || d.PLCLogVerifier == nil {
+		return b, err
+	}
+
+	// derive the DID document locally from the verified operation log
+	doc, err := d.PLCLogVerifier(did, b)
+	if errors.Is(err, ErrDIDNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: PLC operation log verification: %w", ErrDIDResolutionFailed, err)
+	}
+	return json.Marshal(doc)
 }
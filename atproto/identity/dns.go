@@ -0,0 +1,96 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Indicates that a DNS name does not exist (NXDOMAIN), or has no records of the requested type. Returned (wrapped) by DNSTransport implementations.
+var ErrDNSNotFound = errors.New("DNS name not found")
+
+// Low-level DNS lookups used for handle resolution. This makes it possible to control how DNS queries are made (eg, DNS-over-HTTPS), or to mock DNS in tests.
+//
+// Implementations should return an error wrapping ErrDNSNotFound if the name does not exist or has no records of the requested type.
+type DNSTransport interface {
+	// Returns the TXT records for the name. Multiple strings in a single record are concatenated.
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	// Returns the hostnames of the nameservers for the name
+	LookupNS(ctx context.Context, name string) ([]string, error)
+}
+
+// Optional interface for DNSTransport implementations which can send queries directly to a specific nameserver. This is required for authoritative and fallback DNS handle resolution; those steps are skipped for transports which do not implement it.
+type DNSNameserverTransport interface {
+	// Like `LookupTXT`, but queries the given nameserver ("host:port") directly
+	LookupTXTAt(ctx context.Context, nameserver, name string) ([]string, error)
+}
+
+// DNSTransport implementation using the Go standard library resolver (`net.Resolver`). This is what `BaseDirectory` uses if no transport is configured.
+type NetDNSTransport struct {
+	Resolver *net.Resolver
+	// Timeout for dialing nameservers directly (LookupTXTAt)
+	DialTimeout time.Duration
+}
+
+var _ DNSTransport = (*NetDNSTransport)(nil)
+var _ DNSNameserverTransport = (*NetDNSTransport)(nil)
+
+func netDNSErr(err error) error {
+	// check for NXDOMAIN
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return fmt.Errorf("%w: %w", ErrDNSNotFound, err)
+	}
+	return err
+}
+
+func (t *NetDNSTransport) resolver() *net.Resolver {
+	if t.Resolver != nil {
+		return t.Resolver
+	}
+	return net.DefaultResolver
+}
+
+func (t *NetDNSTransport) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	res, err := t.resolver().LookupTXT(ctx, name)
+	if err != nil {
+		return nil, netDNSErr(err)
+	}
+	return res, nil
+}
+
+func (t *NetDNSTransport) LookupNS(ctx context.Context, name string) ([]string, error) {
+	res, err := t.resolver().LookupNS(ctx, name)
+	if err != nil {
+		return nil, netDNSErr(err)
+	}
+	out := make([]string, len(res))
+	for i, ns := range res {
+		out[i] = ns.Host
+	}
+	return out, nil
+}
+
+func (t *NetDNSTransport) LookupTXTAt(ctx context.Context, nameserver, name string) ([]string, error) {
+	timeout := t.DialTimeout
+	if timeout == 0 {
+		timeout = time.Second * 5
+	}
+	// create a custom resolver to use the specific nameserver for TXT lookup
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			rd := net.Dialer{
+				Timeout: timeout,
+			}
+			return rd.DialContext(ctx, network, nameserver)
+		},
+	}
+	res, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return nil, netDNSErr(err)
+	}
+	return res, nil
+}
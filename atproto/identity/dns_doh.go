@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSTransport implementation using DNS-over-HTTPS (RFC 8484, "wire format" GET requests). For example, "https://cloudflare-dns.com/dns-query" or "https://dns.google/dns-query".
+//
+// DoH resolvers are recursive, so this transport does not support direct nameserver queries (authoritative or fallback DNS handle resolution).
+type DoHTransport struct {
+	// full URL of the DoH endpoint, including path
+	URL        string
+	HTTPClient http.Client
+	// User-Agent header for HTTP requests. Optional (ignored if empty string).
+	UserAgent string
+}
+
+var _ DNSTransport = (*DoHTransport)(nil)
+
+// upper limit on DoH response size; DNS messages are at most 64 KByte
+const dohMaxResponseSize = 65535
+
+func (t *DoHTransport) query(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	if !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS name: %w", err)
+	}
+	// ID is zero, as recommended for DoH (for HTTP cache friendliness)
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	q, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("encoding DNS query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", t.URL+"?dns="+base64.RawURLEncoding.EncodeToString(q), nil)
+	if err != nil {
+		return nil, fmt.Errorf("constructing DoH request: %w", err)
+	}
+	req.Header.Set("Accept", "application/dns-message")
+	if t.UserAgent != "" {
+		req.Header.Set("User-Agent", t.UserAgent)
+	}
+	resp, err := t.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DoH request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("DoH request failed: HTTP status %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("DoH response read failed: %w", err)
+	}
+
+	var answer dnsmessage.Message
+	if err := answer.Unpack(b); err != nil {
+		return nil, fmt.Errorf("invalid DoH response: %w", err)
+	}
+	switch answer.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, fmt.Errorf("%w: %s (NXDOMAIN)", ErrDNSNotFound, name)
+	default:
+		return nil, fmt.Errorf("DNS query failed: %s", answer.RCode)
+	}
+	// NOTE: answers may include a CNAME chain; only records of the requested type are returned
+	out := []dnsmessage.Resource{}
+	for _, rr := range answer.Answers {
+		if rr.Header.Type == qtype {
+			out = append(out, rr)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: no %s records for %s", ErrDNSNotFound, qtype, name)
+	}
+	return out, nil
+}
+
+func (t *DoHTransport) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	answers, err := t.query(ctx, name, dnsmessage.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	out := []string{}
+	for _, rr := range answers {
+		if txt, ok := rr.Body.(*dnsmessage.TXTResource); ok {
+			out = append(out, strings.Join(txt.TXT, ""))
+		}
+	}
+	return out, nil
+}
+
+func (t *DoHTransport) LookupNS(ctx context.Context, name string) ([]string, error) {
+	answers, err := t.query(ctx, name, dnsmessage.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	out := []string{}
+	for _, rr := range answers {
+		if ns, ok := rr.Body.(*dnsmessage.NSResource); ok {
+			out = append(out, ns.NS.String())
+		}
+	}
+	return out, nil
+}
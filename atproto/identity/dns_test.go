@@ -0,0 +1,166 @@
+package identity
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// HTTP client which serves well-known handle resolution from a map, and 404 otherwise
+func wellKnownClient(hosts map[string]string) http.Client {
+	return http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body, ok := hosts[req.URL.Host]
+			status := http.StatusOK
+			if !ok || req.URL.Path != "/.well-known/atproto-did" {
+				status = http.StatusNotFound
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		}),
+	}
+}
+
+func TestResolveHandleReport(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	dns := NewMockDNSTransport()
+	dns.TXT["_atproto.dns.example.com"] = []string{"did=did:plc:abc111"}
+	dns.NS["auth.example.com"] = []string{"ns1.example.com."}
+	dns.NameserverTXT["ns1.example.com.:53"] = map[string][]string{
+		"_atproto.auth.example.com": {"did=did:plc:abc222"},
+	}
+	dns.NameserverTXT["192.0.2.1:53"] = map[string][]string{
+		"_atproto.fallback.example.com": {"did=did:plc:abc333"},
+	}
+
+	dir := BaseDirectory{
+		DNSTransport:        &dns,
+		TryAuthoritativeDNS: true,
+		FallbackDNSServers:  []string{"192.0.2.2:53", "192.0.2.1:53"},
+		HTTPClient: wellKnownClient(map[string]string{
+			"web.example.com": "did:plc:abc444\n",
+		}),
+	}
+
+	r := dir.ResolveHandleWithReport(ctx, syntax.Handle("dns.example.com"))
+	assert.NoError(r.Err)
+	assert.Equal(syntax.DID("did:plc:abc111"), r.DID)
+	assert.Equal(HandleMethodDNS, r.Method)
+	assert.Equal(1, len(r.Steps))
+
+	r = dir.ResolveHandleWithReport(ctx, syntax.Handle("auth.example.com"))
+	assert.NoError(r.Err)
+	assert.Equal(syntax.DID("did:plc:abc222"), r.DID)
+	assert.Equal(HandleMethodDNSAuthoritative, r.Method)
+	assert.Equal(2, len(r.Steps))
+	assert.Equal("ns1.example.com.:53", r.Steps[1].Nameserver)
+
+	r = dir.ResolveHandleWithReport(ctx, syntax.Handle("fallback.example.com"))
+	assert.NoError(r.Err)
+	assert.Equal(syntax.DID("did:plc:abc333"), r.DID)
+	assert.Equal(HandleMethodDNSFallback, r.Method)
+	assert.Equal(4, len(r.Steps))
+	assert.Equal("192.0.2.2:53", r.Steps[2].Nameserver)
+	assert.NotEmpty(r.Steps[2].Error)
+
+	did, err := dir.ResolveHandle(ctx, syntax.Handle("web.example.com"))
+	assert.NoError(err)
+	assert.Equal(syntax.DID("did:plc:abc444"), did)
+	r = dir.ResolveHandleWithReport(ctx, syntax.Handle("web.example.com"))
+	assert.Equal(HandleMethodWellKnown, r.Method)
+	assert.Equal(5, len(r.Steps))
+
+	r = dir.ResolveHandleWithReport(ctx, syntax.Handle("missing.example.com"))
+	assert.ErrorIs(r.Err, ErrHandleNotFound)
+	assert.Empty(r.DID)
+	assert.Empty(r.Method)
+	assert.NotEmpty(r.Error)
+
+	// DNS skipped entirely
+	dir.SkipDNSDomainSuffixes = []string{".example.com"}
+	r = dir.ResolveHandleWithReport(ctx, syntax.Handle("dns.example.com"))
+	assert.ErrorIs(r.Err, ErrHandleNotFound)
+	assert.Equal(1, len(r.Steps))
+	assert.Equal(HandleMethodWellKnown, r.Steps[0].Method)
+}
+
+func TestDoHTransport(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		var q dnsmessage.Message
+		if err := q.Unpack(b); err != nil || len(q.Questions) != 1 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		question := q.Questions[0]
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeNameError},
+			Questions: q.Questions,
+		}
+		if question.Name.String() == "_atproto.dns.example.com." && question.Type == dnsmessage.TypeTXT {
+			resp.Header.RCode = dnsmessage.RCodeSuccess
+			resp.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.TXTResource{TXT: []string{"did=did:plc:", "abc111"}},
+			}}
+		}
+		out, err := resp.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(out)
+	}))
+	defer srv.Close()
+
+	doh := DoHTransport{URL: srv.URL + "/dns-query"}
+	res, err := doh.LookupTXT(ctx, "_atproto.dns.example.com")
+	assert.NoError(err)
+	assert.Equal([]string{"did=did:plc:abc111"}, res)
+
+	_, err = doh.LookupTXT(ctx, "_atproto.missing.example.com")
+	assert.ErrorIs(err, ErrDNSNotFound)
+
+	// authoritative lookups are skipped, as DoH can't query specific nameservers
+	dir := BaseDirectory{
+		DNSTransport:        &doh,
+		TryAuthoritativeDNS: true,
+		HTTPClient:          wellKnownClient(nil),
+	}
+	r := dir.ResolveHandleWithReport(ctx, syntax.Handle("dns.example.com"))
+	assert.NoError(r.Err)
+	assert.Equal(syntax.DID("did:plc:abc111"), r.DID)
+	r = dir.ResolveHandleWithReport(ctx, syntax.Handle("missing.example.com"))
+	assert.ErrorIs(r.Err, ErrHandleNotFound)
+	assert.Equal(2, len(r.Steps))
+	assert.Equal(HandleMethodDNS, r.Steps[0].Method)
+	assert.Equal(HandleMethodWellKnown, r.Steps[1].Method)
+}
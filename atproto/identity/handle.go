@@ -28,15 +28,19 @@ func parseTXTResp(res []string) (syntax.DID, error) {
 	return "", ErrHandleNotFound
 }
 
+// DNS transport to use for handle resolution: the configured DNSTransport, or the configured net.Resolver
+func (d *BaseDirectory) dnsTransport() DNSTransport {
+	if d.DNSTransport != nil {
+		return d.DNSTransport
+	}
+	return &NetDNSTransport{Resolver: &d.Resolver}
+}
+
 // Does not cross-verify, only does the handle resolution step.
 func (d *BaseDirectory) ResolveHandleDNS(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
-	res, err := d.Resolver.LookupTXT(ctx, "_atproto."+handle.String())
-	// check for NXDOMAIN
-	var dnsErr *net.DNSError
-	if errors.As(err, &dnsErr) {
-		if dnsErr.IsNotFound {
-			return "", fmt.Errorf("%w: %s", ErrHandleNotFound, handle)
-		}
+	res, err := d.dnsTransport().LookupTXT(ctx, "_atproto."+handle.String())
+	if errors.Is(err, ErrDNSNotFound) {
+		return "", fmt.Errorf("%w: %s", ErrHandleNotFound, handle)
 	}
 	if err != nil {
 		return "", fmt.Errorf("%w: %w", ErrHandleResolutionFailed, err)
@@ -46,77 +50,63 @@ func (d *BaseDirectory) ResolveHandleDNS(ctx context.Context, handle syntax.Hand
 
 // this is a variant of ResolveHandleDNS which first does an authoritative nameserver lookup, then queries there
 func (d *BaseDirectory) ResolveHandleDNSAuthoritative(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
+	did, _, err := d.resolveHandleDNSAuthoritative(ctx, handle)
+	return did, err
+}
+
+// returns the nameserver which was queried, if any
+func (d *BaseDirectory) resolveHandleDNSAuthoritative(ctx context.Context, handle syntax.Handle) (syntax.DID, string, error) {
+	transport := d.dnsTransport()
+	nst, ok := transport.(DNSNameserverTransport)
+	if !ok {
+		return "", "", fmt.Errorf("%w: DNS transport does not support direct nameserver queries", ErrHandleResolutionFailed)
+	}
+
 	// lookup nameserver using configured resolver
-	resNS, err := d.Resolver.LookupNS(ctx, handle.String())
-	// check for NXDOMAIN
-	var dnsErr *net.DNSError
-	if errors.As(err, &dnsErr) {
-		if dnsErr.IsNotFound {
-			return "", ErrHandleNotFound
-		}
+	resNS, err := transport.LookupNS(ctx, handle.String())
+	if errors.Is(err, ErrDNSNotFound) {
+		return "", "", ErrHandleNotFound
 	}
 	if err != nil {
-		return "", fmt.Errorf("%w: DNS error: %w", ErrHandleResolutionFailed, err)
+		return "", "", fmt.Errorf("%w: DNS error: %w", ErrHandleResolutionFailed, err)
 	}
 	if len(resNS) == 0 {
-		return "", ErrHandleNotFound
+		return "", "", ErrHandleNotFound
 	}
-	ns := resNS[0].Host
+	ns := resNS[0]
 	if !strings.Contains(ns, ":") {
 		ns = ns + ":53"
 	}
 
-	// create a custom resolver to use the specific nameserver for TXT lookup
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			rd := net.Dialer{
-				Timeout: time.Second * 5,
-			}
-			return rd.DialContext(ctx, network, ns)
-		},
-	}
-	res, err := resolver.LookupTXT(ctx, "_atproto."+handle.String())
-	// check for NXDOMAIN
-	if errors.As(err, &dnsErr) {
-		if dnsErr.IsNotFound {
-			return "", ErrHandleNotFound
-		}
+	res, err := nst.LookupTXTAt(ctx, ns, "_atproto."+handle.String())
+	if errors.Is(err, ErrDNSNotFound) {
+		return "", ns, ErrHandleNotFound
 	}
 	if err != nil {
-		return "", fmt.Errorf("%w: DNS resolution failed: %w", ErrHandleResolutionFailed, err)
+		return "", ns, fmt.Errorf("%w: DNS resolution failed: %w", ErrHandleResolutionFailed, err)
 	}
-	return parseTXTResp(res)
+	did, err := parseTXTResp(res)
+	return did, ns, err
 }
 
 // variant of ResolveHandleDNS which uses any configured fallback DNS servers
 func (d *BaseDirectory) ResolveHandleDNSFallback(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
+	return d.resolveHandleDNSFallback(ctx, handle, nil)
+}
+
+// if report is not nil, each fallback server attempt is recorded as a step
+func (d *BaseDirectory) resolveHandleDNSFallback(ctx context.Context, handle syntax.Handle, report *HandleResolutionReport) (syntax.DID, error) {
+	nst, ok := d.dnsTransport().(DNSNameserverTransport)
+	if !ok {
+		return "", fmt.Errorf("%w: DNS transport does not support direct nameserver queries", ErrHandleResolutionFailed)
+	}
 	retErr := fmt.Errorf("no fallback servers configured")
-	var dnsErr *net.DNSError
 	for _, ns := range d.FallbackDNSServers {
-		// create a custom resolver to use the specific nameserver for TXT lookup
-		resolver := &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				rd := net.Dialer{
-					Timeout: time.Second * 5,
-				}
-				return rd.DialContext(ctx, network, ns)
-			},
-		}
-		res, err := resolver.LookupTXT(ctx, "_atproto."+handle.String())
-		// check for NXDOMAIN
-		if errors.As(err, &dnsErr) {
-			if dnsErr.IsNotFound {
-				retErr = ErrHandleNotFound
-				continue
-			}
-		}
-		if err != nil {
-			retErr = fmt.Errorf("%w: %w", ErrHandleResolutionFailed, err)
-			continue
+		start := time.Now()
+		ret, err := d.resolveHandleDNSAt(ctx, nst, ns, handle)
+		if report != nil {
+			report.addStep(HandleMethodDNSFallback, ns, start, ret, err)
 		}
-		ret, err := parseTXTResp(res)
 		if err != nil {
 			retErr = err
 			continue
@@ -126,6 +116,17 @@ func (d *BaseDirectory) ResolveHandleDNSFallback(ctx context.Context, handle syn
 	return "", retErr
 }
 
+func (d *BaseDirectory) resolveHandleDNSAt(ctx context.Context, nst DNSNameserverTransport, ns string, handle syntax.Handle) (syntax.DID, error) {
+	res, err := nst.LookupTXTAt(ctx, ns, "_atproto."+handle.String())
+	if errors.Is(err, ErrDNSNotFound) {
+		return "", ErrHandleNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrHandleResolutionFailed, err)
+	}
+	return parseTXTResp(res)
+}
+
 func (d *BaseDirectory) ResolveHandleWellKnown(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s/.well-known/atproto-did", handle), nil)
 	if err != nil {
@@ -173,16 +174,28 @@ func (d *BaseDirectory) ResolveHandleWellKnown(ctx context.Context, handle synta
 }
 
 func (d *BaseDirectory) ResolveHandle(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
+	report := d.ResolveHandleWithReport(ctx, handle)
+	return report.DID, report.Err
+}
+
+// Resolves a handle (like `ResolveHandle`), and returns a report of every resolution method which was attempted, in order, and which one produced the result. This is helpful for debugging handle resolution issues.
+//
+// The report is always returned; the `Err` field is set if resolution failed.
+func (d *BaseDirectory) ResolveHandleWithReport(ctx context.Context, handle syntax.Handle) *HandleResolutionReport {
 	// TODO: *could* do resolution in parallel, but expecting that sequential is sufficient to start
 	var dnsErr error
 	var did syntax.DID
+	report := &HandleResolutionReport{
+		Handle: handle,
+		Steps:  []HandleResolutionStep{},
+	}
 
 	if handle.IsInvalidHandle() {
-		return "", fmt.Errorf("can not resolve handle: %w", ErrInvalidHandle)
+		return report.fail(fmt.Errorf("can not resolve handle: %w", ErrInvalidHandle))
 	}
 
 	if !handle.AllowedTLD() {
-		return "", ErrHandleReservedTLD
+		return report.fail(ErrHandleReservedTLD)
 	}
 
 	tryDNS := true
@@ -195,42 +208,44 @@ func (d *BaseDirectory) ResolveHandle(ctx context.Context, handle syntax.Handle)
 
 	if tryDNS {
 		start := time.Now()
-		triedAuthoritative := false
-		triedFallback := false
+		_, nameserverQueries := d.dnsTransport().(DNSNameserverTransport)
 		did, dnsErr = d.ResolveHandleDNS(ctx, handle)
-		if errors.Is(dnsErr, ErrHandleNotFound) && d.TryAuthoritativeDNS {
+		report.addStep(HandleMethodDNS, "", start, did, dnsErr)
+		if errors.Is(dnsErr, ErrHandleNotFound) && d.TryAuthoritativeDNS && nameserverQueries {
 			slog.Debug("attempting authoritative handle DNS resolution", "handle", handle)
-			triedAuthoritative = true
 			// try harder with authoritative lookup
-			did, dnsErr = d.ResolveHandleDNSAuthoritative(ctx, handle)
+			var ns string
+			stepStart := time.Now()
+			did, ns, dnsErr = d.resolveHandleDNSAuthoritative(ctx, handle)
+			report.addStep(HandleMethodDNSAuthoritative, ns, stepStart, did, dnsErr)
 		}
-		if errors.Is(dnsErr, ErrHandleNotFound) && len(d.FallbackDNSServers) > 0 {
+		if errors.Is(dnsErr, ErrHandleNotFound) && len(d.FallbackDNSServers) > 0 && nameserverQueries {
 			slog.Debug("attempting fallback DNS resolution", "handle", handle)
-			triedFallback = true
 			// try harder with fallback lookup
-			did, dnsErr = d.ResolveHandleDNSFallback(ctx, handle)
+			did, dnsErr = d.resolveHandleDNSFallback(ctx, handle, report)
 		}
 		elapsed := time.Since(start)
-		slog.Debug("resolve handle DNS", "handle", handle, "err", dnsErr, "did", did, "authoritative", triedAuthoritative, "fallback", triedFallback, "duration_ms", elapsed.Milliseconds())
+		slog.Debug("resolve handle DNS", "handle", handle, "err", dnsErr, "did", did, "steps", len(report.Steps), "duration_ms", elapsed.Milliseconds())
 		if nil == dnsErr { // if *not* an error
-			return did, nil
+			return report.succeed(did)
 		}
 	}
 
 	start := time.Now()
 	did, httpErr := d.ResolveHandleWellKnown(ctx, handle)
+	report.addStep(HandleMethodWellKnown, "", start, did, httpErr)
 	elapsed := time.Since(start)
 	slog.Debug("resolve handle HTTP well-known", "handle", handle, "err", httpErr, "did", did, "duration_ms", elapsed.Milliseconds())
 	if nil == httpErr { // if *not* an error
-		return did, nil
+		return report.succeed(did)
 	}
 
 	// return the most specific/helpful error
-	if !errors.Is(dnsErr, ErrHandleNotFound) {
-		return "", dnsErr
+	if dnsErr != nil && !errors.Is(dnsErr, ErrHandleNotFound) {
+		return report.fail(dnsErr)
 	}
-	if !errors.Is(httpErr, ErrHandleNotFound) {
-		return "", httpErr
+	if dnsErr == nil || !errors.Is(httpErr, ErrHandleNotFound) {
+		return report.fail(httpErr)
 	}
-	return "", dnsErr
+	return report.fail(dnsErr)
 }
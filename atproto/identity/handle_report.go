@@ -0,0 +1,70 @@
+package identity
+
+import (
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Names of handle resolution methods, as recorded in HandleResolutionReport
+const (
+	HandleMethodDNS              = "dns"
+	HandleMethodDNSAuthoritative = "dns-authoritative"
+	HandleMethodDNSFallback      = "dns-fallback"
+	HandleMethodWellKnown        = "http-well-known"
+)
+
+// A single attempt to resolve a handle, using one method
+type HandleResolutionStep struct {
+	Method string `json:"method"`
+	// nameserver which was queried directly ("host:port"), for authoritative and fallback DNS resolution
+	Nameserver string     `json:"nameserver,omitempty"`
+	DID        syntax.DID `json:"did,omitempty"`
+	Err        error      `json:"-"`
+	// error message, if this step failed (for JSON output)
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Structured trace of a handle resolution, returned by `BaseDirectory.ResolveHandleWithReport`
+type HandleResolutionReport struct {
+	Handle syntax.Handle `json:"handle"`
+	// resolved DID; empty if resolution failed
+	DID syntax.DID `json:"did,omitempty"`
+	// method (eg, HandleMethodDNS) which produced the DID; empty if resolution failed
+	Method string `json:"method,omitempty"`
+	// every attempted resolution method, in order
+	Steps []HandleResolutionStep `json:"steps"`
+	Err   error                  `json:"-"`
+	// error message, if resolution failed (for JSON output)
+	Error string `json:"error,omitempty"`
+}
+
+func (r *HandleResolutionReport) addStep(method, nameserver string, start time.Time, did syntax.DID, err error) {
+	step := HandleResolutionStep{
+		Method:     method,
+		Nameserver: nameserver,
+		DID:        did,
+		Err:        err,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		step.DID = ""
+		step.Error = err.Error()
+	}
+	r.Steps = append(r.Steps, step)
+}
+
+func (r *HandleResolutionReport) succeed(did syntax.DID) *HandleResolutionReport {
+	r.DID = did
+	if len(r.Steps) > 0 {
+		r.Method = r.Steps[len(r.Steps)-1].Method
+	}
+	return r
+}
+
+func (r *HandleResolutionReport) fail(err error) *HandleResolutionReport {
+	r.Err = err
+	r.Error = err.Error()
+	return r
+}
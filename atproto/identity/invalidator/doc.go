@@ -0,0 +1,8 @@
+/*
+Firehose-driven invalidation of identity caches.
+
+`Invalidator` wraps any `identity.Directory` (usually a caching one, like `identity.CacheDirectory` or `redisdir.RedisDirectory`), and purges DIDs and handles from it when `#identity` events are seen on a repo event stream (firehose). Identities which were looked up recently ("hot") can optionally be re-resolved right away, so the cache stays warm.
+
+It can either subscribe to a relay itself (`Run`), or be fed events from an existing firehose consumer (`HandleIdentityEvent`).
+*/
+package invalidator
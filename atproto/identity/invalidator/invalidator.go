@@ -0,0 +1,263 @@
+package invalidator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/parallel"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// Wraps an `identity.Directory`, purging identities from it in response to firehose `#identity` events.
+//
+// The Invalidator is itself an `identity.Directory`: lookups are passed through to the inner directory, and successful results are tracked as "hot" identities. When an `#identity` event arrives for a hot identity, the previously resolved handle is purged as well, and the identity can be re-resolved immediately (see RefreshHot).
+type Invalidator struct {
+	Inner identity.Directory
+	// If true, hot identities are re-resolved immediately after being purged
+	RefreshHot bool
+	// Number of concurrent event handlers, when subscribing with Run
+	Parallelism int
+	// User-Agent header for the firehose connection. Optional (ignored if empty string).
+	UserAgent string
+	Logger    *slog.Logger
+
+	// recently looked-up identities, with the handle they resolved with
+	hot *expirable.LRU[syntax.DID, syntax.Handle]
+
+	// events from Run are processed concurrently, so may complete out of order. Sequence numbers of events which have been dispatched but not completed are tracked, and lastSeq only advances past events which have all completed
+	seqLk         sync.Mutex
+	inflightSeq   map[int64]bool
+	dispatchedSeq int64
+	lastSeq       atomic.Int64
+}
+
+var _ identity.Directory = (*Invalidator)(nil)
+
+// Creates a new Invalidator wrapping an existing directory.
+//
+// `hotCapacity` and `hotTTL` configure tracking of recently looked-up identities. Capacity of zero means unlimited size. Similarly, ttl of zero means unlimited duration.
+func NewInvalidator(inner identity.Directory, hotCapacity int, hotTTL time.Duration) *Invalidator {
+	return &Invalidator{
+		Inner:       inner,
+		Parallelism: 4,
+		Logger:      slog.Default().With("system", "identity-invalidator"),
+		hot:         expirable.NewLRU[syntax.DID, syntax.Handle](hotCapacity, nil, hotTTL),
+		inflightSeq: make(map[int64]bool),
+	}
+}
+
+func (i *Invalidator) track(ident *identity.Identity) {
+	if ident != nil {
+		i.hot.Add(ident.DID, ident.Handle)
+	}
+}
+
+func (i *Invalidator) LookupHandle(ctx context.Context, h syntax.Handle) (*identity.Identity, error) {
+	ident, err := i.Inner.LookupHandle(ctx, h)
+	if err == nil {
+		i.track(ident)
+	}
+	return ident, err
+}
+
+func (i *Invalidator) LookupDID(ctx context.Context, did syntax.DID) (*identity.Identity, error) {
+	ident, err := i.Inner.LookupDID(ctx, did)
+	if err == nil {
+		i.track(ident)
+	}
+	return ident, err
+}
+
+func (i *Invalidator) Lookup(ctx context.Context, a syntax.AtIdentifier) (*identity.Identity, error) {
+	handle, err := a.AsHandle()
+	if nil == err { // if not an error, is a handle
+		return i.LookupHandle(ctx, handle)
+	}
+	did, err := a.AsDID()
+	if nil == err { // if not an error, is a DID
+		return i.LookupDID(ctx, did)
+	}
+	return nil, fmt.Errorf("at-identifier neither a Handle nor a DID")
+}
+
+func (i *Invalidator) Purge(ctx context.Context, atid syntax.AtIdentifier) error {
+	return i.Inner.Purge(ctx, atid)
+}
+
+// Sequence number up to which all events received by Run have been fully processed (a low-water mark), or zero. Callers can persist this, and use it as the cursor when resuming; some events after it may have already been processed, and will be processed again.
+//
+// Events passed directly to HandleIdentityEvent are not tracked; callers doing so should track their own cursor.
+func (i *Invalidator) LastSeq() int64 {
+	return i.lastSeq.Load()
+}
+
+// marks an event as dispatched for processing. Must be called in sequence order.
+func (i *Invalidator) dispatchSeq(seq int64) {
+	if seq <= 0 {
+		return
+	}
+	i.seqLk.Lock()
+	defer i.seqLk.Unlock()
+	i.inflightSeq[seq] = true
+	i.dispatchedSeq = seq
+}
+
+// marks an event as fully processed, and advances the low-water mark
+func (i *Invalidator) completeSeq(seq int64) {
+	if seq <= 0 {
+		return
+	}
+	i.seqLk.Lock()
+	defer i.seqLk.Unlock()
+	delete(i.inflightSeq, seq)
+
+	// everything before the oldest in-flight event has completed
+	mark := i.dispatchedSeq
+	for k := range i.inflightSeq {
+		if k <= mark {
+			mark = k - 1
+		}
+	}
+	if mark > i.lastSeq.Load() {
+		i.lastSeq.Store(mark)
+		lastSeq.Set(float64(mark))
+	}
+}
+
+// wraps a scheduler, to track the sequence number of each event as it is dispatched
+type seqScheduler struct {
+	events.Scheduler
+	inv *Invalidator
+}
+
+func (s *seqScheduler) AddWork(ctx context.Context, repo string, val *events.XRPCStreamEvent) error {
+	s.inv.dispatchSeq(val.Sequence())
+	return s.Scheduler.AddWork(ctx, repo, val)
+}
+
+func (i *Invalidator) purge(ctx context.Context, atid syntax.AtIdentifier, kind string) {
+	if err := i.Inner.Purge(ctx, atid); err != nil {
+		purges.WithLabelValues(kind, "error").Inc()
+		i.Logger.Error("failed to purge identity from directory", "atid", atid, "err", err)
+		return
+	}
+	purges.WithLabelValues(kind, "success").Inc()
+}
+
+// Processes a single `#identity` event: purges the DID, the handle in the event (if any), and the previous handle of the DID (if known) from the inner directory.
+//
+// Purge failures are logged, not returned, so they do not interrupt event stream processing.
+func (i *Invalidator) HandleIdentityEvent(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Identity) error {
+	identityEventsReceived.Inc()
+	if t, err := syntax.ParseDatetimeLenient(evt.Time); err == nil {
+		eventLag.Set(time.Since(t.Time()).Seconds())
+	}
+
+	did, err := syntax.ParseDID(evt.Did)
+	if err != nil {
+		i.Logger.Warn("invalid DID in #identity event", "did", evt.Did, "seq", evt.Seq, "err", err)
+		return nil
+	}
+	oldHandle, hot := i.hot.Peek(did)
+
+	i.purge(ctx, did.AtIdentifier(), "did")
+	if evt.Handle != nil {
+		handle, err := syntax.ParseHandle(*evt.Handle)
+		if err != nil {
+			i.Logger.Warn("invalid handle in #identity event", "did", evt.Did, "handle", *evt.Handle, "seq", evt.Seq, "err", err)
+		} else {
+			handle = handle.Normalize()
+			i.purge(ctx, handle.AtIdentifier(), "handle")
+			if oldHandle == handle {
+				oldHandle = ""
+			}
+		}
+	}
+	if hot && oldHandle != "" && !oldHandle.IsInvalidHandle() {
+		i.purge(ctx, oldHandle.AtIdentifier(), "handle")
+	}
+
+	if hot && i.RefreshHot {
+		ident, err := i.Inner.LookupDID(ctx, did)
+		if err != nil {
+			refreshes.WithLabelValues("error").Inc()
+			i.hot.Remove(did)
+			i.Logger.Debug("failed to refresh identity", "did", did, "err", err)
+			return nil
+		}
+		refreshes.WithLabelValues("success").Inc()
+		i.track(ident)
+	}
+	return nil
+}
+
+// Subscribes to the repo event stream of a relay (eg, "wss://bsky.network"), and processes `#identity` events until the context is cancelled or the connection fails. Other event types are ignored.
+//
+// If `cursor` is non-zero, the subscription starts from that sequence number.
+func (i *Invalidator) Run(ctx context.Context, relayHost string, cursor int64) error {
+	u, err := url.Parse(relayHost)
+	if err != nil {
+		return fmt.Errorf("invalid relay host URI: %w", err)
+	}
+	if u.Host == "" {
+		return errors.New("relay host URI missing hostname")
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	u.Path = "xrpc/com.atproto.sync.subscribeRepos"
+	if cursor != 0 {
+		u.RawQuery = fmt.Sprintf("cursor=%d", cursor)
+	}
+	header := http.Header{}
+	if i.UserAgent != "" {
+		header.Set("User-Agent", i.UserAgent)
+	}
+
+	i.Logger.Info("subscribing to repo event stream", "upstream", relayHost, "cursor", cursor)
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		return fmt.Errorf("subscribing to firehose failed (dialing): %w", err)
+	}
+
+	rsc := &events.RepoStreamCallbacks{
+		RepoIdentity: func(evt *comatproto.SyncSubscribeRepos_Identity) error {
+			return i.HandleIdentityEvent(ctx, evt)
+		},
+	}
+	parallelism := i.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	// every event (not only #identity) is tracked, so that the cursor advances through the whole stream
+	handler := func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		defer i.completeSeq(evt.Sequence())
+		return rsc.EventHandler(ctx, evt)
+	}
+	scheduler := &seqScheduler{
+		Scheduler: parallel.NewScheduler(
+			parallelism,
+			1000,
+			relayHost,
+			handler,
+		),
+		inv: i,
+	}
+	return events.HandleRepoStream(ctx, con, scheduler, i.Logger)
+}
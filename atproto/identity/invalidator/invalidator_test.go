@@ -0,0 +1,102 @@
+package invalidator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wraps a mock directory, recording lookups and purges
+type recordingDirectory struct {
+	identity.MockDirectory
+	lookups int
+	purged  []string
+}
+
+func (d *recordingDirectory) LookupDID(ctx context.Context, did syntax.DID) (*identity.Identity, error) {
+	d.lookups++
+	return d.MockDirectory.LookupDID(ctx, did)
+}
+
+func (d *recordingDirectory) Purge(ctx context.Context, atid syntax.AtIdentifier) error {
+	d.purged = append(d.purged, atid.String())
+	return nil
+}
+
+func TestInvalidator(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	inner := recordingDirectory{MockDirectory: identity.NewMockDirectory()}
+	inner.Insert(identity.Identity{
+		DID:         syntax.DID("did:plc:abc111"),
+		Handle:      syntax.Handle("alice.example.com"),
+		AlsoKnownAs: []string{"at://alice.example.com"},
+	})
+	inv := NewInvalidator(&inner, 100, time.Hour)
+	inv.RefreshHot = true
+
+	// cold identity: just purged
+	newHandle := "bob.example.com"
+	assert.NoError(inv.HandleIdentityEvent(ctx, &comatproto.SyncSubscribeRepos_Identity{
+		Did:    "did:plc:abc222",
+		Handle: &newHandle,
+		Seq:    123,
+		Time:   syntax.DatetimeNow().String(),
+	}))
+	assert.Equal([]string{"did:plc:abc222", "bob.example.com"}, inner.purged)
+	assert.Equal(0, inner.lookups)
+	// events handled directly are not tracked for the cursor
+	assert.Equal(int64(0), inv.LastSeq())
+
+	// hot identity: previous handle is purged, and identity is re-resolved
+	_, err := inv.LookupHandle(ctx, syntax.Handle("alice.example.com"))
+	assert.NoError(err)
+	inner.purged = nil
+	newHandle = "alice2.example.com"
+	assert.NoError(inv.HandleIdentityEvent(ctx, &comatproto.SyncSubscribeRepos_Identity{
+		Did:    "did:plc:abc111",
+		Handle: &newHandle,
+		Seq:    124,
+	}))
+	assert.Equal([]string{"did:plc:abc111", "alice2.example.com", "alice.example.com"}, inner.purged)
+	assert.Equal(1, inner.lookups)
+
+	// invalid DID is skipped
+	inner.purged = nil
+	assert.NoError(inv.HandleIdentityEvent(ctx, &comatproto.SyncSubscribeRepos_Identity{
+		Did: "invalid",
+		Seq: 125,
+	}))
+	assert.Empty(inner.purged)
+}
+
+func TestLastSeq(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := identity.NewMockDirectory()
+	inv := NewInvalidator(&dir, 100, time.Hour)
+	for seq := int64(1); seq <= 5; seq++ {
+		inv.dispatchSeq(seq)
+	}
+
+	// later events completing first do not advance the cursor past earlier in-flight events
+	inv.completeSeq(3)
+	inv.completeSeq(2)
+	assert.Equal(int64(0), inv.LastSeq())
+	inv.completeSeq(1)
+	assert.Equal(int64(3), inv.LastSeq())
+	inv.completeSeq(5)
+	assert.Equal(int64(3), inv.LastSeq())
+	inv.completeSeq(4)
+	assert.Equal(int64(5), inv.LastSeq())
+	inv.dispatchSeq(6)
+	inv.completeSeq(6)
+	assert.Equal(int64(6), inv.LastSeq())
+}
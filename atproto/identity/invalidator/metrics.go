@@ -0,0 +1,31 @@
+package invalidator
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var identityEventsReceived = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "atproto_identity_invalidator_events_received",
+	Help: "Number of #identity events processed",
+})
+
+var purges = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "atproto_identity_invalidator_purges",
+	Help: "Number of identifiers purged from the directory, by type (did, handle) and status",
+}, []string{"type", "status"})
+
+var refreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "atproto_identity_invalidator_refreshes",
+	Help: "Number of hot identities re-resolved after being purged",
+}, []string{"status"})
+
+var eventLag = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "atproto_identity_invalidator_lag_sec",
+	Help: "Time between an #identity event being emitted and being processed",
+})
+
+var lastSeq = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "atproto_identity_invalidator_last_seq",
+	Help: "Sequence number up to which all firehose events have been processed",
+})
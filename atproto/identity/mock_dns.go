@@ -0,0 +1,59 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A fake in-memory DNS transport, for use in tests
+type MockDNSTransport struct {
+	// TXT records, by name
+	TXT map[string][]string
+	// nameserver hostnames, by name
+	NS map[string][]string
+	// TXT records returned by specific nameservers ("host:port"), by nameserver then name. Queries to other nameservers return ErrDNSNotFound.
+	NameserverTXT map[string]map[string][]string
+	// errors to return for specific names, instead of records
+	Errors map[string]error
+}
+
+var _ DNSTransport = (*MockDNSTransport)(nil)
+var _ DNSNameserverTransport = (*MockDNSTransport)(nil)
+
+func NewMockDNSTransport() MockDNSTransport {
+	return MockDNSTransport{
+		TXT:           make(map[string][]string),
+		NS:            make(map[string][]string),
+		NameserverTXT: make(map[string]map[string][]string),
+		Errors:        make(map[string]error),
+	}
+}
+
+func normalizeDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+func (t *MockDNSTransport) lookup(records map[string][]string, name string) ([]string, error) {
+	name = normalizeDNSName(name)
+	if err, ok := t.Errors[name]; ok {
+		return nil, err
+	}
+	res, ok := records[name]
+	if !ok || len(res) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDNSNotFound, name)
+	}
+	return res, nil
+}
+
+func (t *MockDNSTransport) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return t.lookup(t.TXT, name)
+}
+
+func (t *MockDNSTransport) LookupNS(ctx context.Context, name string) ([]string, error) {
+	return t.lookup(t.NS, name)
+}
+
+func (t *MockDNSTransport) LookupTXTAt(ctx context.Context, nameserver, name string) ([]string, error) {
+	return t.lookup(t.NameserverTXT[nameserver], name)
+}
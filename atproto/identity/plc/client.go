@@ -0,0 +1,65 @@
+package plc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Minimal client for fetching operation logs from a PLC directory. The zero value is usable.
+type Client struct {
+	// if empty, identity.DefaultPLCURL is used
+	Host       string
+	HTTPClient http.Client
+	// User-Agent header for HTTP requests. Optional (ignored if empty string).
+	UserAgent string
+}
+
+// Fetches the raw (JSON) audit log for a DID. The log is not verified.
+func (c *Client) AuditLogJSON(ctx context.Context, did syntax.DID) ([]byte, error) {
+	if did.Method() != "plc" {
+		return nil, fmt.Errorf("expected a did:plc, got: %s", did)
+	}
+	host := c.Host
+	if host == "" {
+		host = identity.DefaultPLCURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", host+"/"+did.String()+"/log/audit", nil)
+	if err != nil {
+		return nil, fmt.Errorf("constructing HTTP request for PLC audit log: %w", err)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: PLC audit log fetch: %w", identity.ErrDIDResolutionFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%w: PLC directory 404", identity.ErrDIDNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%w: PLC directory status %d", identity.ErrDIDResolutionFailed, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Fetches and verifies the audit log for a DID.
+func (c *Client) VerifiedLog(ctx context.Context, did syntax.DID) (*VerifiedLog, error) {
+	b, err := c.AuditLogJSON(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ParseAuditLog(b)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyLog(did, entries)
+}
@@ -0,0 +1,12 @@
+/*
+Package plc verifies did:plc operation logs locally, without trusting the PLC directory which serves them.
+
+An operation log is verified from the genesis operation forward: signatures, rotation key authority (including nullification of operations by higher-priority keys during the 72-hour recovery window), and the hash of the genesis operation against the DID itself. The current DID document is then derived from the most recent valid operation.
+
+To only accept verified logs during regular identity resolution, configure a BaseDirectory with this package's verifier:
+
+	dir := identity.BaseDirectory{
+		PLCLogVerifier: plc.VerifyAuditLogJSON,
+	}
+*/
+package plc
@@ -0,0 +1,232 @@
+package plc
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	OpTypeOperation = "plc_operation"
+	OpTypeTombstone = "plc_tombstone"
+	// deprecated genesis operation format, still present in older operation logs
+	OpTypeLegacyCreate = "create"
+)
+
+type Service struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+}
+
+// A single PLC operation, of any type. Which fields are relevant depends on the type.
+type Op struct {
+	Type string `json:"type"`
+
+	// fields of regular operations ("plc_operation")
+	RotationKeys        []string           `json:"rotationKeys,omitempty"`
+	VerificationMethods map[string]string  `json:"verificationMethods,omitempty"`
+	AlsoKnownAs         []string           `json:"alsoKnownAs,omitempty"`
+	Services            map[string]Service `json:"services,omitempty"`
+
+	// fields of legacy genesis operations ("create")
+	SigningKey  string `json:"signingKey,omitempty"`
+	RecoveryKey string `json:"recoveryKey,omitempty"`
+	Handle      string `json:"handle,omitempty"`
+	Service     string `json:"service,omitempty"`
+
+	// CID of the previous operation; nil for genesis operations
+	Prev *string `json:"prev"`
+	// signature, base64url-encoded without padding
+	Sig string `json:"sig,omitempty"`
+}
+
+// converts to generic data, for DAG-CBOR encoding
+func (op *Op) data(signed bool) (map[string]any, error) {
+	d := map[string]any{
+		"type": op.Type,
+		"prev": nil,
+	}
+	if op.Prev != nil {
+		d["prev"] = *op.Prev
+	}
+	switch op.Type {
+	case OpTypeOperation:
+		rotationKeys := make([]any, len(op.RotationKeys))
+		for i, k := range op.RotationKeys {
+			rotationKeys[i] = k
+		}
+		verificationMethods := make(map[string]any, len(op.VerificationMethods))
+		for k, v := range op.VerificationMethods {
+			verificationMethods[k] = v
+		}
+		alsoKnownAs := make([]any, len(op.AlsoKnownAs))
+		for i, aka := range op.AlsoKnownAs {
+			alsoKnownAs[i] = aka
+		}
+		services := make(map[string]any, len(op.Services))
+		for k, s := range op.Services {
+			services[k] = map[string]any{
+				"type":     s.Type,
+				"endpoint": s.Endpoint,
+			}
+		}
+		d["rotationKeys"] = rotationKeys
+		d["verificationMethods"] = verificationMethods
+		d["alsoKnownAs"] = alsoKnownAs
+		d["services"] = services
+	case OpTypeLegacyCreate:
+		d["signingKey"] = op.SigningKey
+		d["recoveryKey"] = op.RecoveryKey
+		d["handle"] = op.Handle
+		d["service"] = op.Service
+	case OpTypeTombstone:
+	default:
+		return nil, fmt.Errorf("unsupported PLC operation type: %s", op.Type)
+	}
+	if signed {
+		if op.Sig == "" {
+			return nil, fmt.Errorf("PLC operation is not signed")
+		}
+		d["sig"] = op.Sig
+	}
+	return d, nil
+}
+
+// DAG-CBOR encoding of the operation without the signature; this is what gets signed.
+func (op *Op) UnsignedBytes() ([]byte, error) {
+	d, err := op.data(false)
+	if err != nil {
+		return nil, err
+	}
+	return data.MarshalCBOR(d)
+}
+
+// DAG-CBOR encoding of the signed operation
+func (op *Op) SignedBytes() ([]byte, error) {
+	d, err := op.data(true)
+	if err != nil {
+		return nil, err
+	}
+	return data.MarshalCBOR(d)
+}
+
+// Computes the CID of the signed operation, which is how later operations reference it (as "prev").
+func (op *Op) CID() (syntax.CID, error) {
+	b, err := op.SignedBytes()
+	if err != nil {
+		return "", err
+	}
+	c, err := cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum(b)
+	if err != nil {
+		return "", err
+	}
+	return syntax.CID(c.String()), nil
+}
+
+// Computes the DID for a signed genesis operation: a truncated hash of the operation itself.
+func (op *Op) DID() (syntax.DID, error) {
+	if op.Prev != nil {
+		return "", fmt.Errorf("not a genesis operation (has prev)")
+	}
+	b, err := op.SignedBytes()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:])
+	return syntax.ParseDID("did:plc:" + strings.ToLower(enc[:24]))
+}
+
+// Signs the operation, storing the signature in the `Sig` field
+func (op *Op) Sign(priv crypto.PrivateKey) error {
+	b, err := op.UnsignedBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := priv.HashAndSign(b)
+	if err != nil {
+		return err
+	}
+	op.Sig = base64.RawURLEncoding.EncodeToString(sig)
+	return nil
+}
+
+// Checks the signature against a list of public keys (as did:key strings), in order, and returns the index of the key which made the signature.
+func (op *Op) VerifySignature(didKeys []string) (int, error) {
+	if op.Sig == "" {
+		return -1, fmt.Errorf("PLC operation is not signed")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(op.Sig)
+	if err != nil {
+		return -1, fmt.Errorf("invalid PLC operation signature encoding: %w", err)
+	}
+	b, err := op.UnsignedBytes()
+	if err != nil {
+		return -1, err
+	}
+	for i, dk := range didKeys {
+		pub, err := crypto.ParsePublicDIDKey(dk)
+		if err != nil {
+			continue
+		}
+		// NOTE: some older operations have high-S signatures, which were accepted at the time
+		if err := pub.HashAndVerifyLenient(b, sig); err == nil {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("PLC operation signature not valid for any authorized rotation key")
+}
+
+// Returns the rotation keys declared by this operation, in priority order (highest authority first). Tombstones have none.
+func (op *Op) Keys() []string {
+	switch op.Type {
+	case OpTypeOperation:
+		return op.RotationKeys
+	case OpTypeLegacyCreate:
+		return []string{op.RecoveryKey, op.SigningKey}
+	default:
+		return nil
+	}
+}
+
+// Returns an equivalent regular operation for legacy genesis operations. Other operation types are returned as-is.
+//
+// Like the reference implementation, any URL scheme is stripped from the legacy handle, and "https://" is added to the legacy service if it has no scheme.
+func (op *Op) Normalize() Op {
+	if op.Type != OpTypeLegacyCreate {
+		return *op
+	}
+	handle := op.Handle
+	for _, prefix := range []string{"at://", "http://", "https://"} {
+		handle = strings.TrimPrefix(handle, prefix)
+	}
+	endpoint := op.Service
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "https://" + endpoint
+	}
+	return Op{
+		Type:         OpTypeOperation,
+		RotationKeys: []string{op.RecoveryKey, op.SigningKey},
+		VerificationMethods: map[string]string{
+			"atproto": op.SigningKey,
+		},
+		AlsoKnownAs: []string{"at://" + handle},
+		Services: map[string]Service{
+			"atproto_pds": Service{
+				Type:     "AtprotoPersonalDataServer",
+				Endpoint: endpoint,
+			},
+		},
+		Prev: op.Prev,
+		Sig:  op.Sig,
+	}
+}
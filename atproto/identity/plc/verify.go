@@ -0,0 +1,255 @@
+package plc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Period after an operation during which it can be nullified by an operation signed with a higher-priority rotation key
+const RecoveryWindow = 72 * time.Hour
+
+var ErrInvalidLog = errors.New("invalid PLC operation log")
+
+// An entry in a DID's audit log, as returned by the PLC directory (`/<did>/log/audit`)
+type LogEntry struct {
+	DID       string `json:"did"`
+	Operation Op     `json:"operation"`
+	CID       string `json:"cid"`
+	Nullified bool   `json:"nullified"`
+	// timestamp assigned by the PLC directory when the operation was accepted
+	CreatedAt string `json:"createdAt"`
+}
+
+// Result of verifying a single log entry
+type AuditedOp struct {
+	Entry LogEntry
+	// the rotation key which signed this operation, and its index in the authorizing (previous) operation's rotation keys
+	SignerKey   string
+	SignerIndex int
+	// whether this operation was nullified by a later recovery operation
+	Nullified bool
+}
+
+// A fully verified operation log
+type VerifiedLog struct {
+	DID syntax.DID
+	Ops []AuditedOp
+	// the most recent valid (non-nullified) operation, which determines the current state of the DID
+	Current Op
+}
+
+func logErr(idx int, format string, args ...any) error {
+	return fmt.Errorf("%w: operation %d: %s", ErrInvalidLog, idx, fmt.Sprintf(format, args...))
+}
+
+// Verifies a complete audit log for a DID, independently of the PLC directory which served it:
+//
+//   - the genesis operation hashes to the DID, and is self-signed by one of its own rotation keys
+//   - every later operation references an earlier valid operation, and is signed by one of that operation's rotation keys
+//   - operations which fork the history (nullifying later operations) are signed by a higher-priority rotation key than the first nullified operation, within RecoveryWindow of it
+//   - the operation CIDs, and which operations are nullified, match what the directory claimed
+//
+// Operation timestamps (for the recovery window) are assigned by the directory, and are not verifiable.
+func VerifyLog(did syntax.DID, entries []LogEntry) (*VerifiedLog, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidLog)
+	}
+
+	ops := make([]AuditedOp, len(entries))
+	// indexes (in to ops) of the current chain of valid operations
+	chain := []int{}
+	times := make([]time.Time, len(entries))
+
+	for i, entry := range entries {
+		op := entry.Operation
+		ops[i] = AuditedOp{Entry: entry, SignerIndex: -1}
+
+		if entry.DID != did.String() {
+			return nil, logErr(i, "DID mismatch: %s", entry.DID)
+		}
+		cid, err := op.CID()
+		if err != nil {
+			return nil, logErr(i, "%s", err)
+		}
+		if cid.String() != entry.CID {
+			return nil, logErr(i, "CID mismatch: computed %s, log has %s", cid, entry.CID)
+		}
+		ts, err := syntax.ParseDatetimeLenient(entry.CreatedAt)
+		if err != nil {
+			return nil, logErr(i, "invalid timestamp: %s", err)
+		}
+		times[i] = ts.Time()
+		if i > 0 && times[i].Before(times[i-1]) {
+			return nil, logErr(i, "timestamps out of order")
+		}
+
+		// genesis operation
+		if i == 0 {
+			if op.Prev != nil {
+				return nil, logErr(i, "first operation has prev")
+			}
+			if op.Type == OpTypeTombstone {
+				return nil, logErr(i, "genesis operation is a tombstone")
+			}
+			genesisDID, err := op.DID()
+			if err != nil {
+				return nil, logErr(i, "%s", err)
+			}
+			if genesisDID != did {
+				return nil, logErr(i, "genesis operation hashes to a different DID: %s", genesisDID)
+			}
+			keys := op.Keys()
+			idx, err := op.VerifySignature(keys)
+			if err != nil {
+				return nil, logErr(i, "%s", err)
+			}
+			ops[i].SignerIndex = idx
+			ops[i].SignerKey = keys[idx]
+			chain = append(chain, i)
+			continue
+		}
+
+		if op.Type == OpTypeLegacyCreate {
+			return nil, logErr(i, "legacy create operation after genesis")
+		}
+		if op.Prev == nil {
+			return nil, logErr(i, "missing prev")
+		}
+		// find the referenced operation in the current valid chain
+		pos := -1
+		for j, idx := range chain {
+			if entries[idx].CID == *op.Prev {
+				pos = j
+				break
+			}
+		}
+		if pos < 0 {
+			return nil, logErr(i, "prev does not reference a valid operation: %s", *op.Prev)
+		}
+		prev := entries[chain[pos]].Operation
+		if prev.Type == OpTypeTombstone {
+			return nil, logErr(i, "operation after tombstone")
+		}
+		keys := prev.Keys()
+
+		if pos == len(chain)-1 {
+			// regular update, extending the chain
+			idx, err := op.VerifySignature(keys)
+			if err != nil {
+				return nil, logErr(i, "%s", err)
+			}
+			ops[i].SignerIndex = idx
+			ops[i].SignerKey = keys[idx]
+			chain = append(chain, i)
+			continue
+		}
+
+		// fork: this operation nullifies all later operations in the chain, and needs a higher-priority key than the first of them
+		disputed := ops[chain[pos+1]]
+		if disputed.SignerIndex <= 0 {
+			return nil, logErr(i, "nullifies an operation signed by the highest-priority rotation key")
+		}
+		idx, err := op.VerifySignature(keys[:disputed.SignerIndex])
+		if err != nil {
+			return nil, logErr(i, "recovery operation not signed by a higher-priority rotation key: %s", err)
+		}
+		if times[i].Sub(times[chain[pos+1]]) > RecoveryWindow {
+			return nil, logErr(i, "recovery operation is outside the %s recovery window", RecoveryWindow)
+		}
+		ops[i].SignerIndex = idx
+		ops[i].SignerKey = keys[idx]
+		for _, n := range chain[pos+1:] {
+			ops[n].Nullified = true
+		}
+		chain = append(chain[:pos+1], i)
+	}
+
+	for i := range ops {
+		if ops[i].Nullified != entries[i].Nullified {
+			return nil, logErr(i, "nullified status does not match log (computed %t)", ops[i].Nullified)
+		}
+	}
+
+	return &VerifiedLog{
+		DID:     did,
+		Ops:     ops,
+		Current: entries[chain[len(chain)-1]].Operation,
+	}, nil
+}
+
+// Whether the DID has been permanently deactivated
+func (v *VerifiedLog) Tombstoned() bool {
+	return v.Current.Type == OpTypeTombstone
+}
+
+// Derives the current DID document from the verified log, in the same format as the PLC directory. Returns an error wrapping identity.ErrDIDNotFound if the DID has been tombstoned.
+func (v *VerifiedLog) DIDDocument() (*identity.DIDDocument, error) {
+	if v.Tombstoned() {
+		return nil, fmt.Errorf("%w: DID has been tombstoned", identity.ErrDIDNotFound)
+	}
+	op := v.Current.Normalize()
+	did := v.DID.String()
+
+	doc := identity.DIDDocument{
+		DID:         v.DID,
+		AlsoKnownAs: op.AlsoKnownAs,
+	}
+
+	ids := make([]string, 0, len(op.VerificationMethods))
+	for id := range op.VerificationMethods {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		doc.VerificationMethod = append(doc.VerificationMethod, identity.DocVerificationMethod{
+			ID:                 did + "#" + id,
+			Type:               "Multikey",
+			Controller:         did,
+			PublicKeyMultibase: strings.TrimPrefix(op.VerificationMethods[id], "did:key:"),
+		})
+	}
+
+	names := make([]string, 0, len(op.Services))
+	for name := range op.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		svc := op.Services[name]
+		doc.Service = append(doc.Service, identity.DocService{
+			ID:              "#" + name,
+			Type:            svc.Type,
+			ServiceEndpoint: svc.Endpoint,
+		})
+	}
+	return &doc, nil
+}
+
+// Parses an audit log (as JSON), as returned by the PLC directory. The log is not verified.
+func ParseAuditLog(auditLog []byte) ([]LogEntry, error) {
+	var entries []LogEntry
+	if err := json.Unmarshal(auditLog, &entries); err != nil {
+		return nil, fmt.Errorf("%w: JSON parse: %w", ErrInvalidLog, err)
+	}
+	return entries, nil
+}
+
+// Parses and verifies an audit log (as JSON), and derives the current DID document. This has the signature expected by identity.BaseDirectory's PLCLogVerifier field.
+func VerifyAuditLogJSON(did syntax.DID, auditLog []byte) (*identity.DIDDocument, error) {
+	entries, err := ParseAuditLog(auditLog)
+	if err != nil {
+		return nil, err
+	}
+	v, err := VerifyLog(did, entries)
+	if err != nil {
+		return nil, err
+	}
+	return v.DIDDocument()
+}
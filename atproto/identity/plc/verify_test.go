@@ -0,0 +1,346 @@
+package plc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKey struct {
+	priv   crypto.PrivateKey
+	didKey string
+}
+
+func newTestKey(t *testing.T) testKey {
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{priv: priv, didKey: pub.DIDKey()}
+}
+
+// helper for building operation logs
+type testLog struct {
+	t       *testing.T
+	did     syntax.DID
+	entries []LogEntry
+	start   time.Time
+}
+
+func (l *testLog) add(op Op, signer testKey, prev int, offset time.Duration) int {
+	if prev >= 0 {
+		cid := l.entries[prev].CID
+		op.Prev = &cid
+	}
+	if err := op.Sign(signer.priv); err != nil {
+		l.t.Fatal(err)
+	}
+	cid, err := op.CID()
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	if prev < 0 {
+		l.did, err = op.DID()
+		if err != nil {
+			l.t.Fatal(err)
+		}
+	}
+	l.entries = append(l.entries, LogEntry{
+		DID:       l.did.String(),
+		Operation: op,
+		CID:       cid.String(),
+		CreatedAt: l.start.Add(offset).UTC().Format(syntax.AtprotoDatetimeLayout),
+	})
+	return len(l.entries) - 1
+}
+
+func testOp(rotation []testKey, signing testKey, handle string) Op {
+	keys := []string{}
+	for _, k := range rotation {
+		keys = append(keys, k.didKey)
+	}
+	return Op{
+		Type:                OpTypeOperation,
+		RotationKeys:        keys,
+		VerificationMethods: map[string]string{"atproto": signing.didKey},
+		AlsoKnownAs:         []string{"at://" + handle},
+		Services: map[string]Service{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", Endpoint: "https://pds.example.com"},
+		},
+	}
+}
+
+func TestVerifyLogBasics(t *testing.T) {
+	assert := assert.New(t)
+	recovery, rotation, signing := newTestKey(t), newTestKey(t), newTestKey(t)
+
+	l := testLog{t: t, start: time.Now().Add(-10 * 24 * time.Hour)}
+	g := l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, -1, 0)
+	l.add(testOp([]testKey{recovery, rotation}, signing, "alice2.example.com"), rotation, g, time.Hour)
+
+	v, err := VerifyLog(l.did, l.entries)
+	assert.NoError(err)
+	assert.Equal(2, len(v.Ops))
+	assert.Equal(1, v.Ops[1].SignerIndex)
+	assert.Equal(rotation.didKey, v.Ops[1].SignerKey)
+	assert.False(v.Tombstoned())
+
+	doc, err := v.DIDDocument()
+	assert.NoError(err)
+	ident := identity.ParseIdentity(doc)
+	hdl, err := ident.DeclaredHandle()
+	assert.NoError(err)
+	assert.Equal(syntax.Handle("alice2.example.com"), hdl)
+	assert.Equal("https://pds.example.com", ident.PDSEndpoint())
+	pub, err := ident.PublicKey()
+	assert.NoError(err)
+	assert.Equal(signing.didKey, pub.DIDKey())
+
+	// same, via JSON
+	b, err := json.Marshal(l.entries)
+	assert.NoError(err)
+	doc2, err := VerifyAuditLogJSON(l.did, b)
+	assert.NoError(err)
+	assert.Equal(doc, doc2)
+
+	// wrong DID
+	_, err = VerifyLog(syntax.DID("did:plc:aaaaaaaaaaaaaaaaaaaaaaaa"), l.entries)
+	assert.ErrorIs(err, ErrInvalidLog)
+}
+
+func TestVerifyLogInvalid(t *testing.T) {
+	assert := assert.New(t)
+	recovery, rotation, signing, other := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+
+	// update signed by a key which isn't a rotation key
+	l := testLog{t: t, start: time.Now()}
+	g := l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, -1, 0)
+	l.add(testOp([]testKey{recovery, rotation}, signing, "evil.example.com"), signing, g, time.Hour)
+	_, err := VerifyLog(l.did, l.entries)
+	assert.ErrorIs(err, ErrInvalidLog)
+
+	// tampered operation (CID no longer matches)
+	l = testLog{t: t, start: time.Now()}
+	g = l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, -1, 0)
+	l.add(testOp([]testKey{recovery, rotation}, signing, "alice2.example.com"), rotation, g, time.Hour)
+	l.entries[1].Operation.AlsoKnownAs = []string{"at://evil.example.com"}
+	_, err = VerifyLog(l.did, l.entries)
+	assert.ErrorIs(err, ErrInvalidLog)
+
+	// tampered operation, with re-computed CID (signature no longer matches)
+	cid, err := l.entries[1].Operation.CID()
+	assert.NoError(err)
+	l.entries[1].CID = cid.String()
+	_, err = VerifyLog(l.did, l.entries)
+	assert.ErrorIs(err, ErrInvalidLog)
+
+	// rotation keys are replaced; the old keys have no authority
+	l = testLog{t: t, start: time.Now()}
+	g = l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, -1, 0)
+	u := l.add(testOp([]testKey{other}, signing, "alice.example.com"), rotation, g, time.Hour)
+	l.add(testOp([]testKey{rotation}, signing, "alice.example.com"), rotation, u, 2*time.Hour)
+	_, err = VerifyLog(l.did, l.entries)
+	assert.ErrorIs(err, ErrInvalidLog)
+}
+
+func TestVerifyLogRecovery(t *testing.T) {
+	assert := assert.New(t)
+	recovery, rotation, signing, attacker := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+
+	// an update signed by the lower-priority key is nullified by the recovery key, within the window
+	l := testLog{t: t, start: time.Now().Add(-10 * 24 * time.Hour)}
+	g := l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, -1, 0)
+	bad := l.add(testOp([]testKey{attacker}, attacker, "evil.example.com"), rotation, g, time.Hour)
+	l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), recovery, g, 48*time.Hour)
+	l.entries[bad].Nullified = true
+
+	v, err := VerifyLog(l.did, l.entries)
+	assert.NoError(err)
+	assert.True(v.Ops[1].Nullified)
+	assert.Equal(0, v.Ops[2].SignerIndex)
+	doc, err := v.DIDDocument()
+	assert.NoError(err)
+	assert.Equal([]string{"at://alice.example.com"}, doc.AlsoKnownAs)
+
+	// the directory must report the nullification
+	l.entries[bad].Nullified = false
+	_, err = VerifyLog(l.did, l.entries)
+	assert.ErrorIs(err, ErrInvalidLog)
+
+	// recovery too late
+	l = testLog{t: t, start: time.Now().Add(-10 * 24 * time.Hour)}
+	g = l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, -1, 0)
+	bad = l.add(testOp([]testKey{attacker}, attacker, "evil.example.com"), rotation, g, time.Hour)
+	l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), recovery, g, 96*time.Hour)
+	l.entries[bad].Nullified = true
+	_, err = VerifyLog(l.did, l.entries)
+	assert.ErrorIs(err, ErrInvalidLog)
+
+	// fork signed by a key without higher priority
+	l = testLog{t: t, start: time.Now().Add(-10 * 24 * time.Hour)}
+	g = l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, -1, 0)
+	bad = l.add(testOp([]testKey{recovery, rotation}, signing, "alice2.example.com"), rotation, g, time.Hour)
+	l.add(testOp([]testKey{recovery, rotation}, signing, "alice3.example.com"), rotation, g, 2*time.Hour)
+	l.entries[bad].Nullified = true
+	_, err = VerifyLog(l.did, l.entries)
+	assert.ErrorIs(err, ErrInvalidLog)
+}
+
+func TestVerifyLogTombstone(t *testing.T) {
+	assert := assert.New(t)
+	recovery, rotation, signing := newTestKey(t), newTestKey(t), newTestKey(t)
+
+	l := testLog{t: t, start: time.Now()}
+	g := l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, -1, 0)
+	l.add(Op{Type: OpTypeTombstone}, rotation, g, time.Hour)
+
+	v, err := VerifyLog(l.did, l.entries)
+	assert.NoError(err)
+	assert.True(v.Tombstoned())
+	_, err = v.DIDDocument()
+	assert.ErrorIs(err, identity.ErrDIDNotFound)
+
+	// no regular operations after a tombstone
+	l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, 1, 2*time.Hour)
+	_, err = VerifyLog(l.did, l.entries)
+	assert.ErrorIs(err, ErrInvalidLog)
+}
+
+func TestLegacyCreateOp(t *testing.T) {
+	assert := assert.New(t)
+	recovery, signing := newTestKey(t), newTestKey(t)
+
+	l := testLog{t: t, start: time.Now()}
+	l.add(Op{
+		Type:        OpTypeLegacyCreate,
+		SigningKey:  signing.didKey,
+		RecoveryKey: recovery.didKey,
+		Handle:      "alice.example.com",
+		Service:     "https://pds.example.com",
+	}, signing, -1, 0)
+
+	v, err := VerifyLog(l.did, l.entries)
+	assert.NoError(err)
+	assert.Equal(1, v.Ops[0].SignerIndex)
+	doc, err := v.DIDDocument()
+	assert.NoError(err)
+	assert.Equal([]string{"at://alice.example.com"}, doc.AlsoKnownAs)
+	assert.Equal("https://pds.example.com", doc.Service[0].ServiceEndpoint)
+}
+
+// legacy genesis operation signed by the original did:plc implementation (the P-256 test vector from the indigo `plc` package), which has a bare hostname as the service
+func TestLegacyCreateVector(t *testing.T) {
+	assert := assert.New(t)
+
+	op := Op{
+		Type:        OpTypeLegacyCreate,
+		SigningKey:  "did:key:zDnaeRSYs7c2NpcNA5NRAUqS8DCkLWDyNLnATi28D6w7no7hX",
+		RecoveryKey: "did:key:zDnaeRSYs7c2NpcNA5NRAUqS8DCkLWDyNLnATi28D6w7no7hX",
+		Handle:      "why.bsky.social",
+		Service:     "bsky.social",
+		Sig:         "e8h6dCx405Z_95cZWWkZtfLgDPvfdXDG9pCZQi1NhduooZgb4d1w-CzahA3J-iNGCCgP3D0O5l997G3vQfxKOA",
+	}
+	did, err := op.DID()
+	assert.NoError(err)
+	assert.Equal(syntax.DID("did:plc:unnby7mqlcvj5j4kxfpqgnyj"), did)
+	c, err := op.CID()
+	assert.NoError(err)
+
+	v, err := VerifyLog(did, []LogEntry{{DID: did.String(), Operation: op, CID: c.String(), CreatedAt: "2022-10-01T00:00:00.000Z"}})
+	assert.NoError(err)
+	assert.Equal(0, v.Ops[0].SignerIndex)
+	doc, err := v.DIDDocument()
+	assert.NoError(err)
+	assert.Equal([]string{"at://why.bsky.social"}, doc.AlsoKnownAs)
+	assert.Equal("https://bsky.social", doc.Service[0].ServiceEndpoint)
+}
+
+func TestBaseDirectoryVerifier(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	recovery, rotation, signing := newTestKey(t), newTestKey(t), newTestKey(t)
+
+	l := testLog{t: t, start: time.Now()}
+	l.add(testOp([]testKey{recovery, rotation}, signing, "alice.example.com"), rotation, -1, 0)
+
+	tampered := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+l.did.String()+"/log/audit" {
+			http.NotFound(w, r)
+			return
+		}
+		entries := l.entries
+		if tampered {
+			entries = append([]LogEntry{}, l.entries...)
+			entries[0].Operation.AlsoKnownAs = []string{"at://evil.example.com"}
+		}
+		json.NewEncoder(w).Encode(entries)
+	}))
+	defer srv.Close()
+
+	dir := identity.BaseDirectory{
+		PLCURL:         srv.URL,
+		PLCLogVerifier: VerifyAuditLogJSON,
+	}
+	doc, err := dir.ResolveDID(ctx, l.did)
+	assert.NoError(err)
+	assert.Equal([]string{"at://alice.example.com"}, doc.AlsoKnownAs)
+
+	tampered = true
+	_, err = dir.ResolveDID(ctx, l.did)
+	assert.ErrorIs(err, identity.ErrDIDResolutionFailed)
+
+	_, err = dir.ResolveDID(ctx, syntax.DID("did:plc:aaaaaaaaaaaaaaaaaaaaaaaa"))
+	assert.ErrorIs(err, identity.ErrDIDNotFound)
+}
+
+func TestVerifyLogFixture(t *testing.T) {
+	assert := assert.New(t)
+
+	// audit log in the PLC directory's format: a legacy "create" genesis, an update signed by the lower-priority (signing) key, and a fork from the genesis signed by the recovery key, which nullified the update
+	auditLog, err := os.ReadFile("testdata/audit_log_legacy_recovery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docJSON, err := os.ReadFile("testdata/audit_log_legacy_recovery_doc.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := syntax.DID("did:plc:jiae5wrct5vq4arlnulfmtwz")
+
+	entries, err := ParseAuditLog(auditLog)
+	assert.NoError(err)
+	assert.Equal(3, len(entries))
+	assert.Equal(OpTypeLegacyCreate, entries[0].Operation.Type)
+
+	v, err := VerifyLog(did, entries)
+	assert.NoError(err)
+	assert.Equal(3, len(v.Ops))
+	assert.Equal(1, v.Ops[0].SignerIndex)
+	assert.Equal(entries[0].Operation.SigningKey, v.Ops[0].SignerKey)
+	assert.Equal(1, v.Ops[1].SignerIndex)
+	assert.True(v.Ops[1].Nullified)
+	assert.Equal(0, v.Ops[2].SignerIndex)
+	assert.Equal(entries[0].Operation.RecoveryKey, v.Ops[2].SignerKey)
+	assert.False(v.Ops[2].Nullified)
+
+	doc, err := VerifyAuditLogJSON(did, auditLog)
+	assert.NoError(err)
+	b, err := json.Marshal(doc)
+	assert.NoError(err)
+	assert.JSONEq(string(docJSON), string(b))
+}
@@ -0,0 +1,6 @@
+/*
+Identity Directory implementation with persistent caching in an SQL database (PostgreSQL or SQLite, via gorm).
+
+Cached handle and DID resolutions survive process restarts, without needing a shared network cache like Redis. This is intended for smaller services which want a warm identity cache on local disk.
+*/
+package sqldir
@@ -0,0 +1,569 @@
+package sqldir
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Persisted handle resolution result
+type HandleEntry struct {
+	Handle string `gorm:"primaryKey"`
+	// empty if resolution failed
+	DID string `gorm:"column:did"`
+	// error message, if resolution failed
+	Err string
+	// message of the well-known identity package error which Err wraps, if any
+	ErrKind string
+	Updated time.Time `gorm:"index"`
+}
+
+func (HandleEntry) TableName() string {
+	return "identity_handle"
+}
+
+// Persisted DID resolution result
+type IdentityEntry struct {
+	DID string `gorm:"column:did;primaryKey"`
+	// JSON-encoded `identity.Identity`; empty if resolution failed
+	Identity []byte
+	// hostname (and port) of the account's PDS, if any
+	PDSHost string `gorm:"column:pds_host;index"`
+	// error message, if resolution failed
+	Err string
+	// message of the well-known identity package error which Err wraps, if any
+	ErrKind string
+	Updated time.Time `gorm:"index"`
+}
+
+func (IdentityEntry) TableName() string {
+	return "identity_did"
+}
+
+// Uses an SQL database as a persistent cache for identity lookups.
+//
+// Caching semantics (TTLs) are the same as `identity.CacheDirectory`. Expired entries are ignored on read, and deleted by Refresh.
+type SQLDirectory struct {
+	Inner            identity.Directory
+	HitTTL           time.Duration
+	ErrTTL           time.Duration
+	InvalidHandleTTL time.Duration
+	// Successful identity entries which will expire within this period are re-resolved by Refresh, to keep the cache warm. Zero disables background refresh.
+	RefreshWindow time.Duration
+	// Maximum number of identities re-resolved by a single call to Refresh
+	RefreshBatchSize int
+
+	db                *gorm.DB
+	didLookupChans    sync.Map
+	handleLookupChans sync.Map
+}
+
+var _ identity.Directory = (*SQLDirectory)(nil)
+
+// identity package errors which are preserved (for `errors.Is`) when cached
+var knownErrors = []error{
+	identity.ErrHandleResolutionFailed,
+	identity.ErrHandleNotFound,
+	identity.ErrHandleMismatch,
+	identity.ErrHandleNotDeclared,
+	identity.ErrHandleReservedTLD,
+	identity.ErrDIDNotFound,
+	identity.ErrDIDResolutionFailed,
+	identity.ErrKeyNotDeclared,
+	identity.ErrInvalidHandle,
+}
+
+// error restored from the database
+type cachedError struct {
+	msg  string
+	kind error
+}
+
+func (e *cachedError) Error() string {
+	return e.msg
+}
+
+func (e *cachedError) Unwrap() error {
+	return e.kind
+}
+
+func encodeErr(err error) (string, string) {
+	for _, k := range knownErrors {
+		if errors.Is(err, k) {
+			return err.Error(), k.Error()
+		}
+	}
+	return err.Error(), ""
+}
+
+func decodeErr(msg, kind string) error {
+	if msg == "" {
+		return nil
+	}
+	ce := cachedError{msg: msg}
+	for _, k := range knownErrors {
+		if kind == k.Error() {
+			ce.kind = k
+		}
+	}
+	return &ce
+}
+
+// Creates a new caching `identity.Directory` wrapper around an existing directory, persisting results to the provided database. The database tables are created or migrated as needed.
+//
+// `hitTTL` and `errTTL` define how long successful and errored identity metadata should be cached (respectively). errTTL is expected to be shorted than hitTTL. A hitTTL of zero means unlimited duration.
+//
+// NOTE: Errors returned for cached failures are not identical to the original errors. Their message is preserved, and they wrap the same `identity` package error (eg, `identity.ErrDIDNotFound`), if any.
+func NewSQLDirectory(inner identity.Directory, db *gorm.DB, hitTTL, errTTL, invalidHandleTTL time.Duration) (*SQLDirectory, error) {
+	if err := db.AutoMigrate(&HandleEntry{}, &IdentityEntry{}); err != nil {
+		return nil, fmt.Errorf("migrating identity cache tables: %w", err)
+	}
+	return &SQLDirectory{
+		Inner:            inner,
+		HitTTL:           hitTTL,
+		ErrTTL:           errTTL,
+		InvalidHandleTTL: invalidHandleTTL,
+		RefreshBatchSize: 1000,
+		db:               db,
+	}, nil
+}
+
+func (d *SQLDirectory) isExpired(updated time.Time) bool {
+	return d.HitTTL > 0 && time.Since(updated) > d.HitTTL
+}
+
+func (d *SQLDirectory) isHandleStale(e *HandleEntry) bool {
+	if d.isExpired(e.Updated) {
+		return true
+	}
+	if e.Err != "" && time.Since(e.Updated) > d.ErrTTL {
+		return true
+	}
+	return false
+}
+
+func (d *SQLDirectory) isIdentityStale(e *IdentityEntry, ident *identity.Identity) bool {
+	if d.isExpired(e.Updated) {
+		return true
+	}
+	if e.Err != "" && time.Since(e.Updated) > d.ErrTTL {
+		return true
+	}
+	if ident != nil && ident.Handle.IsInvalidHandle() && time.Since(e.Updated) > d.InvalidHandleTTL {
+		return true
+	}
+	return false
+}
+
+// Returns the hostname (and port) of the identity's PDS, or empty string
+func pdsHost(ident *identity.Identity) string {
+	u, err := url.Parse(ident.PDSEndpoint())
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// Reads a cached handle entry, or nil if there is no fresh entry
+func (d *SQLDirectory) getHandle(ctx context.Context, h syntax.Handle) (*HandleEntry, error) {
+	var row HandleEntry
+	res := d.db.WithContext(ctx).Where("handle = ?", h.String()).Limit(1).Find(&row)
+	if res.Error != nil {
+		return nil, fmt.Errorf("identity cache read failed: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	if d.isHandleStale(&row) {
+		return nil, nil
+	}
+	return &row, nil
+}
+
+// Reads a cached identity entry, or nil (for both return values) if there is no fresh entry
+func (d *SQLDirectory) getIdentity(ctx context.Context, did syntax.DID) (*IdentityEntry, *identity.Identity, error) {
+	var row IdentityEntry
+	res := d.db.WithContext(ctx).Where("did = ?", did.String()).Limit(1).Find(&row)
+	if res.Error != nil {
+		return nil, nil, fmt.Errorf("identity cache read failed: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil, nil
+	}
+	var ident *identity.Identity
+	if len(row.Identity) > 0 {
+		ident = &identity.Identity{}
+		if err := json.Unmarshal(row.Identity, ident); err != nil {
+			return nil, nil, fmt.Errorf("identity cache entry invalid: %w", err)
+		}
+	}
+	if d.isIdentityStale(&row, ident) {
+		return nil, nil, nil
+	}
+	return &row, ident, nil
+}
+
+func (d *SQLDirectory) putHandle(ctx context.Context, row *HandleEntry) {
+	err := d.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(row).Error
+	if err != nil {
+		slog.Error("identity cache write failed", "cache", "handle", "handle", row.Handle, "err", err)
+	}
+}
+
+func (d *SQLDirectory) putIdentity(ctx context.Context, row *IdentityEntry) {
+	err := d.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(row).Error
+	if err != nil {
+		slog.Error("identity cache write failed", "cache", "did", "did", row.DID, "err", err)
+	}
+}
+
+func newIdentityEntry(did syntax.DID, ident *identity.Identity, lookupErr error) (*IdentityEntry, error) {
+	row := IdentityEntry{
+		DID:     did.String(),
+		Updated: time.Now(),
+	}
+	if lookupErr != nil {
+		row.Err, row.ErrKind = encodeErr(lookupErr)
+		return &row, nil
+	}
+	b, err := json.Marshal(ident)
+	if err != nil {
+		return nil, err
+	}
+	row.Identity = b
+	row.PDSHost = pdsHost(ident)
+	return &row, nil
+}
+
+func (d *SQLDirectory) updateHandle(ctx context.Context, h syntax.Handle) HandleEntry {
+	h = h.Normalize()
+	ident, err := d.Inner.LookupHandle(ctx, h)
+	if err != nil {
+		he := HandleEntry{
+			Handle:  h.String(),
+			Updated: time.Now(),
+		}
+		he.Err, he.ErrKind = encodeErr(err)
+		d.putHandle(ctx, &he)
+		return he
+	}
+
+	he := HandleEntry{
+		Handle:  h.String(),
+		DID:     ident.DID.String(),
+		Updated: time.Now(),
+	}
+	entry, err := newIdentityEntry(ident.DID, ident, nil)
+	if err != nil {
+		slog.Error("identity cache write failed", "cache", "did", "did", ident.DID, "err", err)
+	} else {
+		d.putIdentity(ctx, entry)
+	}
+	d.putHandle(ctx, &he)
+	return he
+}
+
+func (d *SQLDirectory) ResolveHandle(ctx context.Context, h syntax.Handle) (syntax.DID, error) {
+	start := time.Now()
+	if h.IsInvalidHandle() {
+		return "", fmt.Errorf("can not resolve handle: %w", identity.ErrInvalidHandle)
+	}
+	h = h.Normalize()
+	entry, err := d.getHandle(ctx, h)
+	if err != nil {
+		handleResolution.WithLabelValues("sqldir", "error").Inc()
+		handleResolutionDuration.WithLabelValues("sqldir", "error").Observe(time.Since(start).Seconds())
+		return "", err
+	}
+	if entry != nil {
+		handleResolution.WithLabelValues("sqldir", "cached").Inc()
+		handleResolutionDuration.WithLabelValues("sqldir", "cached").Observe(time.Since(start).Seconds())
+		if entry.Err != "" {
+			return "", decodeErr(entry.Err, entry.ErrKind)
+		}
+		return syntax.DID(entry.DID), nil
+	}
+
+	// Coalesce multiple requests for the same Handle
+	res := make(chan struct{})
+	val, loaded := d.handleLookupChans.LoadOrStore(h.String(), res)
+	if loaded {
+		handleResolution.WithLabelValues("sqldir", "coalesced").Inc()
+		handleResolutionDuration.WithLabelValues("sqldir", "coalesced").Observe(time.Since(start).Seconds())
+		// Wait for the result from the pending request
+		select {
+		case <-val.(chan struct{}):
+			// The result should now be in the cache
+			entry, err := d.getHandle(ctx, h)
+			if err != nil {
+				return "", err
+			}
+			if entry != nil {
+				if entry.Err != "" {
+					return "", decodeErr(entry.Err, entry.ErrKind)
+				}
+				return syntax.DID(entry.DID), nil
+			}
+			return "", errors.New("identity not found in cache after coalesce returned")
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	// Update the Handle Entry from PLC and cache the result
+	newEntry := d.updateHandle(ctx, h)
+
+	// Cleanup the coalesce map and close the results channel
+	d.handleLookupChans.Delete(h.String())
+	// Callers waiting will now get the result from the cache
+	close(res)
+
+	if newEntry.Err != "" {
+		handleResolution.WithLabelValues("sqldir", "error").Inc()
+		handleResolutionDuration.WithLabelValues("sqldir", "error").Observe(time.Since(start).Seconds())
+		return "", decodeErr(newEntry.Err, newEntry.ErrKind)
+	}
+	handleResolution.WithLabelValues("sqldir", "success").Inc()
+	handleResolutionDuration.WithLabelValues("sqldir", "success").Observe(time.Since(start).Seconds())
+	return syntax.DID(newEntry.DID), nil
+}
+
+// Resolves the DID with the inner directory, and persists the result. Returns the identity and (un-cached) lookup error.
+func (d *SQLDirectory) updateDID(ctx context.Context, did syntax.DID) (*identity.Identity, error) {
+	ident, lookupErr := d.Inner.LookupDID(ctx, did)
+	d.storeDID(ctx, did, ident, lookupErr)
+	return ident, lookupErr
+}
+
+func (d *SQLDirectory) storeDID(ctx context.Context, did syntax.DID, ident *identity.Identity, lookupErr error) {
+	entry, err := newIdentityEntry(did, ident, lookupErr)
+	if err != nil {
+		slog.Error("identity cache write failed", "cache", "did", "did", did, "err", err)
+		return
+	}
+	d.putIdentity(ctx, entry)
+
+	// if *not* an error, then also update the handle cache
+	if lookupErr == nil && !ident.Handle.IsInvalidHandle() {
+		d.putHandle(ctx, &HandleEntry{
+			Handle:  ident.Handle.String(),
+			DID:     did.String(),
+			Updated: time.Now(),
+		})
+	}
+}
+
+func (d *SQLDirectory) LookupDID(ctx context.Context, did syntax.DID) (*identity.Identity, error) {
+	id, _, err := d.LookupDIDWithCacheState(ctx, did)
+	return id, err
+}
+
+func (d *SQLDirectory) LookupDIDWithCacheState(ctx context.Context, did syntax.DID) (*identity.Identity, bool, error) {
+	start := time.Now()
+	entry, ident, err := d.getIdentity(ctx, did)
+	if err != nil {
+		didResolution.WithLabelValues("sqldir", "error").Inc()
+		didResolutionDuration.WithLabelValues("sqldir", "error").Observe(time.Since(start).Seconds())
+		return nil, false, err
+	}
+	if entry != nil {
+		didResolution.WithLabelValues("sqldir", "cached").Inc()
+		didResolutionDuration.WithLabelValues("sqldir", "cached").Observe(time.Since(start).Seconds())
+		return ident, true, decodeErr(entry.Err, entry.ErrKind)
+	}
+
+	// Coalesce multiple requests for the same DID
+	res := make(chan struct{})
+	val, loaded := d.didLookupChans.LoadOrStore(did.String(), res)
+	if loaded {
+		didResolution.WithLabelValues("sqldir", "coalesced").Inc()
+		didResolutionDuration.WithLabelValues("sqldir", "coalesced").Observe(time.Since(start).Seconds())
+		// Wait for the result from the pending request
+		select {
+		case <-val.(chan struct{}):
+			// The result should now be in the cache
+			entry, ident, err := d.getIdentity(ctx, did)
+			if err != nil {
+				return nil, false, err
+			}
+			if entry != nil {
+				return ident, false, decodeErr(entry.Err, entry.ErrKind)
+			}
+			return nil, false, errors.New("identity not found in cache after coalesce returned")
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+
+	// Update the Identity Entry from PLC and cache the result
+	ident, err = d.updateDID(ctx, did)
+
+	// Cleanup the coalesce map and close the results channel
+	d.didLookupChans.Delete(did.String())
+	// Callers waiting will now get the result from the cache
+	close(res)
+
+	if err != nil {
+		didResolution.WithLabelValues("sqldir", "error").Inc()
+		didResolutionDuration.WithLabelValues("sqldir", "error").Observe(time.Since(start).Seconds())
+		return nil, false, err
+	}
+	didResolution.WithLabelValues("sqldir", "success").Inc()
+	didResolutionDuration.WithLabelValues("sqldir", "success").Observe(time.Since(start).Seconds())
+	return ident, false, nil
+}
+
+func (d *SQLDirectory) LookupHandle(ctx context.Context, h syntax.Handle) (*identity.Identity, error) {
+	ident, _, err := d.LookupHandleWithCacheState(ctx, h)
+	return ident, err
+}
+
+func (d *SQLDirectory) LookupHandleWithCacheState(ctx context.Context, h syntax.Handle) (*identity.Identity, bool, error) {
+	h = h.Normalize()
+	did, err := d.ResolveHandle(ctx, h)
+	if err != nil {
+		return nil, false, err
+	}
+	ident, hit, err := d.LookupDIDWithCacheState(ctx, did)
+	if err != nil {
+		return nil, hit, err
+	}
+
+	declared, err := ident.DeclaredHandle()
+	if err != nil {
+		return nil, hit, fmt.Errorf("could not verify handle/DID mapping: %w", err)
+	}
+	if declared != h {
+		return nil, hit, fmt.Errorf("%w: %s != %s", identity.ErrHandleMismatch, declared, h)
+	}
+	return ident, hit, nil
+}
+
+func (d *SQLDirectory) Lookup(ctx context.Context, a syntax.AtIdentifier) (*identity.Identity, error) {
+	handle, err := a.AsHandle()
+	if err == nil { // if not an error, is a handle
+		return d.LookupHandle(ctx, handle)
+	}
+	did, err := a.AsDID()
+	if err == nil { // if not an error, is a DID
+		return d.LookupDID(ctx, did)
+	}
+	return nil, errors.New("at-identifier neither a Handle nor a DID")
+}
+
+func (d *SQLDirectory) Purge(ctx context.Context, a syntax.AtIdentifier) error {
+	handle, err := a.AsHandle()
+	if err == nil { // if not an error, is a handle
+		handle = handle.Normalize()
+		return d.db.WithContext(ctx).Where("handle = ?", handle.String()).Delete(&HandleEntry{}).Error
+	}
+	did, err := a.AsDID()
+	if err == nil { // if not an error, is a DID
+		return d.db.WithContext(ctx).Where("did = ?", did.String()).Delete(&IdentityEntry{}).Error
+	}
+	return errors.New("at-identifier neither a Handle nor a DID")
+}
+
+// Flushes all cached identities hosted on the given PDS, along with any handles which resolved to them. The host can be a bare hostname (with optional port), or a URL. Returns the number of identities purged.
+//
+// This is useful when a PDS is migrated, taken down, or otherwise has bulk identity changes.
+func (d *SQLDirectory) PurgeHost(ctx context.Context, host string) (int64, error) {
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	host = strings.ToLower(host)
+	if host == "" {
+		return 0, errors.New("empty PDS host")
+	}
+
+	var count int64
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		dids := tx.Model(&IdentityEntry{}).Select("did").Where("pds_host = ?", host)
+		if err := tx.Where("did IN (?)", dids).Delete(&HandleEntry{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("pds_host = ?", host).Delete(&IdentityEntry{})
+		count = res.RowsAffected
+		return res.Error
+	})
+	return count, err
+}
+
+// Deletes expired entries, then re-resolves (up to RefreshBatchSize) successfully resolved identities which will expire within RefreshWindow. Returns the number of identities refreshed.
+//
+// Does nothing if HitTTL is zero (entries never expire).
+func (d *SQLDirectory) Refresh(ctx context.Context) (int, error) {
+	if d.HitTTL <= 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	expired := now.Add(-d.HitTTL)
+	if err := d.db.WithContext(ctx).Where("updated < ?", expired).Delete(&HandleEntry{}).Error; err != nil {
+		return 0, err
+	}
+	if err := d.db.WithContext(ctx).Where("updated < ?", expired).Delete(&IdentityEntry{}).Error; err != nil {
+		return 0, err
+	}
+	if d.RefreshWindow <= 0 {
+		return 0, nil
+	}
+
+	var dids []string
+	err := d.db.WithContext(ctx).Model(&IdentityEntry{}).
+		Where("err = '' AND updated < ?", expired.Add(d.RefreshWindow)).
+		Order("updated").
+		Limit(d.RefreshBatchSize).
+		Pluck("did", &dids).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, raw := range dids {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		did, err := syntax.ParseDID(raw)
+		if err != nil {
+			continue
+		}
+		ident, err := d.Inner.LookupDID(ctx, did)
+		if err != nil && !errors.Is(err, identity.ErrDIDNotFound) {
+			// keep the existing entry on transient failures; it will expire normally
+			identityRefreshes.WithLabelValues("error").Inc()
+			continue
+		}
+		d.storeDID(ctx, did, ident, err)
+		identityRefreshes.WithLabelValues("success").Inc()
+	}
+	return len(dids), nil
+}
+
+// Runs Refresh periodically, until the context is cancelled.
+func (d *SQLDirectory) Run(ctx context.Context, period time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := d.Refresh(ctx)
+			if err != nil {
+				logger.Error("failed to refresh identity cache", "err", err)
+			} else if n > 0 {
+				logger.Debug("refreshed identity cache entries", "count", n)
+			}
+		}
+	}
+}
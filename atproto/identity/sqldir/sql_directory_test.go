@@ -0,0 +1,182 @@
+package sqldir
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// wraps a mock directory, counting lookups
+type countingDirectory struct {
+	identity.MockDirectory
+	lookups int
+}
+
+func (d *countingDirectory) LookupHandle(ctx context.Context, h syntax.Handle) (*identity.Identity, error) {
+	d.lookups++
+	return d.MockDirectory.LookupHandle(ctx, h)
+}
+
+func (d *countingDirectory) LookupDID(ctx context.Context, did syntax.DID) (*identity.Identity, error) {
+	d.lookups++
+	return d.MockDirectory.LookupDID(ctx, did)
+}
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "identity.sqlite")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func testIdentity(did syntax.DID, handle syntax.Handle, pds string) identity.Identity {
+	return identity.Identity{
+		DID:         did,
+		Handle:      handle,
+		AlsoKnownAs: []string{"at://" + handle.String()},
+		Services: map[string]identity.Service{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: pds},
+		},
+	}
+}
+
+func TestSQLDirectory(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := testDB(t)
+
+	inner := countingDirectory{MockDirectory: identity.NewMockDirectory()}
+	inner.Insert(testIdentity("did:plc:abc111", "alice.example.com", "https://pds.example.com"))
+	inner.Insert(testIdentity("did:plc:abc222", "bob.example.com", "https://other.example.com"))
+
+	dir, err := NewSQLDirectory(&inner, db, time.Hour, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ident, err := dir.LookupHandle(ctx, syntax.Handle("Alice.Example.com"))
+	assert.NoError(err)
+	assert.Equal(syntax.DID("did:plc:abc111"), ident.DID)
+	assert.Equal("https://pds.example.com", ident.PDSEndpoint())
+	assert.Equal(1, inner.lookups)
+
+	// cached, including the DID lookup
+	ident, hit, err := dir.LookupHandleWithCacheState(ctx, syntax.Handle("alice.example.com"))
+	assert.NoError(err)
+	assert.True(hit)
+	assert.Equal(syntax.Handle("alice.example.com"), ident.Handle)
+	_, err = dir.LookupDID(ctx, syntax.DID("did:plc:abc111"))
+	assert.NoError(err)
+	assert.Equal(1, inner.lookups)
+
+	// errors are cached, and still match package errors
+	_, err = dir.LookupDID(ctx, syntax.DID("did:plc:abc999"))
+	assert.ErrorIs(err, identity.ErrDIDNotFound)
+	_, hit, err = dir.LookupDIDWithCacheState(ctx, syntax.DID("did:plc:abc999"))
+	assert.ErrorIs(err, identity.ErrDIDNotFound)
+	assert.True(hit)
+	_, err = dir.LookupHandle(ctx, syntax.Handle("nobody.example.com"))
+	assert.ErrorIs(err, identity.ErrHandleNotFound)
+	_, err = dir.LookupHandle(ctx, syntax.Handle("nobody.example.com"))
+	assert.ErrorIs(err, identity.ErrHandleNotFound)
+	assert.Equal(3, inner.lookups)
+
+	// persisted across instances
+	other := countingDirectory{MockDirectory: identity.NewMockDirectory()}
+	dir2, err := NewSQLDirectory(&other, db, time.Hour, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident, err = dir2.LookupHandle(ctx, syntax.Handle("alice.example.com"))
+	assert.NoError(err)
+	assert.Equal(syntax.DID("did:plc:abc111"), ident.DID)
+	assert.Equal(0, other.lookups)
+
+	// purge
+	assert.NoError(dir.Purge(ctx, syntax.AtIdentifier{Inner: syntax.DID("did:plc:abc111")}))
+	_, hit, err = dir.LookupDIDWithCacheState(ctx, syntax.DID("did:plc:abc111"))
+	assert.NoError(err)
+	assert.False(hit)
+}
+
+func TestSQLDirectoryPurgeHost(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	inner := countingDirectory{MockDirectory: identity.NewMockDirectory()}
+	inner.Insert(testIdentity("did:plc:abc111", "alice.example.com", "https://pds.example.com"))
+	inner.Insert(testIdentity("did:plc:abc222", "bob.example.com", "https://other.example.com"))
+
+	dir, err := NewSQLDirectory(&inner, testDB(t), time.Hour, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []syntax.Handle{"alice.example.com", "bob.example.com"} {
+		_, err := dir.LookupHandle(ctx, h)
+		assert.NoError(err)
+	}
+	assert.Equal(2, inner.lookups)
+
+	n, err := dir.PurgeHost(ctx, "https://PDS.example.com/")
+	assert.NoError(err)
+	assert.Equal(int64(1), n)
+
+	// the handle was purged as well
+	_, err = dir.LookupHandle(ctx, syntax.Handle("alice.example.com"))
+	assert.NoError(err)
+	assert.Equal(3, inner.lookups)
+	_, err = dir.LookupHandle(ctx, syntax.Handle("bob.example.com"))
+	assert.NoError(err)
+	assert.Equal(3, inner.lookups)
+}
+
+func TestSQLDirectoryRefresh(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	inner := countingDirectory{MockDirectory: identity.NewMockDirectory()}
+	inner.Insert(testIdentity("did:plc:abc111", "alice.example.com", "https://pds.example.com"))
+
+	dir, err := NewSQLDirectory(&inner, testDB(t), time.Hour, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dir.LookupDID(ctx, syntax.DID("did:plc:abc111"))
+	assert.NoError(err)
+
+	// not close to expiring
+	dir.RefreshWindow = time.Minute
+	n, err := dir.Refresh(ctx)
+	assert.NoError(err)
+	assert.Equal(0, n)
+
+	// everything is within the window
+	inner.Insert(testIdentity("did:plc:abc111", "alice.example.com", "https://new.example.com"))
+	dir.RefreshWindow = 2 * time.Hour
+	n, err = dir.Refresh(ctx)
+	assert.NoError(err)
+	assert.Equal(1, n)
+
+	ident, hit, err := dir.LookupDIDWithCacheState(ctx, syntax.DID("did:plc:abc111"))
+	assert.NoError(err)
+	assert.True(hit)
+	assert.Equal("https://new.example.com", ident.PDSEndpoint())
+
+	// expired entries are deleted
+	dir.HitTTL = time.Nanosecond
+	dir.RefreshWindow = 0
+	_, err = dir.Refresh(ctx)
+	assert.NoError(err)
+	var count int64
+	assert.NoError(dir.db.Model(&IdentityEntry{}).Count(&count).Error)
+	assert.Equal(int64(0), count)
+}
@@ -0,0 +1,286 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+)
+
+// Wraps a Directory with concurrency control, for services which do a large number of lookups (eg, backfills).
+//
+// Concurrent lookups of the same identifier are coalesced in to a single request to the inner directory. Lookups are grouped by the remote host they will (mostly) hit: the PLC directory for did:plc, the hostname for did:web, and the parent domain for handles. Each host has its own concurrency and rate limits, so that a batch of lookups does not overwhelm any single host. If the inner directory implements Resolver, handle lookups are split in to handle resolution and DID resolution, so that each step is subject to the limits of the host it actually hits.
+//
+// ThrottledDirectory does not do any caching itself; it is usually wrapped around a caching directory, so that only cache misses are limited. If the inner directory also implements Resolver (as BaseDirectory does), so does ThrottledDirectory, which means it can be placed underneath a caching Resolver.
+type ThrottledDirectory struct {
+	Inner Directory
+	// Maximum number of concurrent lookups for a single call to BatchLookup
+	BatchConcurrency int
+	// Maximum concurrent lookups, and requests per second, for any single host. Zero means unlimited.
+	HostConcurrency int
+	HostRateLimit   rate.Limit
+	// Limits for the PLC directory (did:plc lookups), which is usually shared by most identities. Zero means unlimited.
+	PLCConcurrency int
+	PLCRateLimit   rate.Limit
+
+	group   singleflight.Group
+	hostsLk sync.Mutex
+	hosts   *lru.Cache[string, *hostLimiter]
+}
+
+var _ Directory = (*ThrottledDirectory)(nil)
+var _ BatchDirectory = (*ThrottledDirectory)(nil)
+var _ Resolver = (*ThrottledDirectory)(nil)
+
+// concurrency and rate limits for a single remote host
+type hostLimiter struct {
+	sem chan struct{}
+	lim *rate.Limiter
+}
+
+func newHostLimiter(concurrency int, limit rate.Limit) *hostLimiter {
+	h := hostLimiter{}
+	if concurrency > 0 {
+		h.sem = make(chan struct{}, concurrency)
+	}
+	if limit > 0 {
+		burst := concurrency
+		if burst < 1 {
+			burst = 1
+		}
+		h.lim = rate.NewLimiter(limit, burst)
+	}
+	return &h
+}
+
+func (h *hostLimiter) acquire(ctx context.Context) error {
+	if h.sem != nil {
+		select {
+		case h.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if h.lim != nil {
+		if err := h.lim.Wait(ctx); err != nil {
+			h.release()
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *hostLimiter) release() {
+	if h.sem != nil {
+		<-h.sem
+	}
+}
+
+// Creates a new ThrottledDirectory with the given per-host limits, which apply to the PLC directory as well. The PLC-specific limits can be adjusted on the returned struct.
+//
+// `hostConcurrency` of zero means unlimited; `hostRateLimit` (requests per second) of zero means no rate limit.
+func NewThrottledDirectory(inner Directory, hostConcurrency int, hostRateLimit rate.Limit) *ThrottledDirectory {
+	// NOTE: limiters which are evicted while in use will be re-created, which could temporarily exceed the host limits. This only happens with a very large number of distinct hosts.
+	hosts, err := lru.New[string, *hostLimiter](10_000)
+	if err != nil {
+		panic(err)
+	}
+	return &ThrottledDirectory{
+		Inner:            inner,
+		BatchConcurrency: 50,
+		HostConcurrency:  hostConcurrency,
+		HostRateLimit:    hostRateLimit,
+		PLCConcurrency:   hostConcurrency,
+		PLCRateLimit:     hostRateLimit,
+		hosts:            hosts,
+	}
+}
+
+// Returns the remote host which lookups of the identifier will mostly hit, for the purpose of concurrency and rate limiting.
+func lookupHost(atid syntax.AtIdentifier) string {
+	if did, err := atid.AsDID(); err == nil {
+		switch did.Method() {
+		case "plc":
+			return "did:plc"
+		case "web":
+			// port is percent-encoded in did:web
+			host, _, _ := strings.Cut(did.Identifier(), "%3A")
+			return "did:web:" + strings.ToLower(host)
+		default:
+			return "did:" + did.Method()
+		}
+	}
+	if handle, err := atid.AsHandle(); err == nil {
+		// handles on a shared domain (eg, a hosting provider) are usually resolved by the same service
+		h := handle.Normalize().String()
+		if strings.Count(h, ".") > 1 {
+			_, parent, _ := strings.Cut(h, ".")
+			return "handle:" + parent
+		}
+		return "handle:" + h
+	}
+	return ""
+}
+
+func (d *ThrottledDirectory) hostLimiter(host string) *hostLimiter {
+	d.hostsLk.Lock()
+	defer d.hostsLk.Unlock()
+	h, ok := d.hosts.Get(host)
+	if !ok {
+		if host == "did:plc" {
+			h = newHostLimiter(d.PLCConcurrency, d.PLCRateLimit)
+		} else {
+			h = newHostLimiter(d.HostConcurrency, d.HostRateLimit)
+		}
+		d.hosts.Add(host, h)
+	}
+	return h
+}
+
+// Runs a single request against the inner directory, coalescing concurrent requests with the same key and enforcing host limits.
+//
+// NOTE: coalesced callers share the result of the first request, including any error from its context being cancelled.
+func (d *ThrottledDirectory) do(ctx context.Context, key string, atid syntax.AtIdentifier, f func() (any, error)) (any, error) {
+	v, err, _ := d.group.Do(key, func() (any, error) {
+		h := d.hostLimiter(lookupHost(atid))
+		if err := h.acquire(ctx); err != nil {
+			return nil, err
+		}
+		defer h.release()
+		return f()
+	})
+	return v, err
+}
+
+// Runs a single lookup against the inner directory
+func (d *ThrottledDirectory) lookupInner(ctx context.Context, atid syntax.AtIdentifier) (*Identity, error) {
+	v, err := d.do(ctx, "lookup:"+atid.String(), atid, func() (any, error) {
+		return d.Inner.Lookup(ctx, atid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ident, ok := v.(*Identity)
+	if !ok {
+		return nil, fmt.Errorf("unexpected lookup result type: %T", v)
+	}
+	return ident, nil
+}
+
+// Resolves the handle and then the DID (each under its own host limits), and verifies that the DID document declares the handle. Falls back to a single lookup against the inner directory (under the handle's host limits) if it does not implement Resolver.
+func (d *ThrottledDirectory) lookupHandle(ctx context.Context, h syntax.Handle) (*Identity, error) {
+	h = h.Normalize()
+	if _, err := d.resolver(); err != nil {
+		return d.lookupInner(ctx, h.AtIdentifier())
+	}
+
+	did, err := d.ResolveHandle(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := d.ResolveDID(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	ident := ParseIdentity(doc)
+	declared, err := ident.DeclaredHandle()
+	if err != nil {
+		return nil, fmt.Errorf("could not verify handle/DID match: %w", err)
+	}
+	if declared != h {
+		return nil, fmt.Errorf("%w: %s != %s", ErrHandleMismatch, declared, h)
+	}
+	ident.Handle = declared
+	return &ident, nil
+}
+
+func (d *ThrottledDirectory) LookupHandle(ctx context.Context, h syntax.Handle) (*Identity, error) {
+	return d.lookupHandle(ctx, h)
+}
+
+func (d *ThrottledDirectory) LookupDID(ctx context.Context, did syntax.DID) (*Identity, error) {
+	return d.lookupInner(ctx, did.AtIdentifier())
+}
+
+func (d *ThrottledDirectory) Lookup(ctx context.Context, atid syntax.AtIdentifier) (*Identity, error) {
+	if h, err := atid.AsHandle(); err == nil {
+		return d.lookupHandle(ctx, h)
+	}
+	return d.lookupInner(ctx, atid)
+}
+
+func (d *ThrottledDirectory) Purge(ctx context.Context, atid syntax.AtIdentifier) error {
+	return d.Inner.Purge(ctx, atid)
+}
+
+// Looks up all the identifiers, with up to BatchConcurrency lookups in flight, subject to the per-host limits.
+func (d *ThrottledDirectory) BatchLookup(ctx context.Context, atids []syntax.AtIdentifier) map[syntax.AtIdentifier]BatchLookupResult {
+	return LookupEach(ctx, d, atids, d.BatchConcurrency)
+}
+
+func (d *ThrottledDirectory) resolver() (Resolver, error) {
+	res, ok := d.Inner.(Resolver)
+	if !ok {
+		return nil, fmt.Errorf("inner directory does not implement identity.Resolver: %T", d.Inner)
+	}
+	return res, nil
+}
+
+func (d *ThrottledDirectory) ResolveHandle(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
+	res, err := d.resolver()
+	if err != nil {
+		return "", err
+	}
+	handle = handle.Normalize()
+	v, err := d.do(ctx, "handle:"+handle.String(), handle.AtIdentifier(), func() (any, error) {
+		return res.ResolveHandle(ctx, handle)
+	})
+	if err != nil {
+		return "", err
+	}
+	did, ok := v.(syntax.DID)
+	if !ok {
+		return "", fmt.Errorf("unexpected handle resolution result type: %T", v)
+	}
+	return did, nil
+}
+
+func (d *ThrottledDirectory) ResolveDIDRaw(ctx context.Context, did syntax.DID) (json.RawMessage, error) {
+	res, err := d.resolver()
+	if err != nil {
+		return nil, err
+	}
+	v, err := d.do(ctx, "did:"+did.String(), did.AtIdentifier(), func() (any, error) {
+		return res.ResolveDIDRaw(ctx, did)
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected DID resolution result type: %T", v)
+	}
+	return raw, nil
+}
+
+func (d *ThrottledDirectory) ResolveDID(ctx context.Context, did syntax.DID) (*DIDDocument, error) {
+	raw, err := d.ResolveDIDRaw(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	var doc DIDDocument
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("%w: JSON DID document parse: %w", ErrDIDResolutionFailed, err)
+	}
+	if doc.DID != did {
+		return nil, fmt.Errorf("document ID did not match DID")
+	}
+	return &doc, nil
+}
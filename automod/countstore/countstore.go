@@ -14,7 +14,7 @@ const (
 )
 
 // CountStore is an interface for storing incrementing event counts, bucketed into periods.
-// It is implemented by MemCountStore and by RedisCountStore.
+// It is implemented by MemCountStore, RedisCountStore, and SQLCountStore.
 //
 // Period bucketing works on the basis of the current date (as determined mid-call).
 // See the `Period*` consts for the available period types.
@@ -30,26 +30,55 @@ const (
 // one to the count for the hour, one to the count for the day, and one to the all-time count.
 // The "IncrementPeriod" method allows only incrementing a single period bucket. Care must be taken to match the "GetCount" period with the incremented period when using this variant.
 //
+// In addition to the calendar-aligned period buckets, "IncrementWindow" records the time of each increment for up to MaxWindow, which allows "GetCountWindow" to return the number of increments in a true sliding window of arbitrary duration (eg, the last 10 minutes). Storing every increment is relatively expensive, so this is opt-in: "Increment" and "IncrementPeriod" do not record sliding-window increments, and "GetCountWindow" only counts increments made with "IncrementWindow".
+//
+// "IncrementBatch" is equivalent to calling "Increment" (or "IncrementWindow" or "IncrementPeriod", depending on the fields of each CountIncrement) for each of a list of counters, but may be more efficient (eg, a single network round-trip).
+//
 // The exact implementation and precision of the "*Distinct" methods may vary:
 // in the MemCountStore implementation, it is precise (it's based on large maps);
 // in the RedisCountStore implementation, it uses the Redis "pfcount" feature,
 // which is based on a HyperLogLog datastructure which has probabilistic properties
-// (see https://redis.io/commands/pfcount/ ).
+// (see https://redis.io/commands/pfcount/ );
+// in the SQLCountStore implementation, it is precise (one row per distinct value).
 //
 // Memory growth and availability of information over time also varies by implementation.
 // The RedisCountStore implementation uses Redis's key expiration primitives;
 // only the all-time counts go without expiration.
+// The SQLCountStore implementation retains period buckets (for historical queries),
+// and compacts older buckets with periodic rollups.
 // The MemCountStore grows without bound (it's intended to be used in testing
 // and other non-production operations).
 type CountStore interface {
 	GetCount(ctx context.Context, name, val, period string) (int, error)
 	Increment(ctx context.Context, name, val string) error
 	IncrementPeriod(ctx context.Context, name, val, period string) error
-	// TODO: batch increment method
+	IncrementWindow(ctx context.Context, name, val string) error
+	IncrementBatch(ctx context.Context, incs []CountIncrement) error
+	GetCountWindow(ctx context.Context, name, val string, window time.Duration) (int, error)
 	GetCountDistinct(ctx context.Context, name, bucket, period string) (int, error)
 	IncrementDistinct(ctx context.Context, name, bucket, val string) error
 }
 
+// Maximum duration of sliding-window counts (see GetCountWindow)
+const MaxWindow = 24 * time.Hour
+
+// A single counter increment, as passed to IncrementBatch.
+type CountIncrement struct {
+	Name string
+	Val  string
+	// if empty, all periods are incremented (like Increment); otherwise only the single period bucket (like IncrementPeriod)
+	Period string
+	// if true (and Period is empty), the increment is also recorded for sliding-window counts (like IncrementWindow)
+	Window bool
+}
+
+func checkWindow(window time.Duration) error {
+	if window <= 0 || window > MaxWindow {
+		return fmt.Errorf("counter window must be positive and at most %s: %s", MaxWindow, window)
+	}
+	return nil
+}
+
 func periodBucket(name, val, period string) string {
 	switch period {
 	case PeriodTotal:
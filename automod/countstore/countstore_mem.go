@@ -2,6 +2,8 @@ package countstore
 
 import (
 	"context"
+	"sort"
+	"time"
 
 	"github.com/puzpuzpuz/xsync/v3"
 )
@@ -14,12 +16,15 @@ type MemCountStore struct {
 	// (Using a values for `name` and `val` with slashes in them is perhaps inadvisable, as it may be ambiguous.)
 	Counts         *xsync.MapOf[string, int]
 	DistinctCounts *xsync.MapOf[string, *xsync.MapOf[string, bool]]
+	// Windows is keyed by "{name}/{val}", and holds the (sorted) times of increments within MaxWindow
+	Windows *xsync.MapOf[string, []time.Time]
 }
 
 func NewMemCountStore() MemCountStore {
 	return MemCountStore{
 		Counts:         xsync.NewMapOf[string, int](),
 		DistinctCounts: xsync.NewMapOf[string, *xsync.MapOf[string, bool]](),
+		Windows:        xsync.NewMapOf[string, []time.Time](),
 	}
 }
 
@@ -40,6 +45,25 @@ func (s MemCountStore) Increment(ctx context.Context, name, val string) error {
 	return nil
 }
 
+func (s MemCountStore) IncrementWindow(ctx context.Context, name, val string) error {
+	if err := s.Increment(ctx, name, val); err != nil {
+		return err
+	}
+	now := time.Now()
+	s.Windows.Compute(periodBucket(name, val, PeriodTotal), func(times []time.Time, _ bool) ([]time.Time, bool) {
+		// drop expired times, and copy so concurrent readers never see a mutated slice
+		cutoff := now.Add(-MaxWindow)
+		out := make([]time.Time, 0, len(times)+1)
+		for _, t := range times {
+			if t.After(cutoff) {
+				out = append(out, t)
+			}
+		}
+		return append(out, now), false
+	})
+	return nil
+}
+
 func (s MemCountStore) IncrementPeriod(ctx context.Context, name, val, period string) error {
 	k := periodBucket(name, val, period)
 	s.Counts.Compute(k, func(oldVal int, _ bool) (int, bool) {
@@ -48,6 +72,36 @@ func (s MemCountStore) IncrementPeriod(ctx context.Context, name, val, period st
 	return nil
 }
 
+func (s MemCountStore) IncrementBatch(ctx context.Context, incs []CountIncrement) error {
+	for _, inc := range incs {
+		var err error
+		if inc.Period != "" {
+			err = s.IncrementPeriod(ctx, inc.Name, inc.Val, inc.Period)
+		} else if inc.Window {
+			err = s.IncrementWindow(ctx, inc.Name, inc.Val)
+		} else {
+			err = s.Increment(ctx, inc.Name, inc.Val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s MemCountStore) GetCountWindow(ctx context.Context, name, val string, window time.Duration) (int, error) {
+	if err := checkWindow(window); err != nil {
+		return 0, err
+	}
+	times, ok := s.Windows.Load(periodBucket(name, val, PeriodTotal))
+	if !ok {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-window)
+	idx := sort.Search(len(times), func(i int) bool { return times[i].After(cutoff) })
+	return len(times) - idx, nil
+}
+
 func (s MemCountStore) GetCountDistinct(ctx context.Context, name, bucket, period string) (int, error) {
 	v, ok := s.DistinctCounts.Load(periodBucket(name, bucket, period))
 	if !ok {
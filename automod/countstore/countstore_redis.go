@@ -2,6 +2,9 @@ package countstore
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -9,6 +12,7 @@ import (
 
 var redisCountPrefix string = "count/"
 var redisDistinctPrefix string = "distinct/"
+var redisWindowPrefix string = "window/"
 
 type RedisCountStore struct {
 	Client *redis.Client
@@ -44,11 +48,23 @@ func (s *RedisCountStore) GetCount(ctx context.Context, name, val, period string
 }
 
 func (s *RedisCountStore) Increment(ctx context.Context, name, val string) error {
-
-	var key string
-
 	// increment multiple counters in a single redis round-trip
 	multi := s.Client.Pipeline()
+	s.increment(ctx, multi, name, val, false, time.Now())
+	_, err := multi.Exec(ctx)
+	return err
+}
+
+// Variant of Increment() which also records the increment for sliding-window counts (see GetCountWindow)
+func (s *RedisCountStore) IncrementWindow(ctx context.Context, name, val string) error {
+	multi := s.Client.Pipeline()
+	s.increment(ctx, multi, name, val, true, time.Now())
+	_, err := multi.Exec(ctx)
+	return err
+}
+
+func (s *RedisCountStore) increment(ctx context.Context, multi redis.Pipeliner, name, val string, window bool, now time.Time) {
+	var key string
 
 	key = redisCountPrefix + periodBucket(name, val, PeriodHour)
 	multi.Incr(ctx, key)
@@ -62,8 +78,15 @@ func (s *RedisCountStore) Increment(ctx context.Context, name, val string) error
 	multi.Incr(ctx, key)
 	// no expiration for total
 
-	_, err := multi.Exec(ctx)
-	return err
+	if !window {
+		return
+	}
+	// sliding window: sorted set of increments, scored by time (in milliseconds). members need to be unique
+	key = redisWindowPrefix + periodBucket(name, val, PeriodTotal)
+	member := fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())
+	multi.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: member})
+	multi.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(now.Add(-MaxWindow).UnixMilli(), 10))
+	multi.Expire(ctx, key, MaxWindow)
 }
 
 // Variant of Increment() which only acts on a single specified time period. The intended us of this variant is to control the total number of counters persisted, by using a relatively short time period, for which the counters will expire.
@@ -71,7 +94,12 @@ func (s *RedisCountStore) IncrementPeriod(ctx context.Context, name, val, period
 
 	// multiple ops in a single redis round-trip
 	multi := s.Client.Pipeline()
+	s.incrementPeriod(ctx, multi, name, val, period)
+	_, err := multi.Exec(ctx)
+	return err
+}
 
+func (s *RedisCountStore) incrementPeriod(ctx context.Context, multi redis.Pipeliner, name, val, period string) {
 	key := redisCountPrefix + periodBucket(name, val, period)
 	multi.Incr(ctx, key)
 
@@ -81,11 +109,41 @@ func (s *RedisCountStore) IncrementPeriod(ctx context.Context, name, val, period
 	case PeriodDay:
 		multi.Expire(ctx, key, 48*time.Hour)
 	}
+}
 
+// Performs all the increments in a single redis round-trip
+func (s *RedisCountStore) IncrementBatch(ctx context.Context, incs []CountIncrement) error {
+	if len(incs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	multi := s.Client.Pipeline()
+	for _, inc := range incs {
+		if inc.Period == "" {
+			s.increment(ctx, multi, inc.Name, inc.Val, inc.Window, now)
+		} else {
+			s.incrementPeriod(ctx, multi, inc.Name, inc.Val, inc.Period)
+		}
+	}
 	_, err := multi.Exec(ctx)
 	return err
 }
 
+func (s *RedisCountStore) GetCountWindow(ctx context.Context, name, val string, window time.Duration) (int, error) {
+	if err := checkWindow(window); err != nil {
+		return 0, err
+	}
+	key := redisWindowPrefix + periodBucket(name, val, PeriodTotal)
+	min := "(" + strconv.FormatInt(time.Now().Add(-window).UnixMilli(), 10)
+	c, err := s.Client.ZCount(ctx, key, min, "+inf").Result()
+	if err == redis.Nil {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return int(c), nil
+}
+
 func (s *RedisCountStore) GetCountDistinct(ctx context.Context, name, val, period string) (int, error) {
 	key := redisDistinctPrefix + periodBucket(name, val, period)
 	c, err := s.Client.PFCount(ctx, key).Result()
@@ -0,0 +1,279 @@
+package countstore
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Persisted counter value: one row per (counter, period bucket)
+type SQLCount struct {
+	Name   string `gorm:"primaryKey"`
+	Val    string `gorm:"primaryKey"`
+	Period string `gorm:"primaryKey"`
+	// empty for PeriodTotal; otherwise a UTC date ("2024-01-01") or date and hour ("2024-01-01T05")
+	Bucket string `gorm:"primaryKey"`
+	Count  int
+	// true for daily buckets which were created by Rollup (from hourly buckets), as opposed to incremented directly. Later rollups add to these.
+	RolledUp bool `gorm:"not null;default:false"`
+}
+
+func (SQLCount) TableName() string {
+	return "automod_count"
+}
+
+// Persisted distinct counter value: one row per value seen in each period bucket
+type SQLCountDistinct struct {
+	Name         string `gorm:"primaryKey"`
+	Bucket       string `gorm:"primaryKey"`
+	Period       string `gorm:"primaryKey"`
+	PeriodBucket string `gorm:"primaryKey"`
+	Val          string `gorm:"primaryKey"`
+}
+
+func (SQLCountDistinct) TableName() string {
+	return "automod_count_distinct"
+}
+
+// Persisted increment, for sliding-window counts. These are only kept for MaxWindow.
+type SQLCountEvent struct {
+	ID   uint64 `gorm:"primaryKey"`
+	Name string `gorm:"index:idx_count_event_window,priority:1"`
+	Val  string `gorm:"index:idx_count_event_window,priority:2"`
+	// unix time in milliseconds
+	At int64 `gorm:"index:idx_count_event_window,priority:3;index:idx_count_event_at"`
+}
+
+func (SQLCountEvent) TableName() string {
+	return "automod_count_event"
+}
+
+// SQLCountStore is a CountStore implementation backed by an SQL database (PostgreSQL or SQLite, via gorm).
+//
+// Unlike RedisCountStore, period buckets do not expire when the period ends, which means historical counts can be queried with GetCountAt. Distinct counts are exact (not HyperLogLog estimates).
+//
+// Old buckets are compacted by Rollup: hourly buckets older than HourRetention are rolled up in to daily buckets, and daily buckets older than DayRetention (if non-zero) are deleted. All-time counts are never removed. Sliding-window increments are deleted once they are older than MaxWindow.
+type SQLCountStore struct {
+	db *gorm.DB
+
+	// how long to keep hourly buckets around. zero means forever
+	HourRetention time.Duration
+	// how long to keep daily buckets around. zero means forever
+	DayRetention time.Duration
+}
+
+func NewSQLCountStore(db *gorm.DB) (*SQLCountStore, error) {
+	if err := db.AutoMigrate(&SQLCount{}, &SQLCountDistinct{}, &SQLCountEvent{}); err != nil {
+		return nil, fmt.Errorf("migrating countstore tables: %w", err)
+	}
+	return &SQLCountStore{
+		db:            db,
+		HourRetention: 7 * 24 * time.Hour,
+	}, nil
+}
+
+// Returns the bucket string for the given period which contains the given time
+func timeBucket(period string, t time.Time) string {
+	switch period {
+	case PeriodTotal:
+		return ""
+	case PeriodDay:
+		return t.UTC().Format(time.DateOnly)
+	case PeriodHour:
+		return t.UTC().Format(time.RFC3339)[0:13]
+	default:
+		slog.Warn("unhandled counter period", "period", period)
+		return ""
+	}
+}
+
+// Normalizes unknown periods to PeriodTotal, matching the behavior of the other implementations
+func sqlPeriod(period string) string {
+	switch period {
+	case PeriodDay, PeriodHour:
+		return period
+	default:
+		return PeriodTotal
+	}
+}
+
+func (s *SQLCountStore) GetCount(ctx context.Context, name, val, period string) (int, error) {
+	return s.GetCountAt(ctx, name, val, period, time.Now())
+}
+
+// Returns the count for the period bucket which contains the given time. For example, the count for a specific past day.
+func (s *SQLCountStore) GetCountAt(ctx context.Context, name, val, period string, at time.Time) (int, error) {
+	var c SQLCount
+	res := s.db.WithContext(ctx).Where("name = ? AND val = ? AND period = ? AND bucket = ?", name, val, sqlPeriod(period), timeBucket(period, at)).Limit(1).Find(&c)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	return c.Count, nil
+}
+
+func (s *SQLCountStore) increment(tx *gorm.DB, name, val, period string, now time.Time) error {
+	row := SQLCount{
+		Name:   name,
+		Val:    val,
+		Period: sqlPeriod(period),
+		Bucket: timeBucket(period, now),
+		Count:  1,
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "val"}, {Name: "period"}, {Name: "bucket"}},
+		DoUpdates: clause.Assignments(map[string]any{"count": gorm.Expr("automod_count.count + 1")}),
+	}).Create(&row).Error
+}
+
+func (s *SQLCountStore) incrementAll(tx *gorm.DB, name, val string, window bool, now time.Time) error {
+	for _, period := range []string{PeriodHour, PeriodDay, PeriodTotal} {
+		if err := s.increment(tx, name, val, period, now); err != nil {
+			return err
+		}
+	}
+	if !window {
+		return nil
+	}
+	return tx.Create(&SQLCountEvent{Name: name, Val: val, At: now.UnixMilli()}).Error
+}
+
+func (s *SQLCountStore) Increment(ctx context.Context, name, val string) error {
+	now := time.Now()
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.incrementAll(tx, name, val, false, now)
+	})
+}
+
+func (s *SQLCountStore) IncrementWindow(ctx context.Context, name, val string) error {
+	now := time.Now()
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.incrementAll(tx, name, val, true, now)
+	})
+}
+
+func (s *SQLCountStore) IncrementPeriod(ctx context.Context, name, val, period string) error {
+	return s.increment(s.db.WithContext(ctx), name, val, period, time.Now())
+}
+
+// Performs all the increments in a single transaction
+func (s *SQLCountStore) IncrementBatch(ctx context.Context, incs []CountIncrement) error {
+	if len(incs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, inc := range incs {
+			var err error
+			if inc.Period == "" {
+				err = s.incrementAll(tx, inc.Name, inc.Val, inc.Window, now)
+			} else {
+				err = s.increment(tx, inc.Name, inc.Val, inc.Period, now)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLCountStore) GetCountWindow(ctx context.Context, name, val string, window time.Duration) (int, error) {
+	if err := checkWindow(window); err != nil {
+		return 0, err
+	}
+	var c int64
+	cutoff := time.Now().Add(-window).UnixMilli()
+	err := s.db.WithContext(ctx).Model(&SQLCountEvent{}).Where("name = ? AND val = ? AND at > ?", name, val, cutoff).Count(&c).Error
+	if err != nil {
+		return 0, err
+	}
+	return int(c), nil
+}
+
+func (s *SQLCountStore) GetCountDistinct(ctx context.Context, name, bucket, period string) (int, error) {
+	return s.GetCountDistinctAt(ctx, name, bucket, period, time.Now())
+}
+
+// Returns the distinct count for the period bucket which contains the given time.
+func (s *SQLCountStore) GetCountDistinctAt(ctx context.Context, name, bucket, period string, at time.Time) (int, error) {
+	var c int64
+	err := s.db.WithContext(ctx).Model(&SQLCountDistinct{}).Where("name = ? AND bucket = ? AND period = ? AND period_bucket = ?", name, bucket, sqlPeriod(period), timeBucket(period, at)).Count(&c).Error
+	if err != nil {
+		return 0, err
+	}
+	return int(c), nil
+}
+
+func (s *SQLCountStore) IncrementDistinct(ctx context.Context, name, bucket, val string) error {
+	now := time.Now()
+	rows := []SQLCountDistinct{}
+	for _, period := range []string{PeriodHour, PeriodDay, PeriodTotal} {
+		rows = append(rows, SQLCountDistinct{
+			Name:         name,
+			Bucket:       bucket,
+			Period:       period,
+			PeriodBucket: timeBucket(period, now),
+			Val:          val,
+		})
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// Compacts period buckets which are older than the configured retention, relative to the given time.
+//
+// Hourly counts are summed in to the corresponding daily bucket, unless that daily bucket was incremented directly (as it is for counters incremented with Increment, as opposed to IncrementPeriod). A day which straddles the retention cutoff is rolled up over multiple passes, adding to the same daily bucket. Hourly distinct buckets are simply deleted, because the daily bucket always includes the same values.
+func (s *SQLCountStore) Rollup(ctx context.Context, now time.Time) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("at <= ?", now.Add(-MaxWindow).UnixMilli()).Delete(&SQLCountEvent{}).Error; err != nil {
+			return err
+		}
+		if s.HourRetention > 0 {
+			cutoff := timeBucket(PeriodHour, now.Add(-s.HourRetention))
+			err := tx.Exec(`INSERT INTO automod_count (name, val, period, bucket, count, rolled_up)
+				SELECT name, val, ?, substr(bucket, 1, 10), SUM(count), ? FROM automod_count
+				WHERE period = ? AND bucket < ?
+				GROUP BY name, val, substr(bucket, 1, 10)
+				ON CONFLICT (name, val, period, bucket) DO UPDATE SET count = automod_count.count + excluded.count
+				WHERE automod_count.rolled_up`, PeriodDay, true, PeriodHour, cutoff).Error
+			if err != nil {
+				return fmt.Errorf("rolling up hourly counts: %w", err)
+			}
+			if err := tx.Where("period = ? AND bucket < ?", PeriodHour, cutoff).Delete(&SQLCount{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("period = ? AND period_bucket < ?", PeriodHour, cutoff).Delete(&SQLCountDistinct{}).Error; err != nil {
+				return err
+			}
+		}
+		if s.DayRetention > 0 {
+			cutoff := timeBucket(PeriodDay, now.Add(-s.DayRetention))
+			if err := tx.Where("period = ? AND bucket < ?", PeriodDay, cutoff).Delete(&SQLCount{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("period = ? AND period_bucket < ?", PeriodDay, cutoff).Delete(&SQLCountDistinct{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Runs Rollup periodically, until the context is cancelled.
+func (s *SQLCountStore) RunRollups(ctx context.Context, period time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Rollup(ctx, time.Now()); err != nil {
+				logger.Error("countstore rollup failed", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package countstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSQLCountStore(t *testing.T) *SQLCountStore {
+	db, err := cliutil.SetupDatabase("sqlite://:memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := NewSQLCountStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs
+}
+
+func TestSQLCountStoreBasics(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := testSQLCountStore(t)
+
+	c, err := cs.GetCount(ctx, "test1", "val1", PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(0, c)
+	assert.NoError(cs.Increment(ctx, "test1", "val1"))
+	assert.NoError(cs.Increment(ctx, "test1", "val1"))
+
+	for _, period := range []string{PeriodTotal, PeriodDay, PeriodHour} {
+		c, err = cs.GetCount(ctx, "test1", "val1", period)
+		assert.NoError(err)
+		assert.Equal(2, c)
+	}
+
+	assert.NoError(cs.IncrementPeriod(ctx, "test1", "val1", PeriodDay))
+	c, err = cs.GetCount(ctx, "test1", "val1", PeriodDay)
+	assert.NoError(err)
+	assert.Equal(3, c)
+	c, err = cs.GetCount(ctx, "test1", "val1", PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(2, c)
+
+	c, err = cs.GetCountDistinct(ctx, "test2", "val2", PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(0, c)
+	assert.NoError(cs.IncrementDistinct(ctx, "test2", "val2", "one"))
+	assert.NoError(cs.IncrementDistinct(ctx, "test2", "val2", "one"))
+	c, err = cs.GetCountDistinct(ctx, "test2", "val2", PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(1, c)
+
+	assert.NoError(cs.IncrementDistinct(ctx, "test2", "val2", "two"))
+	assert.NoError(cs.IncrementDistinct(ctx, "test2", "val2", "three"))
+
+	for _, period := range []string{PeriodTotal, PeriodDay, PeriodHour} {
+		c, err = cs.GetCountDistinct(ctx, "test2", "val2", period)
+		assert.NoError(err)
+		assert.Equal(3, c)
+	}
+}
+
+func TestSQLCountStoreWindow(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := testSQLCountStore(t)
+
+	assert.NoError(cs.IncrementBatch(ctx, []CountIncrement{
+		{Name: "test1", Val: "val1", Window: true},
+		{Name: "test1", Val: "val1"},
+		{Name: "test1", Val: "val1", Period: PeriodHour},
+	}))
+	assert.NoError(cs.IncrementWindow(ctx, "test1", "val1"))
+	// an old increment, outside the window
+	assert.NoError(cs.db.Create(&SQLCountEvent{Name: "test1", Val: "val1", At: time.Now().Add(-time.Hour).UnixMilli()}).Error)
+
+	c, err := cs.GetCountWindow(ctx, "test1", "val1", 10*time.Minute)
+	assert.NoError(err)
+	assert.Equal(2, c)
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", 2*time.Hour)
+	assert.NoError(err)
+	assert.Equal(3, c)
+	c, err = cs.GetCount(ctx, "test1", "val1", PeriodHour)
+	assert.NoError(err)
+	assert.Equal(4, c)
+
+	// rollups drop increments older than the max window
+	assert.NoError(cs.Rollup(ctx, time.Now().Add(MaxWindow-30*time.Minute)))
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", 2*time.Hour)
+	assert.NoError(err)
+	assert.Equal(2, c)
+}
+
+func TestSQLCountStoreRollup(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := testSQLCountStore(t)
+	cs.HourRetention = 48 * time.Hour
+	cs.DayRetention = 30 * 24 * time.Hour
+
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	old := time.Date(2024, 3, 1, 5, 0, 0, 0, time.UTC)
+	ancient := time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC)
+	rows := []SQLCount{
+		// counter incremented with Increment: has both hour and day buckets
+		{Name: "posts", Val: "did:plc:abc111", Period: PeriodHour, Bucket: "2024-03-01T05", Count: 2},
+		{Name: "posts", Val: "did:plc:abc111", Period: PeriodHour, Bucket: "2024-03-01T06", Count: 3},
+		{Name: "posts", Val: "did:plc:abc111", Period: PeriodDay, Bucket: "2024-03-01", Count: 5},
+		{Name: "posts", Val: "did:plc:abc111", Period: PeriodHour, Bucket: "2024-03-10T11", Count: 1},
+		{Name: "posts", Val: "did:plc:abc111", Period: PeriodDay, Bucket: "2024-01-01", Count: 7},
+		{Name: "posts", Val: "did:plc:abc111", Period: PeriodTotal, Bucket: "", Count: 13},
+		// counter incremented only with IncrementPeriod(hour)
+		{Name: "hourly", Val: "x", Period: PeriodHour, Bucket: "2024-03-01T05", Count: 4},
+		{Name: "hourly", Val: "x", Period: PeriodHour, Bucket: "2024-03-01T07", Count: 1},
+	}
+	assert.NoError(cs.db.Create(&rows).Error)
+	distinct := []SQLCountDistinct{
+		{Name: "likes", Bucket: "post", Period: PeriodHour, PeriodBucket: "2024-03-01T05", Val: "one"},
+		{Name: "likes", Bucket: "post", Period: PeriodDay, PeriodBucket: "2024-03-01", Val: "one"},
+	}
+	assert.NoError(cs.db.Create(&distinct).Error)
+
+	assert.NoError(cs.Rollup(ctx, now))
+
+	c, err := cs.GetCountAt(ctx, "posts", "did:plc:abc111", PeriodHour, old)
+	assert.NoError(err)
+	assert.Equal(0, c)
+	c, err = cs.GetCountAt(ctx, "posts", "did:plc:abc111", PeriodDay, old)
+	assert.NoError(err)
+	assert.Equal(5, c)
+	c, err = cs.GetCountAt(ctx, "posts", "did:plc:abc111", PeriodHour, now.Add(-time.Hour))
+	assert.NoError(err)
+	assert.Equal(1, c)
+	c, err = cs.GetCountAt(ctx, "posts", "did:plc:abc111", PeriodDay, ancient)
+	assert.NoError(err)
+	assert.Equal(0, c)
+	c, err = cs.GetCount(ctx, "posts", "did:plc:abc111", PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(13, c)
+
+	c, err = cs.GetCountAt(ctx, "hourly", "x", PeriodDay, old)
+	assert.NoError(err)
+	assert.Equal(5, c)
+
+	c, err = cs.GetCountDistinctAt(ctx, "likes", "post", PeriodHour, old)
+	assert.NoError(err)
+	assert.Equal(0, c)
+	c, err = cs.GetCountDistinctAt(ctx, "likes", "post", PeriodDay, old)
+	assert.NoError(err)
+	assert.Equal(1, c)
+}
+
+func TestSQLCountStoreRollupMultiPass(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := testSQLCountStore(t)
+	cs.HourRetention = 24 * time.Hour
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	rows := []SQLCount{
+		// counter incremented only with IncrementPeriod(hour), spread over the day
+		{Name: "hourly", Val: "x", Period: PeriodHour, Bucket: "2024-03-01T05", Count: 4},
+		{Name: "hourly", Val: "x", Period: PeriodHour, Bucket: "2024-03-01T12", Count: 2},
+		{Name: "hourly", Val: "x", Period: PeriodHour, Bucket: "2024-03-01T20", Count: 1},
+		// counter incremented with Increment: daily bucket is already complete
+		{Name: "posts", Val: "x", Period: PeriodHour, Bucket: "2024-03-01T05", Count: 2},
+		{Name: "posts", Val: "x", Period: PeriodHour, Bucket: "2024-03-01T20", Count: 3},
+		{Name: "posts", Val: "x", Period: PeriodDay, Bucket: "2024-03-01", Count: 5},
+	}
+	assert.NoError(cs.db.Create(&rows).Error)
+
+	// each pass has a cutoff partway through the day
+	for _, hour := range []int{8, 15, 23} {
+		assert.NoError(cs.Rollup(ctx, day.Add(24*time.Hour+time.Duration(hour)*time.Hour)))
+	}
+
+	c, err := cs.GetCountAt(ctx, "hourly", "x", PeriodDay, day)
+	assert.NoError(err)
+	assert.Equal(7, c)
+	c, err = cs.GetCountAt(ctx, "hourly", "x", PeriodHour, day.Add(20*time.Hour))
+	assert.NoError(err)
+	assert.Equal(0, c)
+	c, err = cs.GetCountAt(ctx, "posts", "x", PeriodDay, day)
+	assert.NoError(err)
+	assert.Equal(5, c)
+
+	// another pass with nothing left to roll up doesn't change anything
+	assert.NoError(cs.Rollup(ctx, day.Add(72*time.Hour)))
+	c, err = cs.GetCountAt(ctx, "hourly", "x", PeriodDay, day)
+	assert.NoError(err)
+	assert.Equal(7, c)
+}
@@ -104,3 +104,46 @@ func TestMemCountStoreConcurrent(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(1, c)
 }
+
+func TestMemCountStoreWindow(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := NewMemCountStore()
+
+	c, err := cs.GetCountWindow(ctx, "test1", "val1", 10*time.Minute)
+	assert.NoError(err)
+	assert.Equal(0, c)
+
+	assert.NoError(cs.IncrementBatch(ctx, []CountIncrement{
+		{Name: "test1", Val: "val1", Window: true},
+		{Name: "test1", Val: "val1", Window: true},
+		{Name: "test1", Val: "val1"},
+		{Name: "test1", Val: "val1", Period: PeriodDay},
+		{Name: "test2", Val: "val2"},
+	}))
+
+	// only increments with Window set are recorded
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", 10*time.Minute)
+	assert.NoError(err)
+	assert.Equal(2, c)
+	c, err = cs.GetCount(ctx, "test1", "val1", PeriodDay)
+	assert.NoError(err)
+	assert.Equal(4, c)
+	c, err = cs.GetCountWindow(ctx, "test2", "val2", 10*time.Minute)
+	assert.NoError(err)
+	assert.Equal(0, c)
+	c, err = cs.GetCount(ctx, "test2", "val2", PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(1, c)
+
+	// increments older than the window are not counted
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(cs.IncrementWindow(ctx, "test1", "val1"))
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", 10*time.Millisecond)
+	assert.NoError(err)
+	assert.Equal(1, c)
+
+	_, err = cs.GetCountWindow(ctx, "test1", "val1", 2*MaxWindow)
+	assert.Error(err)
+}
@@ -1,2 +1,2 @@
-// Interface for fast atomic counters, and separate implementations using redis and in-process memory.
+// Interface for fast atomic counters, and separate implementations using redis, SQL databases, and in-process memory.
 package countstore
@@ -0,0 +1,869 @@
+package declarative
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/engine"
+)
+
+// Rule event types (the `on` field)
+const (
+	OnPost     = "post"
+	OnProfile  = "profile"
+	OnRecord   = "record"
+	OnDelete   = "delete"
+	OnIdentity = "identity"
+	OnAccount  = "account"
+)
+
+// A single validated rule
+type Rule struct {
+	Name        string
+	Description string
+	On          string
+
+	// nil means all collections
+	collections map[string]bool
+	// nil means always match
+	cond    condition
+	actions []action
+}
+
+// A validated set of rules, grouped by event type. Immutable once compiled.
+type CompiledRules struct {
+	// all rules, in file order
+	Rules []*Rule
+
+	record   []*Rule
+	delete   []*Rule
+	identity []*Rule
+	account  []*Rule
+
+	// names of counters which are read with a sliding window, and so need window increments recorded
+	windowed map[string]bool
+}
+
+type condition func(env *evalEnv) bool
+type action func(env *evalEnv)
+type resolver func(env *evalEnv) []any
+type template func(env *evalEnv) string
+
+// per-event state while evaluating rules
+type evalEnv struct {
+	ac *engine.AccountContext
+	// nil for identity and account events
+	rc *engine.RecordContext
+
+	record    map[string]any
+	recordErr error
+	decoded   bool
+}
+
+func (env *evalEnv) recordData() map[string]any {
+	if !env.decoded {
+		env.decoded = true
+		if env.rc != nil && len(env.rc.RecordOp.RecordCBOR) > 0 {
+			env.record, env.recordErr = data.UnmarshalCBOR(env.rc.RecordOp.RecordCBOR)
+		}
+	}
+	return env.record
+}
+
+// Validates and compiles rule files. All errors are returned (joined), not just the first.
+func Compile(files ...*RuleFile) (*CompiledRules, error) {
+	cr := &CompiledRules{windowed: make(map[string]bool)}
+	var errs []error
+	names := make(map[string]bool)
+	for _, f := range files {
+		for i := range f.Rules {
+			spec := &f.Rules[i]
+			rc := ruleCompiler{spec: spec, windowed: cr.windowed}
+			if spec.Name == "" {
+				errs = append(errs, fmt.Errorf("rule #%d: missing name", i+1))
+				continue
+			}
+			if names[spec.Name] {
+				errs = append(errs, fmt.Errorf("rule %q: duplicate name", spec.Name))
+				continue
+			}
+			names[spec.Name] = true
+			r := rc.compile()
+			if len(rc.errs) > 0 {
+				errs = append(errs, rc.errs...)
+				continue
+			}
+			cr.Rules = append(cr.Rules, r)
+			switch r.On {
+			case OnPost, OnProfile, OnRecord:
+				cr.record = append(cr.record, r)
+			case OnDelete:
+				cr.delete = append(cr.delete, r)
+			case OnIdentity:
+				cr.identity = append(cr.identity, r)
+			case OnAccount:
+				cr.account = append(cr.account, r)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cr, nil
+}
+
+type ruleCompiler struct {
+	spec *RuleSpec
+	errs []error
+	// shared across all rules being compiled (see CompiledRules.windowed)
+	windowed map[string]bool
+}
+
+func (rc *ruleCompiler) errorf(format string, args ...any) {
+	rc.errs = append(rc.errs, fmt.Errorf("rule %q: %s", rc.spec.Name, fmt.Sprintf(format, args...)))
+}
+
+// whether the rule runs on events which have a record (or record metadata, for deletions)
+func (rc *ruleCompiler) hasRecordOp() bool {
+	switch rc.spec.On {
+	case OnPost, OnProfile, OnRecord, OnDelete:
+		return true
+	}
+	return false
+}
+
+func (rc *ruleCompiler) compile() *Rule {
+	spec := rc.spec
+	r := &Rule{
+		Name:        spec.Name,
+		Description: spec.Description,
+		On:          spec.On,
+	}
+
+	switch spec.On {
+	case OnPost:
+		r.collections = map[string]bool{"app.bsky.feed.post": true}
+	case OnProfile:
+		r.collections = map[string]bool{"app.bsky.actor.profile": true}
+	case OnRecord, OnDelete, OnIdentity, OnAccount:
+	case "":
+		rc.errorf("missing event type (on)")
+	default:
+		rc.errorf("unknown event type (on): %s", spec.On)
+	}
+
+	if len(spec.Collections) > 0 {
+		if spec.On != OnRecord && spec.On != OnDelete {
+			rc.errorf("collections can only be specified for record and delete rules")
+		}
+		r.collections = make(map[string]bool, len(spec.Collections))
+		for _, c := range spec.Collections {
+			if _, err := syntax.ParseNSID(c); err != nil {
+				rc.errorf("invalid collection: %s", c)
+			}
+			r.collections[c] = true
+		}
+	}
+
+	if spec.When != nil {
+		r.cond = rc.condition(spec.When, "when")
+	}
+
+	if len(spec.Actions) == 0 {
+		rc.errorf("no actions")
+	}
+	for i := range spec.Actions {
+		if a := rc.action(&spec.Actions[i], fmt.Sprintf("actions[%d]", i)); a != nil {
+			r.actions = append(r.actions, a)
+		}
+	}
+	return r
+}
+
+func (rc *ruleCompiler) condition(spec *ConditionSpec, loc string) condition {
+	numeric := rc.numericComparison(spec)
+	stringOps := 0
+	for _, set := range []bool{spec.Equals != nil, spec.Contains != nil, spec.Matches != nil, spec.InSet != nil, spec.Exists != nil} {
+		if set {
+			stringOps++
+		}
+	}
+	kinds := 0
+	for _, set := range []bool{len(spec.All) > 0, len(spec.Any) > 0, spec.Not != nil, spec.Field != "", spec.Count != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		rc.errorf("%s: condition must have exactly one of all, any, not, field, or count", loc)
+		return nil
+	}
+
+	switch {
+	case len(spec.All) > 0 || len(spec.Any) > 0:
+		if stringOps > 0 || numeric != nil {
+			rc.errorf("%s: comparisons must be part of a field or count condition", loc)
+		}
+		sub := spec.All
+		op := "all"
+		if len(spec.Any) > 0 {
+			sub = spec.Any
+			op = "any"
+		}
+		conds := make([]condition, len(sub))
+		for i := range sub {
+			conds[i] = rc.condition(&sub[i], fmt.Sprintf("%s.%s[%d]", loc, op, i))
+		}
+		if op == "all" {
+			return func(env *evalEnv) bool {
+				for _, c := range conds {
+					if !c(env) {
+						return false
+					}
+				}
+				return true
+			}
+		}
+		return func(env *evalEnv) bool {
+			for _, c := range conds {
+				if c(env) {
+					return true
+				}
+			}
+			return false
+		}
+	case spec.Not != nil:
+		if stringOps > 0 || numeric != nil {
+			rc.errorf("%s: comparisons must be part of a field or count condition", loc)
+		}
+		inner := rc.condition(spec.Not, loc+".not")
+		return func(env *evalEnv) bool {
+			return !inner(env)
+		}
+	case spec.Count != nil:
+		return rc.countCondition(spec, numeric, stringOps, loc)
+	default:
+		return rc.fieldCondition(spec, numeric, stringOps, loc)
+	}
+}
+
+func (rc *ruleCompiler) numericComparison(spec *ConditionSpec) func(float64) bool {
+	if spec.LT == nil && spec.LTE == nil && spec.GT == nil && spec.GTE == nil {
+		return nil
+	}
+	return func(v float64) bool {
+		if spec.LT != nil && !(v < *spec.LT) {
+			return false
+		}
+		if spec.LTE != nil && !(v <= *spec.LTE) {
+			return false
+		}
+		if spec.GT != nil && !(v > *spec.GT) {
+			return false
+		}
+		if spec.GTE != nil && !(v >= *spec.GTE) {
+			return false
+		}
+		return true
+	}
+}
+
+func (rc *ruleCompiler) countCondition(spec *ConditionSpec, numeric func(float64) bool, stringOps int, loc string) condition {
+	cs := spec.Count
+	if stringOps > 0 {
+		rc.errorf("%s: count conditions only support numeric comparisons (lt, lte, gt, gte)", loc)
+	}
+	if numeric == nil {
+		rc.errorf("%s: count condition needs a numeric comparison (lt, lte, gt, gte)", loc)
+		return nil
+	}
+	if cs.Name == "" {
+		rc.errorf("%s: count needs a name", loc)
+	}
+	period := rc.period(cs.Period, loc)
+	key := rc.template(cs.Key, loc+".count.key")
+	if key == nil {
+		return nil
+	}
+	name := cs.Name
+	if cs.Window != "" {
+		if cs.Period != "" || cs.Distinct {
+			rc.errorf("%s: count window can not be combined with period or distinct", loc)
+		}
+		window, err := time.ParseDuration(cs.Window)
+		if err != nil || window <= 0 || window > countstore.MaxWindow {
+			rc.errorf("%s: invalid count window (must be a duration up to %s): %s", loc, countstore.MaxWindow, cs.Window)
+			return nil
+		}
+		rc.windowed[name] = true
+		return func(env *evalEnv) bool {
+			return numeric(float64(env.ac.GetCountWindow(name, key(env), window)))
+		}
+	}
+	if cs.Distinct {
+		return func(env *evalEnv) bool {
+			return numeric(float64(env.ac.GetCountDistinct(name, key(env), period)))
+		}
+	}
+	return func(env *evalEnv) bool {
+		return numeric(float64(env.ac.GetCount(name, key(env), period)))
+	}
+}
+
+func (rc *ruleCompiler) fieldCondition(spec *ConditionSpec, numeric func(float64) bool, stringOps int, loc string) condition {
+	res := rc.field(spec.Field, loc)
+	if res == nil {
+		return nil
+	}
+	if stringOps > 1 || (stringOps == 1 && numeric != nil) {
+		rc.errorf("%s: field condition must have exactly one operator (or only numeric comparisons)", loc)
+		return nil
+	}
+	if stringOps == 0 && numeric == nil {
+		rc.errorf("%s: field condition has no operator", loc)
+		return nil
+	}
+
+	// matches if any resolved value matches
+	anyString := func(pred func(string) bool) condition {
+		return func(env *evalEnv) bool {
+			for _, v := range res(env) {
+				if s, ok := valueString(v); ok && pred(s) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	switch {
+	case numeric != nil:
+		return func(env *evalEnv) bool {
+			for _, v := range res(env) {
+				if n, ok := valueNumber(v); ok && numeric(n) {
+					return true
+				}
+			}
+			return false
+		}
+	case spec.Exists != nil:
+		want := *spec.Exists
+		return func(env *evalEnv) bool {
+			return (len(res(env)) > 0) == want
+		}
+	case spec.Equals != nil:
+		want := *spec.Equals
+		return anyString(func(s string) bool { return s == want })
+	case spec.Contains != nil:
+		want := strings.ToLower(*spec.Contains)
+		return anyString(func(s string) bool { return strings.Contains(strings.ToLower(s), want) })
+	case spec.Matches != nil:
+		re, err := regexp.Compile(*spec.Matches)
+		if err != nil {
+			rc.errorf("%s: invalid regex: %v", loc, err)
+			return nil
+		}
+		return anyString(re.MatchString)
+	default:
+		setName := *spec.InSet
+		if setName == "" {
+			rc.errorf("%s: in_set needs a set name", loc)
+			return nil
+		}
+		return func(env *evalEnv) bool {
+			for _, v := range res(env) {
+				if s, ok := valueString(v); ok && env.ac.InSet(setName, s) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+}
+
+func (rc *ruleCompiler) period(p, loc string) string {
+	switch p {
+	case "":
+		return countstore.PeriodTotal
+	case countstore.PeriodTotal, countstore.PeriodDay, countstore.PeriodHour:
+		return p
+	default:
+		rc.errorf("%s: invalid period: %s", loc, p)
+		return countstore.PeriodTotal
+	}
+}
+
+// Compiles a field path to a function which returns all values at that path (possibly none).
+func (rc *ruleCompiler) field(path, loc string) resolver {
+	one := func(f func(env *evalEnv) any) resolver {
+		return func(env *evalEnv) []any {
+			if v := f(env); v != nil {
+				return []any{v}
+			}
+			return nil
+		}
+	}
+	recordOnly := func(f func(op *engine.RecordOp) any) resolver {
+		if !rc.hasRecordOp() {
+			rc.errorf("%s: field %s is only available for record rules", loc, path)
+			return nil
+		}
+		return one(func(env *evalEnv) any { return f(&env.rc.RecordOp) })
+	}
+
+	switch path {
+	case "did":
+		return one(func(env *evalEnv) any {
+			if env.ac.Account.Identity == nil {
+				return nil
+			}
+			return env.ac.Account.Identity.DID.String()
+		})
+	case "handle":
+		return one(func(env *evalEnv) any {
+			if env.ac.Account.Identity == nil {
+				return nil
+			}
+			return env.ac.Account.Identity.Handle.String()
+		})
+	case "collection":
+		return recordOnly(func(op *engine.RecordOp) any { return op.Collection.String() })
+	case "rkey":
+		return recordOnly(func(op *engine.RecordOp) any { return op.RecordKey.String() })
+	case "action":
+		return recordOnly(func(op *engine.RecordOp) any { return op.Action })
+	case "uri":
+		return recordOnly(func(op *engine.RecordOp) any { return op.ATURI().String() })
+	case "cid":
+		return recordOnly(func(op *engine.RecordOp) any {
+			if op.CID == nil {
+				return nil
+			}
+			return op.CID.String()
+		})
+	}
+
+	if strings.HasPrefix(path, "account.") {
+		if f := accountField(strings.TrimPrefix(path, "account.")); f != nil {
+			return f
+		}
+		rc.errorf("%s: unknown account field: %s", loc, path)
+		return nil
+	}
+
+	if strings.HasPrefix(path, "record.") {
+		switch rc.spec.On {
+		case OnPost, OnProfile, OnRecord:
+		default:
+			rc.errorf("%s: record fields are only available for post, profile, and record rules", loc)
+			return nil
+		}
+		parts := strings.Split(strings.TrimPrefix(path, "record."), ".")
+		for _, p := range parts {
+			if p == "" {
+				rc.errorf("%s: invalid record field path: %s", loc, path)
+				return nil
+			}
+		}
+		return func(env *evalEnv) []any {
+			rec := env.recordData()
+			if rec == nil {
+				return nil
+			}
+			return walkPath(rec, parts)
+		}
+	}
+
+	rc.errorf("%s: unknown field: %s", loc, path)
+	return nil
+}
+
+func accountField(name string) resolver {
+	meta := func(f func(am *engine.AccountMeta) any) resolver {
+		return func(env *evalEnv) []any {
+			if v := f(&env.ac.Account); v != nil {
+				return []any{v}
+			}
+			return nil
+		}
+	}
+	list := func(f func(am *engine.AccountMeta) []string) resolver {
+		return func(env *evalEnv) []any {
+			vals := f(&env.ac.Account)
+			out := make([]any, len(vals))
+			for i, v := range vals {
+				out[i] = v
+			}
+			return out
+		}
+	}
+	private := func(f func(p *engine.AccountPrivate) any) resolver {
+		return meta(func(am *engine.AccountMeta) any {
+			if am.Private == nil {
+				return nil
+			}
+			return f(am.Private)
+		})
+	}
+
+	switch name {
+	case "followers":
+		return meta(func(am *engine.AccountMeta) any { return am.FollowersCount })
+	case "follows":
+		return meta(func(am *engine.AccountMeta) any { return am.FollowsCount })
+	case "posts":
+		return meta(func(am *engine.AccountMeta) any { return am.PostsCount })
+	case "age_days":
+		return meta(func(am *engine.AccountMeta) any {
+			if am.CreatedAt == nil {
+				return nil
+			}
+			return time.Since(*am.CreatedAt).Hours() / 24
+		})
+	case "takendown":
+		return meta(func(am *engine.AccountMeta) any { return am.Takendown })
+	case "deactivated":
+		return meta(func(am *engine.AccountMeta) any { return am.Deactivated })
+	case "has_avatar":
+		return meta(func(am *engine.AccountMeta) any { return am.Profile.HasAvatar })
+	case "display_name":
+		return meta(func(am *engine.AccountMeta) any {
+			if am.Profile.DisplayName == nil {
+				return nil
+			}
+			return *am.Profile.DisplayName
+		})
+	case "description":
+		return meta(func(am *engine.AccountMeta) any {
+			if am.Profile.Description == nil {
+				return nil
+			}
+			return *am.Profile.Description
+		})
+	case "labels":
+		return list(func(am *engine.AccountMeta) []string { return am.AccountLabels })
+	case "flags":
+		return list(func(am *engine.AccountMeta) []string { return am.AccountFlags })
+	case "email":
+		return private(func(p *engine.AccountPrivate) any { return p.Email })
+	case "email_confirmed":
+		return private(func(p *engine.AccountPrivate) any { return p.EmailConfirmed })
+	case "review_state":
+		return private(func(p *engine.AccountPrivate) any { return p.ReviewState })
+	case "tags":
+		return list(func(am *engine.AccountMeta) []string {
+			if am.Private == nil {
+				return nil
+			}
+			return am.Private.AccountTags
+		})
+	}
+	return nil
+}
+
+// walks a path through nested maps. arrays are traversed (flattened), so a path can resolve to multiple values
+func walkPath(v any, parts []string) []any {
+	if arr, ok := v.([]any); ok {
+		var out []any
+		for _, elem := range arr {
+			out = append(out, walkPath(elem, parts)...)
+		}
+		return out
+	}
+	if len(parts) == 0 {
+		if v == nil {
+			return nil
+		}
+		return []any{v}
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	child, ok := m[parts[0]]
+	if !ok {
+		return nil
+	}
+	return walkPath(child, parts[1:])
+}
+
+func valueString(v any) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case bool:
+		return strconv.FormatBool(val), true
+	case int64:
+		return strconv.FormatInt(val, 10), true
+	case int:
+		return strconv.Itoa(val), true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+func valueNumber(v any) (float64, bool) {
+	switch val := v.(type) {
+	case int64:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
+
+// Compiles a string with `${path}` placeholders
+func (rc *ruleCompiler) template(s, loc string) template {
+	var parts []template
+	rest := s
+	for {
+		idx := strings.Index(rest, "${")
+		if idx < 0 {
+			break
+		}
+		lit := rest[:idx]
+		parts = append(parts, func(*evalEnv) string { return lit })
+		end := strings.Index(rest[idx:], "}")
+		if end < 0 {
+			rc.errorf("%s: unterminated placeholder: %s", loc, s)
+			return nil
+		}
+		res := rc.field(rest[idx+2:idx+end], loc)
+		if res == nil {
+			return nil
+		}
+		parts = append(parts, func(env *evalEnv) string {
+			vals := res(env)
+			if len(vals) == 0 {
+				return ""
+			}
+			str, _ := valueString(vals[0])
+			return str
+		})
+		rest = rest[idx+end+1:]
+	}
+	if len(parts) == 0 {
+		return func(*evalEnv) string { return s }
+	}
+	parts = append(parts, func(*evalEnv) string { return rest })
+	return func(env *evalEnv) string {
+		var b strings.Builder
+		for _, p := range parts {
+			b.WriteString(p(env))
+		}
+		return b.String()
+	}
+}
+
+var reportReasons = map[string]string{
+	"spam":       engine.ReportReasonSpam,
+	"violation":  engine.ReportReasonViolation,
+	"misleading": engine.ReportReasonMisleading,
+	"sexual":     engine.ReportReasonSexual,
+	"rude":       engine.ReportReasonRude,
+	"other":      engine.ReportReasonOther,
+}
+
+func (rc *ruleCompiler) report(spec *ReportSpec, loc string) (string, template) {
+	reason, ok := reportReasons[spec.Reason]
+	if !ok {
+		if !strings.Contains(spec.Reason, "#") {
+			rc.errorf("%s: unknown report reason: %s", loc, spec.Reason)
+		}
+		reason = spec.Reason
+	}
+	return reason, rc.template(spec.Comment, loc)
+}
+
+func (rc *ruleCompiler) action(spec *ActionSpec, loc string) action {
+	n := 0
+	for _, set := range []bool{
+		spec.AddRecordLabel != "", spec.RemoveRecordLabel != "", spec.AddRecordFlag != "", spec.AddRecordTag != "", spec.ReportRecord != nil, spec.TakedownRecord, spec.EscalateRecord, spec.AcknowledgeRecord,
+		spec.AddAccountLabel != "", spec.RemoveAccountLabel != "", spec.AddAccountFlag != "", spec.AddAccountTag != "", spec.ReportAccount != nil, spec.TakedownAccount, spec.EscalateAccount, spec.AcknowledgeAccount,
+		spec.Increment != nil, spec.IncrementDistinct != nil, spec.Notify != "",
+	} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		rc.errorf("%s: each action must have exactly one effect", loc)
+		return nil
+	}
+
+	// record-level effects
+	recordLevel := spec.AddRecordLabel != "" || spec.RemoveRecordLabel != "" || spec.AddRecordFlag != "" || spec.AddRecordTag != "" || spec.ReportRecord != nil || spec.TakedownRecord || spec.EscalateRecord || spec.AcknowledgeRecord
+	if recordLevel {
+		switch rc.spec.On {
+		case OnPost, OnProfile, OnRecord:
+		default:
+			rc.errorf("%s: record-level effects are only available for post, profile, and record rules", loc)
+			return nil
+		}
+	}
+
+	switch {
+	case spec.AddRecordLabel != "":
+		val := spec.AddRecordLabel
+		return func(env *evalEnv) { env.rc.AddRecordLabel(val) }
+	case spec.RemoveRecordLabel != "":
+		val := spec.RemoveRecordLabel
+		return func(env *evalEnv) { env.rc.RemoveRecordLabel(val) }
+	case spec.AddRecordFlag != "":
+		val := spec.AddRecordFlag
+		return func(env *evalEnv) { env.rc.AddRecordFlag(val) }
+	case spec.AddRecordTag != "":
+		val := spec.AddRecordTag
+		return func(env *evalEnv) { env.rc.AddRecordTag(val) }
+	case spec.ReportRecord != nil:
+		reason, comment := rc.report(spec.ReportRecord, loc)
+		if comment == nil {
+			return nil
+		}
+		return func(env *evalEnv) { env.rc.ReportRecord(reason, comment(env)) }
+	case spec.TakedownRecord:
+		return func(env *evalEnv) { env.rc.TakedownRecord() }
+	case spec.EscalateRecord:
+		return func(env *evalEnv) { env.rc.EscalateRecord() }
+	case spec.AcknowledgeRecord:
+		return func(env *evalEnv) { env.rc.AcknowledgeRecord() }
+	case spec.AddAccountLabel != "":
+		val := spec.AddAccountLabel
+		return func(env *evalEnv) { env.ac.AddAccountLabel(val) }
+	case spec.RemoveAccountLabel != "":
+		val := spec.RemoveAccountLabel
+		return func(env *evalEnv) { env.ac.RemoveAccountLabel(val) }
+	case spec.AddAccountFlag != "":
+		val := spec.AddAccountFlag
+		return func(env *evalEnv) { env.ac.AddAccountFlag(val) }
+	case spec.AddAccountTag != "":
+		val := spec.AddAccountTag
+		return func(env *evalEnv) { env.ac.AddAccountTag(val) }
+	case spec.ReportAccount != nil:
+		reason, comment := rc.report(spec.ReportAccount, loc)
+		if comment == nil {
+			return nil
+		}
+		return func(env *evalEnv) { env.ac.ReportAccount(reason, comment(env)) }
+	case spec.TakedownAccount:
+		return func(env *evalEnv) { env.ac.TakedownAccount() }
+	case spec.EscalateAccount:
+		return func(env *evalEnv) { env.ac.EscalateAccount() }
+	case spec.AcknowledgeAccount:
+		return func(env *evalEnv) { env.ac.AcknowledgeAccount() }
+	case spec.Increment != nil:
+		inc := spec.Increment
+		if inc.Name == "" {
+			rc.errorf("%s: increment needs a name", loc)
+			return nil
+		}
+		if inc.Bucket != "" {
+			rc.errorf("%s: bucket is only used with increment_distinct", loc)
+		}
+		key := rc.template(inc.Key, loc+".key")
+		if key == nil {
+			return nil
+		}
+		name := inc.Name
+		if inc.Period == "" {
+			// the set of windowed counters is only complete once all rules are compiled, so is checked when the action runs
+			windowed := rc.windowed
+			return func(env *evalEnv) {
+				if windowed[name] {
+					env.ac.IncrementWindow(name, key(env))
+				} else {
+					env.ac.Increment(name, key(env))
+				}
+			}
+		}
+		period := rc.period(inc.Period, loc)
+		return func(env *evalEnv) { env.ac.IncrementPeriod(name, key(env), period) }
+	case spec.IncrementDistinct != nil:
+		inc := spec.IncrementDistinct
+		if inc.Name == "" || inc.Bucket == "" {
+			rc.errorf("%s: increment_distinct needs a name and bucket", loc)
+			return nil
+		}
+		if inc.Period != "" {
+			rc.errorf("%s: period is not supported for increment_distinct", loc)
+		}
+		bucket := rc.template(inc.Bucket, loc+".bucket")
+		key := rc.template(inc.Key, loc+".key")
+		if bucket == nil || key == nil {
+			return nil
+		}
+		name := inc.Name
+		return func(env *evalEnv) { env.ac.IncrementDistinct(name, bucket(env), key(env)) }
+	default:
+		srv := spec.Notify
+		return func(env *evalEnv) { env.ac.Notify(srv) }
+	}
+}
+
+func (r *Rule) run(env *evalEnv) {
+	// metrics and effects are attributed to the declarative rule, not the dispatching rule function
+	env.ac.RunRule(r.Name, func() error {
+		if r.cond != nil && !r.cond(env) {
+			return nil
+		}
+		env.ac.Logger.Debug("declarative rule matched", "rule", r.Name)
+		for _, a := range r.actions {
+			a(env)
+		}
+		return nil
+	})
+}
+
+func (r *Rule) matchCollection(c *engine.RecordContext) bool {
+	return r.collections == nil || r.collections[c.RecordOp.Collection.String()]
+}
+
+// Runs all post, profile, and generic record rules which apply to the record's collection
+func (cr *CompiledRules) RunRecordRules(c *engine.RecordContext) error {
+	env := evalEnv{ac: &c.AccountContext, rc: c}
+	for _, r := range cr.record {
+		if r.matchCollection(c) {
+			r.run(&env)
+		}
+	}
+	if env.recordErr != nil {
+		return fmt.Errorf("decoding record for declarative rules: %w", env.recordErr)
+	}
+	return nil
+}
+
+func (cr *CompiledRules) RunRecordDeleteRules(c *engine.RecordContext) error {
+	env := evalEnv{ac: &c.AccountContext, rc: c}
+	for _, r := range cr.delete {
+		if r.matchCollection(c) {
+			r.run(&env)
+		}
+	}
+	return nil
+}
+
+func (cr *CompiledRules) RunIdentityRules(c *engine.AccountContext) error {
+	env := evalEnv{ac: c}
+	for _, r := range cr.identity {
+		r.run(&env)
+	}
+	return nil
+}
+
+func (cr *CompiledRules) RunAccountRules(c *engine.AccountContext) error {
+	env := evalEnv{ac: c}
+	for _, r := range cr.account {
+		r.run(&env)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package declarative
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/engine"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testAccount() engine.AccountMeta {
+	return engine.AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+	}
+}
+
+func testPostContext(t *testing.T, eng *engine.Engine, post *appbsky.FeedPost) engine.RecordContext {
+	buf := new(bytes.Buffer)
+	if err := post.MarshalCBOR(buf); err != nil {
+		t.Fatal(err)
+	}
+	cid := syntax.CID("cid123")
+	am := testAccount()
+	op := engine.RecordOp{
+		Action:     engine.CreateOp,
+		DID:        am.Identity.DID,
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid,
+		RecordCBOR: buf.Bytes(),
+	}
+	return engine.NewRecordContext(context.Background(), eng, am, op)
+}
+
+func TestPostRules(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	eng := engine.EngineTestFixture()
+
+	cr, err := LoadPath("testdata/rules.yaml")
+	assert.NoError(err)
+	assert.Len(cr.Rules, 5)
+
+	// no matches
+	c1 := testPostContext(t, &eng, &appbsky.FeedPost{Text: "free crypto!", Tags: []string{"one"}})
+	assert.NoError(cr.RunRecordRules(&c1))
+	eff1 := engine.ExtractEffects(&c1.BaseContext)
+	assert.Empty(eff1.RecordFlags)
+	assert.Empty(eff1.RecordLabels)
+	assert.Empty(eff1.CounterIncrements)
+
+	// hashtag set membership, counter with placeholder
+	c2 := testPostContext(t, &eng, &appbsky.FeedPost{Text: "hello", Tags: []string{"one", "slur"}})
+	assert.NoError(cr.RunRecordRules(&c2))
+	eff2 := engine.ExtractEffects(&c2.BaseContext)
+	assert.Equal([]string{"bad-hashtag"}, eff2.RecordFlags)
+	assert.Len(eff2.CounterIncrements, 1)
+	assert.Equal("did:plc:abc111", eff2.CounterIncrements[0].Val)
+
+	// counter threshold, case-insensitive substring, templated report comment
+	assert.NoError(eng.Counters.IncrementPeriod(ctx, "crypto-spam", "did:plc:abc111", countstore.PeriodDay))
+	assert.NoError(eng.Counters.IncrementPeriod(ctx, "crypto-spam", "did:plc:abc111", countstore.PeriodDay))
+	c3 := testPostContext(t, &eng, &appbsky.FeedPost{Text: "FREE CRYPTO here"})
+	assert.NoError(cr.RunRecordRules(&c3))
+	eff3 := engine.ExtractEffects(&c3.BaseContext)
+	assert.Equal([]string{"spam"}, eff3.RecordLabels)
+	assert.Len(eff3.AccountReports, 1)
+	assert.Equal(engine.ReportReasonSpam, eff3.AccountReports[0].ReasonType)
+	assert.Equal("repeated crypto spam from handle.example.com", eff3.AccountReports[0].Comment)
+
+	// nested array traversal
+	uri := "https://spam.example.com/buy"
+	c4 := testPostContext(t, &eng, &appbsky.FeedPost{
+		Text: "link",
+		Facets: []*appbsky.RichtextFacet{{
+			Index:    &appbsky.RichtextFacet_ByteSlice{ByteStart: 0, ByteEnd: 4},
+			Features: []*appbsky.RichtextFacet_Features_Elem{{RichtextFacet_Link: &appbsky.RichtextFacet_Link{Uri: uri}}},
+		}},
+	})
+	assert.NoError(cr.RunRecordRules(&c4))
+	eff4 := engine.ExtractEffects(&c4.BaseContext)
+	assert.Equal([]string{"spam-link"}, eff4.RecordTags)
+}
+
+func TestIdentityRules(t *testing.T) {
+	assert := assert.New(t)
+	eng := engine.EngineTestFixture()
+
+	cr, err := LoadPath("testdata/rules.yaml")
+	assert.NoError(err)
+
+	am := testAccount()
+	c1 := engine.NewAccountContext(context.Background(), &eng, am)
+	assert.NoError(cr.RunIdentityRules(&c1))
+	assert.Empty(engine.ExtractEffects(&c1.BaseContext).AccountFlags)
+
+	am.Identity.Handle = syntax.Handle("other.test")
+	c2 := engine.NewAccountContext(context.Background(), &eng, am)
+	assert.NoError(cr.RunIdentityRules(&c2))
+	assert.Equal([]string{"unusual-handle"}, engine.ExtractEffects(&c2.BaseContext).AccountFlags)
+}
+
+func TestValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := LoadPath("testdata/invalid.yaml")
+	assert.Error(err)
+	msg := err.Error()
+	for _, name := range []string{"bad-field", "bad-regex", "two-effects", "bad-period", "bad-window", "record-effect-on-account"} {
+		assert.Contains(msg, `rule "`+name+`"`)
+	}
+
+	// unknown fields are rejected
+	_, err = ParseRuleFile(strings.NewReader("rules:\n  - name: x\n    on: post\n    acions: []\n"))
+	assert.Error(err)
+
+	// duplicate names across files
+	a, err := ParseRuleFile(strings.NewReader("rules:\n  - name: x\n    on: post\n    actions: [{add_record_flag: a}]\n"))
+	assert.NoError(err)
+	_, err = Compile(a, a)
+	assert.ErrorContains(err, "duplicate name")
+}
+
+func TestLoaderReload(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	p := filepath.Join(dir, "rules.yaml")
+	write := func(s string, mtime time.Time) {
+		assert.NoError(os.WriteFile(p, []byte(s), 0644))
+		assert.NoError(os.Chtimes(p, mtime, mtime))
+	}
+	now := time.Now()
+	write("rules:\n  - name: one\n    on: post\n    actions: [{add_record_flag: one}]\n", now.Add(-time.Minute))
+
+	l, err := NewLoader(dir, nil)
+	assert.NoError(err)
+	assert.Len(l.Rules().Rules, 1)
+
+	// unchanged files are not re-loaded
+	changed, err := l.Reload()
+	assert.NoError(err)
+	assert.False(changed)
+
+	// invalid files are rejected, and previous rules kept
+	write("rules:\n  - name: two\n    on: nope\n    actions: [{add_record_flag: two}]\n", now)
+	changed, err = l.Reload()
+	assert.Error(err)
+	assert.False(changed)
+	assert.Equal("one", l.Rules().Rules[0].Name)
+
+	write("rules:\n  - name: two\n    on: post\n    actions: [{add_record_flag: two}]\n", now.Add(time.Minute))
+	changed, err = l.Reload()
+	assert.NoError(err)
+	assert.True(changed)
+	assert.Equal("two", l.Rules().Rules[0].Name)
+}
+
+func TestWindowIncrements(t *testing.T) {
+	assert := assert.New(t)
+	eng := engine.EngineTestFixture()
+
+	// the counter read with a window is incremented by a rule earlier in the file
+	rf, err := ParseRuleFile(strings.NewReader(`rules:
+  - name: count-posts
+    on: post
+    actions:
+      - increment: {name: posts, key: "${did}"}
+      - increment: {name: other-posts, key: "${did}"}
+  - name: post-burst
+    on: post
+    when:
+      count: {name: posts, key: "${did}", window: 10m}
+      gte: 3
+    actions:
+      - add_record_flag: post-burst
+`))
+	assert.NoError(err)
+	cr, err := Compile(rf)
+	assert.NoError(err)
+
+	c1 := testPostContext(t, &eng, &appbsky.FeedPost{Text: "hello"})
+	assert.NoError(cr.RunRecordRules(&c1))
+	eff1 := engine.ExtractEffects(&c1.BaseContext)
+	assert.Len(eff1.CounterIncrements, 2)
+	for _, ref := range eff1.CounterIncrements {
+		assert.Equal(ref.Name == "posts", ref.Window)
+	}
+}
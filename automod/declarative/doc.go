@@ -0,0 +1,30 @@
+/*
+Declarative automod rules, defined in YAML files and loaded at runtime (without re-compiling the daemon).
+
+A rule file contains a list of rules. Each rule runs on a single type of event, has an optional condition (`when`), and a list of effects (`actions`) which are applied if the condition matches:
+
+	rules:
+	  - name: crypto-spam-reply
+	    on: post
+	    when:
+	      all:
+	        - field: record.text
+	          contains: "free crypto"
+	        - field: account.age_days
+	          lt: 7
+	        - count: {name: crypto-spam, key: "${did}", period: day}
+	          gte: 2
+	    actions:
+	      - add_record_label: spam
+	      - increment: {name: crypto-spam, key: "${did}", period: day}
+	      - report_account: {reason: spam, comment: "crypto spam replies from new account"}
+
+Rule types (`on`) are: "post", "profile", "record" (any collection, optionally limited by `collections`), "delete" (record deletions), "identity", and "account".
+
+Conditions can be nested with `all`, `any`, and `not`. Leaf conditions either compare a field value (`equals`, `contains`, `matches`, `in_set`, `exists`, `lt`, `lte`, `gt`, `gte`), or compare a counter value (`count`, with the numeric comparisons; counts are for a `period` bucket, or for a sliding `window` like "10m"). Field paths include event metadata (`did`, `handle`, `collection`, `rkey`, `action`, `uri`, `cid`), account metadata (eg `account.followers`, `account.age_days`, `account.labels`), and record data (`record.` followed by a dot-separated path in to the record; arrays are traversed automatically). When a path resolves to multiple values, the condition matches if any value matches.
+
+String arguments to counters, sets, and effects may include `${<path>}` placeholders, which are substituted with field values.
+
+Rule files are fully validated before any rule is activated: a file with any errors is rejected as a whole, and the previously loaded rules remain in effect.
+*/
+package declarative
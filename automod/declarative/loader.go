@@ -0,0 +1,205 @@
+package declarative
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluesky-social/indigo/automod/engine"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Parses a single YAML rule file. Unknown fields are an error, to catch typos. Does not validate the rules themselves (see Compile).
+func ParseRuleFile(r io.Reader) (*RuleFile, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	var rf RuleFile
+	if err := dec.Decode(&rf); err != nil {
+		if errors.Is(err, io.EOF) {
+			// empty file
+			return &rf, nil
+		}
+		return nil, err
+	}
+	return &rf, nil
+}
+
+// Returns the rule files at a path: either a single file, or all the .yaml and .yml files in a directory (not recursive), sorted by name.
+func ruleFilePaths(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		paths = append(paths, filepath.Join(path, e.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Parses and validates all rule files at a path (file or directory). Errors are prefixed with the file they were found in.
+func LoadPath(path string) (*CompiledRules, error) {
+	paths, err := ruleFilePaths(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []*RuleFile
+	var errs []error
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		rf, err := ParseRuleFile(f)
+		f.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+			continue
+		}
+		// compile each file on its own first, so errors can be attributed to a file
+		if _, err := Compile(rf); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+			continue
+		}
+		files = append(files, rf)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	// this catches rule names duplicated across files
+	return Compile(files...)
+}
+
+// Keeps a set of declarative rules loaded from disk, and re-loads them when the files change.
+//
+// Rules are only swapped in after the new files are fully validated; if there are any errors, the previous rules remain active.
+type Loader struct {
+	path   string
+	logger *slog.Logger
+
+	rules atomic.Pointer[CompiledRules]
+
+	// serializes reloads
+	lk          sync.Mutex
+	fingerprint string
+}
+
+// Creates a loader and does an initial load of rules. Returns an error if any rule files are invalid.
+func NewLoader(path string, logger *slog.Logger) (*Loader, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	l := &Loader{
+		path:   path,
+		logger: logger.With("component", "declarative-rules"),
+	}
+	if _, err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Currently active rules
+func (l *Loader) Rules() *CompiledRules {
+	return l.rules.Load()
+}
+
+// summary of rule file names, sizes, and modification times, used to detect changes
+func (l *Loader) currentFingerprint() (string, error) {
+	paths, err := ruleFilePaths(l.path)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d\n", p, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// Re-loads rules if any rule files have changed. Returns true if new rules were activated. On error, the previous rules stay active.
+func (l *Loader) Reload() (bool, error) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	fp, err := l.currentFingerprint()
+	if err != nil {
+		return false, err
+	}
+	if fp == l.fingerprint && l.rules.Load() != nil {
+		return false, nil
+	}
+	cr, err := LoadPath(l.path)
+	if err != nil {
+		return false, err
+	}
+	l.fingerprint = fp
+	l.rules.Store(cr)
+	l.logger.Info("activated declarative rules", "path", l.path, "count", len(cr.Rules))
+	return true, nil
+}
+
+// Periodically checks for changed rule files until the context is cancelled. Validation errors are logged, and the previous rules kept.
+func (l *Loader) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	var lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := l.Reload(); err != nil {
+			// only log each distinct error once, instead of every period
+			if err.Error() != lastErr {
+				l.logger.Error("failed to reload declarative rules; keeping previous rules", "path", l.path, "err", err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+	}
+}
+
+// Adds rule functions to the engine ruleset which run the currently active declarative rules.
+func (l *Loader) RegisterRules(rs *engine.RuleSet) {
+	rs.AddRecordRule("declarative-record", func(c *engine.RecordContext) error {
+		return l.Rules().RunRecordRules(c)
+	})
+	rs.AddRecordDeleteRule("declarative-delete", func(c *engine.RecordContext) error {
+		return l.Rules().RunRecordDeleteRules(c)
+	})
+	rs.AddIdentityRule("declarative-identity", func(c *engine.AccountContext) error {
+		return l.Rules().RunIdentityRules(c)
+	})
+	rs.AddAccountRule("declarative-account", func(c *engine.AccountContext) error {
+		return l.Rules().RunAccountRules(c)
+	})
+}
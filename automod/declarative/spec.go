@@ -0,0 +1,104 @@
+package declarative
+
+// Top-level structure of a YAML rule file
+type RuleFile struct {
+	Rules []RuleSpec `yaml:"rules"`
+}
+
+type RuleSpec struct {
+	// unique (across all loaded files) name of the rule
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+
+	// event type the rule runs on: post, profile, record, delete, identity, or account
+	On string `yaml:"on"`
+
+	// optional list of collections (NSIDs) for "record" and "delete" rules
+	Collections []string `yaml:"collections,omitempty"`
+
+	// optional; rules with no condition always match
+	When *ConditionSpec `yaml:"when,omitempty"`
+
+	Actions []ActionSpec `yaml:"actions"`
+}
+
+// A condition is either a boolean combination (exactly one of All, Any, or Not), a field comparison (Field with exactly one string operator, or any numeric comparisons), or a counter comparison (Count with numeric comparisons).
+type ConditionSpec struct {
+	All []ConditionSpec `yaml:"all,omitempty"`
+	Any []ConditionSpec `yaml:"any,omitempty"`
+	Not *ConditionSpec  `yaml:"not,omitempty"`
+
+	Field string     `yaml:"field,omitempty"`
+	Count *CountSpec `yaml:"count,omitempty"`
+
+	// exact string match
+	Equals *string `yaml:"equals,omitempty"`
+	// case-insensitive substring match
+	Contains *string `yaml:"contains,omitempty"`
+	// regular expression (Go RE2 syntax)
+	Matches *string `yaml:"matches,omitempty"`
+	// name of a set (in the engine's SetStore)
+	InSet *string `yaml:"in_set,omitempty"`
+	// whether the field has any value
+	Exists *bool `yaml:"exists,omitempty"`
+
+	LT  *float64 `yaml:"lt,omitempty"`
+	LTE *float64 `yaml:"lte,omitempty"`
+	GT  *float64 `yaml:"gt,omitempty"`
+	GTE *float64 `yaml:"gte,omitempty"`
+}
+
+type CountSpec struct {
+	Name string `yaml:"name"`
+	// counter value (or bucket, for distinct counters); may include placeholders
+	Key string `yaml:"key"`
+	// total, day, or hour. defaults to total
+	Period string `yaml:"period,omitempty"`
+	// if true, returns the number of distinct values in the bucket (see GetCountDistinct)
+	Distinct bool `yaml:"distinct,omitempty"`
+	// sliding window duration (eg, "10m"; at most 24h), instead of a period. counts increments made without a period, by declarative or Go rules (increment actions on a counter which any declarative rule reads with a window record the extra data needed for this from the start; other increments are recorded once the counter has been read with a window)
+	Window string `yaml:"window,omitempty"`
+}
+
+// Exactly one field of an action should be set.
+type ActionSpec struct {
+	AddRecordLabel    string      `yaml:"add_record_label,omitempty"`
+	RemoveRecordLabel string      `yaml:"remove_record_label,omitempty"`
+	AddRecordFlag     string      `yaml:"add_record_flag,omitempty"`
+	AddRecordTag      string      `yaml:"add_record_tag,omitempty"`
+	ReportRecord      *ReportSpec `yaml:"report_record,omitempty"`
+	TakedownRecord    bool        `yaml:"takedown_record,omitempty"`
+	EscalateRecord    bool        `yaml:"escalate_record,omitempty"`
+	AcknowledgeRecord bool        `yaml:"acknowledge_record,omitempty"`
+
+	AddAccountLabel    string      `yaml:"add_account_label,omitempty"`
+	RemoveAccountLabel string      `yaml:"remove_account_label,omitempty"`
+	AddAccountFlag     string      `yaml:"add_account_flag,omitempty"`
+	AddAccountTag      string      `yaml:"add_account_tag,omitempty"`
+	ReportAccount      *ReportSpec `yaml:"report_account,omitempty"`
+	TakedownAccount    bool        `yaml:"takedown_account,omitempty"`
+	EscalateAccount    bool        `yaml:"escalate_account,omitempty"`
+	AcknowledgeAccount bool        `yaml:"acknowledge_account,omitempty"`
+
+	Increment         *IncrementSpec `yaml:"increment,omitempty"`
+	IncrementDistinct *IncrementSpec `yaml:"increment_distinct,omitempty"`
+
+	// name of notification service, eg "slack"
+	Notify string `yaml:"notify,omitempty"`
+}
+
+type ReportSpec struct {
+	// short name (spam, violation, misleading, sexual, rude, other), or full reason type
+	Reason  string `yaml:"reason"`
+	Comment string `yaml:"comment"`
+}
+
+type IncrementSpec struct {
+	Name string `yaml:"name"`
+	// counter value; may include placeholders
+	Key string `yaml:"key"`
+	// for regular counters: optionally only increment a single period bucket (total, day, hour)
+	Period string `yaml:"period,omitempty"`
+	// for distinct counters: the bucket; may include placeholders
+	Bucket string `yaml:"bucket,omitempty"`
+}
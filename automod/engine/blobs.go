@@ -42,6 +42,11 @@ func (c *RecordContext) Blobs() ([]lexutil.LexBlob, error) {
 }
 
 func (c *RecordContext) fetchBlob(blob lexutil.LexBlob) ([]byte, error) {
+	return c.FetchBlob(blob.Ref.String())
+}
+
+// Downloads a blob (by CID) for the account from its PDS. Blob rules are passed blob data directly; this is for rules which need blobs outside of record processing (eg, blobs referenced by ozone events).
+func (c *AccountContext) FetchBlob(cid string) ([]byte, error) {
 
 	start := time.Now()
 	defer func() {
@@ -53,7 +58,7 @@ func (c *RecordContext) fetchBlob(blob lexutil.LexBlob) ([]byte, error) {
 
 	// TODO: potential security issue here with malformed or "localhost" PDS endpoint
 	pdsEndpoint := c.Account.Identity.PDSEndpoint()
-	xrpcURL := fmt.Sprintf("%s/xrpc/com.atproto.sync.getBlob?did=%s&cid=%s", pdsEndpoint, c.Account.Identity.DID, blob.Ref)
+	xrpcURL := fmt.Sprintf("%s/xrpc/com.atproto.sync.getBlob?did=%s&cid=%s", pdsEndpoint, c.Account.Identity.DID, cid)
 
 	req, err := http.NewRequest("GET", xrpcURL, nil)
 	if err != nil {
@@ -83,7 +88,7 @@ func (c *RecordContext) fetchBlob(blob lexutil.LexBlob) ([]byte, error) {
 	blobDownloadCount.WithLabelValues(fmt.Sprint(resp.StatusCode)).Inc()
 	if resp.StatusCode != 200 {
 		io.Copy(io.Discard, resp.Body)
-		return nil, fmt.Errorf("failed to fetch blob from PDS. did=%s cid=%s statusCode=%d", c.Account.Identity.DID, blob.Ref, resp.StatusCode)
+		return nil, fmt.Errorf("failed to fetch blob from PDS. did=%s cid=%s statusCode=%d", c.Account.Identity.DID, cid, resp.StatusCode)
 	}
 
 	blobBytes, err = io.ReadAll(resp.Body)
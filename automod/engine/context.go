@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
 
 	toolsozone "github.com/bluesky-social/indigo/api/ozone"
 	"github.com/bluesky-social/indigo/atproto/identity"
@@ -21,6 +22,8 @@ type BaseContext struct {
 
 	engine  *Engine // NOTE: pointer, but expected never to be nil
 	effects *Effects
+	// true when running the engine's shadow ruleset
+	shadow bool
 }
 
 // Both a useful context on it's own (eg, for identity events), and extended by other context types.
@@ -87,8 +90,9 @@ type OzoneEvent struct {
 	CreatedBy  syntax.DID
 	SubjectDID syntax.DID
 	SubjectURI *syntax.ATURI
-	// TODO: SubjectBlobs []syntax.CID
-	Event toolsozone.ModerationDefs_ModEventView_Event
+	// blobs (by CID) the event applies to, for record subjects. May be empty even for record subjects.
+	SubjectBlobs []syntax.CID
+	Event        toolsozone.ModerationDefs_ModEventView_Event
 }
 
 // Checks that op has expected fields, based on the action type
@@ -131,6 +135,21 @@ func (c *BaseContext) GetCount(name, val, period string) int {
 	return out
 }
 
+// Returns the number of increments of a counter in the sliding window of the given duration (up to countstore.MaxWindow) ending now.
+//
+// Reading a counter with a window marks it as windowed, so that from then on all increments of the counter (without a period) are recorded for sliding-window counts, whichever rule makes them.
+func (c *BaseContext) GetCountWindow(name, val string, window time.Duration) int {
+	markWindowed(name)
+	out, err := c.engine.Counters.GetCountWindow(c.Ctx, name, val, window)
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return 0
+	}
+	return out
+}
+
 func (c *BaseContext) GetCountDistinct(name, bucket, period string) int {
 	out, err := c.engine.Counters.GetCountDistinct(c.Ctx, name, bucket, period)
 	if err != nil {
@@ -161,13 +180,18 @@ func (c *BaseContext) InternalEngine() *Engine {
 }
 
 func NewAccountContext(ctx context.Context, eng *Engine, meta AccountMeta) AccountContext {
+	effects := &Effects{}
+	// per-rule attribution of effects is needed to compare production and shadow rules, or to record per-rule effects
+	if eng.ShadowRules != nil || eng.RuleEffectsRecorder != nil {
+		effects.enableTrace()
+	}
 	return AccountContext{
 		BaseContext: BaseContext{
 			Ctx:     ctx,
 			Err:     nil,
 			Logger:  eng.Logger.With("did", meta.Identity.DID),
 			engine:  eng,
-			effects: &Effects{},
+			effects: effects,
 		},
 		Account: meta,
 	}
@@ -222,6 +246,10 @@ func (c *BaseContext) Increment(name, val string) {
 	c.effects.Increment(name, val)
 }
 
+func (c *BaseContext) IncrementWindow(name, val string) {
+	c.effects.IncrementWindow(name, val)
+}
+
 func (c *BaseContext) IncrementDistinct(name, bucket, val string) {
 	c.effects.IncrementDistinct(name, bucket, val)
 }
@@ -8,6 +8,8 @@ type CounterRef struct {
 	Name   string
 	Val    string
 	Period *string
+	// if true, the increment is also recorded for sliding-window counts
+	Window bool
 }
 
 type CounterDistinctRef struct {
@@ -64,6 +66,9 @@ type Effects struct {
 	RejectEvent bool
 	// Services, if any, which should blast out a notification about this even (eg, Slack)
 	NotifyServices []string
+
+	// optional per-rule attribution of effects (see RuleEffects)
+	trace *effectsTrace
 }
 
 // Enqueues the named counter to be incremented at the end of all rule processing. Will automatically increment for all time periods.
@@ -76,6 +81,13 @@ func (e *Effects) Increment(name, val string) {
 	e.CounterIncrements = append(e.CounterIncrements, CounterRef{Name: name, Val: val})
 }
 
+// Same as "Increment", but also records the increment for sliding-window counts (see GetCountWindow). This is more expensive to store, so should only be used for counters which are read with a window. Counters which are read with a window are recorded this way automatically once they have been read (or incremented with this method) at least once; this method makes sure increments are recorded before that.
+func (e *Effects) IncrementWindow(name, val string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.CounterIncrements = append(e.CounterIncrements, CounterRef{Name: name, Val: val, Window: true})
+}
+
 // Enqueues the named counter to be incremented at the end of all rule processing. Will only increment the indicated time period bucket.
 func (e *Effects) IncrementPeriod(name, val string, period string) {
 	e.mu.Lock()
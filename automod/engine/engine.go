@@ -13,6 +13,7 @@ import (
 	"github.com/bluesky-social/indigo/automod/cachestore"
 	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
 	"github.com/bluesky-social/indigo/automod/setstore"
 	"github.com/bluesky-social/indigo/xrpc"
 )
@@ -28,8 +29,12 @@ type Engine struct {
 	Sets      setstore.SetStore
 	Cache     cachestore.CacheStore
 	Flags     flagstore.FlagStore
+	// optional; if set, follow, block, like, and repost records are tracked, for graph helpers on rule contexts
+	Graph graphstore.GraphStore
 	// unlike the other sub-modules, this field (Notifier) may be nil
 	Notifier Notifier
+	// optional; if set, label effects are also published by this emitter (eg, when acting as a standalone labeler)
+	LabelEmitter LabelEmitter
 	// use to fetch public account metadata from AppView; no auth
 	BskyClient *xrpc.Client
 	// used to persist moderation actions in ozone moderation service; optional, admin auth
@@ -38,6 +43,14 @@ type Engine struct {
 	AdminClient *xrpc.Client
 	// used to fetch blobs from upstream PDS instances
 	BlobClient *http.Client
+	// optional secondary ruleset ("shadow mode"), run against the same events as Rules. Effects of shadow rules are recorded (see ShadowRecorder) and compared to production, but never persisted or acted on
+	ShadowRules *RuleSet
+	// where shadow mode results are recorded; may be nil, in which case only metrics are updated
+	ShadowRecorder ShadowRecorder
+	// optional; receives the effects of each rule for every processed event (eg, for offline replay statistics)
+	RuleEffectsRecorder RuleEffectsRecorder
+	// optional; if set, rules which keep failing or exceed a latency budget are automatically disabled
+	RuleGuard *RuleGuard
 
 	// internal configuration
 	Config EngineConfig
@@ -126,6 +139,8 @@ func (eng *Engine) ProcessIdentityEvent(ctx context.Context, evt comatproto.Sync
 		return fmt.Errorf("rule execution failed: %w", err)
 	}
 	eng.CanonicalLogLineAccount(&ac)
+	eng.recordRuleEffects(ctx, ac.Account.Identity.DID.String(), ac.effects)
+	eng.runShadowAccount(ctx, "identity", &ac)
 	if err := eng.persistAccountModActions(&ac); err != nil {
 		eventErrorCount.WithLabelValues("identity").Inc()
 		return fmt.Errorf("failed to persist actions for identity event: %w", err)
@@ -200,6 +215,8 @@ func (eng *Engine) ProcessAccountEvent(ctx context.Context, evt comatproto.SyncS
 		return fmt.Errorf("rule execution failed: %w", err)
 	}
 	eng.CanonicalLogLineAccount(&ac)
+	eng.recordRuleEffects(ctx, ac.Account.Identity.DID.String(), ac.effects)
+	eng.runShadowAccount(ctx, "account", &ac)
 	if err := eng.persistAccountModActions(&ac); err != nil {
 		eventErrorCount.WithLabelValues("account").Inc()
 		return fmt.Errorf("failed to persist actions for account event: %w", err)
@@ -261,6 +278,7 @@ func (eng *Engine) ProcessRecordOp(ctx context.Context, op RecordOp) error {
 			Profile:  ProfileSummary{},
 		}
 	}
+	eng.updateGraph(ctx, op)
 	rc := NewRecordContext(ctx, eng, *am, op)
 	rc.Logger.Debug("processing record")
 	switch op.Action {
@@ -279,6 +297,8 @@ func (eng *Engine) ProcessRecordOp(ctx context.Context, op RecordOp) error {
 		return fmt.Errorf("unexpected op action: %s", op.Action)
 	}
 	eng.CanonicalLogLineRecord(&rc)
+	eng.recordRuleEffects(ctx, op.ATURI().String(), rc.effects)
+	eng.runShadowRecord(ctx, &rc)
 	// purge the account meta cache when profile is updated
 	if rc.RecordOp.Collection == "app.bsky.actor.profile" {
 		if err := eng.PurgeAccountCaches(ctx, op.DID); err != nil {
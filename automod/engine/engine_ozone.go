@@ -91,20 +91,31 @@ func NewOzoneEventContext(ctx context.Context, eng *Engine, eventView *toolsozon
 		return nil, fmt.Errorf("empty ozone event subject")
 	}
 
+	subjectBlobs := []syntax.CID{}
+	for _, c := range eventView.SubjectBlobCids {
+		cidVal, err := syntax.ParseCID(c)
+		if err != nil {
+			// one bad blob reference shouldn't prevent processing the rest of the event
+			eng.Logger.Warn("skipping invalid ozone event subject blob CID", "eventID", eventView.Id, "cid", c, "err", err)
+			continue
+		}
+		subjectBlobs = append(subjectBlobs, cidVal)
+	}
+
 	createdAt, err := syntax.ParseDatetime(eventView.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 
 	evt := OzoneEvent{
-		EventType:  eventType,
-		EventID:    eventView.Id,
-		CreatedAt:  createdAt,
-		CreatedBy:  creatorDID,
-		SubjectDID: subjectDID,
-		SubjectURI: subjectURI,
-		// TODO: SubjectBlobs []syntax.CID
-		Event: *eventView.Event,
+		EventType:    eventType,
+		EventID:      eventView.Id,
+		CreatedAt:    createdAt,
+		CreatedBy:    creatorDID,
+		SubjectDID:   subjectDID,
+		SubjectURI:   subjectURI,
+		SubjectBlobs: subjectBlobs,
+		Event:        *eventView.Event,
 	}
 
 	creatorIdent, err := eng.Directory.LookupDID(ctx, evt.CreatedBy)
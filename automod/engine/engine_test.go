@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"testing"
+	"time"
 
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -49,3 +51,30 @@ func TestEngineBasics(t *testing.T) {
 	op.RecordCBOR = p2cbor
 	assert.NoError(eng.ProcessRecordOp(ctx, op))
 }
+
+func TestWindowedCounters(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.Rules = RuleSet{}
+	windowCount := -1
+	eng.Rules.AddPostRule("plain-increment", func(c *RecordContext, post *appbsky.FeedPost) error {
+		c.Increment("test-windowed-posts", c.Account.Identity.DID.String())
+		return nil
+	})
+	eng.Rules.AddPostRule("window-read", func(c *RecordContext, post *appbsky.FeedPost) error {
+		windowCount = c.GetCountWindow("test-windowed-posts", c.Account.Identity.DID.String(), 10*time.Minute)
+		return nil
+	})
+
+	// once the counter has been read with a window, plain increments by any rule are counted in the window
+	op := testPostOp(t, "hello")
+	for range 3 {
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+	assert.Equal(2, windowCount)
+	n, err := eng.Counters.GetCount(ctx, "test-windowed-posts", op.DID.String(), countstore.PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(3, n)
+}
@@ -22,16 +22,7 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 
 	logger := e.Logger.With("did", ident.DID.String())
 
-	// fallback in case client wasn't configured (eg, testing)
-	if e.BskyClient == nil {
-		logger.Debug("skipping account meta hydration")
-		am := AccountMeta{
-			Identity: ident,
-			Profile:  ProfileSummary{},
-		}
-		return &am, nil
-	}
-
+	// NOTE: cache is checked even if the client isn't configured, so that account metadata can be pre-populated (eg, for offline replay)
 	existing, err := e.Cache.Get(ctx, "acct", ident.DID.String())
 	if err != nil {
 		return nil, fmt.Errorf("failed checking account meta cache: %w", err)
@@ -46,6 +37,16 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 		return &am, nil
 	}
 
+	// fallback in case client wasn't configured (eg, testing)
+	if e.BskyClient == nil {
+		logger.Debug("skipping account meta hydration")
+		am := AccountMeta{
+			Identity: ident,
+			Profile:  ProfileSummary{},
+		}
+		return &am, nil
+	}
+
 	// doing a "full" fetch from here on
 	accountMetaFetches.Inc()
 	am := AccountMeta{
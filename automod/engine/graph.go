@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+)
+
+// relationship kind for each record collection tracked in the graph store
+var graphCollections = map[syntax.NSID]string{
+	"app.bsky.graph.follow": graphstore.KindFollow,
+	"app.bsky.graph.block":  graphstore.KindBlock,
+	"app.bsky.feed.like":    graphstore.KindLike,
+	"app.bsky.feed.repost":  graphstore.KindRepost,
+}
+
+// Extracts the subject (DID or AT-URI) of a relationship record.
+func graphSubject(kind string, raw []byte) (string, error) {
+	switch kind {
+	case graphstore.KindFollow:
+		var rec appbsky.GraphFollow
+		if err := rec.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+			return "", err
+		}
+		did, err := syntax.ParseDID(rec.Subject)
+		return did.String(), err
+	case graphstore.KindBlock:
+		var rec appbsky.GraphBlock
+		if err := rec.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+			return "", err
+		}
+		did, err := syntax.ParseDID(rec.Subject)
+		return did.String(), err
+	case graphstore.KindLike:
+		var rec appbsky.FeedLike
+		if err := rec.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+			return "", err
+		}
+		if rec.Subject == nil {
+			return "", fmt.Errorf("missing like subject")
+		}
+		uri, err := syntax.ParseATURI(rec.Subject.Uri)
+		return uri.String(), err
+	case graphstore.KindRepost:
+		var rec appbsky.FeedRepost
+		if err := rec.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+			return "", err
+		}
+		if rec.Subject == nil {
+			return "", fmt.Errorf("missing repost subject")
+		}
+		uri, err := syntax.ParseATURI(rec.Subject.Uri)
+		return uri.String(), err
+	}
+	return "", fmt.Errorf("unsupported relationship kind: %s", kind)
+}
+
+// Updates the graph store (if configured) for relationship records. This happens before rules are run, so rules see the current record as part of the graph.
+//
+// Failures are logged, but do not fail event processing.
+func (eng *Engine) updateGraph(ctx context.Context, op RecordOp) {
+	if eng.Graph == nil {
+		return
+	}
+	kind, ok := graphCollections[op.Collection]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	switch op.Action {
+	case CreateOp:
+		subject, err := graphSubject(kind, op.RecordCBOR)
+		if err != nil {
+			eng.Logger.Warn("failed to parse relationship record", "did", op.DID, "collection", op.Collection, "rkey", op.RecordKey, "err", err)
+			return
+		}
+		edge := graphstore.Edge{
+			Kind:      kind,
+			Actor:     op.DID.String(),
+			Subject:   subject,
+			RecordKey: op.RecordKey.String(),
+			CreatedAt: now,
+		}
+		if err := eng.Graph.AddEdge(ctx, edge); err != nil {
+			eng.Logger.Error("failed to update graph store", "did", op.DID, "collection", op.Collection, "rkey", op.RecordKey, "err", err)
+		}
+	case DeleteOp:
+		if err := eng.Graph.RemoveEdge(ctx, kind, op.DID.String(), op.RecordKey.String(), now); err != nil {
+			eng.Logger.Error("failed to update graph store", "did", op.DID, "collection", op.Collection, "rkey", op.RecordKey, "err", err)
+		}
+	}
+}
+
+// Returns whether two accounts follow each other, as seen in the graph store. Always false if no graph store is configured.
+func (c *BaseContext) IsMutualFollow(a, b syntax.DID) bool {
+	if c.engine.Graph == nil {
+		return false
+	}
+	for _, pair := range [][2]syntax.DID{{a, b}, {b, a}} {
+		ok, err := c.engine.Graph.HasEdge(c.Ctx, graphstore.KindFollow, pair[0].String(), pair[1].String())
+		if err != nil {
+			if nil == c.Err {
+				c.Err = err
+			}
+			return false
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the accounts which have mutual follow relationships with the given account, as seen in the graph store.
+func (c *BaseContext) GetMutualFollows(did syntax.DID) []syntax.DID {
+	if c.engine.Graph == nil {
+		return nil
+	}
+	dids, err := c.engine.Graph.MutualFollows(c.Ctx, did.String())
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return nil
+	}
+	out := make([]syntax.DID, len(dids))
+	for i, d := range dids {
+		out[i] = syntax.DID(d)
+	}
+	return out
+}
+
+// Returns the accounts which created a relationship of the given kind (eg, graphstore.KindLike) with the subject (a DID or AT-URI) within the window (up to graphstore.MaxWindow) ending now.
+func (c *BaseContext) GetRecentActors(kind, subject string, window time.Duration) []syntax.DID {
+	if c.engine.Graph == nil {
+		return nil
+	}
+	if window <= 0 || window > graphstore.MaxWindow {
+		if nil == c.Err {
+			c.Err = fmt.Errorf("graph window must be positive and at most %s: %s", graphstore.MaxWindow, window)
+		}
+		return nil
+	}
+	dids, err := c.engine.Graph.RecentActors(c.Ctx, kind, subject, time.Now().Add(-window))
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return nil
+	}
+	out := make([]syntax.DID, len(dids))
+	for i, d := range dids {
+		out[i] = syntax.DID(d)
+	}
+	return out
+}
+
+// Returns the accounts which liked the record within the window (up to graphstore.MaxWindow) ending now.
+func (c *BaseContext) GetRecentLikers(uri syntax.ATURI, window time.Duration) []syntax.DID {
+	return c.GetRecentActors(graphstore.KindLike, uri.String(), window)
+}
+
+// Returns the number of follows and unfollows by the account within the window (up to graphstore.MaxWindow) ending now. A high number of both is a sign of follow-spam ("follow churn").
+func (c *BaseContext) GetFollowChurn(did syntax.DID, window time.Duration) graphstore.Churn {
+	if c.engine.Graph == nil {
+		return graphstore.Churn{}
+	}
+	if window <= 0 || window > graphstore.MaxWindow {
+		if nil == c.Err {
+			c.Err = fmt.Errorf("graph window must be positive and at most %s: %s", graphstore.MaxWindow, window)
+		}
+		return graphstore.Churn{}
+	}
+	churn, err := c.engine.Graph.Churn(c.Ctx, graphstore.KindFollow, did.String(), time.Now().Add(-window))
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return graphstore.Churn{}
+	}
+	return churn
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+	"github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func graphTestOp(t *testing.T, did syntax.DID, collection, rkey string, rec util.CBOR) RecordOp {
+	cid1 := syntax.CID("cid123")
+	buf := new(bytes.Buffer)
+	if err := rec.MarshalCBOR(buf); err != nil {
+		t.Fatal(err)
+	}
+	return RecordOp{
+		Action:     CreateOp,
+		DID:        did,
+		Collection: syntax.NSID(collection),
+		RecordKey:  syntax.RecordKey(rkey),
+		CID:        &cid1,
+		RecordCBOR: buf.Bytes(),
+	}
+}
+
+func TestGraphHelpers(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.Graph = graphstore.NewMemGraphStore()
+	dir := eng.Directory.(*identity.MockDirectory)
+	id1 := syntax.DID("did:plc:abc111")
+	id2 := syntax.DID("did:plc:abc222")
+	dir.Insert(identity.Identity{DID: id2, Handle: syntax.Handle("other.example.com")})
+	post := syntax.ATURI("at://did:plc:abc111/app.bsky.feed.post/abc123")
+
+	now := syntax.DatetimeNow().String()
+	ops := []RecordOp{
+		graphTestOp(t, id1, "app.bsky.graph.follow", "f1", &appbsky.GraphFollow{Subject: id2.String(), CreatedAt: now}),
+		graphTestOp(t, id2, "app.bsky.graph.follow", "f2", &appbsky.GraphFollow{Subject: id1.String(), CreatedAt: now}),
+		graphTestOp(t, id2, "app.bsky.feed.like", "l1", &appbsky.FeedLike{Subject: &comatproto.RepoStrongRef{Uri: post.String(), Cid: "cid123"}, CreatedAt: now}),
+	}
+	for _, op := range ops {
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+
+	c := NewAccountContext(ctx, &eng, AccountMeta{Identity: &identity.Identity{DID: id1}})
+	assert.True(c.IsMutualFollow(id1, id2))
+	assert.Equal([]syntax.DID{id2}, c.GetMutualFollows(id1))
+	assert.Equal([]syntax.DID{id2}, c.GetRecentLikers(post, 10*time.Minute))
+	assert.Equal(graphstore.Churn{Added: 1}, c.GetFollowChurn(id2, time.Hour))
+	assert.NoError(c.Err)
+
+	// unfollow
+	assert.NoError(eng.ProcessRecordOp(ctx, RecordOp{
+		Action:     DeleteOp,
+		DID:        id2,
+		Collection: syntax.NSID("app.bsky.graph.follow"),
+		RecordKey:  syntax.RecordKey("f2"),
+	}))
+	assert.False(c.IsMutualFollow(id1, id2))
+	assert.Equal(graphstore.Churn{Added: 1, Removed: 1}, c.GetFollowChurn(id2, time.Hour))
+	assert.NoError(c.Err)
+
+	// windows are limited
+	c.GetRecentLikers(post, 48*time.Hour)
+	assert.Error(c.Err)
+}
@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/bluesky-social/indigo/automod/keyword"
+)
+
+// Interface for a type which publishes labels itself (eg, as a standalone atproto labeler), as an alternative or addition to pushing labels in to the Ozone moderation service.
+//
+// Implementations are expected to de-duplicate against labels they have already published: labels which are already active should not be re-published, and only active labels should be negated.
+type LabelEmitter interface {
+	// uri is either an account DID, or a record AT-URI. cid is nil for accounts.
+	EmitLabels(ctx context.Context, uri string, cid *string, add, remove []string) error
+}
+
+// Passes label effects to the engine's LabelEmitter (if configured). Labels which are both added and removed by the same event are only added.
+func (eng *Engine) emitLabels(ctx context.Context, uri string, cid *string, add, remove []string) error {
+	if eng.LabelEmitter == nil {
+		return nil
+	}
+	add = dedupeStrings(add)
+	rmd := []string{}
+	for _, lbl := range dedupeStrings(remove) {
+		if !keyword.TokenInSet(lbl, add) {
+			rmd = append(rmd, lbl)
+		}
+	}
+	if len(add) == 0 && len(rmd) == 0 {
+		return nil
+	}
+	return eng.LabelEmitter.EmitLabels(ctx, uri, cid, add, rmd)
+}
@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type emittedLabels struct {
+	uri    string
+	cid    *string
+	add    []string
+	remove []string
+}
+
+type testLabelEmitter struct {
+	emitted []emittedLabels
+}
+
+func (e *testLabelEmitter) EmitLabels(ctx context.Context, uri string, cid *string, add, remove []string) error {
+	e.emitted = append(e.emitted, emittedLabels{uri: uri, cid: cid, add: add, remove: remove})
+	return nil
+}
+
+func TestLabelEmitter(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	emitter := &testLabelEmitter{}
+	eng.LabelEmitter = emitter
+	eng.Rules.AddPostRule("label-rule", func(c *RecordContext, post *appbsky.FeedPost) error {
+		if post.Text == "label me" {
+			c.AddRecordLabel("spam")
+			c.AddRecordLabel("spam")
+			c.RemoveRecordLabel("spam")
+			c.RemoveRecordLabel("rude")
+			c.AddAccountLabel("spammer")
+		}
+		return nil
+	})
+
+	// no label effects, nothing emitted
+	assert.NoError(eng.ProcessRecordOp(ctx, testPostOp(t, "hello")))
+	assert.Empty(emitter.emitted)
+
+	assert.NoError(eng.ProcessRecordOp(ctx, testPostOp(t, "label me")))
+	assert.Len(emitter.emitted, 2)
+	assert.Equal("did:plc:abc111", emitter.emitted[0].uri)
+	assert.Nil(emitter.emitted[0].cid)
+	assert.Equal([]string{"spammer"}, emitter.emitted[0].add)
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/abc123", emitter.emitted[1].uri)
+	assert.Equal("cid123", *emitter.emitted[1].cid)
+	assert.Equal([]string{"spam"}, emitter.emitted[1].add)
+	assert.Equal([]string{"rude"}, emitter.emitted[1].remove)
+}
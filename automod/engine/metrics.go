@@ -74,3 +74,39 @@ var blobDownloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 	Name: "automod_blob_download_duration_sec",
 	Help: "Duration of blob download attempts",
 })
+
+var shadowDiffCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_shadow_rule_diffs",
+	Help: "Number of events where shadow and production effects differed, by rule name",
+}, []string{"rule"})
+
+var ruleInvocationCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_rule_invocations",
+	Help: "Number of times each rule was run",
+}, []string{"rule", "ruleset"})
+
+var ruleHitCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_rule_hits",
+	Help: "Number of rule invocations which produced any effects (not including concurrent rules, like blob rules)",
+}, []string{"rule", "ruleset"})
+
+var ruleErrorCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_rule_errors",
+	Help: "Number of rule invocations which returned an error",
+}, []string{"rule", "ruleset"})
+
+var ruleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "automod_rule_duration_sec",
+	Help:    "Duration of individual rule invocations",
+	Buckets: []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+}, []string{"rule", "ruleset"})
+
+var ruleSkippedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_rule_skipped",
+	Help: "Number of rule invocations skipped because the rule was auto-disabled",
+}, []string{"rule", "ruleset"})
+
+var ruleDisabled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "automod_rule_disabled",
+	Help: "Whether a rule is currently auto-disabled (1) or not (0)",
+}, []string{"rule", "ruleset"})
@@ -3,26 +3,45 @@ package engine
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/keyword"
 )
 
+// names of counters which have been read with a sliding window, or incremented with IncrementWindow, by any rule. Windowing is a property of the counter name (not of the rule doing the increment), so that windowed reads see increments from every rule.
+var windowedCounters sync.Map
+
+func markWindowed(name string) {
+	if _, ok := windowedCounters.Load(name); !ok {
+		windowedCounters.Store(name, true)
+	}
+}
+
+func isWindowed(name string) bool {
+	_, ok := windowedCounters.Load(name)
+	return ok
+}
+
 func (eng *Engine) persistCounters(ctx context.Context, eff *Effects) error {
 	// TODO: dedupe this array
-	for _, ref := range eff.CounterIncrements {
-		if ref.Period != nil {
-			err := eng.Counters.IncrementPeriod(ctx, ref.Name, ref.Val, *ref.Period)
-			if err != nil {
-				return err
-			}
-		} else {
-			err := eng.Counters.Increment(ctx, ref.Name, ref.Val)
-			if err != nil {
-				return err
+	if len(eff.CounterIncrements) > 0 {
+		incs := make([]countstore.CountIncrement, len(eff.CounterIncrements))
+		for i, ref := range eff.CounterIncrements {
+			incs[i] = countstore.CountIncrement{Name: ref.Name, Val: ref.Val, Window: ref.Window}
+			if ref.Period != nil {
+				incs[i].Period = *ref.Period
+			} else if ref.Window {
+				markWindowed(ref.Name)
+			} else {
+				incs[i].Window = isWindowed(ref.Name)
 			}
 		}
+		if err := eng.Counters.IncrementBatch(ctx, incs); err != nil {
+			return err
+		}
 	}
 	for _, ref := range eff.CounterDistinctIncrements {
 		err := eng.Counters.IncrementDistinct(ctx, ref.Name, ref.Bucket, ref.Val)
@@ -111,9 +130,14 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		eng.Flags.Add(ctx, c.Account.Identity.DID.String(), newFlags)
 	}
 
+	// labels are published locally independent of the mod service; the emitter does its own de-duplication
+	if err := eng.emitLabels(ctx, c.Account.Identity.DID.String(), nil, c.effects.AccountLabels, c.effects.RemovedAccountLabels); err != nil {
+		c.Logger.Error("failed to emit account labels", "err", err)
+	}
+
 	// if we can't actually talk to service, bail out early
 	if eng.OzoneClient == nil {
-		if anyModActions {
+		if anyModActions && eng.LabelEmitter == nil {
 			c.Logger.Warn("not persisting actions, mod service client not configured")
 		}
 		return nil
@@ -369,13 +393,22 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 		eng.Flags.Add(ctx, atURI, newFlags)
 	}
 
+	if c.RecordOp.CID != nil {
+		cidStr := c.RecordOp.CID.String()
+		if err := eng.emitLabels(ctx, atURI, &cidStr, c.effects.RecordLabels, c.effects.RemovedRecordLabels); err != nil {
+			c.Logger.Error("failed to emit record labels", "err", err)
+		}
+	}
+
 	// exit early
 	if !newAcknowledge && !newEscalation && !newTakedown && len(newLabels) == 0 && len(rmdLabels) == 0 && len(newTags) == 0 && len(newReports) == 0 {
 		return nil
 	}
 
 	if eng.OzoneClient == nil {
-		c.Logger.Warn("not persisting actions because mod service client not configured")
+		if eng.LabelEmitter == nil {
+			c.Logger.Warn("not persisting actions because mod service client not configured")
+		}
 		return nil
 	}
 
@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+type RuleGuardConfig struct {
+	// if non-zero, a rule which returns an error this many times in a row is disabled
+	MaxConsecutiveErrors int
+	// if non-zero, invocations longer than this count against the rule's latency budget
+	LatencyBudget time.Duration
+	// number of over-budget invocations in a row before a rule is disabled (default 1)
+	MaxConsecutiveSlow int
+	// how long a rule stays disabled before it is automatically re-enabled. zero means until manually re-enabled (or restart)
+	DisablePeriod time.Duration
+}
+
+// Names of the rulesets an engine runs. Rules are tracked (and labeled in metrics) per ruleset, because the shadow ruleset may contain rules with the same names as production.
+const (
+	RulesetProduction = "production"
+	RulesetShadow     = "shadow"
+)
+
+// Tracks rule health, and auto-disables rules which keep erroring or exceed a latency budget, so that a single bad rule can't degrade the whole pipeline. Safe for concurrent use.
+type RuleGuard struct {
+	config RuleGuardConfig
+	logger *slog.Logger
+
+	lk    sync.Mutex
+	rules map[ruleKey]*ruleHealth
+}
+
+type ruleKey struct {
+	ruleset string
+	name    string
+}
+
+type ruleHealth struct {
+	consecutiveErrors int
+	consecutiveSlow   int
+	disabled          *DisabledRule
+}
+
+// Describes a rule which has been auto-disabled
+type DisabledRule struct {
+	Ruleset string    `json:"ruleset"`
+	Rule    string    `json:"rule"`
+	Reason  string    `json:"reason"`
+	Since   time.Time `json:"since"`
+	// zero if the rule stays disabled until manually re-enabled
+	Until time.Time `json:"until,omitempty"`
+}
+
+func NewRuleGuard(config RuleGuardConfig, logger *slog.Logger) *RuleGuard {
+	if config.MaxConsecutiveSlow <= 0 {
+		config.MaxConsecutiveSlow = 1
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &RuleGuard{
+		config: config,
+		logger: logger.With("component", "rule-guard"),
+		rules:  make(map[ruleKey]*ruleHealth),
+	}
+}
+
+// whether the rule should be run. re-enables rules whose disable period has passed
+func (g *RuleGuard) allow(ruleset, name string) bool {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+	k := ruleKey{ruleset, name}
+	h, ok := g.rules[k]
+	if !ok || h.disabled == nil {
+		return true
+	}
+	if !h.disabled.Until.IsZero() && time.Now().After(h.disabled.Until) {
+		g.logger.Info("re-enabling rule after disable period", "ruleset", ruleset, "rule", name)
+		g.enable(k, h)
+		return true
+	}
+	return false
+}
+
+// records the outcome of a rule invocation, possibly disabling the rule
+func (g *RuleGuard) observe(ruleset, name string, dur time.Duration, err error) {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+	k := ruleKey{ruleset, name}
+	h, ok := g.rules[k]
+	if !ok {
+		h = &ruleHealth{}
+		g.rules[k] = h
+	}
+	if h.disabled != nil {
+		// concurrent invocations which started before the rule was disabled
+		return
+	}
+
+	if err != nil {
+		h.consecutiveErrors++
+	} else {
+		h.consecutiveErrors = 0
+	}
+	if g.config.LatencyBudget > 0 && dur > g.config.LatencyBudget {
+		h.consecutiveSlow++
+	} else {
+		h.consecutiveSlow = 0
+	}
+
+	var reason string
+	switch {
+	case g.config.MaxConsecutiveErrors > 0 && h.consecutiveErrors >= g.config.MaxConsecutiveErrors:
+		reason = fmt.Sprintf("%d consecutive errors (last: %v)", h.consecutiveErrors, err)
+	case g.config.LatencyBudget > 0 && h.consecutiveSlow >= g.config.MaxConsecutiveSlow:
+		reason = fmt.Sprintf("%d consecutive invocations over latency budget of %s (last: %s)", h.consecutiveSlow, g.config.LatencyBudget, dur)
+	default:
+		return
+	}
+
+	now := time.Now()
+	h.disabled = &DisabledRule{
+		Ruleset: ruleset,
+		Rule:    name,
+		Reason:  reason,
+		Since:   now,
+	}
+	if g.config.DisablePeriod > 0 {
+		h.disabled.Until = now.Add(g.config.DisablePeriod)
+	}
+	ruleDisabled.WithLabelValues(name, ruleset).Set(1)
+	g.logger.Warn("auto-disabling rule", "ruleset", ruleset, "rule", name, "reason", reason, "until", h.disabled.Until)
+}
+
+// caller must hold lock
+func (g *RuleGuard) enable(k ruleKey, h *ruleHealth) {
+	h.disabled = nil
+	h.consecutiveErrors = 0
+	h.consecutiveSlow = 0
+	ruleDisabled.WithLabelValues(k.name, k.ruleset).Set(0)
+}
+
+// Re-enables an auto-disabled rule in the given ruleset (eg, RulesetProduction). Returns false if the rule was not disabled.
+func (g *RuleGuard) Enable(ruleset, name string) bool {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+	k := ruleKey{ruleset, name}
+	h, ok := g.rules[k]
+	if !ok || h.disabled == nil {
+		return false
+	}
+	g.enable(k, h)
+	return true
+}
+
+// Currently disabled rules, sorted by ruleset and name
+func (g *RuleGuard) Disabled() []DisabledRule {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+	var out []DisabledRule
+	for _, h := range g.rules {
+		if h.disabled != nil {
+			out = append(out, *h.disabled)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Ruleset != out[j].Ruleset {
+			return out[i].Ruleset < out[j].Ruleset
+		}
+		return out[i].Rule < out[j].Rule
+	})
+	return out
+}
+
+// Runs a single rule invocation, with per-rule metrics, effect attribution (if tracing is enabled), and auto-disabling (if the engine has a RuleGuard).
+//
+// "trace" should be false for rules which run concurrently with other rules (eg, blob rules), because effects can't be attributed reliably. Such rules are also not included in per-rule hit counts.
+func (c *BaseContext) runRule(name string, trace bool, call func() error) error {
+	ruleset := c.ruleset()
+	guard := c.engine.RuleGuard
+	if guard != nil && !guard.allow(ruleset, name) {
+		ruleSkippedCount.WithLabelValues(name, ruleset).Inc()
+		return nil
+	}
+
+	ruleInvocationCount.WithLabelValues(name, ruleset).Inc()
+	before := c.effects.currentMark()
+	start := time.Now()
+	var err error
+	if trace && c.effects.tracing() {
+		c.effects.startRule(name)
+		err = call()
+		c.effects.endRule()
+	} else {
+		err = call()
+	}
+	dur := time.Since(start)
+
+	ruleDuration.WithLabelValues(name, ruleset).Observe(dur.Seconds())
+	if err != nil {
+		ruleErrorCount.WithLabelValues(name, ruleset).Inc()
+	}
+	// effects from concurrent rules could come from any of them
+	if trace && c.effects.currentMark() != before {
+		ruleHitCount.WithLabelValues(name, ruleset).Inc()
+	}
+	if guard != nil {
+		guard.observe(ruleset, name, dur, err)
+	}
+	return err
+}
+
+// name of the ruleset being run in this context, for rule health tracking and metrics
+func (c *BaseContext) ruleset() string {
+	if c.shadow {
+		return RulesetShadow
+	}
+	return RulesetProduction
+}
+
+// Runs a named logical rule from within a rule function. The rule gets the same per-rule metrics, effect attribution, and auto-disabling as rules registered directly in a RuleSet. This is only needed by rule functions which dispatch to multiple logical rules, such as declarative rules. Nesting is fine: effects are attributed to the innermost rule.
+func (c *BaseContext) RunRule(name string, f func() error) error {
+	return c.runRule(name, true, f)
+}
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPostOp(t *testing.T, text string) RecordOp {
+	p := appbsky.FeedPost{Text: text}
+	buf := new(bytes.Buffer)
+	if err := p.MarshalCBOR(buf); err != nil {
+		t.Fatal(err)
+	}
+	cid1 := syntax.CID("cid123")
+	return RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: buf.Bytes(),
+	}
+}
+
+func TestRuleNames(t *testing.T) {
+	assert := assert.New(t)
+
+	rs := RuleSet{
+		PostRules: []PostRuleFunc{simpleRule},
+	}
+	// closures from the same function literal must keep their own names
+	for _, name := range []string{"named-a", "named-b"} {
+		rs.AddPostRule(name, func(c *RecordContext, post *appbsky.FeedPost) error { return nil })
+	}
+	var names []string
+	for _, rule := range allRules(rs.PostRules, rs.namedPostRules) {
+		names = append(names, rule.name)
+	}
+	assert.Equal([]string{"engine.simpleRule", "named-a", "named-b"}, names)
+}
+
+func TestNestedRuleEffects(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.Rules = RuleSet{}
+	eng.Rules.AddPostRule("outer", func(c *RecordContext, post *appbsky.FeedPost) error {
+		c.AddRecordFlag("outer-before")
+		c.RunRule("inner", func() error {
+			c.AddRecordFlag("inner")
+			return nil
+		})
+		c.AddRecordFlag("outer-after")
+		return nil
+	})
+
+	rc := NewRecordContext(ctx, &eng, AccountMeta{Identity: &identity.Identity{DID: syntax.DID("did:plc:abc111")}}, testPostOp(t, "hello"))
+	rc.effects.enableTrace()
+	assert.NoError(eng.Rules.CallRecordRules(&rc))
+	effects := rc.effects.RuleEffects()
+	assert.Equal([]string{"outer-before", "outer-after"}, effects["outer"].RecordFlags)
+	assert.Equal([]string{"inner"}, effects["inner"].RecordFlags)
+}
+
+func TestRuleGuardErrors(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	calls := 0
+	eng := EngineTestFixture()
+	eng.Rules = RuleSet{}
+	eng.Rules.AddPostRule("failing", func(c *RecordContext, post *appbsky.FeedPost) error {
+		calls++
+		return fmt.Errorf("oops")
+	})
+	eng.RuleGuard = NewRuleGuard(RuleGuardConfig{MaxConsecutiveErrors: 3}, nil)
+
+	op := testPostOp(t, "hello")
+	for i := 0; i < 5; i++ {
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+	assert.Equal(3, calls)
+	disabled := eng.RuleGuard.Disabled()
+	assert.Len(disabled, 1)
+	assert.Equal("failing", disabled[0].Rule)
+	assert.True(disabled[0].Until.IsZero())
+
+	assert.True(eng.RuleGuard.Enable(RulesetProduction, "failing"))
+	assert.False(eng.RuleGuard.Enable(RulesetProduction, "failing"))
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	assert.Equal(4, calls)
+}
+
+func TestRuleGuardLatency(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	calls := 0
+	eng := EngineTestFixture()
+	eng.Rules.AddPostRule("slow", func(c *RecordContext, post *appbsky.FeedPost) error {
+		calls++
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+	eng.RuleGuard = NewRuleGuard(RuleGuardConfig{
+		LatencyBudget:      time.Millisecond,
+		MaxConsecutiveSlow: 2,
+		DisablePeriod:      20 * time.Millisecond,
+	}, nil)
+
+	op := testPostOp(t, "hello")
+	for i := 0; i < 4; i++ {
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+	assert.Equal(2, calls)
+	// the other rule in the fixture is unaffected
+	disabled := eng.RuleGuard.Disabled()
+	assert.Len(disabled, 1)
+	assert.Equal("slow", disabled[0].Rule)
+
+	// automatically re-enabled after the disable period
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	assert.Equal(3, calls)
+}
+
+func TestRuleGuardShadowRuleset(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// production and shadow rulesets have rules with the same name; only the shadow version fails
+	prodCalls := 0
+	shadowCalls := 0
+	eng := EngineTestFixture()
+	eng.Rules = RuleSet{}
+	eng.Rules.AddPostRule("same-name", func(c *RecordContext, post *appbsky.FeedPost) error {
+		prodCalls++
+		return nil
+	})
+	eng.ShadowRules = &RuleSet{}
+	eng.ShadowRules.AddPostRule("same-name", func(c *RecordContext, post *appbsky.FeedPost) error {
+		shadowCalls++
+		return fmt.Errorf("oops")
+	})
+	eng.RuleGuard = NewRuleGuard(RuleGuardConfig{MaxConsecutiveErrors: 2}, nil)
+
+	op := testPostOp(t, "hello")
+	for i := 0; i < 4; i++ {
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+	assert.Equal(4, prodCalls)
+	assert.Equal(2, shadowCalls)
+	disabled := eng.RuleGuard.Disabled()
+	assert.Len(disabled, 1)
+	assert.Equal(RulesetShadow, disabled[0].Ruleset)
+	assert.Equal("same-name", disabled[0].Rule)
+
+	assert.False(eng.RuleGuard.Enable(RulesetProduction, "same-name"))
+	assert.True(eng.RuleGuard.Enable(RulesetShadow, "same-name"))
+}
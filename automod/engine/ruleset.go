@@ -10,6 +10,8 @@ import (
 )
 
 // Holds configuration of which rules of various types should be run, and helps dispatch events to those rules.
+//
+// Rules in the exported slices are named after their Go function. Rules added with the Add* methods have an explicit name, and run after those in the exported slices.
 type RuleSet struct {
 	PostRules         []PostRuleFunc
 	ProfileRules      []ProfileRuleFunc
@@ -19,13 +21,73 @@ type RuleSet struct {
 	AccountRules      []AccountRuleFunc
 	BlobRules         []BlobRuleFunc
 	OzoneEventRules   []OzoneEventRuleFunc
+
+	namedPostRules         []namedRule[PostRuleFunc]
+	namedProfileRules      []namedRule[ProfileRuleFunc]
+	namedRecordRules       []namedRule[RecordRuleFunc]
+	namedRecordDeleteRules []namedRule[RecordRuleFunc]
+	namedIdentityRules     []namedRule[IdentityRuleFunc]
+	namedAccountRules      []namedRule[AccountRuleFunc]
+	namedBlobRules         []namedRule[BlobRuleFunc]
+	namedOzoneEventRules   []namedRule[OzoneEventRuleFunc]
+}
+
+// a rule function along with the name used for metrics, logging, and auto-disabling
+type namedRule[F any] struct {
+	name string
+	fn   F
+}
+
+// returns all rules of one type: those from an exported slice (named after their Go function), followed by those added with an explicit name
+func allRules[F any](fns []F, named []namedRule[F]) []namedRule[F] {
+	if len(fns) == 0 {
+		return named
+	}
+	out := make([]namedRule[F], 0, len(fns)+len(named))
+	for _, f := range fns {
+		out = append(out, namedRule[F]{name: RuleName(f), fn: f})
+	}
+	return append(out, named...)
+}
+
+// Adds a post rule, with an explicit name.
+func (r *RuleSet) AddPostRule(name string, f PostRuleFunc) {
+	r.namedPostRules = append(r.namedPostRules, namedRule[PostRuleFunc]{name: name, fn: f})
+}
+
+func (r *RuleSet) AddProfileRule(name string, f ProfileRuleFunc) {
+	r.namedProfileRules = append(r.namedProfileRules, namedRule[ProfileRuleFunc]{name: name, fn: f})
+}
+
+func (r *RuleSet) AddRecordRule(name string, f RecordRuleFunc) {
+	r.namedRecordRules = append(r.namedRecordRules, namedRule[RecordRuleFunc]{name: name, fn: f})
+}
+
+func (r *RuleSet) AddRecordDeleteRule(name string, f RecordRuleFunc) {
+	r.namedRecordDeleteRules = append(r.namedRecordDeleteRules, namedRule[RecordRuleFunc]{name: name, fn: f})
+}
+
+func (r *RuleSet) AddIdentityRule(name string, f IdentityRuleFunc) {
+	r.namedIdentityRules = append(r.namedIdentityRules, namedRule[IdentityRuleFunc]{name: name, fn: f})
+}
+
+func (r *RuleSet) AddAccountRule(name string, f AccountRuleFunc) {
+	r.namedAccountRules = append(r.namedAccountRules, namedRule[AccountRuleFunc]{name: name, fn: f})
+}
+
+func (r *RuleSet) AddBlobRule(name string, f BlobRuleFunc) {
+	r.namedBlobRules = append(r.namedBlobRules, namedRule[BlobRuleFunc]{name: name, fn: f})
+}
+
+func (r *RuleSet) AddOzoneEventRule(name string, f OzoneEventRuleFunc) {
+	r.namedOzoneEventRules = append(r.namedOzoneEventRules, namedRule[OzoneEventRuleFunc]{name: name, fn: f})
 }
 
 // Executes all the various record-related rules. Only dispatches execution, does no other de-dupe or pre/post processing.
 func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 	// first the generic rules
-	for _, f := range r.RecordRules {
-		err := f(c)
+	for _, rule := range allRules(r.RecordRules, r.namedRecordRules) {
+		err := c.runRule(rule.name, true, func() error { return rule.fn(c) })
 		if err != nil {
 			c.Logger.Error("record rule execution failed", "err", err)
 		}
@@ -37,8 +99,8 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 		if err := post.UnmarshalCBOR(bytes.NewReader(c.RecordOp.RecordCBOR)); err != nil {
 			return fmt.Errorf("failed to parse app.bsky.feed.post record: %v", err)
 		}
-		for _, f := range r.PostRules {
-			err := f(c, &post)
+		for _, rule := range allRules(r.PostRules, r.namedPostRules) {
+			err := c.runRule(rule.name, true, func() error { return rule.fn(c, &post) })
 			if err != nil {
 				c.Logger.Error("post rule execution failed", "err", err)
 			}
@@ -48,18 +110,22 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 		if err := profile.UnmarshalCBOR(bytes.NewReader(c.RecordOp.RecordCBOR)); err != nil {
 			return fmt.Errorf("failed to parse app.bsky.actor.profile record: %v", err)
 		}
-		for _, f := range r.ProfileRules {
-			err := f(c, &profile)
+		for _, rule := range allRules(r.ProfileRules, r.namedProfileRules) {
+			err := c.runRule(rule.name, true, func() error { return rule.fn(c, &profile) })
 			if err != nil {
 				c.Logger.Error("profile rule execution failed", "err", err)
 			}
 		}
 	}
 	// then blob rules, if any
-	if len(r.BlobRules) == 0 {
+	if len(r.BlobRules) == 0 && len(r.namedBlobRules) == 0 {
 		return nil
 	}
-	err := r.fetchAndProcessBlobs(c)
+	// blob rules run concurrently, so effects are only attributed to them as a group
+	var err error
+	c.traceGroup("blob-rules", func() {
+		err = r.fetchAndProcessBlobs(c)
+	})
 	if err != nil {
 		c.Logger.Error("failed to fetch and process blobs", "err", err)
 	}
@@ -69,8 +135,8 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 
 // NOTE: this will probably be removed and merged in to `CallRecordRules`
 func (r *RuleSet) CallRecordDeleteRules(c *RecordContext) error {
-	for _, f := range r.RecordDeleteRules {
-		err := f(c)
+	for _, rule := range allRules(r.RecordDeleteRules, r.namedRecordDeleteRules) {
+		err := c.runRule(rule.name, true, func() error { return rule.fn(c) })
 		if err != nil {
 			c.Logger.Error("record delete rule execution failed", "err", err)
 		}
@@ -80,8 +146,8 @@ func (r *RuleSet) CallRecordDeleteRules(c *RecordContext) error {
 
 // Executes rules for identity update events.
 func (r *RuleSet) CallIdentityRules(c *AccountContext) error {
-	for _, f := range r.IdentityRules {
-		err := f(c)
+	for _, rule := range allRules(r.IdentityRules, r.namedIdentityRules) {
+		err := c.runRule(rule.name, true, func() error { return rule.fn(c) })
 		if err != nil {
 			c.Logger.Error("identity rule execution failed", "err", err)
 		}
@@ -91,8 +157,8 @@ func (r *RuleSet) CallIdentityRules(c *AccountContext) error {
 
 // Executes rules for account update events.
 func (r *RuleSet) CallAccountRules(c *AccountContext) error {
-	for _, f := range r.AccountRules {
-		err := f(c)
+	for _, rule := range allRules(r.AccountRules, r.namedAccountRules) {
+		err := c.runRule(rule.name, true, func() error { return rule.fn(c) })
 		if err != nil {
 			c.Logger.Error("account rule execution failed", "err", err)
 		}
@@ -101,8 +167,8 @@ func (r *RuleSet) CallAccountRules(c *AccountContext) error {
 }
 
 func (r *RuleSet) CallOzoneEventRules(c *OzoneEventContext) error {
-	for _, f := range r.OzoneEventRules {
-		err := f(c)
+	for _, rule := range allRules(r.OzoneEventRules, r.namedOzoneEventRules) {
+		err := c.runRule(rule.name, true, func() error { return rule.fn(c) })
 		if err != nil {
 			c.Logger.Error("ozone event rule execution failed", "err", err)
 		}
@@ -153,18 +219,19 @@ func (r *RuleSet) fetchAndProcessBlobs(c *RecordContext) error {
 }
 
 func (r *RuleSet) processBlob(c *RecordContext, blob lexutil.LexBlob, data []byte) error {
-	errChan := make(chan error, len(r.BlobRules))
+	rules := allRules(r.BlobRules, r.namedBlobRules)
+	errChan := make(chan error, len(rules))
 	var wg sync.WaitGroup
-	for _, f := range r.BlobRules {
+	for _, rule := range rules {
 		wg.Add(1)
-		go func(brf BlobRuleFunc) {
+		go func(rule namedRule[BlobRuleFunc]) {
 			defer wg.Done()
-			err := brf(c, blob, data)
+			err := c.runRule(rule.name, false, func() error { return rule.fn(c, blob, data) })
 			if err != nil {
 				errChan <- err
 				return
 			}
-		}(f)
+		}(rule)
 	}
 
 	wg.Wait()
@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outcome of running both the production and shadow rulesets against a single event. Effects are broken down by rule name.
+type ShadowResult struct {
+	Time      time.Time `json:"time"`
+	EventType string    `json:"eventType"`
+	DID       string    `json:"did"`
+	// for record events
+	URI        string                    `json:"uri,omitempty"`
+	Production map[string]EffectsSummary `json:"production,omitempty"`
+	Shadow     map[string]EffectsSummary `json:"shadow,omitempty"`
+}
+
+// Interface for persisting shadow-mode results. Only called for events where either ruleset produced any effects.
+type ShadowRecorder interface {
+	RecordShadowResult(ctx context.Context, res *ShadowResult) error
+}
+
+// Writes shadow results as newline-delimited JSON (eg, to a log file). Safe for concurrent use.
+type ShadowLogWriter struct {
+	lk  sync.Mutex
+	out io.Writer
+}
+
+func NewShadowLogWriter(out io.Writer) *ShadowLogWriter {
+	return &ShadowLogWriter{out: out}
+}
+
+func (w *ShadowLogWriter) RecordShadowResult(ctx context.Context, res *ShadowResult) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	_, err = w.out.Write(b)
+	return err
+}
+
+// Per-rule comparison of shadow and production effects, over many events.
+type ShadowRuleStats struct {
+	Rule string `json:"rule"`
+	// number of events where the rule produced effects in production
+	Production int `json:"production"`
+	// number of events where the rule produced effects in shadow mode
+	Shadow int `json:"shadow"`
+	// events where the rule produced identical effects in both
+	Matched int `json:"matched"`
+	// events where the rule produced effects in both, but they were different
+	Differed int `json:"differed"`
+	// events where the rule only produced effects in production
+	ProductionOnly int `json:"productionOnly"`
+	// events where the rule only produced effects in shadow mode
+	ShadowOnly int `json:"shadowOnly"`
+}
+
+// Aggregates shadow results in to per-rule statistics. Can be used directly as a ShadowRecorder, or populated from a log file (see ReadShadowLog). Safe for concurrent use.
+type ShadowReport struct {
+	lk     sync.Mutex
+	events int
+	rules  map[string]*ShadowRuleStats
+}
+
+func NewShadowReport() *ShadowReport {
+	return &ShadowReport{
+		rules: make(map[string]*ShadowRuleStats),
+	}
+}
+
+func (r *ShadowReport) RecordShadowResult(ctx context.Context, res *ShadowResult) error {
+	r.Add(res)
+	return nil
+}
+
+func (r *ShadowReport) Add(res *ShadowResult) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.events++
+	for _, name := range shadowRuleNames(res) {
+		stats, ok := r.rules[name]
+		if !ok {
+			stats = &ShadowRuleStats{Rule: name}
+			r.rules[name] = stats
+		}
+		prod, inProd := res.Production[name]
+		shadow, inShadow := res.Shadow[name]
+		if inProd {
+			stats.Production++
+		}
+		if inShadow {
+			stats.Shadow++
+		}
+		switch {
+		case inProd && inShadow && prod.Equal(&shadow):
+			stats.Matched++
+		case inProd && inShadow:
+			stats.Differed++
+		case inProd:
+			stats.ProductionOnly++
+		default:
+			stats.ShadowOnly++
+		}
+	}
+}
+
+// Number of events included in the report
+func (r *ShadowReport) Events() int {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.events
+}
+
+// Per-rule statistics, sorted by rule name
+func (r *ShadowReport) Rules() []ShadowRuleStats {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	out := make([]ShadowRuleStats, 0, len(r.rules))
+	for _, stats := range r.rules {
+		out = append(out, *stats)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Rule < out[j].Rule
+	})
+	return out
+}
+
+// Reads a log of shadow results (as written by ShadowLogWriter) in to a report.
+func ReadShadowLog(in io.Reader) (*ShadowReport, error) {
+	report := NewShadowReport()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var res ShadowResult
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			return nil, fmt.Errorf("shadow log line %d: %w", line, err)
+		}
+		report.Add(&res)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// union of rule names which had effects in either ruleset
+func shadowRuleNames(res *ShadowResult) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range []map[string]EffectsSummary{res.Production, res.Shadow} {
+		for name := range m {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Records the result of a shadow run, if either ruleset had any effects.
+func (eng *Engine) recordShadowResult(ctx context.Context, res *ShadowResult, prod, shadow *Effects) {
+	res.Production = prod.RuleEffects()
+	res.Shadow = shadow.RuleEffects()
+	if len(res.Production) == 0 && len(res.Shadow) == 0 {
+		return
+	}
+	res.Time = time.Now().UTC()
+	for _, name := range shadowRuleNames(res) {
+		p, inProd := res.Production[name]
+		s, inShadow := res.Shadow[name]
+		if inProd != inShadow || !p.Equal(&s) {
+			shadowDiffCount.WithLabelValues(name).Inc()
+		}
+	}
+	if eng.ShadowRecorder == nil {
+		return
+	}
+	if err := eng.ShadowRecorder.RecordShadowResult(ctx, res); err != nil {
+		eng.Logger.Error("failed to record shadow result", "err", err)
+	}
+}
+
+// shadow rule execution must never interfere with production processing, so panics are recovered and errors only logged
+func (eng *Engine) recoverShadow(did string) {
+	if r := recover(); r != nil {
+		eng.Logger.Error("automod shadow rule execution exception", "err", r, "did", did)
+	}
+}
+
+// Runs the shadow ruleset (if configured) against the same account event as the production context. Shadow effects are recorded, but never persisted.
+func (eng *Engine) runShadowAccount(ctx context.Context, eventType string, prod *AccountContext) {
+	if eng.ShadowRules == nil {
+		return
+	}
+	did := prod.Account.Identity.DID.String()
+	defer eng.recoverShadow(did)
+
+	sc := NewAccountContext(ctx, eng, prod.Account)
+	sc.shadow = true
+	sc.Logger = sc.Logger.With("shadow", true)
+	var err error
+	switch eventType {
+	case "identity":
+		err = eng.ShadowRules.CallIdentityRules(&sc)
+	case "account":
+		err = eng.ShadowRules.CallAccountRules(&sc)
+	}
+	if err != nil {
+		sc.Logger.Warn("shadow rule execution failed", "err", err)
+	}
+	eng.recordShadowResult(ctx, &ShadowResult{EventType: eventType, DID: did}, prod.effects, sc.effects)
+}
+
+// Runs the shadow ruleset (if configured) against the same record op as the production context. Shadow effects are recorded, but never persisted.
+func (eng *Engine) runShadowRecord(ctx context.Context, prod *RecordContext) {
+	if eng.ShadowRules == nil {
+		return
+	}
+	did := prod.RecordOp.DID.String()
+	defer eng.recoverShadow(did)
+
+	sc := NewRecordContext(ctx, eng, prod.Account, prod.RecordOp)
+	sc.shadow = true
+	sc.Logger = sc.Logger.With("shadow", true)
+	var err error
+	switch prod.RecordOp.Action {
+	case CreateOp, UpdateOp:
+		err = eng.ShadowRules.CallRecordRules(&sc)
+	case DeleteOp:
+		err = eng.ShadowRules.CallRecordDeleteRules(&sc)
+	}
+	if err != nil {
+		sc.Logger.Warn("shadow rule execution failed", "err", err)
+	}
+	res := ShadowResult{
+		EventType: "record",
+		DID:       did,
+		URI:       prod.RecordOp.ATURI().String(),
+	}
+	eng.recordShadowResult(ctx, &res, prod.effects, sc.effects)
+}
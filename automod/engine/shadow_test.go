@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func shadowFlagRule(c *RecordContext, post *appbsky.FeedPost) error {
+	if strings.Contains(post.Text, "blah") {
+		c.AddRecordFlag("shadow-blah")
+		c.Increment("shadow-blah", c.Account.Identity.DID.String())
+	}
+	return nil
+}
+
+func TestShadowMode(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.ShadowRules = &RuleSet{
+		PostRules: []PostRuleFunc{
+			simpleRule,
+			shadowFlagRule,
+		},
+	}
+	report := NewShadowReport()
+	var log bytes.Buffer
+	eng.ShadowRecorder = report
+
+	cid1 := syntax.CID("cid123")
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+	}
+	var records [][]byte
+	for _, p := range []appbsky.FeedPost{
+		{Text: "some post blah", Tags: []string{"one", "slur"}},
+		{Text: "some post blah"},
+		{Text: "some post"},
+	} {
+		buf := new(bytes.Buffer)
+		assert.NoError(p.MarshalCBOR(buf))
+		records = append(records, buf.Bytes())
+		op.RecordCBOR = buf.Bytes()
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+
+	// the final post had no effects in either ruleset
+	assert.Equal(2, report.Events())
+	rules := report.Rules()
+	assert.Equal([]ShadowRuleStats{
+		{Rule: "engine.shadowFlagRule", Shadow: 2, ShadowOnly: 2},
+		{Rule: "engine.simpleRule", Production: 1, Shadow: 1, Matched: 1},
+	}, rules)
+
+	// shadow effects are never persisted
+	flags, err := eng.Flags.Get(ctx, op.ATURI().String())
+	assert.NoError(err)
+	assert.Empty(flags)
+	count, err := eng.Counters.GetCount(ctx, "shadow-blah", "did:plc:abc111", "total")
+	assert.NoError(err)
+	assert.Equal(0, count)
+
+	// round-trip through the log format
+	eng.ShadowRecorder = NewShadowLogWriter(&log)
+	for _, rec := range records {
+		op.RecordCBOR = rec
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+	fromLog, err := ReadShadowLog(&log)
+	assert.NoError(err)
+	assert.Equal(2, fromLog.Events())
+	assert.Equal(rules, fromLog.Rules())
+}
@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Summary of the moderation actions produced by rule execution (or a single rule), for comparisons and reporting. Counter increments are not included.
+type EffectsSummary struct {
+	AccountLabels        []string `json:"accountLabels,omitempty"`
+	RemovedAccountLabels []string `json:"removedAccountLabels,omitempty"`
+	AccountTags          []string `json:"accountTags,omitempty"`
+	AccountFlags         []string `json:"accountFlags,omitempty"`
+	// report reason types
+	AccountReports     []string `json:"accountReports,omitempty"`
+	AccountTakedown    bool     `json:"accountTakedown,omitempty"`
+	AccountEscalate    bool     `json:"accountEscalate,omitempty"`
+	AccountAcknowledge bool     `json:"accountAcknowledge,omitempty"`
+
+	RecordLabels        []string `json:"recordLabels,omitempty"`
+	RemovedRecordLabels []string `json:"removedRecordLabels,omitempty"`
+	RecordTags          []string `json:"recordTags,omitempty"`
+	RecordFlags         []string `json:"recordFlags,omitempty"`
+	// report reason types
+	RecordReports     []string `json:"recordReports,omitempty"`
+	RecordTakedown    bool     `json:"recordTakedown,omitempty"`
+	RecordEscalate    bool     `json:"recordEscalate,omitempty"`
+	RecordAcknowledge bool     `json:"recordAcknowledge,omitempty"`
+
+	BlobTakedowns  []string `json:"blobTakedowns,omitempty"`
+	NotifyServices []string `json:"notifyServices,omitempty"`
+}
+
+func (s *EffectsSummary) IsEmpty() bool {
+	return reflect.ValueOf(*s).IsZero()
+}
+
+func (s *EffectsSummary) Equal(other *EffectsSummary) bool {
+	return reflect.DeepEqual(s, other)
+}
+
+func (s *EffectsSummary) merge(other *EffectsSummary) {
+	s.AccountLabels = append(s.AccountLabels, other.AccountLabels...)
+	s.RemovedAccountLabels = append(s.RemovedAccountLabels, other.RemovedAccountLabels...)
+	s.AccountTags = append(s.AccountTags, other.AccountTags...)
+	s.AccountFlags = append(s.AccountFlags, other.AccountFlags...)
+	s.AccountReports = append(s.AccountReports, other.AccountReports...)
+	s.AccountTakedown = s.AccountTakedown || other.AccountTakedown
+	s.AccountEscalate = s.AccountEscalate || other.AccountEscalate
+	s.AccountAcknowledge = s.AccountAcknowledge || other.AccountAcknowledge
+	s.RecordLabels = append(s.RecordLabels, other.RecordLabels...)
+	s.RemovedRecordLabels = append(s.RemovedRecordLabels, other.RemovedRecordLabels...)
+	s.RecordTags = append(s.RecordTags, other.RecordTags...)
+	s.RecordFlags = append(s.RecordFlags, other.RecordFlags...)
+	s.RecordReports = append(s.RecordReports, other.RecordReports...)
+	s.RecordTakedown = s.RecordTakedown || other.RecordTakedown
+	s.RecordEscalate = s.RecordEscalate || other.RecordEscalate
+	s.RecordAcknowledge = s.RecordAcknowledge || other.RecordAcknowledge
+	s.BlobTakedowns = append(s.BlobTakedowns, other.BlobTakedowns...)
+	s.NotifyServices = append(s.NotifyServices, other.NotifyServices...)
+}
+
+// position in an Effects struct. because effects are only ever appended (or flipped to true), the difference between two marks is the set of effects added between them.
+type effectsMark struct {
+	accountLabels, removedAccountLabels, accountTags, accountFlags, accountReports int
+	recordLabels, removedRecordLabels, recordTags, recordFlags, recordReports      int
+	blobTakedowns, notifyServices                                                  int
+	accountTakedown, accountEscalate, accountAcknowledge                           bool
+	recordTakedown, recordEscalate, recordAcknowledge                              bool
+}
+
+// tracks which rule produced which effects. only used if enabled on the Effects struct.
+type effectsTrace struct {
+	cursor effectsMark
+	// names of the rules currently running, innermost last
+	running []string
+	rules   map[string]*EffectsSummary
+}
+
+// caller must hold lock
+func (e *Effects) mark() effectsMark {
+	return effectsMark{
+		accountLabels:        len(e.AccountLabels),
+		removedAccountLabels: len(e.RemovedAccountLabels),
+		accountTags:          len(e.AccountTags),
+		accountFlags:         len(e.AccountFlags),
+		accountReports:       len(e.AccountReports),
+		recordLabels:         len(e.RecordLabels),
+		removedRecordLabels:  len(e.RemovedRecordLabels),
+		recordTags:           len(e.RecordTags),
+		recordFlags:          len(e.RecordFlags),
+		recordReports:        len(e.RecordReports),
+		blobTakedowns:        len(e.BlobTakedowns),
+		notifyServices:       len(e.NotifyServices),
+		accountTakedown:      e.AccountTakedown,
+		accountEscalate:      e.AccountEscalate,
+		accountAcknowledge:   e.AccountAcknowledge,
+		recordTakedown:       e.RecordTakedown,
+		recordEscalate:       e.RecordEscalate,
+		recordAcknowledge:    e.RecordAcknowledge,
+	}
+}
+
+func reportReasons(reports []ModReport) []string {
+	var out []string
+	for _, r := range reports {
+		out = append(out, r.ReasonType)
+	}
+	return out
+}
+
+// summary of effects added since the given mark. caller must hold lock
+func (e *Effects) since(m effectsMark) EffectsSummary {
+	tail := func(s []string, n int) []string {
+		if len(s) <= n {
+			return nil
+		}
+		return append([]string{}, s[n:]...)
+	}
+	s := EffectsSummary{
+		AccountLabels:        tail(e.AccountLabels, m.accountLabels),
+		RemovedAccountLabels: tail(e.RemovedAccountLabels, m.removedAccountLabels),
+		AccountTags:          tail(e.AccountTags, m.accountTags),
+		AccountFlags:         tail(e.AccountFlags, m.accountFlags),
+		AccountTakedown:      e.AccountTakedown && !m.accountTakedown,
+		AccountEscalate:      e.AccountEscalate && !m.accountEscalate,
+		AccountAcknowledge:   e.AccountAcknowledge && !m.accountAcknowledge,
+		RecordLabels:         tail(e.RecordLabels, m.recordLabels),
+		RemovedRecordLabels:  tail(e.RemovedRecordLabels, m.removedRecordLabels),
+		RecordTags:           tail(e.RecordTags, m.recordTags),
+		RecordFlags:          tail(e.RecordFlags, m.recordFlags),
+		RecordTakedown:       e.RecordTakedown && !m.recordTakedown,
+		RecordEscalate:       e.RecordEscalate && !m.recordEscalate,
+		RecordAcknowledge:    e.RecordAcknowledge && !m.recordAcknowledge,
+		BlobTakedowns:        tail(e.BlobTakedowns, m.blobTakedowns),
+		NotifyServices:       tail(e.NotifyServices, m.notifyServices),
+	}
+	if len(e.AccountReports) > m.accountReports {
+		s.AccountReports = reportReasons(e.AccountReports[m.accountReports:])
+	}
+	if len(e.RecordReports) > m.recordReports {
+		s.RecordReports = reportReasons(e.RecordReports[m.recordReports:])
+	}
+	return s
+}
+
+// Enables tracking of which rule produced which effects (see RuleEffects). Adds some overhead to every rule call.
+func (e *Effects) enableTrace() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.trace = &effectsTrace{
+		cursor: e.mark(),
+		rules:  make(map[string]*EffectsSummary),
+	}
+}
+
+func (e *Effects) tracing() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.trace != nil
+}
+
+// called before a rule runs. any effects since the last attribution point are attributed to the enclosing rule, if any.
+func (e *Effects) startRule(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.attribute()
+	e.trace.running = append(e.trace.running, name)
+}
+
+// called after a rule runs; attributes all effects since the last attribution point to the rule
+func (e *Effects) endRule() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.attribute()
+	e.trace.running = e.trace.running[:len(e.trace.running)-1]
+}
+
+// attributes effects since the last attribution point to the innermost running rule (or to nothing, if no rule is running), and moves the attribution point. caller must hold lock
+func (e *Effects) attribute() {
+	s := e.since(e.trace.cursor)
+	e.trace.cursor = e.mark()
+	if s.IsEmpty() || len(e.trace.running) == 0 {
+		return
+	}
+	name := e.trace.running[len(e.trace.running)-1]
+	if prev, ok := e.trace.rules[name]; ok {
+		prev.merge(&s)
+		return
+	}
+	e.trace.rules[name] = &s
+}
+
+// Returns the effects produced by each rule (by name), for rules which produced any effects. Returns nil if tracing was not enabled for this event.
+func (e *Effects) RuleEffects() map[string]EffectsSummary {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.trace == nil {
+		return nil
+	}
+	out := make(map[string]EffectsSummary, len(e.trace.rules))
+	for name, s := range e.trace.rules {
+		out[name] = *s
+	}
+	return out
+}
+
+// Interface for observing which rules produced which effects, for every event processed by an engine. Only rules which produced effects are included.
+type RuleEffectsRecorder interface {
+	// "subject" is the AT-URI (for record events) or DID (for account events)
+	RecordRuleEffects(ctx context.Context, subject string, rules map[string]EffectsSummary)
+}
+
+func (eng *Engine) recordRuleEffects(ctx context.Context, subject string, eff *Effects) {
+	if eng.RuleEffectsRecorder == nil {
+		return
+	}
+	rules := eff.RuleEffects()
+	if len(rules) == 0 {
+		return
+	}
+	eng.RuleEffectsRecorder.RecordRuleEffects(ctx, subject, rules)
+}
+
+// cache of function names, by code pointer
+var ruleNameCache sync.Map
+
+// Returns a short name for a rule function, like "rules.BadHashtagsPostRule", based on the Go function name.
+func RuleName(f any) string {
+	ptr := reflect.ValueOf(f).Pointer()
+	if name, ok := ruleNameCache.Load(ptr); ok {
+		return name.(string)
+	}
+	name := "unknown"
+	if fn := runtime.FuncForPC(ptr); fn != nil {
+		name = fn.Name()
+		if idx := strings.LastIndex(name, "/"); idx >= 0 {
+			name = name[idx+1:]
+		}
+	}
+	ruleNameCache.Store(ptr, name)
+	return name
+}
+
+func (e *Effects) currentMark() effectsMark {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mark()
+}
+
+// attributes any effects from f to a group name, without per-rule metrics. used for blob rules, which run concurrently so can't be attributed individually
+func (c *BaseContext) traceGroup(name string, f func()) {
+	if !c.effects.tracing() {
+		f()
+		return
+	}
+	c.effects.startRule(name)
+	defer c.effects.endRule()
+	f()
+}
@@ -0,0 +1,61 @@
+package flagstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Persisted flag: one row per (key, flag)
+type SQLFlag struct {
+	Key       string `gorm:"primaryKey"`
+	Flag      string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+func (SQLFlag) TableName() string {
+	return "automod_flag"
+}
+
+// FlagStore implementation backed by an SQL database (PostgreSQL or SQLite, via gorm).
+type SQLFlagStore struct {
+	db *gorm.DB
+}
+
+func NewSQLFlagStore(db *gorm.DB) (*SQLFlagStore, error) {
+	if err := db.AutoMigrate(&SQLFlag{}); err != nil {
+		return nil, fmt.Errorf("migrating flagstore tables: %w", err)
+	}
+	return &SQLFlagStore{db: db}, nil
+}
+
+func (s *SQLFlagStore) Get(ctx context.Context, key string) ([]string, error) {
+	out := []string{}
+	err := s.db.WithContext(ctx).Model(&SQLFlag{}).Where("key = ?", key).Order("flag").Pluck("flag", &out).Error
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *SQLFlagStore) Add(ctx context.Context, key string, flags []string) error {
+	if len(flags) == 0 {
+		return nil
+	}
+	rows := []SQLFlag{}
+	for _, f := range dedupeStrings(flags) {
+		rows = append(rows, SQLFlag{Key: key, Flag: f})
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// does not error if flags not in set
+func (s *SQLFlagStore) Remove(ctx context.Context, key string, flags []string) error {
+	if len(flags) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Where("key = ? AND flag IN ?", key, flags).Delete(&SQLFlag{}).Error
+}
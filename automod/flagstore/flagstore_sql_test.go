@@ -0,0 +1,43 @@
+package flagstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLFlagStoreBasics(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db, err := cliutil.SetupDatabase("sqlite://:memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := NewSQLFlagStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := fs.Get(ctx, "test1")
+	assert.NoError(err)
+	assert.Empty(l)
+
+	assert.NoError(fs.Add(ctx, "test1", []string{"red", "green"}))
+	assert.NoError(fs.Add(ctx, "test1", []string{"red", "blue", "blue"}))
+	l, err = fs.Get(ctx, "test1")
+	assert.NoError(err)
+	assert.Equal([]string{"blue", "green", "red"}, l)
+
+	assert.NoError(fs.Remove(ctx, "test1", []string{"red", "blue", "purple"}))
+	l, err = fs.Get(ctx, "test1")
+	assert.NoError(err)
+	assert.Equal([]string{"green"}, l)
+
+	l, err = fs.Get(ctx, "test2")
+	assert.NoError(err)
+	assert.Empty(l)
+}
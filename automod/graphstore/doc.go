@@ -0,0 +1,2 @@
+// Interface for storing social graph relationships (follows, blocks, likes, reposts) seen on the firehose, and separate implementations using redis and in-process memory.
+package graphstore
@@ -0,0 +1,60 @@
+package graphstore
+
+import (
+	"context"
+	"time"
+)
+
+// Relationship types, one per record collection
+const (
+	KindFollow = "follow"
+	KindBlock  = "block"
+	KindLike   = "like"
+	KindRepost = "repost"
+)
+
+// Maximum age of recent activity (see RecentActors and Churn)
+const MaxWindow = 24 * time.Hour
+
+// A relationship, created by a record in the actor's repository.
+type Edge struct {
+	Kind string
+	// DID of the account which created the record
+	Actor string
+	// DID (for follows and blocks) or AT-URI (for likes and reposts)
+	Subject string
+	// record key of the record which created this edge, used to remove the edge when the record is deleted
+	RecordKey string
+	// time the relationship was seen (not the record's self-reported creation time)
+	CreatedAt time.Time
+}
+
+// Number of relationships of a single kind which an account created and removed within a time window.
+type Churn struct {
+	Added   int
+	Removed int
+}
+
+// GraphStore is an interface for storing relationships between accounts (and records), as seen on the firehose. It is implemented by MemGraphStore and RedisGraphStore.
+//
+// The store is only as complete as the events which have been processed: there is no backfill of existing relationships.
+//
+// "AddEdge" and "RemoveEdge" are called for record creation and deletion. Deletion records only include the record key, so stores keep track of which subject each record refers to.
+//
+// "HasEdge" and "MutualFollows" answer questions about current relationships. Multiple records for the same relationship (eg, duplicate follows) are tracked separately, and the relationship exists as long as any of them does.
+//
+// "RecentActors" and "Churn" answer questions about activity within the last MaxWindow. Recent actors are not removed when a record is deleted (eg, an account which liked and then un-liked a post is still a recent liker), which makes them useful for detecting coordinated activity.
+//
+// Memory growth varies by implementation. The RedisGraphStore expires like and repost relationships after MaxWindow (they are mostly useful for recent activity), but retains follows and blocks indefinitely. The MemGraphStore grows without bound (it's intended to be used in testing and other non-production operations).
+type GraphStore interface {
+	AddEdge(ctx context.Context, edge Edge) error
+	RemoveEdge(ctx context.Context, kind, actor, rkey string, now time.Time) error
+	HasEdge(ctx context.Context, kind, actor, subject string) (bool, error)
+	MutualFollows(ctx context.Context, did string) ([]string, error)
+	RecentActors(ctx context.Context, kind, subject string, since time.Time) ([]string, error)
+	Churn(ctx context.Context, kind, actor string, since time.Time) (Churn, error)
+}
+
+func edgeKey(kind, actor string) string {
+	return kind + "/" + actor
+}
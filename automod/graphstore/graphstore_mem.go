@@ -0,0 +1,138 @@
+package graphstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemGraphStore struct {
+	lk sync.Mutex
+	// record key to subject, keyed by "{kind}/{actor}"
+	records map[string]map[string]string
+	// subject to number of records, keyed by "{kind}/{actor}"
+	out map[string]map[string]int
+	// actor to most recent time seen, keyed by "{kind}/{subject}"
+	recent map[string]map[string]time.Time
+	// times of record creation and deletion, keyed by "{kind}/{actor}"
+	added   map[string][]time.Time
+	removed map[string][]time.Time
+}
+
+func NewMemGraphStore() *MemGraphStore {
+	return &MemGraphStore{
+		records: make(map[string]map[string]string),
+		out:     make(map[string]map[string]int),
+		recent:  make(map[string]map[string]time.Time),
+		added:   make(map[string][]time.Time),
+		removed: make(map[string][]time.Time),
+	}
+}
+
+// appends a time, dropping times older than MaxWindow
+func appendRecent(times []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-MaxWindow)
+	out := make([]time.Time, 0, len(times)+1)
+	for _, t := range times {
+		if t.After(cutoff) {
+			out = append(out, t)
+		}
+	}
+	return append(out, now)
+}
+
+func countSince(times []time.Time, since time.Time) int {
+	n := 0
+	for _, t := range times {
+		if !t.Before(since) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *MemGraphStore) AddEdge(ctx context.Context, edge Edge) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	k := edgeKey(edge.Kind, edge.Actor)
+	if s.records[k] == nil {
+		s.records[k] = make(map[string]string)
+		s.out[k] = make(map[string]int)
+	}
+	if _, ok := s.records[k][edge.RecordKey]; ok {
+		// record update; relationships can't change subject
+		return nil
+	}
+	s.records[k][edge.RecordKey] = edge.Subject
+	s.out[k][edge.Subject]++
+	s.added[k] = appendRecent(s.added[k], edge.CreatedAt)
+
+	rk := edgeKey(edge.Kind, edge.Subject)
+	if s.recent[rk] == nil {
+		s.recent[rk] = make(map[string]time.Time)
+	}
+	s.recent[rk][edge.Actor] = edge.CreatedAt
+	return nil
+}
+
+func (s *MemGraphStore) RemoveEdge(ctx context.Context, kind, actor, rkey string, now time.Time) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	k := edgeKey(kind, actor)
+	subject, ok := s.records[k][rkey]
+	if !ok {
+		return nil
+	}
+	delete(s.records[k], rkey)
+	s.out[k][subject]--
+	if s.out[k][subject] <= 0 {
+		delete(s.out[k], subject)
+	}
+	s.removed[k] = appendRecent(s.removed[k], now)
+	return nil
+}
+
+func (s *MemGraphStore) HasEdge(ctx context.Context, kind, actor, subject string) (bool, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.out[edgeKey(kind, actor)][subject] > 0, nil
+}
+
+func (s *MemGraphStore) MutualFollows(ctx context.Context, did string) ([]string, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	out := []string{}
+	for other := range s.out[edgeKey(KindFollow, did)] {
+		if s.out[edgeKey(KindFollow, other)][did] > 0 {
+			out = append(out, other)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *MemGraphStore) RecentActors(ctx context.Context, kind, subject string, since time.Time) ([]string, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	out := []string{}
+	for actor, t := range s.recent[edgeKey(kind, subject)] {
+		if !t.Before(since) {
+			out = append(out, actor)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *MemGraphStore) Churn(ctx context.Context, kind, actor string, since time.Time) (Churn, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	k := edgeKey(kind, actor)
+	return Churn{
+		Added:   countSince(s.added[k], since),
+		Removed: countSince(s.removed[k], since),
+	}, nil
+}
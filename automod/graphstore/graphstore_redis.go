@@ -0,0 +1,184 @@
+package graphstore
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisGraphPrefix string = "graph/"
+
+// hash of record key to subject, per actor
+var redisRecordPrefix string = redisGraphPrefix + "rec/"
+
+// hash of subject to number of records, per actor
+var redisOutPrefix string = redisGraphPrefix + "out/"
+
+// sorted set of actors (scored by time), per subject
+var redisRecentPrefix string = redisGraphPrefix + "recent/"
+
+// sorted sets of record keys (scored by time), per actor
+var redisAddedPrefix string = redisGraphPrefix + "added/"
+var redisRemovedPrefix string = redisGraphPrefix + "removed/"
+
+// adds an edge, unless the record was already seen. all the keys involved are updated atomically
+var addEdgeScript = redis.NewScript(`
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call('HINCRBY', KEYS[2], ARGV[2], 1)
+redis.call('ZADD', KEYS[3], ARGV[4], ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', ARGV[5])
+redis.call('EXPIRE', KEYS[3], ARGV[6])
+redis.call('ZADD', KEYS[4], ARGV[4], ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[4], '-inf', ARGV[5])
+redis.call('EXPIRE', KEYS[4], ARGV[6])
+if tonumber(ARGV[7]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[7])
+	redis.call('EXPIRE', KEYS[2], ARGV[7])
+end
+return 1
+`)
+
+// removes the edge for a record, if it is known
+var removeEdgeScript = redis.NewScript(`
+local subject = redis.call('HGET', KEYS[1], ARGV[1])
+if not subject then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+if redis.call('HINCRBY', KEYS[2], subject, -1) <= 0 then
+	redis.call('HDEL', KEYS[2], subject)
+end
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', ARGV[3])
+redis.call('EXPIRE', KEYS[3], ARGV[4])
+return 1
+`)
+
+type RedisGraphStore struct {
+	Client *redis.Client
+}
+
+func NewRedisGraphStore(redisURL string) (*RedisGraphStore, error) {
+	ctx := context.Background()
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opt)
+	// check redis connection
+	_, err = rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	return &RedisGraphStore{Client: rdb}, nil
+}
+
+// likes and reposts are high-volume, and mostly relevant as recent activity, so they are not retained indefinitely
+func edgeTTL(kind string) time.Duration {
+	switch kind {
+	case KindLike, KindRepost:
+		return MaxWindow
+	default:
+		return 0
+	}
+}
+
+func scoreTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func (s *RedisGraphStore) AddEdge(ctx context.Context, edge Edge) error {
+	k := edgeKey(edge.Kind, edge.Actor)
+	keys := []string{
+		redisRecordPrefix + k,
+		redisOutPrefix + k,
+		redisAddedPrefix + k,
+		redisRecentPrefix + edgeKey(edge.Kind, edge.Subject),
+	}
+	args := []any{
+		edge.RecordKey,
+		edge.Subject,
+		edge.Actor,
+		scoreTime(edge.CreatedAt),
+		scoreTime(edge.CreatedAt.Add(-MaxWindow)),
+		int(MaxWindow.Seconds()),
+		int(edgeTTL(edge.Kind).Seconds()),
+	}
+	return addEdgeScript.Run(ctx, s.Client, keys, args...).Err()
+}
+
+func (s *RedisGraphStore) RemoveEdge(ctx context.Context, kind, actor, rkey string, now time.Time) error {
+	k := edgeKey(kind, actor)
+	keys := []string{
+		redisRecordPrefix + k,
+		redisOutPrefix + k,
+		redisRemovedPrefix + k,
+	}
+	args := []any{
+		rkey,
+		scoreTime(now),
+		scoreTime(now.Add(-MaxWindow)),
+		int(MaxWindow.Seconds()),
+	}
+	return removeEdgeScript.Run(ctx, s.Client, keys, args...).Err()
+}
+
+func (s *RedisGraphStore) HasEdge(ctx context.Context, kind, actor, subject string) (bool, error) {
+	return s.Client.HExists(ctx, redisOutPrefix+edgeKey(kind, actor), subject).Result()
+}
+
+func (s *RedisGraphStore) MutualFollows(ctx context.Context, did string) ([]string, error) {
+	following, err := s.Client.HKeys(ctx, redisOutPrefix+edgeKey(KindFollow, did)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := []string{}
+	if len(following) == 0 {
+		return out, nil
+	}
+
+	// check for the reverse follows in a single round-trip
+	multi := s.Client.Pipeline()
+	cmds := make([]*redis.BoolCmd, len(following))
+	for i, other := range following {
+		cmds[i] = multi.HExists(ctx, redisOutPrefix+edgeKey(KindFollow, other), did)
+	}
+	if _, err := multi.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, other := range following {
+		if cmds[i].Val() {
+			out = append(out, other)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *RedisGraphStore) RecentActors(ctx context.Context, kind, subject string, since time.Time) ([]string, error) {
+	actors, err := s.Client.ZRangeByScore(ctx, redisRecentPrefix+edgeKey(kind, subject), &redis.ZRangeBy{
+		Min: scoreTime(since),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(actors)
+	return actors, nil
+}
+
+func (s *RedisGraphStore) Churn(ctx context.Context, kind, actor string, since time.Time) (Churn, error) {
+	k := edgeKey(kind, actor)
+	multi := s.Client.Pipeline()
+	added := multi.ZCount(ctx, redisAddedPrefix+k, scoreTime(since), "+inf")
+	removed := multi.ZCount(ctx, redisRemovedPrefix+k, scoreTime(since), "+inf")
+	if _, err := multi.Exec(ctx); err != nil {
+		return Churn{}, err
+	}
+	return Churn{Added: int(added.Val()), Removed: int(removed.Val())}, nil
+}
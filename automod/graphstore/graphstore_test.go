@@ -0,0 +1,80 @@
+package graphstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testGraphStoreBasics(t *testing.T, gs GraphStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	now := time.Now()
+	alice, bob, carol := "did:plc:alice111", "did:plc:bob222", "did:plc:carol333"
+
+	assert.NoError(gs.AddEdge(ctx, Edge{Kind: KindFollow, Actor: alice, Subject: bob, RecordKey: "f1", CreatedAt: now}))
+	assert.NoError(gs.AddEdge(ctx, Edge{Kind: KindFollow, Actor: alice, Subject: carol, RecordKey: "f2", CreatedAt: now}))
+	assert.NoError(gs.AddEdge(ctx, Edge{Kind: KindFollow, Actor: bob, Subject: alice, RecordKey: "f3", CreatedAt: now}))
+	// duplicate follow record for the same subject
+	assert.NoError(gs.AddEdge(ctx, Edge{Kind: KindFollow, Actor: bob, Subject: alice, RecordKey: "f4", CreatedAt: now}))
+
+	ok, err := gs.HasEdge(ctx, KindFollow, alice, bob)
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = gs.HasEdge(ctx, KindFollow, carol, alice)
+	assert.NoError(err)
+	assert.False(ok)
+	// relationship kinds are independent
+	ok, err = gs.HasEdge(ctx, KindBlock, alice, bob)
+	assert.NoError(err)
+	assert.False(ok)
+
+	mutuals, err := gs.MutualFollows(ctx, alice)
+	assert.NoError(err)
+	assert.Equal([]string{bob}, mutuals)
+
+	// removing one of the duplicate records keeps the relationship
+	assert.NoError(gs.RemoveEdge(ctx, KindFollow, bob, "f3", now))
+	ok, err = gs.HasEdge(ctx, KindFollow, bob, alice)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.NoError(gs.RemoveEdge(ctx, KindFollow, bob, "f4", now))
+	ok, err = gs.HasEdge(ctx, KindFollow, bob, alice)
+	assert.NoError(err)
+	assert.False(ok)
+	mutuals, err = gs.MutualFollows(ctx, alice)
+	assert.NoError(err)
+	assert.Empty(mutuals)
+
+	// unknown records are ignored
+	assert.NoError(gs.RemoveEdge(ctx, KindFollow, bob, "unknown", now))
+
+	churn, err := gs.Churn(ctx, KindFollow, bob, now.Add(-time.Minute))
+	assert.NoError(err)
+	assert.Equal(Churn{Added: 2, Removed: 2}, churn)
+	churn, err = gs.Churn(ctx, KindFollow, bob, now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Equal(Churn{}, churn)
+
+	post := "at://did:plc:carol333/app.bsky.feed.post/abc123"
+	assert.NoError(gs.AddEdge(ctx, Edge{Kind: KindLike, Actor: alice, Subject: post, RecordKey: "l1", CreatedAt: now.Add(-time.Hour)}))
+	assert.NoError(gs.AddEdge(ctx, Edge{Kind: KindLike, Actor: bob, Subject: post, RecordKey: "l2", CreatedAt: now}))
+	likers, err := gs.RecentActors(ctx, KindLike, post, now.Add(-10*time.Minute))
+	assert.NoError(err)
+	assert.Equal([]string{bob}, likers)
+	likers, err = gs.RecentActors(ctx, KindLike, post, now.Add(-2*time.Hour))
+	assert.NoError(err)
+	assert.Equal([]string{alice, bob}, likers)
+
+	// recent actors are kept after un-liking
+	assert.NoError(gs.RemoveEdge(ctx, KindLike, bob, "l2", now))
+	likers, err = gs.RecentActors(ctx, KindLike, post, now.Add(-10*time.Minute))
+	assert.NoError(err)
+	assert.Equal([]string{bob}, likers)
+}
+
+func TestMemGraphStoreBasics(t *testing.T) {
+	testGraphStoreBasics(t, NewMemGraphStore())
+}
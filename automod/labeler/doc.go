@@ -0,0 +1,8 @@
+/*
+Standalone atproto labeler for automod.
+
+A Labeler receives label effects from the automod engine (it implements engine.LabelEmitter), signs them with the labeler's key, persists them with sequence numbers in an SQL database, and serves them over the standard labeler endpoints: com.atproto.label.queryLabels and the com.atproto.label.subscribeLabels event stream.
+
+This allows an automod daemon to act as its own labeling service, without an Ozone moderation service. Labels are de-duplicated against those the labeler has already published: an active label is not re-published, and removing a label publishes a negation only if the label is currently active.
+*/
+package labeler
@@ -0,0 +1,282 @@
+package labeler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"gorm.io/gorm"
+)
+
+// Persisted signed label, with sequence number. Negations are stored as separate rows, same as they are published.
+type LabelRow struct {
+	Seq int64  `gorm:"primaryKey"`
+	URI string `gorm:"index:idx_label_subject,priority:1"`
+	Val string `gorm:"index:idx_label_subject,priority:2"`
+	CID *string
+	Neg bool
+	Src string
+	// creation timestamp, in atproto datetime syntax (as signed)
+	Cts string
+	Sig []byte
+}
+
+func (LabelRow) TableName() string {
+	return "automod_label"
+}
+
+// Converts to the API (lexicon) representation
+func (r *LabelRow) ToLexicon() *comatproto.LabelDefs_Label {
+	ver := label.ATPROTO_LABEL_VERSION
+	out := &comatproto.LabelDefs_Label{
+		Cid: r.CID,
+		Cts: r.Cts,
+		Sig: r.Sig,
+		Src: r.Src,
+		Uri: r.URI,
+		Val: r.Val,
+		Ver: &ver,
+	}
+	if r.Neg {
+		neg := true
+		out.Neg = &neg
+	}
+	return out
+}
+
+// Labeler signs, sequences, and persists labels, and broadcasts them to live subscribers. It implements engine.LabelEmitter, so that automod label effects are published as atproto labels.
+type Labeler struct {
+	DID    syntax.DID
+	Logger *slog.Logger
+
+	db  *gorm.DB
+	key crypto.PrivateKey
+
+	// serializes label creation, so subscribers always receive labels in sequence order
+	emitLk sync.Mutex
+
+	subsLk sync.Mutex
+	subs   map[*subscriber]bool
+}
+
+type subscriber struct {
+	labels chan *LabelRow
+	// closed if the subscriber falls too far behind
+	dropped chan struct{}
+}
+
+// max number of labels buffered per live subscriber before it is disconnected
+var subscriberBuffer = 1000
+
+func NewLabeler(db *gorm.DB, did syntax.DID, key crypto.PrivateKey, logger *slog.Logger) (*Labeler, error) {
+	if err := db.AutoMigrate(&LabelRow{}); err != nil {
+		return nil, fmt.Errorf("migrating label table: %w", err)
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Labeler{
+		DID:    did,
+		Logger: logger.With("subsystem", "labeler"),
+		db:     db,
+		key:    key,
+		subs:   make(map[*subscriber]bool),
+	}, nil
+}
+
+// Returns the most recent label row for each of the given values on the subject, keyed by value.
+func (l *Labeler) currentLabels(tx *gorm.DB, uri string, vals []string) (map[string]*LabelRow, error) {
+	var rows []LabelRow
+	err := tx.Where("uri = ? AND val IN ?", uri, vals).Order("seq").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]*LabelRow, len(rows))
+	for i := range rows {
+		out[rows[i].Val] = &rows[i]
+	}
+	return out, nil
+}
+
+func (l *Labeler) newRow(uri string, cid *string, val string, neg bool) (*LabelRow, error) {
+	lbl := label.Label{
+		CID:       cid,
+		CreatedAt: syntax.DatetimeNow().String(),
+		SourceDID: l.DID.String(),
+		URI:       uri,
+		Val:       val,
+		Version:   label.ATPROTO_LABEL_VERSION,
+	}
+	if neg {
+		lbl.Negated = &neg
+	}
+	if err := lbl.Sign(l.key); err != nil {
+		return nil, fmt.Errorf("signing label: %w", err)
+	}
+	return &LabelRow{
+		URI: uri,
+		Val: val,
+		CID: cid,
+		Neg: neg,
+		Src: lbl.SourceDID,
+		Cts: lbl.CreatedAt,
+		Sig: []byte(lbl.Sig),
+	}, nil
+}
+
+// Creates (and negates) labels on a subject. Labels which are already active are not re-created, and labels which are not active are not negated.
+func (l *Labeler) EmitLabels(ctx context.Context, uri string, cid *string, add, remove []string) error {
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+	l.emitLk.Lock()
+	defer l.emitLk.Unlock()
+
+	var created []*LabelRow
+	err := l.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		current, err := l.currentLabels(tx, uri, append(append([]string{}, add...), remove...))
+		if err != nil {
+			return err
+		}
+		for _, val := range add {
+			if prev, ok := current[val]; ok && !prev.Neg {
+				continue
+			}
+			row, err := l.newRow(uri, cid, val, false)
+			if err != nil {
+				return err
+			}
+			created = append(created, row)
+		}
+		for _, val := range remove {
+			prev, ok := current[val]
+			if !ok || prev.Neg {
+				continue
+			}
+			// negations are for the same subject as the original label
+			row, err := l.newRow(uri, prev.CID, val, true)
+			if err != nil {
+				return err
+			}
+			created = append(created, row)
+		}
+		if len(created) == 0 {
+			return nil
+		}
+		return tx.Create(&created).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, row := range created {
+		l.Logger.Info("emitted label", "seq", row.Seq, "uri", row.URI, "val", row.Val, "neg", row.Neg)
+		labelsEmitted.WithLabelValues(row.Val, fmt.Sprintf("%t", row.Neg)).Inc()
+	}
+	l.broadcast(created)
+	return nil
+}
+
+func (l *Labeler) broadcast(rows []*LabelRow) {
+	if len(rows) == 0 {
+		return
+	}
+	l.subsLk.Lock()
+	defer l.subsLk.Unlock()
+	for sub := range l.subs {
+		if !sub.send(rows) {
+			// subscriber is too slow; disconnect it (it can reconnect with a cursor)
+			close(sub.dropped)
+			delete(l.subs, sub)
+		}
+	}
+}
+
+// non-blocking; returns false if the subscriber's buffer is full
+func (sub *subscriber) send(rows []*LabelRow) bool {
+	for _, row := range rows {
+		select {
+		case sub.labels <- row:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Labeler) subscribe() *subscriber {
+	sub := &subscriber{
+		labels:  make(chan *LabelRow, subscriberBuffer),
+		dropped: make(chan struct{}),
+	}
+	l.subsLk.Lock()
+	l.subs[sub] = true
+	l.subsLk.Unlock()
+	return sub
+}
+
+func (l *Labeler) unsubscribe(sub *subscriber) {
+	l.subsLk.Lock()
+	delete(l.subs, sub)
+	l.subsLk.Unlock()
+}
+
+// Returns the current (highest) sequence number, or 0 if no labels have been emitted.
+func (l *Labeler) CurrentSeq(ctx context.Context) (int64, error) {
+	var seq *int64
+	if err := l.db.WithContext(ctx).Model(&LabelRow{}).Select("MAX(seq)").Scan(&seq).Error; err != nil {
+		return 0, err
+	}
+	if seq == nil {
+		return 0, nil
+	}
+	return *seq, nil
+}
+
+// Returns labels with sequence number greater than since, in order.
+func (l *Labeler) LabelsSince(ctx context.Context, since int64, limit int) ([]LabelRow, error) {
+	var rows []LabelRow
+	err := l.db.WithContext(ctx).Where("seq > ?", since).Order("seq").Limit(limit).Find(&rows).Error
+	return rows, err
+}
+
+// Returns the current state of labels on subjects matching any of the URI patterns: the most recent label (or negation) for each subject and value. Results are in sequence order, starting after the cursor (a sequence number). Patterns are either a full URI, or a prefix ending in '*'.
+func (l *Labeler) QueryLabels(ctx context.Context, uriPatterns []string, cursor int64, limit int) ([]LabelRow, error) {
+	latest := l.db.Model(&LabelRow{}).Select("MAX(seq)").Group("uri, val")
+	q := l.db.WithContext(ctx).Where("seq > ? AND seq IN (?)", cursor, latest)
+	conds := []string{}
+	args := []any{}
+	for _, p := range uriPatterns {
+		if strings.HasSuffix(p, "*") {
+			prefix := strings.TrimSuffix(p, "*")
+			if prefix == "" {
+				// matches everything
+				conds = nil
+				break
+			}
+			conds = append(conds, "uri LIKE ? ESCAPE '\\'")
+			args = append(args, escapeLike(prefix)+"%")
+		} else {
+			conds = append(conds, "uri = ?")
+			args = append(args, p)
+		}
+	}
+	if len(conds) > 0 {
+		q = q.Where(strings.Join(conds, " OR "), args...)
+	}
+	var rows []LabelRow
+	err := q.Order("seq").Limit(limit).Find(&rows).Error
+	return rows, err
+}
+
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
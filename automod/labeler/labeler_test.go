@@ -0,0 +1,174 @@
+package labeler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func testLabeler(t *testing.T) (*Labeler, crypto.PublicKey) {
+	db, err := cliutil.SetupDatabase("sqlite://:memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLabeler(db, syntax.DID("did:plc:labeler111"), priv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, pub
+}
+
+func TestEmitLabels(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	l, pub := testLabeler(t)
+
+	uri := "at://did:plc:abc111/app.bsky.feed.post/3lbdw7ubypk2z"
+	cid := "bafyreiclp443lavogvhj3d2ob2cxbfuscni2k5jk7bebjzg7khl3esabwq"
+	assert.NoError(l.EmitLabels(ctx, uri, &cid, []string{"spam"}, nil))
+	// already active labels are not re-emitted, and inactive labels are not negated
+	assert.NoError(l.EmitLabels(ctx, uri, &cid, []string{"spam"}, []string{"rude"}))
+	assert.NoError(l.EmitLabels(ctx, "did:plc:abc111", nil, []string{"!hide"}, nil))
+	assert.NoError(l.EmitLabels(ctx, uri, &cid, nil, []string{"spam"}))
+
+	rows, err := l.LabelsSince(ctx, 0, 100)
+	assert.NoError(err)
+	assert.Len(rows, 3)
+	assert.Equal(int64(1), rows[0].Seq)
+	assert.Equal("spam", rows[0].Val)
+	assert.False(rows[0].Neg)
+	assert.Equal("!hide", rows[1].Val)
+	assert.Nil(rows[1].CID)
+	assert.Equal("spam", rows[2].Val)
+	assert.True(rows[2].Neg)
+	assert.Equal(cid, *rows[2].CID)
+
+	for _, row := range rows {
+		lbl := label.FromLexicon(row.ToLexicon())
+		lbl.Negated = row.ToLexicon().Neg
+		assert.NoError(lbl.VerifySyntax())
+		assert.NoError(lbl.VerifySignature(pub))
+	}
+
+	seq, err := l.CurrentSeq(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(3), seq)
+
+	// query returns current state only
+	found, err := l.QueryLabels(ctx, []string{"at://did:plc:abc111/*"}, 0, 50)
+	assert.NoError(err)
+	assert.Len(found, 1)
+	assert.True(found[0].Neg)
+	found, err = l.QueryLabels(ctx, []string{"did:plc:abc111"}, 0, 50)
+	assert.NoError(err)
+	assert.Len(found, 1)
+	assert.Equal("!hide", found[0].Val)
+	found, err = l.QueryLabels(ctx, []string{"*"}, 0, 50)
+	assert.NoError(err)
+	assert.Len(found, 2)
+
+	// re-applying a negated label creates a new label
+	assert.NoError(l.EmitLabels(ctx, uri, &cid, []string{"spam"}, nil))
+	seq, err = l.CurrentSeq(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(4), seq)
+}
+
+func TestQueryLabelsHandler(t *testing.T) {
+	assert := assert.New(t)
+	l, _ := testLabeler(t)
+	assert.NoError(l.EmitLabels(context.Background(), "did:plc:abc111", nil, []string{"a", "b", "c"}, nil))
+
+	mux := http.NewServeMux()
+	l.RegisterHandlers(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/xrpc/com.atproto.label.queryLabels?uriPatterns=did:plc:abc111&limit=2")
+	assert.NoError(err)
+	defer resp.Body.Close()
+	var out comatproto.LabelQueryLabels_Output
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&out))
+	assert.Len(out.Labels, 2)
+	assert.Equal("2", *out.Cursor)
+	assert.Equal("did:plc:labeler111", out.Labels[0].Src)
+
+	resp2, err := http.Get(srv.URL + "/xrpc/com.atproto.label.queryLabels?uriPatterns=did:plc:abc111&sources=did:plc:other")
+	assert.NoError(err)
+	defer resp2.Body.Close()
+	var out2 comatproto.LabelQueryLabels_Output
+	assert.NoError(json.NewDecoder(resp2.Body).Decode(&out2))
+	assert.Empty(out2.Labels)
+}
+
+func TestSubscribeLabels(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	l, _ := testLabeler(t)
+	assert.NoError(l.EmitLabels(ctx, "did:plc:abc111", nil, []string{"a", "b"}, nil))
+
+	mux := http.NewServeMux()
+	l.RegisterHandlers(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/xrpc/com.atproto.label.subscribeLabels"
+
+	readEvent := func(conn *websocket.Conn) *events.XRPCStreamEvent {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var evt events.XRPCStreamEvent
+		assert.NoError(evt.Deserialize(bytes.NewReader(msg)))
+		return &evt
+	}
+
+	// replay from cursor, then live
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?cursor=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	evt := readEvent(conn)
+	assert.Equal(int64(2), evt.LabelLabels.Seq)
+	assert.Equal("b", evt.LabelLabels.Labels[0].Val)
+
+	assert.NoError(l.EmitLabels(ctx, "did:plc:abc111", nil, []string{"c"}, []string{"a"}))
+	evt = readEvent(conn)
+	assert.Equal(int64(3), evt.LabelLabels.Seq)
+	assert.Equal("c", evt.LabelLabels.Labels[0].Val)
+	evt = readEvent(conn)
+	assert.Equal(int64(4), evt.LabelLabels.Seq)
+	assert.True(*evt.LabelLabels.Labels[0].Neg)
+
+	// cursors from the future are rejected
+	conn2, _, err := websocket.DefaultDialer.Dial(wsURL+"?cursor=100", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	evt = readEvent(conn2)
+	assert.Equal("FutureCursor", evt.Error.Error)
+}
@@ -0,0 +1,16 @@
+package labeler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var labelsEmitted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_labeler_labels_emitted",
+	Help: "Number of labels signed and published by the labeler",
+}, []string{"val", "neg"})
+
+var labelSubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "automod_labeler_subscribers",
+	Help: "Number of connected label stream subscribers",
+})
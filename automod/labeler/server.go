@@ -0,0 +1,254 @@
+package labeler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 10_000,
+}
+
+// number of labels read from the database at a time, when replaying from a cursor
+var replayBatchSize = 500
+
+// Registers the public labeler XRPC endpoints on the given mux
+func (l *Labeler) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /xrpc/com.atproto.label.queryLabels", l.HandleQueryLabels)
+	mux.HandleFunc("GET /xrpc/com.atproto.label.subscribeLabels", l.HandleSubscribeLabels)
+}
+
+func writeXRPCError(w http.ResponseWriter, status int, name, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": name, "message": msg})
+}
+
+// HTTP handler for com.atproto.label.queryLabels
+func (l *Labeler) HandleQueryLabels(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	patterns := params["uriPatterns"]
+	if len(patterns) == 0 {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "uriPatterns is required")
+		return
+	}
+	limit := 50
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 250 {
+			writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "limit must be between 1 and 250")
+			return
+		}
+		limit = n
+	}
+	var cursor int64
+	if v := params.Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid cursor")
+			return
+		}
+		cursor = n
+	}
+
+	out := comatproto.LabelQueryLabels_Output{
+		Labels: []*comatproto.LabelDefs_Label{},
+	}
+	// this labeler only has labels from a single source
+	sources := params["sources"]
+	wanted := len(sources) == 0
+	for _, src := range sources {
+		if src == l.DID.String() {
+			wanted = true
+		}
+	}
+	if wanted {
+		rows, err := l.QueryLabels(r.Context(), patterns, cursor, limit)
+		if err != nil {
+			l.Logger.Error("failed to query labels", "err", err)
+			writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", "failed to query labels")
+			return
+		}
+		for i := range rows {
+			out.Labels = append(out.Labels, rows[i].ToLexicon())
+		}
+		if len(rows) == limit {
+			c := strconv.FormatInt(rows[len(rows)-1].Seq, 10)
+			out.Cursor = &c
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		l.Logger.Error("failed to write queryLabels response", "err", err)
+	}
+}
+
+func encodeLabelFrame(row *LabelRow) ([]byte, error) {
+	evt := events.XRPCStreamEvent{
+		LabelLabels: &comatproto.LabelSubscribeLabels_Labels{
+			Seq:    row.Seq,
+			Labels: []*comatproto.LabelDefs_Label{row.ToLexicon()},
+		},
+	}
+	var buf bytes.Buffer
+	if err := evt.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeErrorFrame(name, msg string) ([]byte, error) {
+	evt := events.XRPCStreamEvent{
+		Error: &events.ErrorFrame{Error: name, Message: msg},
+	}
+	var buf bytes.Buffer
+	if err := evt.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// HTTP handler for the com.atproto.label.subscribeLabels event stream.
+//
+// If a cursor is provided, labels after that sequence number are replayed from the database before switching to live labels. Without a cursor, only new labels are sent.
+func (l *Labeler) HandleSubscribeLabels(w http.ResponseWriter, r *http.Request) {
+	var cursor *int64
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid cursor")
+			return
+		}
+		cursor = &n
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	conn, err := wsUpgrader.Upgrade(w, r, w.Header())
+	if err != nil {
+		l.Logger.Warn("failed to upgrade websocket", "err", err)
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// subscribe before replaying, so no labels are missed in between. duplicates are skipped by sequence number
+	sub := l.subscribe()
+	defer l.unsubscribe(sub)
+	labelSubscribers.Inc()
+	defer labelSubscribers.Dec()
+
+	logger := l.Logger.With("remote_addr", r.RemoteAddr, "user_agent", r.UserAgent())
+	logger.Info("new label subscriber", "cursor", cursor)
+
+	writeLk := sync.Mutex{}
+	write := func(b []byte) error {
+		writeLk.Lock()
+		defer writeLk.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, b)
+	}
+
+	// read (and discard) client messages, to process control frames and detect disconnects
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	// keep idle connections alive
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(5*time.Second)); err != nil {
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var last int64
+	if cursor != nil {
+		cur, err := l.CurrentSeq(ctx)
+		if err != nil {
+			logger.Error("failed to fetch current label seq", "err", err)
+			return
+		}
+		if *cursor > cur {
+			if b, err := encodeErrorFrame("FutureCursor", "cursor is ahead of the current sequence number"); err == nil {
+				_ = write(b)
+			}
+			return
+		}
+		last = *cursor
+		for {
+			rows, err := l.LabelsSince(ctx, last, replayBatchSize)
+			if err != nil {
+				logger.Error("failed to replay labels", "err", err)
+				return
+			}
+			for i := range rows {
+				b, err := encodeLabelFrame(&rows[i])
+				if err != nil {
+					logger.Error("failed to encode label", "err", err)
+					return
+				}
+				if err := write(b); err != nil {
+					return
+				}
+				last = rows[i].Seq
+			}
+			if len(rows) < replayBatchSize {
+				break
+			}
+		}
+	}
+
+	for {
+		select {
+		case row := <-sub.labels:
+			if row.Seq <= last {
+				continue
+			}
+			b, err := encodeLabelFrame(row)
+			if err != nil {
+				logger.Error("failed to encode label", "err", err)
+				return
+			}
+			if err := write(b); err != nil {
+				return
+			}
+			last = row.Seq
+		case <-sub.dropped:
+			logger.Warn("disconnecting slow label subscriber", "last_seq", last)
+			if b, err := encodeErrorFrame("ConsumerTooSlow", "stream consumer too slow"); err == nil {
+				_ = write(b)
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,14 @@
+/*
+Offline replay of archived events through an automod rules engine.
+
+Supported archive formats are:
+
+  - automod account captures (JSON; see the capture package), one per file or one per line
+  - firehose dumps as JSON lines, as output by `goat firehose --blocks` (full events, with CAR blocks) or `goat firehose --ops` (individual record ops)
+  - repository CAR files, where every record is replayed as a "create" op
+
+All state (counters, flags, caches) is kept in memory, and no moderation actions are persisted externally. Identities are not resolved: account captures include identity metadata, and for other formats a placeholder identity is used (DID only).
+
+The main output is per-rule statistics: how many events each rule produced effects for, with some example subjects. Replaying the same corpus before and after a rule change is a form of regression testing.
+*/
+package replay
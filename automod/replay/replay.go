@@ -0,0 +1,418 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/cachestore"
+	"github.com/bluesky-social/indigo/automod/capture"
+	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/setstore"
+
+	"github.com/ipfs/go-cid"
+)
+
+type Config struct {
+	Logger *slog.Logger
+	Rules  engine.RuleSet
+	// optional; defaults to an empty in-memory set store
+	Sets setstore.SetStore
+	// max number of example subjects to keep per rule
+	MaxExamples int
+}
+
+// Feeds archived events through an engine with in-memory state. Not safe for concurrent use: events are processed in order, so that counter-based rules behave as they would live.
+type Replayer struct {
+	Engine *engine.Engine
+	Stats  *Stats
+
+	// number of events processed
+	Events int
+	// number of events which failed processing (the engine returned an error)
+	Errors int
+	// number of archive entries which could not be replayed (eg, commit events without blocks)
+	Skipped int
+
+	logger *slog.Logger
+	dir    *identity.MockDirectory
+}
+
+func NewReplayer(config Config) *Replayer {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	sets := config.Sets
+	if sets == nil {
+		mem := setstore.NewMemSetStore()
+		sets = &mem
+	}
+	dir := identity.NewMockDirectory()
+	stats := NewStats(config.MaxExamples)
+	eng := engine.Engine{
+		Logger:              logger,
+		Directory:           &dir,
+		Rules:               config.Rules,
+		Counters:            countstore.NewMemCountStore(),
+		Sets:                sets,
+		Cache:               cachestore.NewMemCacheStore(1_000_000, 365*24*time.Hour),
+		Flags:               flagstore.NewMemFlagStore(),
+		RuleEffectsRecorder: stats,
+	}
+	return &Replayer{
+		Engine: &eng,
+		Stats:  stats,
+		logger: logger,
+		dir:    &dir,
+	}
+}
+
+// Replays a single archive file, or all supported files in a directory (recursively, in lexical order). The format is determined by file extension: ".car", ".json" (account capture), or ".jsonl"/".ndjson" (JSON lines).
+func (r *Replayer) ReplayPath(ctx context.Context, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return r.ReplayFile(ctx, path)
+	}
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		switch filepath.Ext(p) {
+		case ".car", ".json", ".jsonl", ".ndjson":
+			return r.ReplayFile(ctx, p)
+		}
+		return nil
+	})
+}
+
+func (r *Replayer) ReplayFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r.logger.Info("replaying archive", "path", path)
+	switch filepath.Ext(path) {
+	case ".car":
+		err = r.ReplayRepoCAR(ctx, f)
+	case ".json":
+		var ac capture.AccountCapture
+		if err := json.NewDecoder(f).Decode(&ac); err != nil {
+			return fmt.Errorf("%s: parsing account capture: %w", path, err)
+		}
+		err = r.ReplayCapture(ctx, &ac)
+	case ".jsonl", ".ndjson":
+		err = r.ReplayJSONLines(ctx, f)
+	default:
+		return fmt.Errorf("%s: unsupported archive file type", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// makes sure there is an identity in the directory for the DID, inserting a placeholder if needed
+func (r *Replayer) ensureIdentity(ctx context.Context, did syntax.DID) {
+	if _, err := r.dir.LookupDID(ctx, did); err == nil {
+		return
+	}
+	r.dir.Insert(identity.Identity{
+		DID:    did,
+		Handle: syntax.HandleInvalid,
+	})
+}
+
+func (r *Replayer) processRecordOp(ctx context.Context, op engine.RecordOp) {
+	r.ensureIdentity(ctx, op.DID)
+	r.Events++
+	if err := r.Engine.ProcessRecordOp(ctx, op); err != nil {
+		r.Errors++
+		r.logger.Warn("failed to process record op", "uri", op.ATURI(), "err", err)
+	}
+}
+
+// Replays an account capture: account metadata is pre-populated (so account-level rules see it), then every post record is processed.
+func (r *Replayer) ReplayCapture(ctx context.Context, ac *capture.AccountCapture) error {
+	if ac.AccountMeta.Identity == nil {
+		return fmt.Errorf("account capture missing identity")
+	}
+	r.dir.Insert(*ac.AccountMeta.Identity)
+	meta, err := json.Marshal(ac.AccountMeta)
+	if err != nil {
+		return err
+	}
+	if err := r.Engine.Cache.Set(ctx, "acct", ac.AccountMeta.Identity.DID.String(), string(meta)); err != nil {
+		return err
+	}
+
+	for _, pr := range ac.PostRecords {
+		aturi, err := syntax.ParseATURI(pr.Uri)
+		if err != nil {
+			return err
+		}
+		did, err := aturi.Authority().AsDID()
+		if err != nil {
+			return err
+		}
+		recCID := syntax.CID(pr.Cid)
+		recBuf := new(bytes.Buffer)
+		if err := pr.Value.Val.MarshalCBOR(recBuf); err != nil {
+			return err
+		}
+		r.processRecordOp(ctx, engine.RecordOp{
+			Action:     engine.CreateOp,
+			DID:        did,
+			Collection: aturi.Collection(),
+			RecordKey:  aturi.RecordKey(),
+			CID:        &recCID,
+			RecordCBOR: recBuf.Bytes(),
+		})
+	}
+	return nil
+}
+
+// Replays every record in a repository export (CAR file) as a "create".
+func (r *Replayer) ReplayRepoCAR(ctx context.Context, in io.Reader) error {
+	commit, rr, err := repo.LoadRepoFromCAR(ctx, in)
+	if err != nil {
+		return err
+	}
+	did, err := syntax.ParseDID(commit.DID)
+	if err != nil {
+		return err
+	}
+	return rr.MST.Walk(func(key []byte, val cid.Cid) error {
+		collection, rkey, err := syntax.ParseRepoPath(string(key))
+		if err != nil {
+			r.Skipped++
+			return nil
+		}
+		blk, err := rr.RecordStore.Get(ctx, val)
+		if err != nil {
+			return err
+		}
+		recCID := syntax.CID(val.String())
+		r.processRecordOp(ctx, engine.RecordOp{
+			Action:     engine.CreateOp,
+			DID:        did,
+			Collection: collection,
+			RecordKey:  rkey,
+			CID:        &recCID,
+			RecordCBOR: blk.RawData(),
+		})
+		return nil
+	})
+}
+
+// a single line of a JSON lines archive; fields from all the supported line formats are included
+type jsonLine struct {
+	// full firehose events (`goat firehose`)
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+
+	// individual record ops (`goat firehose --ops`)
+	DID        string          `json:"did"`
+	Collection string          `json:"collection"`
+	RKey       string          `json:"rkey"`
+	Action     string          `json:"action"`
+	CID        string          `json:"cid"`
+	Record     json.RawMessage `json:"record"`
+
+	// account captures
+	AccountMeta json.RawMessage `json:"accountMeta"`
+}
+
+// Replays a JSON lines archive. Each line can be a full firehose event, an individual record op, or an account capture.
+func (r *Replayer) ReplayJSONLines(ctx context.Context, in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	// commit events with blocks can be large
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		if err := r.replayLine(ctx, raw); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (r *Replayer) replayLine(ctx context.Context, raw []byte) error {
+	var line jsonLine
+	if err := json.Unmarshal(raw, &line); err != nil {
+		return err
+	}
+
+	switch {
+	case line.AccountMeta != nil:
+		var ac capture.AccountCapture
+		if err := json.Unmarshal(raw, &ac); err != nil {
+			return err
+		}
+		return r.ReplayCapture(ctx, &ac)
+	case line.Type == "commit":
+		var evt comatproto.SyncSubscribeRepos_Commit
+		if err := json.Unmarshal(line.Payload, &evt); err != nil {
+			return err
+		}
+		return r.replayCommit(ctx, &evt)
+	case line.Type == "identity":
+		var evt comatproto.SyncSubscribeRepos_Identity
+		if err := json.Unmarshal(line.Payload, &evt); err != nil {
+			return err
+		}
+		did, err := syntax.ParseDID(evt.Did)
+		if err != nil {
+			return err
+		}
+		r.ensureIdentity(ctx, did)
+		r.Events++
+		if err := r.Engine.ProcessIdentityEvent(ctx, evt); err != nil {
+			r.Errors++
+			r.logger.Warn("failed to process identity event", "did", did, "err", err)
+		}
+	case line.Type == "account":
+		var evt comatproto.SyncSubscribeRepos_Account
+		if err := json.Unmarshal(line.Payload, &evt); err != nil {
+			return err
+		}
+		did, err := syntax.ParseDID(evt.Did)
+		if err != nil {
+			return err
+		}
+		r.ensureIdentity(ctx, did)
+		r.Events++
+		if err := r.Engine.ProcessAccountEvent(ctx, evt); err != nil {
+			r.Errors++
+			r.logger.Warn("failed to process account event", "did", did, "err", err)
+		}
+	case line.Type == "" && line.Action != "":
+		return r.replayOpLine(ctx, &line)
+	default:
+		// eg, sync events
+		r.Skipped++
+	}
+	return nil
+}
+
+func (r *Replayer) replayCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	if len(evt.Blocks) == 0 {
+		// firehose dumps need to be captured with blocks included
+		r.Skipped++
+		return nil
+	}
+	did, err := syntax.ParseDID(evt.Repo)
+	if err != nil {
+		return err
+	}
+	_, rr, err := repo.LoadRepoFromCAR(ctx, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		r.logger.Warn("failed to read commit blocks", "did", did, "seq", evt.Seq, "err", err)
+		r.Skipped++
+		return nil
+	}
+	for _, op := range evt.Ops {
+		collection, rkey, err := syntax.ParseRepoPath(op.Path)
+		if err != nil {
+			r.Skipped++
+			continue
+		}
+		rop := engine.RecordOp{
+			Action:     op.Action,
+			DID:        did,
+			Collection: collection,
+			RecordKey:  rkey,
+		}
+		switch op.Action {
+		case engine.CreateOp, engine.UpdateOp:
+			recBytes, rc, err := rr.GetRecordBytes(ctx, collection, rkey)
+			if err != nil {
+				r.Skipped++
+				continue
+			}
+			recCID := syntax.CID(rc.String())
+			rop.CID = &recCID
+			rop.RecordCBOR = recBytes
+		case engine.DeleteOp:
+		default:
+			r.Skipped++
+			continue
+		}
+		r.processRecordOp(ctx, rop)
+	}
+	return nil
+}
+
+func (r *Replayer) replayOpLine(ctx context.Context, line *jsonLine) error {
+	if line.DID == "" {
+		// older `goat firehose --ops` output did not include the account DID
+		r.Skipped++
+		return nil
+	}
+	did, err := syntax.ParseDID(line.DID)
+	if err != nil {
+		return err
+	}
+	collection, err := syntax.ParseNSID(line.Collection)
+	if err != nil {
+		return err
+	}
+	rkey, err := syntax.ParseRecordKey(line.RKey)
+	if err != nil {
+		return err
+	}
+	op := engine.RecordOp{
+		Action:     strings.ToLower(line.Action),
+		DID:        did,
+		Collection: collection,
+		RecordKey:  rkey,
+	}
+	if op.Action != engine.DeleteOp {
+		if line.Record == nil || line.CID == "" {
+			r.Skipped++
+			return nil
+		}
+		rec, err := data.UnmarshalJSON(line.Record)
+		if err != nil {
+			return fmt.Errorf("parsing record JSON: %w", err)
+		}
+		recBytes, err := data.MarshalCBOR(rec)
+		if err != nil {
+			return err
+		}
+		recCID := syntax.CID(line.CID)
+		op.CID = &recCID
+		op.RecordCBOR = recBytes
+	}
+	r.processRecordOp(ctx, op)
+	return nil
+}
@@ -0,0 +1,85 @@
+package replay
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/engine"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func helloPostRule(c *engine.RecordContext, post *appbsky.FeedPost) error {
+	if strings.Contains(post.Text, "hello") {
+		c.AddRecordFlag("hello")
+	}
+	return nil
+}
+
+func badHashtagPostRule(c *engine.RecordContext, post *appbsky.FeedPost) error {
+	for _, tag := range post.Tags {
+		if c.InSet("bad-hashtags", tag) {
+			c.AddRecordLabel("bad-hashtag")
+		}
+	}
+	return nil
+}
+
+func anyRecordRule(c *engine.RecordContext) error {
+	c.AddRecordTag("seen")
+	return nil
+}
+
+func testReplayer() *Replayer {
+	eng := engine.EngineTestFixture()
+	return NewReplayer(Config{
+		Rules: engine.RuleSet{
+			PostRules:   []engine.PostRuleFunc{helloPostRule, badHashtagPostRule},
+			RecordRules: []engine.RecordRuleFunc{anyRecordRule},
+		},
+		Sets:        eng.Sets,
+		MaxExamples: 1,
+	})
+}
+
+func TestReplayJSONLines(t *testing.T) {
+	assert := assert.New(t)
+	r := testReplayer()
+
+	assert.NoError(r.ReplayPath(context.Background(), "testdata/ops.jsonl"))
+	assert.Equal(3, r.Events)
+	assert.Equal(0, r.Errors)
+	// the sync event, and an op without a DID
+	assert.Equal(2, r.Skipped)
+
+	assert.Equal([]RuleStats{
+		{Rule: "replay.anyRecordRule", Hits: 2, Examples: []string{"at://did:plc:abc111/app.bsky.feed.post/3lbdw7ubypk2a"}},
+		{Rule: "replay.badHashtagPostRule", Hits: 1, Examples: []string{"at://did:plc:abc111/app.bsky.feed.post/3lbdw7ubypk2a"}},
+		{Rule: "replay.helloPostRule", Hits: 2, Examples: []string{"at://did:plc:abc111/app.bsky.feed.post/3lbdw7ubypk2a"}},
+	}, r.Stats.Rules())
+}
+
+func TestReplayCaptureAndCAR(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	r := testReplayer()
+
+	assert.NoError(r.ReplayPath(ctx, "../capture/testdata/capture_atprotocom.json"))
+	assert.True(r.Events > 0)
+	assert.Equal(0, r.Errors)
+
+	// capture account metadata is used for rule execution
+	ident, err := r.dir.LookupHandle(ctx, syntax.Handle("atproto.com"))
+	assert.NoError(err)
+	am, err := r.Engine.GetAccountMeta(ctx, ident)
+	assert.NoError(err)
+	assert.True(am.Profile.HasAvatar)
+
+	events := r.Events
+	assert.NoError(r.ReplayPath(ctx, "../../testing/testdata/greenground.repo.car"))
+	assert.True(r.Events > events)
+	assert.Equal(0, r.Errors)
+}
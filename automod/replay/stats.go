@@ -0,0 +1,64 @@
+package replay
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/bluesky-social/indigo/automod/engine"
+)
+
+// Per-rule replay statistics
+type RuleStats struct {
+	Rule string `json:"rule"`
+	// number of events the rule produced any effects for
+	Hits int `json:"hits"`
+	// subjects (AT-URI or DID) of the first events the rule produced effects for
+	Examples []string `json:"examples"`
+}
+
+// Aggregates per-rule effects during replay. Implements engine.RuleEffectsRecorder.
+type Stats struct {
+	// max number of example subjects to keep per rule
+	MaxExamples int
+
+	lk    sync.Mutex
+	rules map[string]*RuleStats
+}
+
+func NewStats(maxExamples int) *Stats {
+	return &Stats{
+		MaxExamples: maxExamples,
+		rules:       make(map[string]*RuleStats),
+	}
+}
+
+func (s *Stats) RecordRuleEffects(ctx context.Context, subject string, rules map[string]engine.EffectsSummary) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for name := range rules {
+		rs, ok := s.rules[name]
+		if !ok {
+			rs = &RuleStats{Rule: name, Examples: []string{}}
+			s.rules[name] = rs
+		}
+		rs.Hits++
+		if len(rs.Examples) < s.MaxExamples {
+			rs.Examples = append(rs.Examples, subject)
+		}
+	}
+}
+
+// Per-rule statistics, sorted by rule name
+func (s *Stats) Rules() []RuleStats {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	out := make([]RuleStats, 0, len(s.rules))
+	for _, rs := range s.rules {
+		out = append(out, *rs)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Rule < out[j].Rule
+	})
+	return out
+}
@@ -1,2 +1,2 @@
-// Interface for simple sets of strings, with fast inclusion checks.
+// Interface for simple sets of strings, with fast inclusion checks. Sets can be static (loaded from JSON), or mutable at runtime and stored in redis or an SQL database.
 package setstore
@@ -0,0 +1,147 @@
+package setstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Summary metadata about a single set
+type SetInfo struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+	// when the set was last modified (values added or removed)
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetStore which can be modified at runtime (eg, by moderators via an admin API), instead of only being loaded from static configuration.
+//
+// Adding values to a set which doesn't exist yet creates the set. Removing values which are not in the set is not an error.
+type MutableSetStore interface {
+	SetStore
+	ListSets(ctx context.Context) ([]SetInfo, error)
+	// returns nil (not an error) if the set doesn't exist
+	GetSet(ctx context.Context, name string) (*SetInfo, []string, error)
+	AddValues(ctx context.Context, name string, vals []string) error
+	RemoveValues(ctx context.Context, name string, vals []string) error
+	DeleteSet(ctx context.Context, name string) error
+}
+
+// Loads sets from a JSON file (same format as MemSetStore.LoadFromFileJSON) in to a mutable store. Only sets which don't already exist in the store are imported, so that runtime changes aren't clobbered on restart. Returns the names of imported sets.
+func ImportFileJSON(ctx context.Context, s MutableSetStore, p string) ([]string, error) {
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var sets map[string][]string
+	if err := json.Unmarshal(raw, &sets); err != nil {
+		return nil, err
+	}
+	imported := []string{}
+	for name, vals := range sets {
+		info, _, err := s.GetSet(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil || len(vals) == 0 {
+			continue
+		}
+		if err := s.AddValues(ctx, name, vals); err != nil {
+			return nil, fmt.Errorf("importing set %s: %w", name, err)
+		}
+		imported = append(imported, name)
+	}
+	return imported, nil
+}
+
+// Local (in-process) copy of sets, which keeps InSet fast for the remote (Redis and SQL) implementations. Each set is re-loaded in full when its UpdatedAt timestamp changes.
+type setCache struct {
+	mu       sync.RWMutex
+	sets     map[string]map[string]bool
+	versions map[string]time.Time
+}
+
+func newSetCache() *setCache {
+	return &setCache{
+		sets:     make(map[string]map[string]bool),
+		versions: make(map[string]time.Time),
+	}
+}
+
+func (c *setCache) inSet(name, val string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sets[name][val]
+}
+
+// Updates the cache from the store's current set metadata; only sets which have changed since the last refresh are fetched.
+func (c *setCache) refresh(ctx context.Context, s MutableSetStore) error {
+	infos, err := s.ListSets(ctx)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		seen[info.Name] = true
+		c.mu.RLock()
+		version, ok := c.versions[info.Name]
+		c.mu.RUnlock()
+		if ok && version.Equal(info.UpdatedAt) {
+			continue
+		}
+		if err := c.load(ctx, s, info.Name); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	for name := range c.sets {
+		if !seen[name] {
+			delete(c.sets, name)
+			delete(c.versions, name)
+		}
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// Fetches a single set from the store, replacing (or removing) any cached copy.
+func (c *setCache) load(ctx context.Context, s MutableSetStore, name string) error {
+	info, vals, err := s.GetSet(ctx, name)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if info == nil {
+		delete(c.sets, name)
+		delete(c.versions, name)
+		return nil
+	}
+	m := make(map[string]bool, len(vals))
+	for _, v := range vals {
+		m[v] = true
+	}
+	c.sets[name] = m
+	c.versions[name] = info.UpdatedAt
+	return nil
+}
+
+// Periodically refreshes the cache, until the context is cancelled. Used to pick up changes made by other processes.
+func (c *setCache) run(ctx context.Context, s MutableSetStore, period time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.refresh(ctx, s); err != nil {
+				logger.Error("failed to refresh sets", "err", err)
+			}
+		}
+	}
+}
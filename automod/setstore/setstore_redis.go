@@ -0,0 +1,160 @@
+package setstore
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisSetPrefix string = "set/"
+
+// hash of set name to change timestamp (unix nanoseconds)
+var redisSetMetaKey string = "setmeta"
+
+// MutableSetStore implementation backed by Redis. Each set is a Redis set, with change timestamps stored in a separate hash.
+//
+// Like SQLSetStore, membership checks (InSet) are answered from an in-process copy of all sets.
+type RedisSetStore struct {
+	Client *redis.Client
+	cache  *setCache
+}
+
+func NewRedisSetStore(ctx context.Context, redisURL string) (*RedisSetStore, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opt)
+	// check redis connection
+	_, err = rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	s := &RedisSetStore{
+		Client: rdb,
+		cache:  newSetCache(),
+	}
+	if err := s.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RedisSetStore) InSet(ctx context.Context, name, val string) (bool, error) {
+	return s.cache.inSet(name, val), nil
+}
+
+func parseSetTimestamp(v string) time.Time {
+	nanos, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
+
+func (s *RedisSetStore) ListSets(ctx context.Context) ([]SetInfo, error) {
+	meta, err := s.Client.HGetAll(ctx, redisSetMetaKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(meta))
+	for name := range meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// fetch all sizes in a single round-trip
+	multi := s.Client.Pipeline()
+	cards := make([]*redis.IntCmd, len(names))
+	for i, name := range names {
+		cards[i] = multi.SCard(ctx, redisSetPrefix+name)
+	}
+	if len(names) > 0 {
+		if _, err := multi.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]SetInfo, len(names))
+	for i, name := range names {
+		out[i] = SetInfo{Name: name, Size: int(cards[i].Val()), UpdatedAt: parseSetTimestamp(meta[name])}
+	}
+	return out, nil
+}
+
+func (s *RedisSetStore) GetSet(ctx context.Context, name string) (*SetInfo, []string, error) {
+	multi := s.Client.TxPipeline()
+	ts := multi.HGet(ctx, redisSetMetaKey, name)
+	members := multi.SMembers(ctx, redisSetPrefix+name)
+	if _, err := multi.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, nil, err
+	}
+	if ts.Err() == redis.Nil {
+		return nil, nil, nil
+	} else if ts.Err() != nil {
+		return nil, nil, ts.Err()
+	}
+	vals := members.Val()
+	sort.Strings(vals)
+	return &SetInfo{Name: name, Size: len(vals), UpdatedAt: parseSetTimestamp(ts.Val())}, vals, nil
+}
+
+func (s *RedisSetStore) AddValues(ctx context.Context, name string, vals []string) error {
+	multi := s.Client.TxPipeline()
+	if len(vals) > 0 {
+		members := make([]any, len(vals))
+		for i, v := range vals {
+			members[i] = v
+		}
+		multi.SAdd(ctx, redisSetPrefix+name, members...)
+	}
+	multi.HSet(ctx, redisSetMetaKey, name, time.Now().UnixNano())
+	if _, err := multi.Exec(ctx); err != nil {
+		return err
+	}
+	return s.cache.load(ctx, s, name)
+}
+
+func (s *RedisSetStore) RemoveValues(ctx context.Context, name string, vals []string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	members := make([]any, len(vals))
+	for i, v := range vals {
+		members[i] = v
+	}
+	n, err := s.Client.SRem(ctx, redisSetPrefix+name, members...).Result()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		if err := s.Client.HSet(ctx, redisSetMetaKey, name, time.Now().UnixNano()).Err(); err != nil {
+			return err
+		}
+	}
+	return s.cache.load(ctx, s, name)
+}
+
+func (s *RedisSetStore) DeleteSet(ctx context.Context, name string) error {
+	multi := s.Client.TxPipeline()
+	multi.Del(ctx, redisSetPrefix+name)
+	multi.HDel(ctx, redisSetMetaKey, name)
+	if _, err := multi.Exec(ctx); err != nil {
+		return err
+	}
+	return s.cache.load(ctx, s, name)
+}
+
+// Re-loads any sets which have changed since the last refresh.
+func (s *RedisSetStore) Refresh(ctx context.Context) error {
+	return s.cache.refresh(ctx, s)
+}
+
+// Runs Refresh periodically, until the context is cancelled.
+func (s *RedisSetStore) Run(ctx context.Context, period time.Duration, logger *slog.Logger) {
+	s.cache.run(ctx, s, period, logger)
+}
@@ -0,0 +1,170 @@
+package setstore
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Persisted set metadata
+type SQLSet struct {
+	Name      string `gorm:"primaryKey"`
+	UpdatedAt time.Time
+}
+
+func (SQLSet) TableName() string {
+	return "automod_set"
+}
+
+// Persisted set value: one row per (set, value)
+type SQLSetValue struct {
+	SetName   string `gorm:"primaryKey"`
+	Val       string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+func (SQLSetValue) TableName() string {
+	return "automod_set_value"
+}
+
+// MutableSetStore implementation backed by an SQL database (PostgreSQL or SQLite, via gorm).
+//
+// Membership checks (InSet) are answered from an in-process copy of all sets, which is updated immediately on local changes. Changes made by other processes are picked up by Refresh (see Run).
+type SQLSetStore struct {
+	db    *gorm.DB
+	cache *setCache
+}
+
+func NewSQLSetStore(ctx context.Context, db *gorm.DB) (*SQLSetStore, error) {
+	if err := db.AutoMigrate(&SQLSet{}, &SQLSetValue{}); err != nil {
+		return nil, fmt.Errorf("migrating setstore tables: %w", err)
+	}
+	s := &SQLSetStore{
+		db:    db,
+		cache: newSetCache(),
+	}
+	if err := s.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLSetStore) InSet(ctx context.Context, name, val string) (bool, error) {
+	return s.cache.inSet(name, val), nil
+}
+
+func (s *SQLSetStore) ListSets(ctx context.Context) ([]SetInfo, error) {
+	var rows []struct {
+		Name      string
+		UpdatedAt time.Time
+		Size      int
+	}
+	err := s.db.WithContext(ctx).Model(&SQLSet{}).
+		Select("automod_set.name, automod_set.updated_at, COUNT(automod_set_value.val) AS size").
+		Joins("LEFT JOIN automod_set_value ON automod_set_value.set_name = automod_set.name").
+		Group("automod_set.name, automod_set.updated_at").
+		Order("automod_set.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SetInfo, len(rows))
+	for i, r := range rows {
+		out[i] = SetInfo{Name: r.Name, Size: r.Size, UpdatedAt: r.UpdatedAt.UTC()}
+	}
+	return out, nil
+}
+
+func (s *SQLSetStore) GetSet(ctx context.Context, name string) (*SetInfo, []string, error) {
+	var set SQLSet
+	var vals []string
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("name = ?", name).Limit(1).Find(&set)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return tx.Model(&SQLSetValue{}).Where("set_name = ?", name).Order("val").Pluck("val", &vals).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if set.Name == "" {
+		return nil, nil, nil
+	}
+	if vals == nil {
+		vals = []string{}
+	}
+	return &SetInfo{Name: set.Name, Size: len(vals), UpdatedAt: set.UpdatedAt.UTC()}, vals, nil
+}
+
+// bumps the set's change timestamp, creating the set if necessary
+func (s *SQLSetStore) touch(tx *gorm.DB, name string) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at"}),
+	}).Create(&SQLSet{Name: name, UpdatedAt: time.Now().UTC()}).Error
+}
+
+func (s *SQLSetStore) AddValues(ctx context.Context, name string, vals []string) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.touch(tx, name); err != nil {
+			return err
+		}
+		if len(vals) == 0 {
+			return nil
+		}
+		rows := make([]SQLSetValue, len(vals))
+		for i, v := range vals {
+			rows[i] = SQLSetValue{SetName: name, Val: v}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+	})
+	if err != nil {
+		return err
+	}
+	return s.cache.load(ctx, s, name)
+}
+
+func (s *SQLSetStore) RemoveValues(ctx context.Context, name string, vals []string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("set_name = ? AND val IN ?", name, vals).Delete(&SQLSetValue{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return s.touch(tx, name)
+	})
+	if err != nil {
+		return err
+	}
+	return s.cache.load(ctx, s, name)
+}
+
+func (s *SQLSetStore) DeleteSet(ctx context.Context, name string) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("set_name = ?", name).Delete(&SQLSetValue{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name = ?", name).Delete(&SQLSet{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return s.cache.load(ctx, s, name)
+}
+
+// Re-loads any sets which have changed since the last refresh.
+func (s *SQLSetStore) Refresh(ctx context.Context) error {
+	return s.cache.refresh(ctx, s)
+}
+
+// Runs Refresh periodically, until the context is cancelled.
+func (s *SQLSetStore) Run(ctx context.Context, period time.Duration, logger *slog.Logger) {
+	s.cache.run(ctx, s, period, logger)
+}
@@ -0,0 +1,94 @@
+package setstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLSetStore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db, err := cliutil.SetupDatabase("sqlite://:memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSQLSetStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := s.InSet(ctx, "bad-domains", "example.com")
+	assert.NoError(err)
+	assert.False(ok)
+	info, vals, err := s.GetSet(ctx, "bad-domains")
+	assert.NoError(err)
+	assert.Nil(info)
+	assert.Nil(vals)
+
+	assert.NoError(s.AddValues(ctx, "bad-domains", []string{"example.com", "spam.example", "example.com"}))
+	ok, err = s.InSet(ctx, "bad-domains", "example.com")
+	assert.NoError(err)
+	assert.True(ok)
+
+	info, vals, err = s.GetSet(ctx, "bad-domains")
+	assert.NoError(err)
+	assert.Equal(2, info.Size)
+	assert.False(info.UpdatedAt.IsZero())
+	assert.Equal([]string{"example.com", "spam.example"}, vals)
+	firstUpdate := info.UpdatedAt
+
+	assert.NoError(s.RemoveValues(ctx, "bad-domains", []string{"example.com", "missing.example"}))
+	ok, err = s.InSet(ctx, "bad-domains", "example.com")
+	assert.NoError(err)
+	assert.False(ok)
+	info, _, err = s.GetSet(ctx, "bad-domains")
+	assert.NoError(err)
+	assert.True(info.UpdatedAt.After(firstUpdate))
+
+	assert.NoError(s.AddValues(ctx, "empty", nil))
+	sets, err := s.ListSets(ctx)
+	assert.NoError(err)
+	assert.Len(sets, 2)
+	assert.Equal("bad-domains", sets[0].Name)
+	assert.Equal(1, sets[0].Size)
+	assert.Equal("empty", sets[1].Name)
+	assert.Equal(0, sets[1].Size)
+
+	// changes from another process are picked up on refresh
+	other, err := NewSQLSetStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(other.AddValues(ctx, "bad-domains", []string{"new.example"}))
+	assert.NoError(other.DeleteSet(ctx, "empty"))
+	ok, err = s.InSet(ctx, "bad-domains", "new.example")
+	assert.NoError(err)
+	assert.False(ok)
+	assert.NoError(s.Refresh(ctx))
+	ok, err = s.InSet(ctx, "bad-domains", "new.example")
+	assert.NoError(err)
+	assert.True(ok)
+	sets, err = s.ListSets(ctx)
+	assert.NoError(err)
+	assert.Len(sets, 1)
+
+	// JSON import skips sets which already exist
+	p := filepath.Join(t.TempDir(), "sets.json")
+	assert.NoError(os.WriteFile(p, []byte(`{"bad-domains": ["clobbered.example"], "bad-words": ["hardr"]}`), 0644))
+	imported, err := ImportFileJSON(ctx, s, p)
+	assert.NoError(err)
+	assert.Equal([]string{"bad-words"}, imported)
+	ok, err = s.InSet(ctx, "bad-domains", "clobbered.example")
+	assert.NoError(err)
+	assert.False(ok)
+	ok, err = s.InSet(ctx, "bad-words", "hardr")
+	assert.NoError(err)
+	assert.True(ok)
+}
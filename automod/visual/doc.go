@@ -1,2 +1,4 @@
 // automod helpers for visual content (image blobs)
+//
+// Includes clients for remote image analysis services (Hive, Abyss), and local perceptual-hash matching against known images (ImageHashMatcher).
 package visual
@@ -24,3 +24,13 @@ var abyssAPICount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "automod_abyss_api_count",
 	Help: "Number of abyss image scanning API calls, by HTTP status code",
 }, []string{"status"})
+
+var imageHashDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name: "automod_image_hash_duration_sec",
+	Help: "Duration of local perceptual hashing of image blobs",
+})
+
+var imageHashMatchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_image_hash_match_count",
+	Help: "Number of image blobs matching a known perceptual hash, by hash algorithm",
+}, []string{"algorithm"})
@@ -0,0 +1,187 @@
+package visual
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	// image decoders available to the perceptual hash rule
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Perceptual hash algorithm identifier
+type HashAlgorithm string
+
+const (
+	// DCT-based perceptual hash: robust to re-encoding, resizing, and minor color changes
+	PHash HashAlgorithm = "phash"
+	// gradient ("difference") hash: cheaper, and somewhat more robust to brightness and contrast changes
+	DHash HashAlgorithm = "dhash"
+)
+
+// images larger than this (in pixels) are not decoded for hashing, to bound memory use. Bluesky clients resize images to at most 2000x2000 before upload
+var maxHashImagePixels = 5_000_000
+
+// A 64-bit perceptual image hash. Similar images have hashes with small Hamming distance, when computed with the same algorithm.
+type ImageHash struct {
+	Algorithm HashAlgorithm
+	Value     uint64
+}
+
+// String representation, as used in hash files and sets: algorithm and hex value, like "phash:8f373714acfcf4d0"
+func (h ImageHash) String() string {
+	return fmt.Sprintf("%s:%016x", h.Algorithm, h.Value)
+}
+
+func ParseImageHash(raw string) (ImageHash, error) {
+	alg, hexVal, ok := strings.Cut(strings.TrimSpace(raw), ":")
+	if !ok {
+		return ImageHash{}, fmt.Errorf("invalid image hash (expected algorithm prefix): %s", raw)
+	}
+	switch HashAlgorithm(alg) {
+	case PHash, DHash:
+	default:
+		return ImageHash{}, fmt.Errorf("unsupported image hash algorithm: %s", alg)
+	}
+	if len(hexVal) != 16 {
+		return ImageHash{}, fmt.Errorf("invalid image hash value (expected 16 hex digits): %s", raw)
+	}
+	val, err := strconv.ParseUint(hexVal, 16, 64)
+	if err != nil {
+		return ImageHash{}, fmt.Errorf("invalid image hash value: %w", err)
+	}
+	return ImageHash{Algorithm: HashAlgorithm(alg), Value: val}, nil
+}
+
+// Number of differing bits between two hash values
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Decodes image bytes (JPEG, PNG, or GIF) and computes all supported hashes. Returns image.ErrFormat for unsupported image formats.
+func HashImageBytes(data []byte) ([]ImageHash, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxHashImagePixels {
+		return nil, fmt.Errorf("image too large to hash: %dx%d", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return HashImage(img), nil
+}
+
+// Computes all supported hashes of the image
+func HashImage(img image.Image) []ImageHash {
+	return []ImageHash{
+		{Algorithm: PHash, Value: PerceptualHash(img)},
+		{Algorithm: DHash, Value: DifferenceHash(img)},
+	}
+}
+
+// Computes a 64-bit DCT-based perceptual hash ("pHash"): the image is reduced to 32x32 grayscale, and each bit indicates whether one of the 8x8 lowest-frequency DCT coefficients is above the median.
+func PerceptualHash(img image.Image) uint64 {
+	const size = 32
+	const low = 8
+	pix := grayscale(img, size, size)
+
+	// separable 2D DCT-II, only computing the low frequencies which are used
+	coeffs := dctCoefficients(size)
+	rows := make([]float64, size*low)
+	for y := 0; y < size; y++ {
+		for u := 0; u < low; u++ {
+			var sum float64
+			for x := 0; x < size; x++ {
+				sum += pix[y*size+x] * coeffs[u*size+x]
+			}
+			rows[y*low+u] = sum
+		}
+	}
+	freqs := make([]float64, low*low)
+	for v := 0; v < low; v++ {
+		for u := 0; u < low; u++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				sum += rows[y*low+u] * coeffs[v*size+y]
+			}
+			freqs[v*low+u] = sum
+		}
+	}
+
+	sorted := append([]float64{}, freqs...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, f := range freqs {
+		if f > median {
+			hash |= 1 << uint(len(freqs)-1-i)
+		}
+	}
+	return hash
+}
+
+// Computes a 64-bit gradient hash ("dHash"): the image is reduced to 9x8 grayscale, and each bit indicates whether a pixel is brighter than its neighbor to the right.
+func DifferenceHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	pix := grayscale(img, w, h)
+	var hash uint64
+	i := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			if pix[y*w+x] > pix[y*w+x+1] {
+				hash |= 1 << uint(63-i)
+			}
+			i++
+		}
+	}
+	return hash
+}
+
+// DCT-II basis values, indexed [frequency*n + position]
+func dctCoefficients(n int) []float64 {
+	out := make([]float64, n*n)
+	for u := 0; u < n; u++ {
+		for x := 0; x < n; x++ {
+			out[u*n+x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+		}
+	}
+	return out
+}
+
+// Downscales the image to w by h luminance values (row-major), averaging the source pixels covered by each output pixel.
+func grayscale(img image.Image, w, h int) []float64 {
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	out := make([]float64, w*h)
+	if sw == 0 || sh == 0 {
+		return out
+	}
+	for y := 0; y < h; y++ {
+		y0 := bounds.Min.Y + y*sh/h
+		y1 := bounds.Min.Y + max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0 := bounds.Min.X + x*sw/w
+			x1 := bounds.Min.X + max((x+1)*sw/w, x*sw/w+1)
+			var sum float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			out[y*w+x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return out
+}
@@ -0,0 +1,315 @@
+package visual
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/setstore"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// default max Hamming distance (out of 64 bits) for an image hash match
+var DefaultHashThreshold = 8
+
+// number of recently processed blobs (by CID) to remember hashes for. Used when adding hashes from takedowns, because blobs may no longer be available from the PDS once taken down.
+var recentBlobHashes = 50_000
+
+// Matches image blobs against a local set of known perceptual hashes, without calling any remote service.
+//
+// Known hashes come from a static hash file (see LoadHashFile), and optionally from a mutable set store, which also persists hashes added from moderator takedowns (see ImageHashTakedownRule).
+//
+// Hashes added at runtime are tracked per "source" (the taken-down blob CID), so that reversing one takedown doesn't remove a hash which is still known from another. In the store, each value is the hash followed by a space and the source; values without a source are also accepted.
+type ImageHashMatcher struct {
+	// max Hamming distance for a hash to count as a match
+	Threshold int
+	// if true, matching records (and the matching blob) are taken down, in addition to being flagged and reported
+	Takedown bool
+	// optional persistent storage of known hashes, as values of the named set
+	Store   setstore.MutableSetStore
+	SetName string
+
+	lk sync.RWMutex
+	// hashes loaded from file
+	static map[ImageHash]bool
+	// hashes added at runtime or loaded from the store, with the set of sources for each
+	stored map[ImageHash]map[string]bool
+
+	recent *lru.Cache[string, []ImageHash]
+}
+
+func NewImageHashMatcher(threshold int) *ImageHashMatcher {
+	recent, err := lru.New[string, []ImageHash](recentBlobHashes)
+	if err != nil {
+		panic(err)
+	}
+	return &ImageHashMatcher{
+		Threshold: threshold,
+		static:    make(map[ImageHash]bool),
+		stored:    make(map[ImageHash]map[string]bool),
+		recent:    recent,
+	}
+}
+
+// Loads known hashes from a text file, with one hash per line (like "phash:8f373714acfcf4d0"). Blank lines and lines starting with '#' are ignored.
+func (m *ImageHashMatcher) LoadHashFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hashes := []ImageHash{}
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h, err := ParseImageHash(line)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", path, lineNum, err)
+		}
+		hashes = append(hashes, h)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	for _, h := range hashes {
+		m.static[h] = true
+	}
+	return nil
+}
+
+// store value for a hash from the given source
+func storedHashValue(h ImageHash, source string) string {
+	if source == "" {
+		return h.String()
+	}
+	return h.String() + " " + source
+}
+
+func addStoredHash(stored map[ImageHash]map[string]bool, h ImageHash, source string) {
+	if stored[h] == nil {
+		stored[h] = make(map[string]bool)
+	}
+	stored[h][source] = true
+}
+
+// Re-loads known hashes from the store, if one is configured. Invalid values in the set are skipped.
+func (m *ImageHashMatcher) Refresh(ctx context.Context) error {
+	if m.Store == nil {
+		return nil
+	}
+	_, vals, err := m.Store.GetSet(ctx, m.SetName)
+	if err != nil {
+		return err
+	}
+	stored := make(map[ImageHash]map[string]bool, len(vals))
+	for _, v := range vals {
+		raw, source, _ := strings.Cut(v, " ")
+		h, err := ParseImageHash(raw)
+		if err != nil {
+			continue
+		}
+		addStoredHash(stored, h, source)
+	}
+	m.lk.Lock()
+	m.stored = stored
+	m.lk.Unlock()
+	return nil
+}
+
+// Runs Refresh periodically, until the context is cancelled.
+func (m *ImageHashMatcher) Run(ctx context.Context, period time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Refresh(ctx); err != nil {
+				logger.Error("failed to refresh image hashes", "err", err)
+			}
+		}
+	}
+}
+
+// Adds known hashes from the given source (eg, a taken-down blob CID), persisting them to the store if one is configured.
+func (m *ImageHashMatcher) AddHashes(ctx context.Context, source string, hashes []ImageHash) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	if m.Store != nil {
+		vals := make([]string, len(hashes))
+		for i, h := range hashes {
+			vals[i] = storedHashValue(h, source)
+		}
+		if err := m.Store.AddValues(ctx, m.SetName, vals); err != nil {
+			return err
+		}
+	}
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	for _, h := range hashes {
+		addStoredHash(m.stored, h, source)
+	}
+	return nil
+}
+
+// Removes known hashes which were added from the given source. Hashes which are still known from other sources, or from the hash file, continue to match.
+func (m *ImageHashMatcher) RemoveHashes(ctx context.Context, source string, hashes []ImageHash) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	if m.Store != nil {
+		vals := make([]string, len(hashes))
+		for i, h := range hashes {
+			vals[i] = storedHashValue(h, source)
+		}
+		if err := m.Store.RemoveValues(ctx, m.SetName, vals); err != nil {
+			return err
+		}
+	}
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	for _, h := range hashes {
+		delete(m.stored[h], source)
+		if len(m.stored[h]) == 0 {
+			delete(m.stored, h)
+		}
+	}
+	return nil
+}
+
+// Finds the closest known hash (of the same algorithm) to any of the given hashes, within the threshold distance. Returns nil if there is no match.
+//
+// This does a linear scan of known hashes, which is fine for up to tens of thousands of hashes.
+func (m *ImageHashMatcher) Match(hashes []ImageHash) (*ImageHash, int) {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+	var best *ImageHash
+	bestDist := m.Threshold + 1
+	check := func(k ImageHash) {
+		for _, h := range hashes {
+			if h.Algorithm != k.Algorithm {
+				continue
+			}
+			if d := HammingDistance(h.Value, k.Value); d < bestDist {
+				best = &k
+				bestDist = d
+			}
+		}
+	}
+	for k := range m.static {
+		check(k)
+	}
+	for k := range m.stored {
+		check(k)
+	}
+	if best == nil {
+		return nil, 0
+	}
+	return best, bestDist
+}
+
+// Number of distinct known hashes
+func (m *ImageHashMatcher) Size() int {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+	return len(m.static) + len(m.stored)
+}
+
+var _ automod.BlobRuleFunc = (&ImageHashMatcher{}).ImageHashBlobRule
+
+// Blob rule which hashes image blobs and checks them against known hashes. Matching records are flagged and reported, and optionally taken down.
+func (m *ImageHashMatcher) ImageHashBlobRule(c *automod.RecordContext, blob lexutil.LexBlob, data []byte) error {
+
+	if !strings.HasPrefix(blob.MimeType, "image/") {
+		return nil
+	}
+
+	start := time.Now()
+	hashes, err := HashImageBytes(data)
+	imageHashDuration.Observe(time.Since(start).Seconds())
+	if errors.Is(err, image.ErrFormat) {
+		// eg, webp images
+		return nil
+	} else if err != nil {
+		c.Logger.Warn("failed to hash image blob", "cid", blob.Ref.String(), "err", err)
+		return nil
+	}
+	m.recent.Add(blob.Ref.String(), hashes)
+
+	match, dist := m.Match(hashes)
+	if match == nil {
+		return nil
+	}
+	imageHashMatchCount.WithLabelValues(string(match.Algorithm)).Inc()
+	c.Logger.Warn("image hash match", "cid", blob.Ref.String(), "hash", match.String(), "distance", dist)
+	c.AddRecordFlag("image-hash-match")
+	if m.Takedown {
+		c.TakedownRecord()
+		c.TakedownBlob(blob.Ref.String())
+	}
+	c.ReportRecord(automod.ReportReasonViolation, fmt.Sprintf("image matches known hash (%s, distance %d)\nblob: %s", match.String(), dist, blob.Ref.String()))
+	return nil
+}
+
+var _ automod.OzoneEventRuleFunc = (&ImageHashMatcher{}).ImageHashTakedownRule
+
+// Ozone event rule which adds the hashes of taken-down blobs to the known hashes, and removes them on reversal of the takedown.
+//
+// Hashes are taken from recently processed blobs when possible; otherwise the blob is fetched from the account's PDS, which may fail if the takedown has already been applied there.
+func (m *ImageHashMatcher) ImageHashTakedownRule(c *automod.OzoneEventContext) error {
+	if c.Event.EventType != "takedown" && c.Event.EventType != "reverseTakedown" {
+		return nil
+	}
+
+	// hashes are added and removed per blob, so that reversing this takedown doesn't affect identical images from other takedowns
+	var errs []error
+	for _, cid := range c.Event.SubjectBlobs {
+		hashes, ok := m.recent.Get(cid.String())
+		if !ok {
+			data, err := c.FetchBlob(cid.String())
+			if err != nil {
+				c.Logger.Warn("failed to fetch blob for image hashing", "cid", cid.String(), "err", err)
+				continue
+			}
+			hashes, err = HashImageBytes(data)
+			if err != nil {
+				// not an image, or unsupported format
+				continue
+			}
+		}
+
+		if c.Event.EventType == "takedown" {
+			c.Logger.Info("adding image hashes from takedown", "cid", cid.String(), "count", len(hashes))
+			if err := m.AddHashes(c.Ctx, cid.String(), hashes); err != nil {
+				errs = append(errs, err)
+			}
+		} else {
+			c.Logger.Info("removing image hashes from reversed takedown", "cid", cid.String(), "count", len(hashes))
+			if err := m.RemoveHashes(c.Ctx, cid.String(), hashes); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
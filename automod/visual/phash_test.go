@@ -0,0 +1,248 @@
+package visual
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/automod/setstore"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+// synthetic test image: a grid of pseudo-random colored blocks, determined by the seed
+func testImage(w, h int, seed int64) image.Image {
+	const grid = 6
+	rng := rand.New(rand.NewSource(seed))
+	blocks := make([]color.RGBA, grid*grid)
+	for i := range blocks {
+		blocks[i] = color.RGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, blocks[(y*grid/h)*grid+(x*grid/w)])
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageHashParse(t *testing.T) {
+	assert := assert.New(t)
+
+	h := ImageHash{Algorithm: PHash, Value: 0x8f373714acfcf4d0}
+	assert.Equal("phash:8f373714acfcf4d0", h.String())
+	parsed, err := ParseImageHash(h.String())
+	assert.NoError(err)
+	assert.Equal(h, parsed)
+
+	for _, bad := range []string{"", "8f373714acfcf4d0", "ahash:8f373714acfcf4d0", "phash:8f37", "dhash:zz373714acfcf4d0"} {
+		_, err := ParseImageHash(bad)
+		assert.Error(err, bad)
+	}
+}
+
+func TestImageHashSimilarity(t *testing.T) {
+	assert := assert.New(t)
+
+	orig := testImage(400, 300, 2)
+	origHashes := HashImage(orig)
+
+	// re-encoded as lossy JPEG, at a smaller size
+	var buf bytes.Buffer
+	assert.NoError(jpeg.Encode(&buf, testImage(200, 150, 2), &jpeg.Options{Quality: 50}))
+	similar, err := HashImageBytes(buf.Bytes())
+	assert.NoError(err)
+
+	different := HashImage(testImage(400, 300, 5))
+
+	for i := range origHashes {
+		assert.LessOrEqual(HammingDistance(origHashes[i].Value, similar[i].Value), DefaultHashThreshold, origHashes[i].Algorithm)
+		assert.Greater(HammingDistance(origHashes[i].Value, different[i].Value), DefaultHashThreshold, origHashes[i].Algorithm)
+	}
+
+	// unsupported formats are reported as such
+	_, err = HashImageBytes([]byte("RIFF....WEBPVP8 "))
+	assert.ErrorIs(err, image.ErrFormat)
+}
+
+func TestImageHashMatcher(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	known := HashImage(testImage(400, 300, 2))
+	path := filepath.Join(t.TempDir(), "hashes.txt")
+	assert.NoError(os.WriteFile(path, []byte("# known bad images\n\n"+known[0].String()+"\n"), 0644))
+
+	m := NewImageHashMatcher(DefaultHashThreshold)
+	assert.NoError(m.LoadHashFile(path))
+	assert.Equal(1, m.Size())
+
+	match, _ := m.Match(HashImage(testImage(300, 225, 2)))
+	assert.NotNil(match)
+	match, _ = m.Match(HashImage(testImage(400, 300, 5)))
+	assert.Nil(match)
+
+	// hashes added at runtime are persisted to the store, and picked up by other instances
+	db, err := cliutil.SetupDatabase("sqlite://:memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := setstore.NewSQLSetStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Store = store
+	m.SetName = "image-hashes"
+	other := HashImage(testImage(400, 300, 5))
+	assert.NoError(m.AddHashes(ctx, "bafkreiblobone", other))
+	// the same image, from a second takedown
+	assert.NoError(m.AddHashes(ctx, "bafkreiblobtwo", other))
+
+	m2 := NewImageHashMatcher(DefaultHashThreshold)
+	m2.Store = store
+	m2.SetName = "image-hashes"
+	assert.NoError(m2.Refresh(ctx))
+	assert.Equal(2, m2.Size())
+	match, dist := m2.Match(other)
+	assert.NotNil(match)
+	assert.Equal(0, dist)
+
+	// removing the hashes from one source leaves those from the other
+	assert.NoError(m2.RemoveHashes(ctx, "bafkreiblobone", other))
+	assert.NoError(m.Refresh(ctx))
+	match, _ = m.Match(other)
+	assert.NotNil(match)
+	assert.Equal(1+len(other), m.Size())
+
+	assert.NoError(m2.RemoveHashes(ctx, "bafkreiblobtwo", other))
+	assert.NoError(m.Refresh(ctx))
+	match, _ = m.Match(other)
+	assert.Nil(match)
+	assert.Equal(1, m.Size())
+}
+
+func TestImageHashBlobRule(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	eng := engine.EngineTestFixture()
+
+	m := NewImageHashMatcher(DefaultHashThreshold)
+	assert.NoError(m.AddHashes(ctx, "", HashImage(testImage(400, 300, 2))))
+
+	am1 := automod.AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+	}
+	cid1 := syntax.CID("cid123")
+	op := engine.RecordOp{
+		Action:     engine.CreateOp,
+		DID:        am1.Identity.DID,
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: []byte{},
+	}
+	blobCID, err := cid.Decode("bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := lexutil.LexBlob{
+		Ref:      lexutil.LexLink(blobCID),
+		MimeType: "image/png",
+	}
+
+	c1 := engine.NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(m.ImageHashBlobRule(&c1, blob, encodePNG(t, testImage(400, 300, 5))))
+	eff1 := engine.ExtractEffects(&c1.BaseContext)
+	assert.Empty(eff1.RecordFlags)
+
+	m.Takedown = true
+	c2 := engine.NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(m.ImageHashBlobRule(&c2, blob, encodePNG(t, testImage(320, 240, 2))))
+	eff2 := engine.ExtractEffects(&c2.BaseContext)
+	assert.Equal([]string{"image-hash-match"}, eff2.RecordFlags)
+	assert.True(eff2.RecordTakedown)
+	assert.Equal([]string{blobCID.String()}, eff2.BlobTakedowns)
+	assert.Equal(1, len(eff2.RecordReports))
+
+	// hashes of processed blobs are remembered, for adding from takedowns
+	_, ok := m.recent.Get(blobCID.String())
+	assert.True(ok)
+}
+
+func TestImageHashTakedownRule(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	eng := engine.EngineTestFixture()
+
+	m := NewImageHashMatcher(DefaultHashThreshold)
+	hashes := HashImage(testImage(400, 300, 2))
+	blobOne := "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"
+	blobTwo := "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+	m.recent.Add(blobOne, hashes)
+	m.recent.Add(blobTwo, hashes)
+
+	event := func(id int64, blobCIDs []string, reverse bool) *automod.OzoneEventContext {
+		view := &toolsozone.ModerationDefs_ModEventView{
+			Id:              id,
+			CreatedAt:       syntax.DatetimeNow().String(),
+			CreatedBy:       "did:plc:abc111",
+			Subject:         &toolsozone.ModerationDefs_ModEventView_Subject{AdminDefs_RepoRef: &comatproto.AdminDefs_RepoRef{Did: "did:plc:abc111"}},
+			SubjectBlobCids: blobCIDs,
+			Event:           &toolsozone.ModerationDefs_ModEventView_Event{},
+		}
+		if reverse {
+			view.Event.ModerationDefs_ModEventReverseTakedown = &toolsozone.ModerationDefs_ModEventReverseTakedown{}
+		} else {
+			view.Event.ModerationDefs_ModEventTakedown = &toolsozone.ModerationDefs_ModEventTakedown{}
+		}
+		c, err := engine.NewOzoneEventContext(ctx, &eng, view)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// an invalid blob CID is skipped, instead of failing the whole event
+	c1 := event(1, []string{"not-a-cid", blobOne}, false)
+	assert.Equal(1, len(c1.Event.SubjectBlobs))
+	assert.NoError(m.ImageHashTakedownRule(c1))
+	assert.NoError(m.ImageHashTakedownRule(event(2, []string{blobTwo}, false)))
+	match, _ := m.Match(hashes)
+	assert.NotNil(match)
+
+	// reversing one takedown doesn't remove hashes which are still known from the other
+	assert.NoError(m.ImageHashTakedownRule(event(3, []string{blobOne}, true)))
+	match, _ = m.Match(hashes)
+	assert.NotNil(match)
+	assert.NoError(m.ImageHashTakedownRule(event(4, []string{blobTwo}, true)))
+	match, _ = m.Match(hashes)
+	assert.Nil(match)
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/atproto/identity"
@@ -70,84 +72,92 @@ func (srv *Server) ResolveDid(c echo.Context) error {
 	})
 }
 
-// helper for resolveIdentity
-func (srv *Server) resolveIdentityFromHandle(c echo.Context, handle syntax.Handle) error {
-	ctx := c.Request().Context()
+// error from an identity resolution helper, with HTTP status code
+type identityError struct {
+	Status int
+	GenericError
+}
 
+// helper for resolveIdentity
+func (srv *Server) resolveIdentityInfoFromHandle(ctx context.Context, handle syntax.Handle) (*comatproto.IdentityDefs_IdentityInfo, *identityError) {
 	did, err := srv.dir.ResolveHandle(ctx, handle)
 	if err != nil && errors.Is(err, identity.ErrHandleNotFound) {
-		return c.JSON(404, GenericError{
+		return nil, &identityError{404, GenericError{
 			Error:   "HandleNotFound",
 			Message: err.Error(),
-		})
+		}}
 	} else if err != nil {
 		srv.logger.Warn("failed handle resolution", "err", err, "handle", handle)
-		return c.JSON(502, GenericError{
+		return nil, &identityError{502, GenericError{
 			Error:   "HandleResolutionFailed",
 			Message: err.Error(),
-		})
+		}}
 	}
 
 	rawDoc, err := srv.dir.ResolveDIDRaw(ctx, did)
 	if err != nil && errors.Is(err, identity.ErrDIDNotFound) {
-		return c.JSON(404, GenericError{
+		return nil, &identityError{404, GenericError{
 			Error:   "DidNotFound",
 			Message: err.Error(),
-		})
+		}}
 	} else if err != nil {
-		return c.JSON(502, GenericError{
+		return nil, &identityError{502, GenericError{
 			Error:   "DIDResolutionFailed",
 			Message: err.Error(),
-		})
+		}}
 	}
 
 	var doc identity.DIDDocument
 	if err := json.Unmarshal(rawDoc, &doc); err != nil {
-		return c.JSON(400, GenericError{
+		return nil, &identityError{400, GenericError{
 			Error:   "InvalidDidDocument",
 			Message: err.Error(),
-		})
+		}}
 	}
 
 	ident := identity.ParseIdentity(&doc)
 	declHandle, err := ident.DeclaredHandle()
-	if err != nil || declHandle != handle {
-		return c.JSON(400, GenericError{
+	if err != nil {
+		return nil, &identityError{400, GenericError{
 			Error:   "HandleMismatch",
 			Message: err.Error(),
-		})
+		}}
+	}
+	if declHandle != handle {
+		return nil, &identityError{400, GenericError{
+			Error:   "HandleMismatch",
+			Message: fmt.Sprintf("DID document declares a different handle: %s", declHandle),
+		}}
 	}
 
-	return c.JSON(200, comatproto.IdentityDefs_IdentityInfo{
+	return &comatproto.IdentityDefs_IdentityInfo{
 		Did:    ident.DID.String(),
 		Handle: handle.String(),
 		DidDoc: rawDoc,
-	})
+	}, nil
 }
 
 // helper for resolveIdentity
-func (srv *Server) resolveIdentityFromDID(c echo.Context, did syntax.DID) error {
-	ctx := c.Request().Context()
-
+func (srv *Server) resolveIdentityInfoFromDID(ctx context.Context, did syntax.DID) (*comatproto.IdentityDefs_IdentityInfo, *identityError) {
 	rawDoc, err := srv.dir.ResolveDIDRaw(ctx, did)
 	if err != nil && errors.Is(err, identity.ErrDIDNotFound) {
-		return c.JSON(404, GenericError{
+		return nil, &identityError{404, GenericError{
 			Error:   "DidNotFound",
 			Message: err.Error(),
-		})
+		}}
 	} else if err != nil {
-		return c.JSON(502, GenericError{
+		return nil, &identityError{502, GenericError{
 			Error:   "DIDResolutionFailed",
 			Message: err.Error(),
-		})
+		}}
 	}
 
 	var doc identity.DIDDocument
 	if err := json.Unmarshal(rawDoc, &doc); err != nil {
-		return c.JSON(400, GenericError{
+		return nil, &identityError{400, GenericError{
 			Error:   "InvalidDidDocument",
 			Message: err.Error(),
-		})
+		}}
 	}
 
 	ident := identity.ParseIdentity(&doc)
@@ -162,16 +172,47 @@ func (srv *Server) resolveIdentityFromDID(c echo.Context, did syntax.DID) error
 		handle = syntax.HandleInvalid
 	}
 
-	return c.JSON(200, comatproto.IdentityDefs_IdentityInfo{
+	return &comatproto.IdentityDefs_IdentityInfo{
 		Did:    ident.DID.String(),
 		Handle: handle.String(),
 		DidDoc: rawDoc,
-	})
+	}, nil
+}
+
+func (srv *Server) resolveIdentityInfo(ctx context.Context, atid syntax.AtIdentifier) (*comatproto.IdentityDefs_IdentityInfo, *identityError) {
+	// we partially re-implement the "Lookup()" logic here, but returning the full DID document, not `identity.Identity`
+	handle, err := atid.AsHandle()
+	if nil == err {
+		return srv.resolveIdentityInfoFromHandle(ctx, handle)
+	}
+	did, err := atid.AsDID()
+	if nil == err {
+		return srv.resolveIdentityInfoFromDID(ctx, did)
+	}
+	return nil, &identityError{500, GenericError{
+		Error:   "InternalError",
+		Message: "unreachable code path",
+	}}
+}
+
+func (srv *Server) resolveIdentityFromHandle(c echo.Context, handle syntax.Handle) error {
+	info, ierr := srv.resolveIdentityInfoFromHandle(c.Request().Context(), handle)
+	if ierr != nil {
+		return c.JSON(ierr.Status, ierr.GenericError)
+	}
+	return c.JSON(200, info)
+}
+
+func (srv *Server) resolveIdentityFromDID(c echo.Context, did syntax.DID) error {
+	info, ierr := srv.resolveIdentityInfoFromDID(c.Request().Context(), did)
+	if ierr != nil {
+		return c.JSON(ierr.Status, ierr.GenericError)
+	}
+	return c.JSON(200, info)
 }
 
 // GET /xrpc/com.atproto.identity.resolveIdentity
 func (srv *Server) ResolveIdentity(c echo.Context) error {
-	// we partially re-implement the "Lookup()" logic here, but returning the full DID document, not `identity.Identity`
 	atid, err := syntax.ParseAtIdentifier(c.QueryParam("identifier"))
 	if err != nil {
 		return c.JSON(400, GenericError{
@@ -180,15 +221,82 @@ func (srv *Server) ResolveIdentity(c echo.Context) error {
 		})
 	}
 
-	handle, err := atid.AsHandle()
-	if nil == err {
-		return srv.resolveIdentityFromHandle(c, handle)
+	info, ierr := srv.resolveIdentityInfo(c.Request().Context(), *atid)
+	if ierr != nil {
+		return c.JSON(ierr.Status, ierr.GenericError)
 	}
-	did, err := atid.AsDID()
-	if nil == err {
-		return srv.resolveIdentityFromDID(c, did)
+	return c.JSON(200, info)
+}
+
+// maximum number of identifiers in a single batch request
+const batchResolveMaxIdentifiers = 500
+
+// maximum number of concurrent resolutions for a single batch request. Cache misses are additionally limited per-host (and for PLC) by the identity.ThrottledDirectory underneath the cache
+const batchResolveConcurrency = 20
+
+type BatchResolveIdentityInput struct {
+	Identifiers []string `json:"identifiers"`
+}
+
+type BatchResolveIdentityResult struct {
+	Identifier string                                `json:"identifier"`
+	Identity   *comatproto.IdentityDefs_IdentityInfo `json:"identity,omitempty"`
+	Error      string                                `json:"error,omitempty"`
+	Message    string                                `json:"message,omitempty"`
+}
+
+type BatchResolveIdentityOutput struct {
+	Results []BatchResolveIdentityResult `json:"results"`
+}
+
+// POST /batch/resolveIdentity
+//
+// Non-Lexicon endpoint which resolves many identities in a single request. Results are returned in the same order as the request identifiers, each with either an identity or an error (with the same error names as resolveIdentity).
+func (srv *Server) BatchResolveIdentity(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var body BatchResolveIdentityInput
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(400, GenericError{
+			Error:   "InvalidRequestBody",
+			Message: err.Error(),
+		})
 	}
-	return fmt.Errorf("unreachable code path")
+	if len(body.Identifiers) > batchResolveMaxIdentifiers {
+		return c.JSON(400, GenericError{
+			Error:   "BatchTooLarge",
+			Message: fmt.Sprintf("at most %d identifiers per request", batchResolveMaxIdentifiers),
+		})
+	}
+
+	results := make([]BatchResolveIdentityResult, len(body.Identifiers))
+	sem := make(chan struct{}, batchResolveConcurrency)
+	var wg sync.WaitGroup
+	for i, raw := range body.Identifiers {
+		results[i].Identifier = raw
+		atid, err := syntax.ParseAtIdentifier(raw)
+		if err != nil {
+			results[i].Error = "InvalidIdentifierSyntax"
+			results[i].Message = err.Error()
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, atid syntax.AtIdentifier) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			info, ierr := srv.resolveIdentityInfo(ctx, atid)
+			if ierr != nil {
+				results[i].Error = ierr.Error
+				results[i].Message = ierr.Message
+				return
+			}
+			results[i].Identity = info
+		}(i, *atid)
+	}
+	wg.Wait()
+
+	return c.JSON(200, BatchResolveIdentityOutput{Results: results})
 }
 
 // POST /xrpc/com.atproto.identity.refreshIdentity
@@ -51,6 +51,12 @@ func run(args []string) error {
 				Value:   300,
 				EnvVars: []string{"BLUEPAGES_PLC_RATE_LIMIT"},
 			},
+			&cli.IntFlag{
+				Name:    "host-concurrency",
+				Usage:   "max number of concurrent resolution requests to any single host (other than PLC registry); 0 for unlimited",
+				Value:   20,
+				EnvVars: []string{"BLUEPAGES_HOST_CONCURRENCY"},
+			},
 			&cli.StringFlag{
 				Name:    "redis-url",
 				Usage:   "redis connection URL: redis://<user>:<pass>@<hostname>:6379/<db>",
@@ -193,12 +199,13 @@ func runServeCmd(cctx *cli.Context) error {
 
 	srv, err := NewServer(
 		Config{
-			Logger:         logger,
-			Bind:           cctx.String("bind"),
-			RedisURL:       cctx.String("redis-url"),
-			PLCHost:        cctx.String("atp-plc-host"),
-			PLCRateLimit:   cctx.Int("plc-rate-limit"),
-			DisableRefresh: cctx.Bool("disable-refresh"),
+			Logger:          logger,
+			Bind:            cctx.String("bind"),
+			RedisURL:        cctx.String("redis-url"),
+			PLCHost:         cctx.String("atp-plc-host"),
+			PLCRateLimit:    cctx.Int("plc-rate-limit"),
+			HostConcurrency: cctx.Int("host-concurrency"),
+			DisableRefresh:  cctx.Bool("disable-refresh"),
 		},
 	)
 	if err != nil {
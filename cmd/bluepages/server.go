@@ -39,12 +39,14 @@ type Server struct {
 }
 
 type Config struct {
-	Logger         *slog.Logger
-	PLCHost        string
-	PLCRateLimit   int
-	RedisURL       string
-	Bind           string
-	DisableRefresh bool
+	Logger       *slog.Logger
+	PLCHost      string
+	PLCRateLimit int
+	// max concurrent resolutions against any single host (PDS, handle domain, etc); zero for unlimited
+	HostConcurrency int
+	RedisURL        string
+	Bind            string
+	DisableRefresh  bool
 }
 
 func NewServer(config Config) (*Server, error) {
@@ -71,14 +73,18 @@ func NewServer(config Config) (*Server, error) {
 				return d.DialContext(ctx, network, address)
 			},
 		},
-		PLCLimiter:            rate.NewLimiter(rate.Limit(config.PLCRateLimit), 1),
 		TryAuthoritativeDNS:   true,
 		SkipDNSDomainSuffixes: []string{".bsky.social", ".staging.bsky.dev"},
 		// TODO: UserAgent: "bluepages",
 	}
 
+	// all cache misses (including from batch requests) are coalesced, and limited per-host and for PLC
+	throttledDir := identity.NewThrottledDirectory(&baseDir, config.HostConcurrency, 0)
+	throttledDir.PLCConcurrency = 0
+	throttledDir.PLCRateLimit = rate.Limit(config.PLCRateLimit)
+
 	// TODO: config these timeouts
-	redisDir, err := NewRedisResolver(&baseDir, config.RedisURL, time.Hour*24, time.Minute*2, time.Minute*5, 50_000)
+	redisDir, err := NewRedisResolver(throttledDir, config.RedisURL, time.Hour*24, time.Minute*2, time.Minute*5, 50_000)
 	if err != nil {
 		return nil, err
 	}
@@ -138,6 +144,7 @@ func NewServer(config Config) (*Server, error) {
 	e.GET("/xrpc/com.atproto.identity.resolveHandle", srv.ResolveHandle)
 	e.GET("/xrpc/com.atproto.identity.resolveDid", srv.ResolveDid)
 	e.GET("/xrpc/com.atproto.identity.resolveIdentity", srv.ResolveIdentity)
+	e.POST("/batch/resolveIdentity", srv.BatchResolveIdentity)
 	if !config.DisableRefresh {
 		e.POST("/xrpc/com.atproto.identity.refreshIdentity", srv.RefreshIdentity)
 	}
@@ -20,6 +20,15 @@ var cmdResolve = &cli.Command{
 			Name:  "did",
 			Usage: "just resolve to DID",
 		},
+		&cli.BoolFlag{
+			Name:  "trace",
+			Usage: "for handles, output a report of each resolution method attempted (instead of the DID document)",
+		},
+		&cli.StringFlag{
+			Name:    "doh",
+			Usage:   "DNS-over-HTTPS endpoint URL to use for handle resolution (eg, https://cloudflare-dns.com/dns-query)",
+			EnvVars: []string{"GOAT_DOH_URL"},
+		},
 	},
 	Action: runResolve,
 }
@@ -36,6 +45,9 @@ func runResolve(cctx *cli.Context) error {
 		return err
 	}
 	dir := identity.BaseDirectory{}
+	if cctx.String("doh") != "" {
+		dir.DNSTransport = &identity.DoHTransport{URL: cctx.String("doh")}
+	}
 	var raw json.RawMessage
 
 	if atid.IsDID() {
@@ -56,6 +68,15 @@ func runResolve(cctx *cli.Context) error {
 		if err != nil {
 			return err
 		}
+		if cctx.Bool("trace") {
+			report := dir.ResolveHandleWithReport(ctx, handle)
+			b, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+			return report.Err
+		}
 		did, err := dir.ResolveHandle(ctx, handle)
 		if err != nil {
 			return err
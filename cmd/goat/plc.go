@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/identity/plc"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/util"
 
@@ -42,6 +44,24 @@ var cmdPLC = &cli.Command{
 			Flags:     []cli.Flag{},
 			Action:    runPLCData,
 		},
+		&cli.Command{
+			Name:      "audit",
+			Usage:     "verify full operation log for individual DID locally (signatures, key authority, recovery)",
+			ArgsUsage: `<at-identifier>`,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:      "file",
+					Aliases:   []string{"f"},
+					Usage:     "read audit log (JSON) from local file, instead of fetching from PLC registry",
+					TakesFile: true,
+				},
+				&cli.BoolFlag{
+					Name:  "doc",
+					Usage: "output the DID document derived from the verified log",
+				},
+			},
+			Action: runPLCAudit,
+		},
 		&cli.Command{
 			Name:  "dump",
 			Usage: "output full operation log, as JSON lines",
@@ -193,6 +213,90 @@ func runPLCData(cctx *cli.Context) error {
 	return nil
 }
 
+func runPLCAudit(cctx *cli.Context) error {
+	ctx := context.Background()
+	plcHost := cctx.String("plc-host")
+	s := cctx.Args().First()
+	if s == "" {
+		return fmt.Errorf("need to provide account identifier as an argument")
+	}
+
+	dir := identity.BaseDirectory{
+		PLCURL: plcHost,
+	}
+
+	id, err := syntax.ParseAtIdentifier(s)
+	if err != nil {
+		return err
+	}
+	var did syntax.DID
+	if id.IsDID() {
+		did, err = id.AsDID()
+		if err != nil {
+			return err
+		}
+	} else {
+		hdl, err := id.AsHandle()
+		if err != nil {
+			return err
+		}
+		did, err = dir.ResolveHandle(ctx, hdl)
+		if err != nil {
+			return err
+		}
+	}
+
+	if did.Method() != "plc" {
+		return fmt.Errorf("non-PLC DID method: %s", did.Method())
+	}
+
+	var logBytes []byte
+	if cctx.String("file") != "" {
+		logBytes, err = os.ReadFile(cctx.String("file"))
+	} else {
+		client := plc.Client{Host: plcHost}
+		logBytes, err = client.AuditLogJSON(ctx, did)
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := plc.ParseAuditLog(logBytes)
+	if err != nil {
+		return err
+	}
+	vlog, err := plc.VerifyLog(did, entries)
+	if err != nil {
+		return err
+	}
+
+	if cctx.Bool("doc") {
+		doc, err := vlog.DIDDocument()
+		if err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	for _, op := range vlog.Ops {
+		status := "valid"
+		if op.Nullified {
+			status = "nullified"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\tkey[%d]=%s\n", op.Entry.CreatedAt, op.Entry.CID, op.Entry.Operation.Type, status, op.SignerIndex, op.SignerKey)
+	}
+	if vlog.Tombstoned() {
+		fmt.Printf("%s: verified (%d operations; tombstoned)\n", did, len(vlog.Ops))
+	} else {
+		fmt.Printf("%s: verified (%d operations)\n", did, len(vlog.Ops))
+	}
+	return nil
+}
+
 func runPLCDump(cctx *cli.Context) error {
 	ctx := context.Background()
 	plcHost := cctx.String("plc-host")
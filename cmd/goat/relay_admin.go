@@ -134,6 +134,41 @@ var cmdRelayAdmin = &cli.Command{
 				},
 			},
 		},
+		&cli.Command{
+			Name:  "inventory",
+			Usage: "sub-commands for signed host and account inventories",
+			Subcommands: []*cli.Command{
+				&cli.Command{
+					Name:  "export",
+					Usage: "download a snapshot of all hosts and accounts on relay",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "format",
+							Usage: "output format: ndjson or csv",
+							Value: "ndjson",
+						},
+						&cli.StringFlag{
+							Name:    "output",
+							Aliases: []string{"o"},
+							Usage:   "file path to write to (default: stdout)",
+						},
+					},
+					Action: runRelayAdminInventoryExport,
+				},
+				&cli.Command{
+					Name:      "verify",
+					Usage:     "check the digest and signature of an inventory export file",
+					ArgsUsage: `<file>`,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "key",
+							Usage: "expected signing key (did:key); otherwise the key in the file is used",
+						},
+					},
+					Action: runRelayAdminInventoryVerify,
+				},
+			},
+		},
 	},
 }
 
@@ -143,7 +178,7 @@ type RelayAdminClient struct {
 	BearerToken string
 }
 
-func (c *RelayAdminClient) Do(method, path string, params map[string]string, body map[string]any) ([]byte, error) {
+func (c *RelayAdminClient) newRequest(method, path string, params map[string]string, body map[string]any) (*http.Request, error) {
 	u, err := url.Parse(c.Host)
 	if err != nil {
 		return nil, err
@@ -182,6 +217,14 @@ func (c *RelayAdminClient) Do(method, path string, params map[string]string, bod
 	if buf != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	return req, nil
+}
+
+func (c *RelayAdminClient) Do(method, path string, params map[string]string, body map[string]any) ([]byte, error) {
+	req, err := c.newRequest(method, path, params, body)
+	if err != nil {
+		return nil, err
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -200,6 +243,26 @@ func (c *RelayAdminClient) Do(method, path string, params map[string]string, bod
 	return respBytes, nil
 }
 
+// Like Do, but for large responses: returns the response body for the caller to read (and close), instead of buffering it.
+func (c *RelayAdminClient) Stream(method, path string, params map[string]string) (io.ReadCloser, error) {
+	req, err := c.newRequest(method, path, params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		slog.Warn("relay HTTP error", "statusCode", resp.StatusCode, "body", string(respBytes))
+		return nil, fmt.Errorf("relay HTTP request failed: %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
 func NewRelayAdminClient(cctx *cli.Context) (*RelayAdminClient, error) {
 	client := RelayAdminClient{
 		Host:        cctx.String("relay-host"),
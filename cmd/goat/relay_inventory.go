@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+
+	"github.com/urfave/cli/v2"
+)
+
+func runRelayAdminInventoryExport(cctx *cli.Context) error {
+	client, err := NewRelayAdminClient(cctx)
+	if err != nil {
+		return err
+	}
+
+	format := cctx.String("format")
+	if format != "ndjson" && format != "csv" {
+		return fmt.Errorf("unsupported inventory format: %s", format)
+	}
+
+	var out io.Writer = os.Stdout
+	if p := cctx.String("output"); p != "" {
+		f, err := os.Create(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	body, err := client.Stream("GET", "/admin/inventory/export", map[string]string{"format": format})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = io.Copy(out, body)
+	return err
+}
+
+// parsed final line of an inventory export
+type inventorySignature struct {
+	SHA256 string `json:"sha256"`
+	Key    string `json:"key"`
+	Sig    string `json:"sig"`
+}
+
+func parseInventorySignature(line []byte) (*inventorySignature, error) {
+	if bytes.HasPrefix(line, []byte("#signature,")) {
+		row, err := csv.NewReader(bytes.NewReader(line)).Read()
+		if err != nil {
+			return nil, err
+		}
+		if len(row) != 4 {
+			return nil, fmt.Errorf("malformed CSV signature line")
+		}
+		return &inventorySignature{SHA256: row[1], Key: row[2], Sig: row[3]}, nil
+	}
+	var sig inventorySignature
+	if err := json.Unmarshal(line, &sig); err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
+
+func runRelayAdminInventoryVerify(cctx *cli.Context) error {
+	p := cctx.Args().First()
+	if p == "" {
+		return fmt.Errorf("need to provide inventory file path as an argument")
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the digest covers every line before the signature line, which must be last
+	hasher := sha256.New()
+	var trailer []byte
+	hosts, accounts := 0, 0
+	rdr := bufio.NewReader(f)
+	for {
+		line, err := rdr.ReadBytes('\n')
+		if len(line) > 0 {
+			if trailer != nil {
+				return fmt.Errorf("unexpected content after signature line")
+			}
+			switch {
+			case bytes.HasPrefix(line, []byte(`{"type":"signature"`)), bytes.HasPrefix(line, []byte("#signature,")):
+				trailer = bytes.TrimSpace(line)
+			case bytes.HasPrefix(line, []byte(`{"type":"host"`)), bytes.HasPrefix(line, []byte("host,")):
+				hosts++
+			case bytes.HasPrefix(line, []byte(`{"type":"account"`)), bytes.HasPrefix(line, []byte("account,")):
+				accounts++
+			}
+			if trailer == nil {
+				hasher.Write(line)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if trailer == nil {
+		return fmt.Errorf("no signature line found (export may be truncated)")
+	}
+
+	sig, err := parseInventorySignature(trailer)
+	if err != nil {
+		return fmt.Errorf("parsing signature line: %w", err)
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != sig.SHA256 {
+		return fmt.Errorf("digest mismatch: inventory contents were modified or truncated")
+	}
+	if sig.Sig == "" || sig.Key == "" {
+		return fmt.Errorf("digest matches, but inventory is not signed")
+	}
+
+	if k := cctx.String("key"); k != "" && k != sig.Key {
+		return fmt.Errorf("inventory signed by unexpected key: %s", sig.Key)
+	}
+	pub, err := crypto.ParsePublicDIDKey(sig.Key)
+	if err != nil {
+		return fmt.Errorf("parsing signing key: %w", err)
+	}
+	sigBytes, err := base64.RawURLEncoding.DecodeString(sig.Sig)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	if err := pub.HashAndVerify([]byte(sig.SHA256), sigBytes); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	fmt.Printf("valid inventory: %d hosts, %d accounts\n", hosts, accounts)
+	fmt.Printf("signed by: %s\n", sig.Key)
+	if !cctx.IsSet("key") {
+		fmt.Println("NOTE: compare the signing key against the one published by the relay operator (or pass --key)")
+	}
+	return nil
+}
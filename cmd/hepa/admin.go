@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bluesky-social/indigo/automod/setstore"
+)
+
+// max size of admin request bodies
+const adminMaxBodyBytes = 4 << 20
+
+type setValuesRequest struct {
+	Values []string `json:"values"`
+}
+
+type setResponse struct {
+	Set    setstore.SetInfo `json:"set"`
+	Values []string         `json:"values"`
+}
+
+// Registers the authenticated admin API for editing sets:
+//
+//	GET    /admin/sets                  list all sets (name, size, and last-change time)
+//	GET    /admin/sets/{name}           set metadata and values
+//	POST   /admin/sets/{name}/values    add values: {"values": [...]}
+//	DELETE /admin/sets/{name}/values    remove values: {"values": [...]}
+//	DELETE /admin/sets/{name}           delete the entire set
+func (s *Server) registerAdminHandlers(mux *http.ServeMux) {
+	mux.Handle("GET /admin/sets", s.checkAdminAuth(s.handleListSets))
+	mux.Handle("GET /admin/sets/{name}", s.checkAdminAuth(s.handleGetSet))
+	mux.Handle("POST /admin/sets/{name}/values", s.checkAdminAuth(s.handleAddSetValues))
+	mux.Handle("DELETE /admin/sets/{name}/values", s.checkAdminAuth(s.handleRemoveSetValues))
+	mux.Handle("DELETE /admin/sets/{name}", s.checkAdminAuth(s.handleDeleteSet))
+}
+
+// Enforces HTTP basic auth, with username "admin" and any of the configured admin passwords
+func (s *Server) checkAdminAuth(next http.HandlerFunc) http.Handler {
+	validAuthHeaders := []string{}
+	for _, pw := range s.adminPasswords {
+		hdr := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:"+pw))
+		validAuthHeaders = append(validAuthHeaders, hdr)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := r.Header.Get("Authorization")
+		for _, val := range validAuthHeaders {
+			if hdr != "" && subtle.ConstantTimeCompare([]byte(hdr), []byte(val)) == 1 {
+				next(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Basic")
+		s.writeAdminError(w, http.StatusUnauthorized, fmt.Errorf("admin authentication required"))
+	})
+}
+
+func (s *Server) writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("failed to write admin response", "err", err)
+	}
+}
+
+func (s *Server) writeAdminError(w http.ResponseWriter, status int, err error) {
+	s.writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) handleListSets(w http.ResponseWriter, r *http.Request) {
+	sets, err := s.MutableSets.ListSets(r.Context())
+	if err != nil {
+		s.writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeAdminJSON(w, http.StatusOK, map[string]any{"sets": sets})
+}
+
+func (s *Server) writeSet(w http.ResponseWriter, r *http.Request, name string) {
+	info, vals, err := s.MutableSets.GetSet(r.Context(), name)
+	if err != nil {
+		s.writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if info == nil {
+		s.writeAdminError(w, http.StatusNotFound, fmt.Errorf("set not found: %s", name))
+		return
+	}
+	s.writeAdminJSON(w, http.StatusOK, setResponse{Set: *info, Values: vals})
+}
+
+func (s *Server) handleGetSet(w http.ResponseWriter, r *http.Request) {
+	s.writeSet(w, r, r.PathValue("name"))
+}
+
+func parseSetValues(r *http.Request) ([]string, error) {
+	var req setValuesRequest
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, adminMaxBodyBytes)).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	if len(req.Values) == 0 {
+		return nil, fmt.Errorf("request must include at least one value")
+	}
+	for _, v := range req.Values {
+		if strings.TrimSpace(v) == "" {
+			return nil, fmt.Errorf("set values must not be empty")
+		}
+	}
+	return req.Values, nil
+}
+
+func (s *Server) handleAddSetValues(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	vals, err := parseSetValues(r)
+	if err != nil {
+		s.writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.MutableSets.AddValues(r.Context(), name, vals); err != nil {
+		s.writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.logger.Info("added values to set", "set", name, "count", len(vals), "remote", r.RemoteAddr)
+	s.writeSet(w, r, name)
+}
+
+func (s *Server) handleRemoveSetValues(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	vals, err := parseSetValues(r)
+	if err != nil {
+		s.writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.MutableSets.RemoveValues(r.Context(), name, vals); err != nil {
+		s.writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.logger.Info("removed values from set", "set", name, "count", len(vals), "remote", r.RemoteAddr)
+	s.writeSet(w, r, name)
+}
+
+func (s *Server) handleDeleteSet(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := s.MutableSets.DeleteSet(r.Context(), name); err != nil {
+		s.writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.logger.Info("deleted set", "set", name, "remote", r.RemoteAddr)
+	s.writeAdminJSON(w, http.StatusOK, map[string]string{"deleted": name})
+}
@@ -11,13 +11,21 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"text/tabwriter"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/identity/redisdir"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
 	"github.com/bluesky-social/indigo/automod/capture"
 	"github.com/bluesky-social/indigo/automod/consumer"
+	"github.com/bluesky-social/indigo/automod/declarative"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/automod/replay"
+	"github.com/bluesky-social/indigo/automod/rules"
+	"github.com/bluesky-social/indigo/automod/setstore"
 
 	"github.com/carlmjohnson/versioninfo"
 	_ "github.com/joho/godotenv/autoload"
@@ -93,12 +101,24 @@ func run(args []string) error {
 			// redis://localhost:6379/0
 			EnvVars: []string{"HEPA_REDIS_URL"},
 		},
+		&cli.StringFlag{
+			Name:  "database-url",
+			Usage: "SQL database URL for durable counters and flags (instead of redis)",
+			// postgres://<user>:<pass>@localhost:5432/<db>
+			// sqlite://hepa.sqlite
+			EnvVars: []string{"HEPA_DATABASE_URL"},
+		},
 		&cli.IntFlag{
 			Name:    "plc-rate-limit",
 			Usage:   "max number of requests per second to PLC registry",
 			Value:   100,
 			EnvVars: []string{"HEPA_PLC_RATE_LIMIT"},
 		},
+		&cli.BoolFlag{
+			Name:    "dynamic-sets",
+			Usage:   "store sets in the database (or redis), editable at runtime via the admin API; sets from --sets-json-path are imported if they don't exist yet",
+			EnvVars: []string{"HEPA_DYNAMIC_SETS"},
+		},
 		&cli.StringFlag{
 			Name:    "sets-json-path",
 			Usage:   "file path of JSON file containing static sets",
@@ -119,11 +139,42 @@ func run(args []string) error {
 			Usage:   "admin auth password for abyss API",
 			EnvVars: []string{"ABYSS_PASSWORD"},
 		},
+		&cli.BoolFlag{
+			Name:    "graph",
+			Usage:   "track follow, block, like, and repost relationships (in redis, if configured) for graph-based rules",
+			EnvVars: []string{"HEPA_GRAPH"},
+		},
+		&cli.StringFlag{
+			Name:    "image-hash-file",
+			Usage:   "file path of known perceptual image hashes (one per line, like 'phash:8f373714acfcf4d0'); enables local image hash matching",
+			EnvVars: []string{"HEPA_IMAGE_HASH_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:    "image-hashes",
+			Usage:   "enable local perceptual image hash matching, with hashes added from moderator takedowns (persisted in the 'image-hashes' set when dynamic sets are enabled)",
+			EnvVars: []string{"HEPA_IMAGE_HASHES"},
+		},
+		&cli.IntFlag{
+			Name:    "image-hash-threshold",
+			Usage:   "max Hamming distance (bits, out of 64) for a perceptual image hash match",
+			Value:   8,
+			EnvVars: []string{"HEPA_IMAGE_HASH_THRESHOLD"},
+		},
+		&cli.BoolFlag{
+			Name:    "image-hash-takedown",
+			Usage:   "take down records with images matching a known hash (by default they are only flagged and reported)",
+			EnvVars: []string{"HEPA_IMAGE_HASH_TAKEDOWN"},
+		},
 		&cli.StringFlag{
 			Name:    "ruleset",
 			Usage:   "which ruleset config to use: default, no-blobs, only-blobs",
 			EnvVars: []string{"HEPA_RULESET"},
 		},
+		&cli.StringFlag{
+			Name:    "rules-path",
+			Usage:   "path of declarative (YAML) rules file, or directory of rule files, to load in addition to the ruleset",
+			EnvVars: []string{"HEPA_RULES_PATH"},
+		},
 		&cli.StringFlag{
 			Name:    "log-level",
 			Usage:   "log verbosity level (eg: warn, info, debug)",
@@ -198,6 +249,9 @@ func run(args []string) error {
 		processRecordCmd,
 		processRecentCmd,
 		captureRecentCmd,
+		validateRulesCmd,
+		shadowReportCmd,
+		replayCmd,
 	}
 
 	return app.Run(args)
@@ -264,6 +318,88 @@ var runCmd = &cli.Command{
 			Usage:   "full URL of slack webhook",
 			EnvVars: []string{"SLACK_WEBHOOK_URL"},
 		},
+		&cli.StringFlag{
+			Name:    "shadow-rules-path",
+			Usage:   "path of declarative (YAML) rules file, or directory, to run in shadow mode: effects are only logged and compared against production, never actioned",
+			EnvVars: []string{"HEPA_SHADOW_RULES_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "shadow-log-path",
+			Usage:   "file to append shadow mode results to (JSON lines); see the shadow-report command",
+			EnvVars: []string{"HEPA_SHADOW_LOG_PATH"},
+		},
+		&cli.IntFlag{
+			Name:    "rule-max-errors",
+			Usage:   "auto-disable a rule after this many consecutive errors (0 to never disable)",
+			EnvVars: []string{"HEPA_RULE_MAX_ERRORS"},
+		},
+		&cli.DurationFlag{
+			Name:    "rule-latency-budget",
+			Usage:   "auto-disable a rule whose invocations take longer than this (see --rule-max-slow; 0 for no budget)",
+			EnvVars: []string{"HEPA_RULE_LATENCY_BUDGET"},
+		},
+		&cli.IntFlag{
+			Name:    "rule-max-slow",
+			Usage:   "number of consecutive over-budget invocations before a rule is auto-disabled",
+			Value:   10,
+			EnvVars: []string{"HEPA_RULE_MAX_SLOW"},
+		},
+		&cli.DurationFlag{
+			Name:    "rule-disable-period",
+			Usage:   "how long auto-disabled rules stay disabled before being retried (0 for until restart)",
+			Value:   10 * time.Minute,
+			EnvVars: []string{"HEPA_RULE_DISABLE_PERIOD"},
+		},
+		&cli.DurationFlag{
+			Name:    "rules-reload-period",
+			Usage:   "how often to check declarative rule files for changes",
+			Value:   30 * time.Second,
+			EnvVars: []string{"HEPA_RULES_RELOAD_PERIOD"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "admin-password",
+			Usage:   "secret password for admin API endpoints (HTTP basic auth, username 'admin') on the metrics port; multiple values allowed. admin API is disabled if not set",
+			EnvVars: []string{"HEPA_ADMIN_PASSWORD"},
+		},
+		&cli.DurationFlag{
+			Name:    "sets-refresh-period",
+			Usage:   "how often to check for dynamic set changes made by other processes",
+			Value:   time.Minute,
+			EnvVars: []string{"HEPA_SETS_REFRESH_PERIOD"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-did",
+			Usage:   "DID of this labeler. if set (along with signing key and database URL), label effects are signed and published by hepa directly",
+			EnvVars: []string{"HEPA_LABELER_DID"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-signing-key",
+			Usage:   "private key (multibase) for signing labels; must match the #atproto_label key in the labeler's DID document",
+			EnvVars: []string{"HEPA_LABELER_SIGNING_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-listen",
+			Usage:   "IP or address, and port, to listen on for public labeler APIs (queryLabels and subscribeLabels)",
+			Value:   ":3990",
+			EnvVars: []string{"HEPA_LABELER_LISTEN"},
+		},
+		&cli.DurationFlag{
+			Name:    "count-hour-retention",
+			Usage:   "how long to keep hourly counter buckets in the SQL database before rolling them up to daily (0 for forever)",
+			Value:   7 * 24 * time.Hour,
+			EnvVars: []string{"HEPA_COUNT_HOUR_RETENTION"},
+		},
+		&cli.DurationFlag{
+			Name:    "count-day-retention",
+			Usage:   "how long to keep daily counter buckets in the SQL database (0 for forever)",
+			EnvVars: []string{"HEPA_COUNT_DAY_RETENTION"},
+		},
+		&cli.DurationFlag{
+			Name:    "count-rollup-period",
+			Usage:   "how often to roll up old counter buckets in the SQL database",
+			Value:   time.Hour,
+			EnvVars: []string{"HEPA_COUNT_ROLLUP_PERIOD"},
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		ctx := context.Background()
@@ -275,6 +411,14 @@ var runCmd = &cli.Command{
 			return fmt.Errorf("failed to configure identity directory: %v", err)
 		}
 
+		var labelerKey crypto.PrivateKey
+		if cctx.String("labeler-signing-key") != "" {
+			labelerKey, err = crypto.ParsePrivateMultibase(cctx.String("labeler-signing-key"))
+			if err != nil {
+				return fmt.Errorf("parsing labeler signing key: %w", err)
+			}
+		}
+
 		srv, err := NewServer(
 			dir,
 			Config{
@@ -287,12 +431,27 @@ var runCmd = &cli.Command{
 				PDSAdminToken:        cctx.String("pds-admin-token"),
 				SetsFileJSON:         cctx.String("sets-json-path"),
 				RedisURL:             cctx.String("redis-url"),
+				DatabaseURL:          cctx.String("database-url"),
+				CountHourRetention:   cctx.Duration("count-hour-retention"),
+				CountDayRetention:    cctx.Duration("count-day-retention"),
+				DynamicSets:          cctx.Bool("dynamic-sets"),
+				Graph:                cctx.Bool("graph"),
+				AdminPasswords:       cctx.StringSlice("admin-password"),
+				LabelerDID:           cctx.String("labeler-did"),
+				LabelerSigningKey:    labelerKey,
 				SlackWebhookURL:      cctx.String("slack-webhook-url"),
 				HiveAPIToken:         cctx.String("hiveai-api-token"),
 				AbyssHost:            cctx.String("abyss-host"),
 				AbyssPassword:        cctx.String("abyss-password"),
+				ImageHashes:          cctx.Bool("image-hashes"),
+				ImageHashFile:        cctx.String("image-hash-file"),
+				ImageHashThreshold:   cctx.Int("image-hash-threshold"),
+				ImageHashTakedown:    cctx.Bool("image-hash-takedown"),
 				RatelimitBypass:      cctx.String("ratelimit-bypass"),
 				RulesetName:          cctx.String("ruleset"),
+				RulesPath:            cctx.String("rules-path"),
+				ShadowRulesPath:      cctx.String("shadow-rules-path"),
+				ShadowLogPath:        cctx.String("shadow-log-path"),
 				PreScreenHost:        cctx.String("prescreen-host"),
 				PreScreenToken:       cctx.String("prescreen-token"),
 				ReportDupePeriod:     cctx.Duration("report-dupe-period"),
@@ -302,12 +461,47 @@ var runCmd = &cli.Command{
 				RecordEventTimeout:   cctx.Duration("record-event-timeout"),
 				IdentityEventTimeout: cctx.Duration("identity-event-timeout"),
 				OzoneEventTimeout:    cctx.Duration("ozone-event-timeout"),
+				RuleMaxErrors:        cctx.Int("rule-max-errors"),
+				RuleLatencyBudget:    cctx.Duration("rule-latency-budget"),
+				RuleMaxSlow:          cctx.Int("rule-max-slow"),
+				RuleDisablePeriod:    cctx.Duration("rule-disable-period"),
 			},
 		)
 		if err != nil {
 			return fmt.Errorf("failed to construct server: %v", err)
 		}
 
+		// hot-reload of declarative rules (if configured)
+		if srv.RuleLoader != nil {
+			go srv.RuleLoader.Run(ctx, cctx.Duration("rules-reload-period"))
+		}
+		if srv.ShadowRuleLoader != nil {
+			go srv.ShadowRuleLoader.Run(ctx, cctx.Duration("rules-reload-period"))
+		}
+
+		// pick up set changes from other processes (if using dynamic sets)
+		if rs, ok := srv.MutableSets.(setRefresher); ok {
+			go rs.Run(ctx, cctx.Duration("sets-refresh-period"), logger)
+		}
+		if srv.ImageHashes != nil && srv.ImageHashes.Store != nil {
+			go srv.ImageHashes.Run(ctx, cctx.Duration("sets-refresh-period"), logger)
+		}
+
+		// public labeler endpoints (if configured)
+		if srv.Labeler != nil {
+			go func() {
+				if err := srv.RunLabeler(cctx.String("labeler-listen")); err != nil {
+					slog.Error("failed to start labeler endpoint", "error", err)
+					panic(fmt.Errorf("failed to start labeler endpoint: %w", err))
+				}
+			}()
+		}
+
+		// compaction of old counter buckets (if using SQL database)
+		if srv.SQLCounters != nil {
+			go srv.SQLCounters.RunRollups(ctx, cctx.Duration("count-rollup-period"), logger)
+		}
+
 		// ozone event consumer (if configured)
 		if srv.Engine.OzoneClient != nil {
 			oc := consumer.OzoneConsumer{
@@ -379,22 +573,29 @@ func configEphemeralServer(cctx *cli.Context) (*Server, error) {
 	return NewServer(
 		dir,
 		Config{
-			Logger:          logger,
-			BskyHost:        cctx.String("atp-bsky-host"),
-			OzoneHost:       cctx.String("atp-ozone-host"),
-			OzoneDID:        cctx.String("ozone-did"),
-			OzoneAdminToken: cctx.String("ozone-admin-token"),
-			PDSHost:         cctx.String("atp-pds-host"),
-			PDSAdminToken:   cctx.String("pds-admin-token"),
-			SetsFileJSON:    cctx.String("sets-json-path"),
-			RedisURL:        cctx.String("redis-url"),
-			HiveAPIToken:    cctx.String("hiveai-api-token"),
-			AbyssHost:       cctx.String("abyss-host"),
-			AbyssPassword:   cctx.String("abyss-password"),
-			RatelimitBypass: cctx.String("ratelimit-bypass"),
-			RulesetName:     cctx.String("ruleset"),
-			PreScreenHost:   cctx.String("prescreen-host"),
-			PreScreenToken:  cctx.String("prescreen-token"),
+			Logger:             logger,
+			BskyHost:           cctx.String("atp-bsky-host"),
+			OzoneHost:          cctx.String("atp-ozone-host"),
+			OzoneDID:           cctx.String("ozone-did"),
+			OzoneAdminToken:    cctx.String("ozone-admin-token"),
+			PDSHost:            cctx.String("atp-pds-host"),
+			PDSAdminToken:      cctx.String("pds-admin-token"),
+			SetsFileJSON:       cctx.String("sets-json-path"),
+			RedisURL:           cctx.String("redis-url"),
+			DatabaseURL:        cctx.String("database-url"),
+			DynamicSets:        cctx.Bool("dynamic-sets"),
+			HiveAPIToken:       cctx.String("hiveai-api-token"),
+			AbyssHost:          cctx.String("abyss-host"),
+			AbyssPassword:      cctx.String("abyss-password"),
+			ImageHashes:        cctx.Bool("image-hashes"),
+			ImageHashFile:      cctx.String("image-hash-file"),
+			ImageHashThreshold: cctx.Int("image-hash-threshold"),
+			ImageHashTakedown:  cctx.Bool("image-hash-takedown"),
+			RatelimitBypass:    cctx.String("ratelimit-bypass"),
+			RulesetName:        cctx.String("ruleset"),
+			RulesPath:          cctx.String("rules-path"),
+			PreScreenHost:      cctx.String("prescreen-host"),
+			PreScreenToken:     cctx.String("prescreen-token"),
 		},
 	)
 }
@@ -496,3 +697,139 @@ var captureRecentCmd = &cli.Command{
 		return nil
 	},
 }
+
+var validateRulesCmd = &cli.Command{
+	Name:      "validate-rules",
+	Usage:     "check declarative rule files for errors, without running them",
+	ArgsUsage: `<path>`,
+	Action: func(cctx *cli.Context) error {
+		p := cctx.Args().First()
+		if p == "" {
+			p = cctx.String("rules-path")
+		}
+		if p == "" {
+			return fmt.Errorf("expected a rule file or directory path argument")
+		}
+		cr, err := declarative.LoadPath(p)
+		if err != nil {
+			return err
+		}
+		for _, r := range cr.Rules {
+			fmt.Printf("%s\t%s\n", r.On, r.Name)
+		}
+		fmt.Printf("OK: %d rules\n", len(cr.Rules))
+		return nil
+	},
+}
+
+var shadowReportCmd = &cli.Command{
+	Name:      "shadow-report",
+	Usage:     "summarize a shadow mode log: compare shadow and production effects per rule",
+	ArgsUsage: `<log-path>`,
+	Action: func(cctx *cli.Context) error {
+		p := cctx.Args().First()
+		if p == "" {
+			return fmt.Errorf("expected a shadow mode log file path argument")
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		report, err := engine.ReadShadowLog(f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("events with effects: %d\n\n", report.Events())
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "RULE\tPRODUCTION\tSHADOW\tMATCHED\tDIFFERED\tPRODUCTION-ONLY\tSHADOW-ONLY")
+		for _, r := range report.Rules() {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", r.Rule, r.Production, r.Shadow, r.Matched, r.Differed, r.ProductionOnly, r.ShadowOnly)
+		}
+		return tw.Flush()
+	},
+}
+
+var replayCmd = &cli.Command{
+	Name:      "replay",
+	Usage:     "process archived events (account captures, firehose dumps, repo CAR files) offline with in-memory state, and report per-rule hits",
+	ArgsUsage: `<path>...`,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "examples",
+			Usage: "number of example subjects to show per rule",
+			Value: 3,
+		},
+		&cli.BoolFlag{
+			Name:  "declarative-only",
+			Usage: "only run declarative rules (from --rules-path), not the compiled-in ruleset",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output results as JSON",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		if cctx.Args().Len() == 0 {
+			return fmt.Errorf("expected one or more archive file or directory paths")
+		}
+		logger := configLogger(cctx, os.Stderr)
+
+		var ruleset automod.RuleSet
+		if !cctx.Bool("declarative-only") {
+			ruleset = rules.DefaultRules()
+			// blobs can't be fetched offline
+			ruleset.BlobRules = nil
+		}
+		if p := cctx.String("rules-path"); p != "" {
+			rl, err := declarative.NewLoader(p, logger)
+			if err != nil {
+				return err
+			}
+			rl.RegisterRules(&ruleset)
+		}
+		sets := setstore.NewMemSetStore()
+		if p := cctx.String("sets-json-path"); p != "" {
+			if err := sets.LoadFromFileJSON(p); err != nil {
+				return err
+			}
+		}
+
+		r := replay.NewReplayer(replay.Config{
+			Logger:      logger,
+			Rules:       ruleset,
+			Sets:        &sets,
+			MaxExamples: cctx.Int("examples"),
+		})
+		for _, p := range cctx.Args().Slice() {
+			if err := r.ReplayPath(ctx, p); err != nil {
+				return err
+			}
+		}
+
+		if cctx.Bool("json") {
+			out := map[string]any{
+				"events":  r.Events,
+				"errors":  r.Errors,
+				"skipped": r.Skipped,
+				"rules":   r.Stats.Rules(),
+			}
+			b, err := json.MarshalIndent(out, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+			return nil
+		}
+
+		fmt.Printf("events: %d  errors: %d  skipped: %d\n\n", r.Events, r.Errors, r.Skipped)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "RULE\tHITS\tEXAMPLES")
+		for _, rs := range r.Stats.Rules() {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", rs.Rule, rs.Hits, strings.Join(rs.Examples, " "))
+		}
+		return tw.Flush()
+	},
+}
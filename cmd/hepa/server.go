@@ -2,34 +2,59 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod"
 	"github.com/bluesky-social/indigo/automod/cachestore"
 	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/declarative"
 	"github.com/bluesky-social/indigo/automod/engine"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+	"github.com/bluesky-social/indigo/automod/labeler"
 	"github.com/bluesky-social/indigo/automod/rules"
 	"github.com/bluesky-social/indigo/automod/setstore"
 	"github.com/bluesky-social/indigo/automod/visual"
 	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/cliutil"
 	"github.com/bluesky-social/indigo/xrpc"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
 )
 
 type Server struct {
 	Engine      *automod.Engine
 	RedisClient *redis.Client
+	// only set if declarative rules are configured
+	RuleLoader *declarative.Loader
+	// only set if shadow mode (declarative) rules are configured
+	ShadowRuleLoader *declarative.Loader
+	// only set if an SQL database is configured
+	SQLCounters *countstore.SQLCountStore
+	// only set if dynamic sets are configured
+	MutableSets setstore.MutableSetStore
+	// only set if running as a standalone labeler
+	Labeler *labeler.Labeler
+	// only set if local image hash matching is configured
+	ImageHashes *visual.ImageHashMatcher
 
-	logger *slog.Logger
+	logger         *slog.Logger
+	adminPasswords []string
+}
+
+// implemented by dynamic set stores, to periodically pick up external changes
+type setRefresher interface {
+	Run(ctx context.Context, period time.Duration, logger *slog.Logger)
 }
 
 type Config struct {
@@ -42,11 +67,26 @@ type Config struct {
 	PDSAdminToken        string
 	SetsFileJSON         string
 	RedisURL             string
+	DatabaseURL          string
+	CountHourRetention   time.Duration
+	CountDayRetention    time.Duration
+	DynamicSets          bool
+	Graph                bool
+	AdminPasswords       []string
+	LabelerDID           string
+	LabelerSigningKey    crypto.PrivateKey
 	SlackWebhookURL      string
 	HiveAPIToken         string
 	AbyssHost            string
 	AbyssPassword        string
+	ImageHashes          bool
+	ImageHashFile        string
+	ImageHashThreshold   int
+	ImageHashTakedown    bool
 	RulesetName          string
+	RulesPath            string
+	ShadowRulesPath      string
+	ShadowLogPath        string
 	RatelimitBypass      string
 	PreScreenHost        string
 	PreScreenToken       string
@@ -57,6 +97,10 @@ type Config struct {
 	RecordEventTimeout   time.Duration
 	IdentityEventTimeout time.Duration
 	OzoneEventTimeout    time.Duration
+	RuleMaxErrors        int
+	RuleLatencyBudget    time.Duration
+	RuleMaxSlow          int
+	RuleDisablePeriod    time.Duration
 }
 
 func NewServer(dir identity.Directory, config Config) (*Server, error) {
@@ -106,15 +150,6 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		logger.Info("did not configure PDS admin client")
 	}
 
-	sets := setstore.NewMemSetStore()
-	if config.SetsFileJSON != "" {
-		if err := sets.LoadFromFileJSON(config.SetsFileJSON); err != nil {
-			return nil, fmt.Errorf("initializing in-process setstore: %v", err)
-		} else {
-			logger.Info("loaded set config from JSON", "path", config.SetsFileJSON)
-		}
-	}
-
 	var counters countstore.CountStore
 	var cache cachestore.CacheStore
 	var flags flagstore.FlagStore
@@ -155,6 +190,99 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		flags = flagstore.NewMemFlagStore()
 	}
 
+	// durable counters and flags take precedence over redis (which is still used for caching and cursors)
+	var sqlCounters *countstore.SQLCountStore
+	var db *gorm.DB
+	if config.DatabaseURL != "" {
+		var err error
+		db, err = cliutil.SetupDatabase(config.DatabaseURL, 10)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to database: %v", err)
+		}
+		sqlCounters, err = countstore.NewSQLCountStore(db)
+		if err != nil {
+			return nil, fmt.Errorf("initializing SQL countstore: %v", err)
+		}
+		sqlCounters.HourRetention = config.CountHourRetention
+		sqlCounters.DayRetention = config.CountDayRetention
+		counters = sqlCounters
+
+		flg, err := flagstore.NewSQLFlagStore(db)
+		if err != nil {
+			return nil, fmt.Errorf("initializing SQL flagstore: %v", err)
+		}
+		flags = flg
+	}
+
+	var graph graphstore.GraphStore
+	if config.Graph {
+		if config.RedisURL != "" {
+			gs, err := graphstore.NewRedisGraphStore(config.RedisURL)
+			if err != nil {
+				return nil, fmt.Errorf("initializing redis graphstore: %v", err)
+			}
+			graph = gs
+		} else {
+			graph = graphstore.NewMemGraphStore()
+		}
+	}
+
+	// labels signed and published directly, instead of (or in addition to) being pushed to ozone
+	var lblr *labeler.Labeler
+	if config.LabelerDID != "" {
+		did, err := syntax.ParseDID(config.LabelerDID)
+		if err != nil {
+			return nil, fmt.Errorf("parsing labeler DID: %v", err)
+		}
+		if config.LabelerSigningKey == nil {
+			return nil, fmt.Errorf("labeler requires a signing key")
+		}
+		if db == nil {
+			return nil, fmt.Errorf("labeler requires a database URL")
+		}
+		lblr, err = labeler.NewLabeler(db, did, config.LabelerSigningKey, logger)
+		if err != nil {
+			return nil, fmt.Errorf("initializing labeler: %v", err)
+		}
+		logger.Info("configured standalone labeler", "did", did)
+	}
+
+	// sets are either static (loaded from JSON at startup), or stored in the database or redis and editable at runtime
+	var sets setstore.SetStore
+	var mutableSets setstore.MutableSetStore
+	if config.DynamicSets {
+		var err error
+		switch {
+		case db != nil:
+			mutableSets, err = setstore.NewSQLSetStore(context.TODO(), db)
+		case config.RedisURL != "":
+			mutableSets, err = setstore.NewRedisSetStore(context.TODO(), config.RedisURL)
+		default:
+			err = fmt.Errorf("dynamic sets require a database or redis URL")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("initializing dynamic setstore: %v", err)
+		}
+		if config.SetsFileJSON != "" {
+			imported, err := setstore.ImportFileJSON(context.TODO(), mutableSets, config.SetsFileJSON)
+			if err != nil {
+				return nil, fmt.Errorf("importing sets from JSON: %v", err)
+			}
+			logger.Info("imported new sets from JSON", "path", config.SetsFileJSON, "sets", imported)
+		}
+		sets = mutableSets
+	} else {
+		mem := setstore.NewMemSetStore()
+		if config.SetsFileJSON != "" {
+			if err := mem.LoadFromFileJSON(config.SetsFileJSON); err != nil {
+				return nil, fmt.Errorf("initializing in-process setstore: %v", err)
+			} else {
+				logger.Info("loaded set config from JSON", "path", config.SetsFileJSON)
+			}
+		}
+		sets = mem
+	}
+
 	// IMPORTANT: reminder that these are the indigo-edition rules, not production rules
 	extraBlobRules := []automod.BlobRuleFunc{}
 	if config.HiveAPIToken != "" && config.RulesetName != "no-hive" {
@@ -174,6 +302,27 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		extraBlobRules = append(extraBlobRules, ac.AbyssScanBlobRule)
 	}
 
+	var imageHashes *visual.ImageHashMatcher
+	if config.ImageHashes || config.ImageHashFile != "" {
+		logger.Info("configuring local image hash matching")
+		imageHashes = visual.NewImageHashMatcher(config.ImageHashThreshold)
+		imageHashes.Takedown = config.ImageHashTakedown
+		if config.ImageHashFile != "" {
+			if err := imageHashes.LoadHashFile(config.ImageHashFile); err != nil {
+				return nil, fmt.Errorf("loading image hash file: %v", err)
+			}
+		}
+		if mutableSets != nil {
+			imageHashes.Store = mutableSets
+			imageHashes.SetName = "image-hashes"
+			if err := imageHashes.Refresh(context.TODO()); err != nil {
+				return nil, fmt.Errorf("loading image hashes from set: %v", err)
+			}
+		}
+		logger.Info("loaded known image hashes", "count", imageHashes.Size())
+		extraBlobRules = append(extraBlobRules, imageHashes.ImageHashBlobRule)
+	}
+
 	var ruleset automod.RuleSet
 	switch config.RulesetName {
 	case "", "default", "no-hive":
@@ -188,6 +337,43 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		return nil, fmt.Errorf("unknown ruleset config: %s", config.RulesetName)
 	}
 
+	if imageHashes != nil {
+		ruleset.AddOzoneEventRule("image-hashes-from-takedowns", imageHashes.ImageHashTakedownRule)
+	}
+
+	var ruleLoader *declarative.Loader
+	if config.RulesPath != "" {
+		logger.Info("loading declarative rules", "path", config.RulesPath)
+		rl, err := declarative.NewLoader(config.RulesPath, logger)
+		if err != nil {
+			return nil, fmt.Errorf("loading declarative rules: %w", err)
+		}
+		rl.RegisterRules(&ruleset)
+		ruleLoader = rl
+	}
+
+	// shadow mode: rules which are evaluated against live events, with effects only recorded to a log
+	var shadowRules *automod.RuleSet
+	var shadowLoader *declarative.Loader
+	var shadowRecorder engine.ShadowRecorder
+	if config.ShadowRulesPath != "" {
+		logger.Info("loading shadow mode declarative rules", "path", config.ShadowRulesPath)
+		sl, err := declarative.NewLoader(config.ShadowRulesPath, logger.With("shadow", true))
+		if err != nil {
+			return nil, fmt.Errorf("loading shadow mode rules: %w", err)
+		}
+		shadowRules = &automod.RuleSet{}
+		sl.RegisterRules(shadowRules)
+		shadowLoader = sl
+		if config.ShadowLogPath != "" {
+			f, err := os.OpenFile(config.ShadowLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return nil, fmt.Errorf("opening shadow mode log: %w", err)
+			}
+			shadowRecorder = engine.NewShadowLogWriter(f)
+		}
+	}
+
 	var notifier automod.Notifier
 	if config.SlackWebhookURL != "" {
 		notifier = &automod.SlackNotifier{
@@ -204,19 +390,33 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		bskyClient.Headers["x-ratelimit-bypass"] = config.RatelimitBypass
 	}
 	blobClient := util.RobustHTTPClient()
+
+	var ruleGuard *engine.RuleGuard
+	if config.RuleMaxErrors > 0 || config.RuleLatencyBudget > 0 {
+		ruleGuard = engine.NewRuleGuard(engine.RuleGuardConfig{
+			MaxConsecutiveErrors: config.RuleMaxErrors,
+			LatencyBudget:        config.RuleLatencyBudget,
+			MaxConsecutiveSlow:   config.RuleMaxSlow,
+			DisablePeriod:        config.RuleDisablePeriod,
+		}, logger)
+	}
 	eng := automod.Engine{
-		Logger:      logger,
-		Directory:   dir,
-		Counters:    counters,
-		Sets:        sets,
-		Flags:       flags,
-		Cache:       cache,
-		Rules:       ruleset,
-		Notifier:    notifier,
-		BskyClient:  &bskyClient,
-		OzoneClient: ozoneClient,
-		AdminClient: adminClient,
-		BlobClient:  blobClient,
+		Logger:         logger,
+		Directory:      dir,
+		Counters:       counters,
+		Sets:           sets,
+		Flags:          flags,
+		Graph:          graph,
+		Cache:          cache,
+		Rules:          ruleset,
+		Notifier:       notifier,
+		BskyClient:     &bskyClient,
+		OzoneClient:    ozoneClient,
+		AdminClient:    adminClient,
+		BlobClient:     blobClient,
+		ShadowRules:    shadowRules,
+		ShadowRecorder: shadowRecorder,
+		RuleGuard:      ruleGuard,
 		Config: engine.EngineConfig{
 			ReportDupePeriod:     config.ReportDupePeriod,
 			QuotaModReportDay:    config.QuotaModReportDay,
@@ -227,11 +427,21 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 			OzoneEventTimeout:    config.OzoneEventTimeout,
 		},
 	}
+	if lblr != nil {
+		eng.LabelEmitter = lblr
+	}
 
 	s := &Server{
-		logger:      logger,
-		Engine:      &eng,
-		RedisClient: rdb,
+		logger:           logger,
+		Engine:           &eng,
+		RedisClient:      rdb,
+		RuleLoader:       ruleLoader,
+		ShadowRuleLoader: shadowLoader,
+		SQLCounters:      sqlCounters,
+		Labeler:          lblr,
+		ImageHashes:      imageHashes,
+		MutableSets:      mutableSets,
+		adminPasswords:   config.AdminPasswords,
 	}
 
 	return s, nil
@@ -239,5 +449,30 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 
 func (s *Server) RunMetrics(listen string) error {
 	http.Handle("/metrics", promhttp.Handler())
+	if s.Engine.RuleGuard != nil {
+		http.HandleFunc("/rules/disabled", s.handleDisabledRules)
+	}
+	if s.MutableSets != nil && len(s.adminPasswords) > 0 {
+		s.registerAdminHandlers(http.DefaultServeMux)
+	}
 	return http.ListenAndServe(listen, nil)
 }
+
+// serves the public labeler endpoints (com.atproto.label.*)
+func (s *Server) RunLabeler(listen string) error {
+	mux := http.NewServeMux()
+	s.Labeler.RegisterHandlers(mux)
+	return http.ListenAndServe(listen, mux)
+}
+
+// lists rules which have been auto-disabled
+func (s *Server) handleDisabledRules(w http.ResponseWriter, r *http.Request) {
+	disabled := s.Engine.RuleGuard.Disabled()
+	if disabled == nil {
+		disabled = []engine.DisabledRule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(disabled); err != nil {
+		s.logger.Error("failed to write disabled rules response", "err", err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,8 +12,10 @@ import (
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/cmd/relay/relay"
 	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/repostore"
 	"github.com/bluesky-social/indigo/xrpc"
 
+	"github.com/ipfs/go-cid"
 	"github.com/labstack/echo/v4"
 )
 
@@ -198,7 +201,9 @@ func (s *Service) handleComAtprotoSyncGetRepoStatus(c echo.Context, did syntax.D
 		return nil, err
 	}
 
-	out.Rev = &repo.Rev
+	if repo != nil {
+		out.Rev = &repo.Rev
+	}
 
 	return out, nil
 }
@@ -215,17 +220,8 @@ func (s *Service) handleComAtprotoSyncGetLatestCommit(c echo.Context, did syntax
 		return nil, c.JSON(http.StatusInternalServerError, xrpc.XRPCError{ErrStr: "DatabaseError", Message: "looking up account information"})
 	}
 
-	switch acc.AccountStatus() {
-	case models.AccountStatusTakendown, models.AccountStatusSuspended:
-		return nil, c.JSON(http.StatusForbidden, xrpc.XRPCError{ErrStr: "RepoTakendown", Message: "account not active (takendown)"})
-	case models.AccountStatusDeactivated:
-		return nil, c.JSON(http.StatusForbidden, xrpc.XRPCError{ErrStr: "RepoDeactivated", Message: "account not active (deactivated)"})
-	case models.AccountStatusDeleted:
-		return nil, c.JSON(http.StatusForbidden, xrpc.XRPCError{ErrStr: "RepoDeleted", Message: "account not active (deleted)"})
-	case models.AccountStatusActive:
-		// pass
-	default:
-		return nil, c.JSON(http.StatusForbidden, xrpc.XRPCError{ErrStr: "RepoInactive", Message: fmt.Sprintf("account not active: %s", acc.AccountStatus())})
+	if xerr := accountInactiveError(acc); xerr != nil {
+		return nil, c.JSON(http.StatusForbidden, xerr)
 	}
 
 	repo, err := s.relay.GetAccountRepo(ctx, acc.UID)
@@ -242,6 +238,101 @@ func (s *Service) handleComAtprotoSyncGetLatestCommit(c echo.Context, did syntax
 	}, nil
 }
 
+// returns an error response body if the account is not active, or nil if active
+func accountInactiveError(acc *models.Account) *xrpc.XRPCError {
+	switch acc.AccountStatus() {
+	case models.AccountStatusTakendown, models.AccountStatusSuspended:
+		return &xrpc.XRPCError{ErrStr: "RepoTakendown", Message: "account not active (takendown)"}
+	case models.AccountStatusDeactivated:
+		return &xrpc.XRPCError{ErrStr: "RepoDeactivated", Message: "account not active (deactivated)"}
+	case models.AccountStatusDeleted:
+		return &xrpc.XRPCError{ErrStr: "RepoDeleted", Message: "account not active (deleted)"}
+	case models.AccountStatusActive:
+		return nil
+	default:
+		return &xrpc.XRPCError{ErrStr: "RepoInactive", Message: fmt.Sprintf("account not active: %s", acc.AccountStatus())}
+	}
+}
+
+// looks up account for serving from the local repo store. if the response has already been handled (eg, error or redirect), returns nil account
+func (s *Service) snapshotAccount(c echo.Context, did syntax.DID) (*models.Account, error) {
+	ctx := c.Request().Context()
+
+	acc, err := s.relay.GetAccount(ctx, did)
+	if err != nil {
+		if errors.Is(err, relay.ErrAccountNotFound) {
+			// the PDS might know about the account, even if we don't
+			return nil, s.redirectToPDS(c, did)
+		}
+		return nil, c.JSON(http.StatusInternalServerError, xrpc.XRPCError{ErrStr: "DatabaseError", Message: "looking up account information"})
+	}
+
+	if xerr := accountInactiveError(acc); xerr != nil {
+		return nil, c.JSON(http.StatusForbidden, xerr)
+	}
+	return acc, nil
+}
+
+func (s *Service) handleComAtprotoSyncGetRepo(c echo.Context, did syntax.DID) error {
+	ctx := c.Request().Context()
+
+	acc, err := s.snapshotAccount(c, did)
+	if acc == nil {
+		return err
+	}
+
+	// NOTE: snapshots are never fetched from the PDS on behalf of public requests (that would let anybody trigger large upstream fetches); admins can populate them with /admin/repo/fetchSnapshot
+	if !s.relay.RepoStore.HasSnapshot(acc.UID) {
+		return s.redirectToPDS(c, did)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/vnd.ipld.car")
+	c.Response().WriteHeader(http.StatusOK)
+	return s.relay.RepoStore.WriteRepoCAR(ctx, acc.UID, c.Response())
+}
+
+func (s *Service) handleComAtprotoSyncGetRecord(c echo.Context, did syntax.DID, collection syntax.NSID, rkey syntax.RecordKey) error {
+	ctx := c.Request().Context()
+
+	acc, err := s.snapshotAccount(c, did)
+	if acc == nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := s.relay.RepoStore.WriteRecordCAR(ctx, acc.UID, collection, rkey, &buf); err != nil {
+		if errors.Is(err, repostore.ErrSnapshotNotFound) {
+			return s.redirectToPDS(c, did)
+		}
+		if errors.Is(err, repostore.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, xrpc.XRPCError{ErrStr: "RecordNotFound", Message: "record not found in repository"})
+		}
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/vnd.ipld.car", buf.Bytes())
+}
+
+func (s *Service) handleComAtprotoSyncGetBlocks(c echo.Context, did syntax.DID, cids []cid.Cid) error {
+	ctx := c.Request().Context()
+
+	acc, err := s.snapshotAccount(c, did)
+	if acc == nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := s.relay.RepoStore.WriteBlocksCAR(ctx, acc.UID, cids, &buf); err != nil {
+		if errors.Is(err, repostore.ErrSnapshotNotFound) {
+			return s.redirectToPDS(c, did)
+		}
+		if errors.Is(err, repostore.ErrBlockNotFound) {
+			return c.JSON(http.StatusNotFound, xrpc.XRPCError{ErrStr: "BlockNotFound", Message: err.Error()})
+		}
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/vnd.ipld.car", buf.Bytes())
+}
+
 type HealthStatus struct {
 	Status  string `json:"status"`
 	Message string `json:"msg,omitempty"`
@@ -191,6 +191,264 @@ func (s *Service) handleAdminListRepoTakeDowns(c echo.Context) error {
 	return c.JSON(http.StatusOK, out)
 }
 
+type ListQuarantinesResponse struct {
+	Accounts []*models.AccountQuarantine `json:"accounts"`
+	Cursor   int64                       `json:"cursor,omitempty"`
+}
+
+func (s *Service) handleAdminListQuarantines(c echo.Context) error {
+	ctx := c.Request().Context()
+	var err error
+
+	limit := 500
+	cursor := int64(0)
+	cursorQuery := c.QueryParam("cursor")
+	if cursorQuery != "" {
+		cursor, err = strconv.ParseInt(cursorQuery, 10, 64)
+		if err != nil {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid cursor param"}
+		}
+	}
+
+	rows, err := s.relay.ListAccountQuarantines(ctx, cursor, limit)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "failed to list quarantined accounts"}
+	}
+
+	out := ListQuarantinesResponse{
+		Accounts: rows,
+	}
+	if len(rows) >= limit {
+		out.Cursor = int64(rows[len(rows)-1].UID)
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+type quarantineInfo struct {
+	Account    *models.Account             `json:"account"`
+	Hostname   string                      `json:"hostname,omitempty"`
+	Quarantine *models.AccountQuarantine   `json:"quarantine,omitempty"`
+	Limiter    *relay.AccountLimiterStatus `json:"limiter,omitempty"`
+}
+
+func (s *Service) handleAdminGetQuarantine(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	did, err := syntax.ParseDID(c.QueryParam("did"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "must pass a valid DID"}
+	}
+
+	acc, err := s.relay.GetAccount(ctx, relay.NormalizeDID(did))
+	if err != nil {
+		if errors.Is(err, relay.ErrAccountNotFound) {
+			return &echo.HTTPError{Code: http.StatusNotFound, Message: "account not found"}
+		}
+		return err
+	}
+
+	out := quarantineInfo{
+		Account: acc,
+		Limiter: s.relay.AccountLimiter.Status(acc.DID),
+	}
+	if host, err := s.relay.GetHostByID(ctx, acc.HostID); err == nil {
+		out.Hostname = host.Hostname
+	}
+	q, err := s.relay.GetAccountQuarantine(ctx, acc.UID)
+	if err != nil && !errors.Is(err, relay.ErrAccountNotQuarantined) {
+		return err
+	}
+	out.Quarantine = q
+	return c.JSON(http.StatusOK, out)
+}
+
+func (s *Service) handleAdminReleaseQuarantine(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var body map[string]string
+	if err := c.Bind(&body); err != nil {
+		return err
+	}
+	did, err := syntax.ParseDID(body["did"])
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    http.StatusBadRequest,
+			Message: "must specify valid DID parameter in body",
+		}
+	}
+
+	if err := s.relay.ReleaseAccountQuarantine(ctx, relay.NormalizeDID(did)); err != nil {
+		if errors.Is(err, relay.ErrAccountNotFound) {
+			return &echo.HTTPError{
+				Code:    http.StatusNotFound,
+				Message: "account not found",
+			}
+		}
+		if errors.Is(err, relay.ErrAccountNotQuarantined) {
+			return &echo.HTTPError{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			}
+		}
+		return &echo.HTTPError{
+			Code:    http.StatusInternalServerError,
+			Message: err.Error(),
+		}
+	}
+
+	// forward on to any sibling instances
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	go s.ForwardSiblingRequest(c, b)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"success": "true",
+	})
+}
+
+type accountInspection struct {
+	Account *models.Account     `json:"account"`
+	Repo    *models.AccountRepo `json:"repo,omitempty"`
+	Host    *models.Host        `json:"host,omitempty"`
+
+	// status as reported publicly (eg, in getRepoStatus), combining local and upstream status
+	Active bool    `json:"active"`
+	Status *string `json:"status,omitempty"`
+
+	Events     *relay.AccountEventSummary  `json:"events,omitempty"`
+	Limiter    *relay.AccountLimiterStatus `json:"limiter,omitempty"`
+	Quarantine *models.AccountQuarantine   `json:"quarantine,omitempty"`
+	Resync     *models.AccountResync       `json:"resync,omitempty"`
+}
+
+func (s *Service) handleAdminInspectRepo(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	did, err := syntax.ParseDID(c.QueryParam("did"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "must pass a valid DID"}
+	}
+
+	acc, err := s.relay.GetAccount(ctx, relay.NormalizeDID(did))
+	if err != nil {
+		if errors.Is(err, relay.ErrAccountNotFound) {
+			return &echo.HTTPError{Code: http.StatusNotFound, Message: "account not found"}
+		}
+		return err
+	}
+
+	out := accountInspection{
+		Account: acc,
+		Active:  acc.IsActive(),
+		Status:  acc.StatusField(),
+		Events:  s.relay.AccountEvents.Summary(acc.DID),
+		Limiter: s.relay.AccountLimiter.Status(acc.DID),
+	}
+
+	out.Repo, err = s.relay.GetAccountRepo(ctx, acc.UID)
+	if err != nil && !errors.Is(err, relay.ErrAccountRepoNotFound) {
+		return err
+	}
+	out.Host, err = s.relay.GetHostByID(ctx, acc.HostID)
+	if err != nil && !errors.Is(err, relay.ErrHostNotFound) {
+		return err
+	}
+	out.Quarantine, err = s.relay.GetAccountQuarantine(ctx, acc.UID)
+	if err != nil && !errors.Is(err, relay.ErrAccountNotQuarantined) {
+		return err
+	}
+	out.Resync, err = s.relay.GetAccountResync(ctx, acc.UID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, out)
+}
+
+func (s *Service) handleAdminFetchRepoSnapshot(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if s.relay.RepoStore == nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "repo store not enabled"}
+	}
+
+	var body map[string]string
+	if err := c.Bind(&body); err != nil {
+		return err
+	}
+	did, err := syntax.ParseDID(body["did"])
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    http.StatusBadRequest,
+			Message: "must specify valid DID parameter in body",
+		}
+	}
+
+	acc, err := s.relay.GetAccount(ctx, relay.NormalizeDID(did))
+	if err != nil {
+		if errors.Is(err, relay.ErrAccountNotFound) {
+			return &echo.HTTPError{Code: http.StatusNotFound, Message: "account not found"}
+		}
+		return err
+	}
+	if !acc.IsActive() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "account is not active"}
+	}
+
+	if err := s.relay.FetchRepoSnapshot(ctx, acc); err != nil {
+		return &echo.HTTPError{
+			Code:    http.StatusBadGateway,
+			Message: err.Error(),
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"success": "true",
+	})
+}
+
+func (s *Service) handleAdminRequestResync(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if s.relay.Config.ResyncWorkers <= 0 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "repo resync not enabled"}
+	}
+
+	var body map[string]string
+	if err := c.Bind(&body); err != nil {
+		return err
+	}
+	did, err := syntax.ParseDID(body["did"])
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    http.StatusBadRequest,
+			Message: "must specify valid DID parameter in body",
+		}
+	}
+
+	acc, err := s.relay.GetAccount(ctx, relay.NormalizeDID(did))
+	if err != nil {
+		if errors.Is(err, relay.ErrAccountNotFound) {
+			return &echo.HTTPError{Code: http.StatusNotFound, Message: "account not found"}
+		}
+		return err
+	}
+	if acc.Status != models.AccountStatusActive && acc.Status != models.AccountStatusDesynchronized {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "account is not active or desynchronized"}
+	}
+
+	// this also restarts a parked resync request
+	if err := s.relay.RequestResync(ctx, acc, "requested by admin"); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"success": "true",
+	})
+}
+
 func (s *Service) handleAdminGetUpstreamConns(c echo.Context) error {
 	return c.JSON(http.StatusOK, s.relay.Slurper.GetActiveSubHostnames())
 }
@@ -212,6 +470,7 @@ type hostInfo struct {
 	CrawlRateLimit float64
 	RepoCount      int64
 	RepoLimit      int64
+	Relay          bool
 
 	HasActiveConnection    bool      `json:"HasActiveConnection"`
 	EventsSeenSinceStartup uint64    `json:"EventsSeenSinceStartup"`
@@ -249,6 +508,7 @@ func (s *Service) handleListHosts(c echo.Context) error {
 			Blocked:    host.Status == models.HostStatusBanned,
 			RepoCount:  host.AccountCount,
 			RepoLimit:  host.AccountLimit,
+			Relay:      host.Relay,
 
 			HasActiveConnection: isActive,
 			UserCount:           host.AccountCount,
@@ -513,3 +773,27 @@ func (s *Service) handleAdminChangeHostRateLimits(c echo.Context) error {
 		"success": "true",
 	})
 }
+
+func (s *Service) handleAdminExportInventory(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	format, err := relay.ParseInventoryFormat(c.QueryParam("format"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	resp := c.Response()
+	switch format {
+	case relay.InventoryFormatCSV:
+		resp.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	default:
+		resp.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	}
+	resp.WriteHeader(http.StatusOK)
+
+	// the response has already started, so errors can only be logged. a missing signature line indicates the export is incomplete
+	if err := s.relay.ExportInventory(ctx, resp, format, s.config.InventorySigningKey); err != nil {
+		s.logger.Error("inventory export failed", "err", err)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -16,14 +17,20 @@ import (
 	_ "go.uber.org/automaxprocs"
 	_ "net/http/pprof"
 
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/cmd/relay/relay"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/repostore"
 	"github.com/bluesky-social/indigo/cmd/relay/stream/eventmgr"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/persist"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/persist/archive"
 	"github.com/bluesky-social/indigo/cmd/relay/stream/persist/diskpersist"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/persist/pebblepersist"
 	"github.com/bluesky-social/indigo/util/cliutil"
 
 	"github.com/carlmjohnson/versioninfo"
 	"github.com/urfave/cli/v2"
+	"gorm.io/gorm"
 	"gorm.io/plugin/opentelemetry/tracing"
 )
 
@@ -47,6 +54,11 @@ func run(args []string) error {
 			Usage:   "secret password/token for accessing admin endpoints (multiple values allowed)",
 			EnvVars: []string{"RELAY_ADMIN_PASSWORD", "RELAY_ADMIN_KEY"},
 		},
+		&cli.StringFlag{
+			Name:    "inventory-signing-key",
+			Usage:   "private key (multibase) used to sign host and account inventory exports",
+			EnvVars: []string{"RELAY_INVENTORY_SIGNING_KEY"},
+		},
 		&cli.StringFlag{
 			Name:    "plc-host",
 			Usage:   "method, hostname, and port of PLC registry",
@@ -95,6 +107,63 @@ func run(args []string) error {
 					EnvVars: []string{"RELAY_REPLAY_WINDOW", "RELAY_EVENT_PLAYBACK_TTL"},
 					Value:   72 * time.Hour,
 				},
+				&cli.StringFlag{
+					Name:    "persist-backend",
+					Usage:   "storage backend for firehose playback: 'disk' (log files) or 'pebble'",
+					Value:   "disk",
+					EnvVars: []string{"RELAY_PERSIST_BACKEND"},
+				},
+				&cli.StringFlag{
+					Name:    "archive-s3-endpoint",
+					Usage:   "S3-compatible endpoint URL for archiving playback log files after the replay window (disk backend only)",
+					EnvVars: []string{"RELAY_ARCHIVE_S3_ENDPOINT"},
+				},
+				&cli.StringFlag{
+					Name:    "archive-s3-bucket",
+					Usage:   "bucket for archived playback log files",
+					EnvVars: []string{"RELAY_ARCHIVE_S3_BUCKET"},
+				},
+				&cli.StringFlag{
+					Name:    "archive-s3-region",
+					Value:   "us-east-1",
+					EnvVars: []string{"RELAY_ARCHIVE_S3_REGION"},
+				},
+				&cli.StringFlag{
+					Name:    "archive-s3-prefix",
+					Usage:   "object key prefix for archived playback log files",
+					EnvVars: []string{"RELAY_ARCHIVE_S3_PREFIX"},
+				},
+				&cli.StringFlag{
+					Name:    "archive-s3-access-key-id",
+					EnvVars: []string{"RELAY_ARCHIVE_S3_ACCESS_KEY_ID"},
+				},
+				&cli.StringFlag{
+					Name:    "archive-s3-secret-access-key",
+					EnvVars: []string{"RELAY_ARCHIVE_S3_SECRET_ACCESS_KEY"},
+				},
+				&cli.DurationFlag{
+					Name:    "archive-retention",
+					Usage:   "total firehose playback history to keep when archiving is enabled, including the replay window (0 to keep forever)",
+					EnvVars: []string{"RELAY_ARCHIVE_RETENTION"},
+					Value:   30 * 24 * time.Hour,
+				},
+				&cli.StringFlag{
+					Name:    "repo-store-dir",
+					Usage:   "local folder to store full repo snapshots, for serving getRepo directly (disabled if not set)",
+					EnvVars: []string{"RELAY_REPO_STORE_DIR"},
+				},
+				&cli.Int64Flag{
+					Name:    "repo-store-max-bytes",
+					Usage:   "storage budget for repo snapshots; least-recently-accessed are evicted when exceeded",
+					Value:   100 * 1024 * 1024 * 1024,
+					EnvVars: []string{"RELAY_REPO_STORE_MAX_BYTES"},
+				},
+				&cli.Int64Flag{
+					Name:    "repo-store-max-repo-bytes",
+					Usage:   "maximum size of a single repo which will be snapshotted",
+					Value:   512 * 1024 * 1024,
+					EnvVars: []string{"RELAY_REPO_STORE_MAX_REPO_BYTES"},
+				},
 				&cli.IntFlag{
 					Name:    "host-concurrency",
 					Usage:   "number of concurrent worker routines per upstream host",
@@ -107,6 +176,42 @@ func run(args []string) error {
 					Usage:   "max number of active accounts for new upstream hosts",
 					EnvVars: []string{"RELAY_DEFAULT_ACCOUNT_LIMIT", "RELAY_DEFAULT_REPO_LIMIT"},
 				},
+				&cli.Int64Flag{
+					Name:    "account-per-minute-limit",
+					Value:   0,
+					Usage:   "max #commit events per minute for a single account; excess events are dropped (0 to disable)",
+					EnvVars: []string{"RELAY_ACCOUNT_PER_MINUTE_LIMIT"},
+				},
+				&cli.Int64Flag{
+					Name:    "account-per-hour-limit",
+					Value:   0,
+					Usage:   "max #commit events per hour for a single account; excess events are dropped (0 to disable)",
+					EnvVars: []string{"RELAY_ACCOUNT_PER_HOUR_LIMIT"},
+				},
+				&cli.Int64Flag{
+					Name:    "account-quarantine-threshold",
+					Value:   0,
+					Usage:   "number of rate-limited events within an hour after which an account is quarantined (0 to disable)",
+					EnvVars: []string{"RELAY_ACCOUNT_QUARANTINE_THRESHOLD"},
+				},
+				&cli.IntFlag{
+					Name:    "resync-workers",
+					Value:   4,
+					Usage:   "number of concurrent full-repo resyncs when an account's commit chain breaks (0 to disable)",
+					EnvVars: []string{"RELAY_RESYNC_WORKERS"},
+				},
+				&cli.IntFlag{
+					Name:    "resync-max-attempts",
+					Value:   10,
+					Usage:   "number of failed resync attempts before an account is left desynchronized until an admin requests resync again (0 to retry forever)",
+					EnvVars: []string{"RELAY_RESYNC_MAX_ATTEMPTS"},
+				},
+				&cli.StringFlag{
+					Name:    "slow-consumer-policy",
+					Value:   "disconnect",
+					Usage:   "default handling of firehose consumers which fall behind: disconnect, drop (skip events and send an info frame), or spill (catch up from persisted events)",
+					EnvVars: []string{"RELAY_SLOW_CONSUMER_POLICY"},
+				},
 				&cli.IntFlag{
 					Name:    "new-hosts-per-day-limit",
 					Value:   50,
@@ -145,6 +250,11 @@ func run(args []string) error {
 					Usage:   "servers (eg https://example.com) to forward admin state changes to; multiple allowed",
 					EnvVars: []string{"RELAY_SIBLING_RELAYS"},
 				},
+				&cli.StringSliceFlag{
+					Name:    "upstream-relays",
+					Usage:   "trusted upstream relays (eg https://relay.example.com) to subscribe to, in addition to PDS hosts; multiple allowed",
+					EnvVars: []string{"RELAY_UPSTREAM_RELAYS"},
+				},
 				&cli.StringSliceFlag{
 					Name:    "trusted-domains",
 					Usage:   "domain names which mark trusted hosts; use wildcard prefix to match suffixes",
@@ -236,13 +346,9 @@ func runRelay(cctx *cli.Context) error {
 	if err := os.MkdirAll(persistDir, os.ModePerm); err != nil {
 		return err
 	}
-	persitConfig := diskpersist.DefaultDiskPersistOptions()
-	persitConfig.Retention = cctx.Duration("replay-window")
-	persitConfig.InitialSeq = cctx.Int64("initial-seq-number")
-	logger.Info("setting up disk persister", "dir", persistDir, "replayWindow", persitConfig.Retention)
-	persister, err := diskpersist.NewDiskPersistence(persistDir, "", db, persitConfig)
+	persister, setUidSource, err := setupPersister(cctx, persistDir, db, logger)
 	if err != nil {
-		return fmt.Errorf("setting up disk persister: %w", err)
+		return err
 	}
 
 	relayConfig := relay.DefaultRelayConfig()
@@ -252,6 +358,16 @@ func runRelay(cctx *cli.Context) error {
 	relayConfig.HostPerDayLimit = cctx.Int64("new-hosts-per-day-limit")
 	relayConfig.TrustedDomains = cctx.StringSlice("trusted-domains")
 	relayConfig.LenientSyncValidation = cctx.Bool("lenient-sync-validation")
+	relayConfig.AccountPerMinuteLimit = cctx.Int64("account-per-minute-limit")
+	relayConfig.AccountPerHourLimit = cctx.Int64("account-per-hour-limit")
+	relayConfig.AccountQuarantineThreshold = cctx.Int64("account-quarantine-threshold")
+	relayConfig.ResyncWorkers = cctx.Int("resync-workers")
+	relayConfig.ResyncMaxAttempts = cctx.Int("resync-max-attempts")
+	slowPolicy, err := eventmgr.ParseSlowConsumerPolicy(cctx.String("slow-consumer-policy"))
+	if err != nil {
+		return err
+	}
+	relayConfig.SlowConsumerPolicy = slowPolicy
 
 	svcConfig := DefaultServiceConfig()
 	svcConfig.AllowInsecureHosts = cctx.Bool("allow-insecure-hosts")
@@ -260,6 +376,13 @@ func runRelay(cctx *cli.Context) error {
 	if len(svcConfig.SiblingRelayHosts) > 0 {
 		logger.Info("sibling relay hosts configured for admin state forwarding", "servers", svcConfig.SiblingRelayHosts)
 	}
+	if cctx.String("inventory-signing-key") != "" {
+		key, err := crypto.ParsePrivateMultibase(cctx.String("inventory-signing-key"))
+		if err != nil {
+			return fmt.Errorf("parsing inventory signing key: %w", err)
+		}
+		svcConfig.InventorySigningKey = key
+	}
 	if cctx.IsSet("admin-password") {
 		svcConfig.AdminPasswords = cctx.StringSlice("admin-password")
 	} else {
@@ -277,11 +400,27 @@ func runRelay(cctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if cctx.String("repo-store-dir") != "" {
+		rsConfig := repostore.DefaultRepoStoreConfig()
+		rsConfig.Dir = cctx.String("repo-store-dir")
+		rsConfig.MaxBytes = cctx.Int64("repo-store-max-bytes")
+		rsConfig.MaxRepoBytes = cctx.Int64("repo-store-max-repo-bytes")
+		if err := os.MkdirAll(rsConfig.Dir, os.ModePerm); err != nil {
+			return err
+		}
+		logger.Info("setting up repo store", "dir", rsConfig.Dir, "maxBytes", rsConfig.MaxBytes)
+		rs, err := repostore.NewRepoStore(db, rsConfig)
+		if err != nil {
+			return fmt.Errorf("setting up repo store: %w", err)
+		}
+		r.RepoStore = rs
+	}
+
 	svc, err := NewService(r, svcConfig)
 	if err != nil {
 		return err
 	}
-	persister.SetUidSource(r)
+	setUidSource(r)
 
 	// start metrics endpoint
 	go func() {
@@ -301,10 +440,18 @@ func runRelay(cctx *cli.Context) error {
 		}
 	}
 
+	r.StartResyncWorkers(ctx)
+
 	// restart any existing subscriptions as worker goroutines
 	if err := r.ResubscribeAllHosts(ctx); err != nil {
 		return err
 	}
+	for _, raw := range cctx.StringSlice("upstream-relays") {
+		logger.Info("configuring upstream relay", "url", raw)
+		if err := r.AddUpstreamRelay(ctx, raw); err != nil {
+			return fmt.Errorf("configuring upstream relay: %w", err)
+		}
+	}
 
 	svcErr := make(chan error, 1)
 	go func() {
@@ -335,3 +482,51 @@ func runRelay(cctx *cli.Context) error {
 
 	return nil
 }
+
+// Configures the firehose playback backend. Returns the persister, and a function to register the UID source (which isn't available until the relay is created).
+func setupPersister(cctx *cli.Context, persistDir string, db *gorm.DB, logger *slog.Logger) (persist.EventPersistence, func(*relay.Relay), error) {
+	switch cctx.String("persist-backend") {
+	case "pebble":
+		if cctx.String("archive-s3-endpoint") != "" {
+			return nil, nil, fmt.Errorf("playback archiving is only supported with the 'disk' persist backend")
+		}
+		opts := pebblepersist.DefaultPebblePersistOptions()
+		opts.DbPath = filepath.Join(persistDir, "pebble")
+		opts.Retention = cctx.Duration("replay-window")
+		opts.InitialSeq = cctx.Int64("initial-seq-number")
+		logger.Info("setting up pebble persister", "dir", opts.DbPath, "replayWindow", opts.Retention)
+		pp, err := pebblepersist.NewPebblePersistence(opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("setting up pebble persister: %w", err)
+		}
+		return pp, func(r *relay.Relay) { pp.SetUidSource(r) }, nil
+	case "disk":
+		opts := diskpersist.DefaultDiskPersistOptions()
+		opts.Retention = cctx.Duration("replay-window")
+		opts.InitialSeq = cctx.Int64("initial-seq-number")
+		if endpoint := cctx.String("archive-s3-endpoint"); endpoint != "" {
+			arc, err := archive.NewS3Archive(archive.S3Config{
+				Endpoint:        endpoint,
+				Region:          cctx.String("archive-s3-region"),
+				Bucket:          cctx.String("archive-s3-bucket"),
+				Prefix:          cctx.String("archive-s3-prefix"),
+				AccessKeyID:     cctx.String("archive-s3-access-key-id"),
+				SecretAccessKey: cctx.String("archive-s3-secret-access-key"),
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("setting up playback archive: %w", err)
+			}
+			opts.Archive = arc
+			opts.ArchiveRetention = cctx.Duration("archive-retention")
+			logger.Info("archiving playback log files", "endpoint", endpoint, "bucket", cctx.String("archive-s3-bucket"), "archiveRetention", opts.ArchiveRetention)
+		}
+		logger.Info("setting up disk persister", "dir", persistDir, "replayWindow", opts.Retention)
+		dp, err := diskpersist.NewDiskPersistence(persistDir, "", db, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("setting up disk persister: %w", err)
+		}
+		return dp, func(r *relay.Relay) { dp.SetUidSource(r) }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown persist backend: %s", cctx.String("persist-backend"))
+	}
+}
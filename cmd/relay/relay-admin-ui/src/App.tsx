@@ -15,6 +15,7 @@ import Domains from "./components/Domains/Domains";
 import Repos from "./components/Repos/Repos";
 import Consumers from "./components/Consumers/Consumers";
 import NewPDS from "./components/NewPDS/NewPDS";
+import Inspect from "./components/Inspect/Inspect";
 
 function classNames(...classes: string[]) {
   return classes.filter(Boolean).join(" ");
@@ -116,6 +117,21 @@ const routes: Route[] = [
     ),
     requrieAuth: true,
   },
+  {
+    path: "/inspect",
+    name: "Inspect Repo",
+    element: (
+      <RequireAuth>
+        <Nav />
+        <main>
+          <div className="mx-auto max-w-7xl px-2 py-6 sm:px-6 lg:px-8">
+            <Inspect />
+          </div>
+        </main>
+      </RequireAuth>
+    ),
+    requrieAuth: true,
+  },
 
   {
     path: "/login",
@@ -76,6 +76,16 @@ const Consumers: FC<{}> = () => {
             ID: consumer.id,
             EventsConsumed: consumer.events_consumed,
             ConnectedAt: new Date(Date.parse(consumer.connected_at)),
+            Format: consumer.format,
+            WantedCollections: consumer.wanted_collections || [],
+            WantedDIDCount: consumer.wanted_did_count || 0,
+            EventsFiltered: consumer.events_filtered,
+            OpsFiltered: consumer.ops_filtered,
+            SlowPolicy: consumer.slow_policy,
+            LastSeq: consumer.last_seq,
+            Lag: consumer.lag,
+            EventsDropped: consumer.events_dropped,
+            Spills: consumer.spills,
           };
         });
 
@@ -327,9 +337,34 @@ const Consumers: FC<{}> = () => {
                       </td>
                       <td className="whitespace-nowrap px-3 py-2 text-sm text-gray-400 w-8 pr-6">
                         {consumer.UserAgent}
+                        {consumer.Format && consumer.Format !== "cbor" && (
+                          <div className="text-xs text-gray-400">
+                            format: {consumer.Format}
+                          </div>
+                        )}
                       </td>
                       <td className="whitespace-nowrap px-3 py-2 text-sm text-gray-400 w-8 pr-6">
                         {consumer.EventsConsumed?.toLocaleString()}
+                        {(consumer.WantedCollections.length > 0 ||
+                          consumer.WantedDIDCount > 0) && (
+                          <div
+                            className="text-xs text-gray-400"
+                            title={consumer.WantedCollections.join(", ")}
+                          >
+                            filtered: {consumer.EventsFiltered?.toLocaleString()}{" "}
+                            events, {consumer.OpsFiltered?.toLocaleString()} ops
+                          </div>
+                        )}
+                        <div
+                          className="text-xs text-gray-400"
+                          title={`last seq: ${consumer.LastSeq}`}
+                        >
+                          lag: {consumer.Lag?.toLocaleString()} ({consumer.SlowPolicy})
+                          {consumer.EventsDropped > 0 &&
+                            `, dropped: ${consumer.EventsDropped.toLocaleString()}`}
+                          {consumer.Spills > 0 &&
+                            `, spills: ${consumer.Spills.toLocaleString()}`}
+                        </div>
                       </td>
                       <td className="whitespace-nowrap px-3 py-2 text-sm text-gray-400 text-center w-8 pr-6">
                         {consumer.ConnectedAt.toLocaleString()}
@@ -911,6 +911,11 @@ const Dash: FC<{}> = () => {
                       </td>
                       <td className="whitespace-nowrap px-3 py-4 text-sm text-gray-500 text-left">
                         {pds.Host}
+                        {pds.Relay && (
+                          <span className="ml-2 inline-flex items-center rounded-md bg-blue-50 px-2 py-1 text-xs font-medium text-blue-700">
+                            upstream relay
+                          </span>
+                        )}
                       </td>
                       <td className="whitespace-nowrap px-3 py-2 text-sm text-gray-400 w-8 pr-6">
                         {pds.HasActiveConnection ? (
@@ -0,0 +1,310 @@
+import { FC, useEffect, useState } from "react";
+import Notification, {
+  NotificationMeta,
+  NotificationType,
+} from "../Notification/Notification";
+
+import { RELAY_HOST } from "../../constants";
+
+import { useNavigate, useSearchParams } from "react-router-dom";
+import { MagnifyingGlassIcon } from "@heroicons/react/24/outline";
+import { AccountInspection } from "../../models/account";
+
+const Field: FC<{ label: string; value?: React.ReactNode }> = ({
+  label,
+  value,
+}) => (
+  <div className="py-2 sm:grid sm:grid-cols-3 sm:gap-4">
+    <dt className="text-sm font-medium text-gray-900">{label}</dt>
+    <dd className="mt-1 text-sm text-gray-700 sm:col-span-2 sm:mt-0 break-all">
+      {value === undefined || value === "" ? (
+        <span className="text-gray-400">-</span>
+      ) : (
+        value
+      )}
+    </dd>
+  </div>
+);
+
+const Section: FC<{ title: string; children: React.ReactNode }> = ({
+  title,
+  children,
+}) => (
+  <div className="mt-6">
+    <h2 className="text-base font-semibold leading-7 text-gray-900">{title}</h2>
+    <dl className="mt-2 divide-y divide-gray-100 border-t border-gray-100">
+      {children}
+    </dl>
+  </div>
+);
+
+const formatTime = (ts?: string) =>
+  ts ? new Date(Date.parse(ts)).toLocaleString() : undefined;
+
+const Inspect: FC<{}> = () => {
+  const [searchParams, setSearchParams] = useSearchParams();
+  const [didInput, setDidInput] = useState<string>(
+    searchParams.get("did") || ""
+  );
+  const [inspection, setInspection] = useState<AccountInspection | null>(
+    null
+  );
+
+  // Notification Management
+  const [shouldShowNotification, setShouldShowNotification] =
+    useState<boolean>(false);
+  const [notification, setNotification] = useState<NotificationMeta>({
+    message: "",
+    alertType: "",
+  });
+
+  const [adminToken, setAdminToken] = useState<string>(
+    localStorage.getItem("admin_route_token") || ""
+  );
+  const navigate = useNavigate();
+
+  const setAlertWithTimeout = (
+    type: NotificationType,
+    message: string,
+    dismiss: boolean
+  ) => {
+    setNotification({
+      message,
+      alertType: type,
+      autodismiss: dismiss,
+    });
+    setShouldShowNotification(true);
+  };
+
+  useEffect(() => {
+    const token = localStorage.getItem("admin_route_token");
+    if (token) {
+      setAdminToken(token);
+    } else {
+      navigate("/login");
+    }
+  }, []);
+
+  const inspectRepo = (did: string) => {
+    fetch(`${RELAY_HOST}/admin/repo/inspect?did=${encodeURIComponent(did)}`, {
+      method: "GET",
+      headers: {
+        "Content-Type": "application/json",
+        //Authorization: `Bearer ${adminToken}`,
+        Authorization: `Basic ` + btoa("admin:" + adminToken),
+      },
+    })
+      .then((res) => res.json())
+      .then((res) => {
+        if ("message" in res || "error" in res) {
+          setInspection(null);
+          setAlertWithTimeout(
+            "failure",
+            `Failed to inspect repo: ${res.message || res.error}`,
+            true
+          );
+          return;
+        }
+        setInspection(res as AccountInspection);
+      })
+      .catch((err) => {
+        setAlertWithTimeout("failure", `Failed to inspect repo: ${err}`, true);
+      });
+  };
+
+  // support linking directly to an account
+  useEffect(() => {
+    const did = searchParams.get("did");
+    if (did) {
+      inspectRepo(did);
+    }
+  }, [searchParams]);
+
+  const submit = () => {
+    const did = didInput.trim();
+    if (did) {
+      setSearchParams({ did });
+    }
+  };
+
+  return (
+    <div className="mx-auto max-w-full">
+      {shouldShowNotification ? (
+        <Notification
+          message={notification.message}
+          alertType={notification.alertType}
+          subMessage={notification.subMessage}
+          autodismiss={notification.autodismiss}
+          unshow={() => {
+            setShouldShowNotification(false);
+            setNotification({ message: "", alertType: "" });
+          }}
+          show={shouldShowNotification}
+        ></Notification>
+      ) : (
+        <></>
+      )}
+      <div className="sm:flex sm:items-center">
+        <div className="sm:flex-auto">
+          <h1 className="text-2xl font-semibold leading-6 text-gray-900">
+            Inspect Repo
+          </h1>
+          <p className="mt-2 text-sm text-gray-700">
+            Look up the relay's view of a single account: status, repo state,
+            host, and recent events and errors.
+          </p>
+        </div>
+      </div>
+      <div className="flex-grow mt-5">
+        <div className="max-w-3xl w-full">
+          <label
+            htmlFor="did"
+            className="block text-sm font-medium leading-6 text-gray-900"
+          >
+            Repo DID
+          </label>
+          <div className="mt-2 inline-flex flex-col sm:flex-row">
+            <input
+              type="text"
+              name="did"
+              id="did"
+              className="block w-72 rounded-md border-0 py-1.5 text-gray-900 shadow-sm ring-1 ring-inset ring-gray-300 placeholder:text-gray-400 focus:ring-2 focus:ring-inset focus:ring-indigo-600 sm:text-sm sm:leading-6"
+              placeholder="did:plc:someone"
+              value={didInput}
+              onChange={(e) => {
+                setDidInput(e.target.value);
+              }}
+              onKeyDown={(e) => {
+                if (e.key === "Enter") submit();
+              }}
+            />
+            <div className="inline-flex mt-4 sm:mt-0">
+              <button
+                type="button"
+                onClick={submit}
+                className="ml-0 sm:ml-2 inline-flex whitespace-nowrap items-center gap-x-1.5 rounded-md bg-indigo-600 px-2.5 py-1.5 text-sm font-semibold text-white shadow-sm hover:bg-indigo-500 focus-visible:outline focus-visible:outline-2 focus-visible:outline-offset-2 focus-visible:outline-indigo-600"
+              >
+                <MagnifyingGlassIcon
+                  className="-ml-0.5 h-5 w-5"
+                  aria-hidden="true"
+                />
+                Inspect
+              </button>
+            </div>
+          </div>
+        </div>
+      </div>
+      {inspection && (
+        <div className="max-w-3xl w-full">
+          <Section title="Account">
+            <Field label="DID" value={inspection.account.did} />
+            <Field label="UID" value={inspection.account.uid} />
+            <Field
+              label="Public Status"
+              value={
+                inspection.active ? (
+                  <span className="text-green-600">active</span>
+                ) : (
+                  <span className="text-red-600">{inspection.status}</span>
+                )
+              }
+            />
+            <Field label="Local Status" value={inspection.account.status} />
+            <Field
+              label="Upstream Status"
+              value={inspection.account.upstreamStatus}
+            />
+          </Section>
+          <Section title="Repo">
+            <Field label="Rev" value={inspection.repo?.rev} />
+            <Field label="Commit CID" value={inspection.repo?.commitCID} />
+            <Field label="Data CID" value={inspection.repo?.commitDataCID} />
+          </Section>
+          <Section title="Host">
+            <Field
+              label="Hostname"
+              value={
+                inspection.host
+                  ? `${inspection.host.hostname} (${inspection.host.status})`
+                  : undefined
+              }
+            />
+            <Field
+              label="Flags"
+              value={[
+                inspection.host?.trusted ? "trusted" : "",
+                inspection.host?.relay ? "upstream relay" : "",
+              ]
+                .filter(Boolean)
+                .join(", ")}
+            />
+            <Field label="Host Cursor" value={inspection.host?.lastSeq} />
+          </Section>
+          <Section title="Recent Events">
+            {inspection.events ? (
+              <>
+                <Field
+                  label="Counts"
+                  value={Object.entries(inspection.events.counts)
+                    .map(([k, v]) => `${k}: ${v}`)
+                    .join(", ")}
+                />
+                <Field
+                  label="Last Event"
+                  value={`${formatTime(inspection.events.lastEventAt)} from ${inspection.events.lastHostname} (seq ${inspection.events.lastSeq})`}
+                />
+              </>
+            ) : (
+              <Field label="Counts" value="no events since relay restart" />
+            )}
+            {inspection.limiter && (
+              <Field
+                label="Rate Limits"
+                value={`${inspection.limiter.accepted} accepted, ${inspection.limiter.dropped} dropped`}
+              />
+            )}
+          </Section>
+          {inspection.quarantine && (
+            <Section title="Quarantine">
+              <Field label="Reason" value={inspection.quarantine.reason} />
+              <Field
+                label="Since"
+                value={formatTime(inspection.quarantine.createdAt)}
+              />
+              <Field
+                label="Dropped Events"
+                value={inspection.quarantine.droppedEvents}
+              />
+            </Section>
+          )}
+          {inspection.resync && (
+            <Section title="Pending Resync">
+              <Field label="Reason" value={inspection.resync.reason} />
+              <Field label="Attempts" value={inspection.resync.attempts} />
+              <Field
+                label="Next Attempt"
+                value={formatTime(inspection.resync.nextAttemptAt)}
+              />
+              <Field label="Last Error" value={inspection.resync.lastError} />
+            </Section>
+          )}
+          <Section title="Recent Errors and Drops">
+            {inspection.events?.recentErrors?.length ? (
+              inspection.events.recentErrors.map((e, idx) => (
+                <Field
+                  key={idx}
+                  label={`${formatTime(e.time)} ${e.eventType}`}
+                  value={`${e.dropped ? "dropped: " : ""}${e.error} (${e.hostname}, seq ${e.seq})`}
+                />
+              ))
+            ) : (
+              <Field label="None" />
+            )}
+          </Section>
+        </div>
+      )}
+    </div>
+  );
+};
+
+export default Inspect;
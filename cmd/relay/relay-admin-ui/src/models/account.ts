@@ -0,0 +1,62 @@
+interface AccountEventError {
+  time: string;
+  eventType: string;
+  hostname: string;
+  seq: number;
+  error: string;
+  dropped?: boolean;
+}
+
+interface AccountInspection {
+  account: {
+    uid: number;
+    did: string;
+    hostID: number;
+    status: string;
+    upstreamStatus: string;
+  };
+  repo?: {
+    rev: string;
+    commitCID: string;
+    commitDataCID: string;
+  };
+  host?: {
+    id: number;
+    hostname: string;
+    status: string;
+    relay: boolean;
+    trusted: boolean;
+    lastSeq: number;
+  };
+  active: boolean;
+  status?: string;
+  events?: {
+    counts: Record<string, number>;
+    lastEventAt: string;
+    lastHostname: string;
+    lastSeq: number;
+    recentErrors: AccountEventError[];
+  };
+  limiter?: {
+    perMinuteLimit: number;
+    perHourLimit: number;
+    quarantineThreshold: number;
+    accepted: number;
+    dropped: number;
+    lastDropped?: string;
+  };
+  quarantine?: {
+    createdAt: string;
+    reason: string;
+    droppedEvents: number;
+  };
+  resync?: {
+    createdAt: string;
+    reason: string;
+    attempts: number;
+    nextAttemptAt: string;
+    lastError?: string;
+  };
+}
+
+export type { AccountInspection, AccountEventError };
@@ -4,6 +4,16 @@ interface Consumer {
   EventsConsumed: number;
   ConnectedAt: Date;
   ID: number;
+  Format: string;
+  WantedCollections: string[];
+  WantedDIDCount: number;
+  EventsFiltered: number;
+  OpsFiltered: number;
+  SlowPolicy: string;
+  LastSeq: number;
+  Lag: number;
+  EventsDropped: number;
+  Spills: number;
 }
 
 interface ConsumerResponse {
@@ -12,6 +22,16 @@ interface ConsumerResponse {
   user_agent: string;
   events_consumed: number;
   connected_at: string;
+  format: string;
+  wanted_collections?: string[];
+  wanted_did_count?: number;
+  events_filtered: number;
+  ops_filtered: number;
+  slow_policy: string;
+  last_seq: number;
+  lag: number;
+  events_dropped: number;
+  spills: number;
 }
 
 type ConsumerKey = keyof Consumer;
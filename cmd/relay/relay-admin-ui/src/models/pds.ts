@@ -21,6 +21,7 @@ interface PDS {
   PerDayEventRate: RateLimit;
   RepoCount: number;
   RepoLimit: number;
+  Relay?: boolean;
 }
 
 type PDSKey = keyof PDS;
@@ -0,0 +1,164 @@
+package relay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+
+	"github.com/hashicorp/golang-lru/v2"
+)
+
+// number of recent processing errors (and dropped events) remembered per account
+const accountEventErrorHistory = 10
+
+// Tracks recent firehose activity for individual accounts (event counts by type, and the most recent processing errors and dropped events), to help operators debug individual accounts.
+//
+// State is kept in-process only, and is lost on restart. Least-recently-active accounts are forgotten.
+type AccountEventTracker struct {
+	accounts *lru.Cache[string, *accountEventStats]
+}
+
+type accountEventStats struct {
+	lk sync.Mutex
+
+	counts       map[string]int64
+	lastEventAt  time.Time
+	lastHostname string
+	lastSeq      int64
+	errors       []AccountEventError
+}
+
+// A single failure to process an upstream event for an account, or an event which was intentionally dropped (in which case `Error` is the reason)
+type AccountEventError struct {
+	Time      time.Time `json:"time"`
+	EventType string    `json:"eventType"`
+	Hostname  string    `json:"hostname"`
+	Seq       int64     `json:"seq"`
+	Error     string    `json:"error"`
+	Dropped   bool      `json:"dropped,omitempty"`
+}
+
+// Point-in-time summary of recent event activity for a single account
+type AccountEventSummary struct {
+	// counts of events received (including those which failed processing), by event type
+	Counts       map[string]int64    `json:"counts"`
+	LastEventAt  time.Time           `json:"lastEventAt"`
+	LastHostname string              `json:"lastHostname"`
+	LastSeq      int64               `json:"lastSeq"`
+	RecentErrors []AccountEventError `json:"recentErrors"`
+}
+
+func NewAccountEventTracker(size int) *AccountEventTracker {
+	if size <= 0 {
+		size = 500_000
+	}
+	// NOTE: discarded second argument is only an error if size is non-positive
+	accounts, _ := lru.New[string, *accountEventStats](size)
+	return &AccountEventTracker{accounts: accounts}
+}
+
+func (t *AccountEventTracker) stats(did string) *accountEventStats {
+	did = NormalizeDID(syntax.DID(did)).String()
+	st, ok := t.accounts.Get(did)
+	if !ok {
+		st = &accountEventStats{counts: make(map[string]int64)}
+		// another goroutine may have added stats concurrently
+		if prev, found, _ := t.accounts.PeekOrAdd(did, st); found {
+			st = prev
+		}
+	}
+	return st
+}
+
+// Records the outcome of processing an upstream event. `procErr` may be nil.
+func (t *AccountEventTracker) Record(evt *stream.XRPCStreamEvent, hostname string, procErr error) {
+	did, eventType, seq := eventAccountInfo(evt)
+	if did == "" {
+		return
+	}
+	st := t.stats(did)
+
+	now := time.Now()
+	st.lk.Lock()
+	defer st.lk.Unlock()
+	st.counts[eventType]++
+	st.lastEventAt = now
+	st.lastHostname = hostname
+	st.lastSeq = seq
+	if procErr != nil {
+		st.addError(AccountEventError{
+			Time:      now,
+			EventType: eventType,
+			Hostname:  hostname,
+			Seq:       seq,
+			Error:     procErr.Error(),
+		})
+	}
+}
+
+// Records that an upstream event was dropped without a processing error (eg, rate-limited, or an old rev), and why. The event itself is still counted by Record.
+func (t *AccountEventTracker) RecordDrop(did, eventType, hostname string, seq int64, reason string) {
+	st := t.stats(did)
+
+	st.lk.Lock()
+	defer st.lk.Unlock()
+	st.addError(AccountEventError{
+		Time:      time.Now(),
+		EventType: eventType,
+		Hostname:  hostname,
+		Seq:       seq,
+		Error:     reason,
+		Dropped:   true,
+	})
+}
+
+// caller must hold the lock
+func (st *accountEventStats) addError(e AccountEventError) {
+	st.errors = append(st.errors, e)
+	if len(st.errors) > accountEventErrorHistory {
+		st.errors = st.errors[len(st.errors)-accountEventErrorHistory:]
+	}
+}
+
+// Returns recent activity for an account, or nil if there has been none recently. Errors are ordered most recent first.
+func (t *AccountEventTracker) Summary(did string) *AccountEventSummary {
+	st, ok := t.accounts.Peek(did)
+	if !ok {
+		return nil
+	}
+
+	st.lk.Lock()
+	defer st.lk.Unlock()
+	out := AccountEventSummary{
+		Counts:       make(map[string]int64, len(st.counts)),
+		LastEventAt:  st.lastEventAt,
+		LastHostname: st.lastHostname,
+		LastSeq:      st.lastSeq,
+		RecentErrors: make([]AccountEventError, 0, len(st.errors)),
+	}
+	for k, v := range st.counts {
+		out.Counts[k] = v
+	}
+	for i := len(st.errors) - 1; i >= 0; i-- {
+		out.RecentErrors = append(out.RecentErrors, st.errors[i])
+	}
+	return &out
+}
+
+// Returns the account DID, event type name, and upstream sequence number for a repo stream event. DID is empty for unhandled event types.
+func eventAccountInfo(evt *stream.XRPCStreamEvent) (did, eventType string, seq int64) {
+	switch {
+	case evt.RepoCommit != nil:
+		return evt.RepoCommit.Repo, "commit", evt.RepoCommit.Seq
+	case evt.RepoSync != nil:
+		return evt.RepoSync.Did, "sync", evt.RepoSync.Seq
+	case evt.RepoIdentity != nil:
+		return evt.RepoIdentity.Did, "identity", evt.RepoIdentity.Seq
+	case evt.RepoAccount != nil:
+		return evt.RepoAccount.Did, "account", evt.RepoAccount.Seq
+	default:
+		return "", "", 0
+	}
+}
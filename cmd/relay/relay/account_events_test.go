@@ -0,0 +1,85 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountEventTracker(t *testing.T) {
+	assert := assert.New(t)
+
+	tracker := NewAccountEventTracker(0)
+	did := "did:plc:abc123"
+	assert.Nil(tracker.Summary(did))
+
+	commit := &stream.XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Repo: did, Seq: 10}}
+	ident := &stream.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:ABC123", Seq: 11}}
+
+	tracker.Record(commit, "pds.example.com", nil)
+	// DIDs are normalized
+	tracker.Record(ident, "pds.example.com", nil)
+	for i := range 15 {
+		tracker.Record(commit, "pds.example.com", fmt.Errorf("failure %d", i))
+	}
+
+	sum := tracker.Summary(did)
+	assert.NotNil(sum)
+	assert.Equal(int64(16), sum.Counts["commit"])
+	assert.Equal(int64(1), sum.Counts["identity"])
+	assert.Equal("pds.example.com", sum.LastHostname)
+	assert.Equal(int64(10), sum.LastSeq)
+
+	// only most recent errors are kept, newest first
+	assert.Equal(accountEventErrorHistory, len(sum.RecentErrors))
+	assert.Equal("failure 14", sum.RecentErrors[0].Error)
+	assert.Equal("commit", sum.RecentErrors[0].EventType)
+
+	// drops are included in the history, but don't count as additional events
+	tracker.RecordDrop("did:plc:ABC123", "commit", "pds.example.com", 12, "rate-limited")
+	sum = tracker.Summary(did)
+	assert.Equal(int64(16), sum.Counts["commit"])
+	assert.Equal("rate-limited", sum.RecentErrors[0].Error)
+	assert.True(sum.RecentErrors[0].Dropped)
+	assert.False(sum.RecentErrors[1].Dropped)
+
+	// other event types are ignored
+	tracker.Record(&stream.XRPCStreamEvent{}, "pds.example.com", nil)
+}
+
+func TestAccountEventDropReasons(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	env := newTestResyncEnv(t)
+	r := env.relay
+
+	rev := syntax.NewTIDNow(0)
+	assert.NoError(r.UpsertAccountRepo(ctx, env.acc.UID, rev, "bafyreicommit", "bafyreidata"))
+
+	// commit with an old rev
+	commit := &stream.XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Repo: env.acc.DID, Seq: 10, Rev: "3lbdw7ubypk2z"}}
+	assert.NoError(r.processRepoEvent(ctx, commit, "pds.example.com", 1))
+	sum := r.AccountEvents.Summary(env.acc.DID)
+	assert.NotNil(sum)
+	assert.Equal(int64(1), sum.Counts["commit"])
+	assert.Len(sum.RecentErrors, 1)
+	assert.True(sum.RecentErrors[0].Dropped)
+	assert.Contains(sum.RecentErrors[0].Error, "old or duplicate rev")
+	assert.Equal(int64(10), sum.RecentErrors[0].Seq)
+
+	// commit for an inactive account
+	assert.NoError(r.UpdateAccountLocalStatus(ctx, syntax.DID(env.acc.DID), models.AccountStatusTakendown, false))
+	commit.RepoCommit.Seq = 11
+	assert.NoError(r.processRepoEvent(ctx, commit, "pds.example.com", 1))
+	sum = r.AccountEvents.Summary(env.acc.DID)
+	assert.Equal(int64(2), sum.Counts["commit"])
+	assert.Contains(sum.RecentErrors[0].Error, "account not active")
+	assert.Equal(int64(11), sum.RecentErrors[0].Seq)
+}
@@ -0,0 +1,141 @@
+package relay
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/RussellLuo/slidingwindow"
+	"github.com/hashicorp/golang-lru/v2"
+)
+
+// Per-account (DID) event rate limits. Zero values disable the corresponding limit.
+type AccountLimiterConfig struct {
+	PerMinute int64
+	PerHour   int64
+
+	// If an account has more than this many events dropped (due to rate limits) within an hour, it should be quarantined. Zero disables quarantine.
+	QuarantineThreshold int64
+
+	// Maximum number of accounts tracked at a time. Least-recently-active accounts are forgotten (which resets their limits)
+	CacheSize int
+}
+
+// Tracks sliding-window event rates for individual accounts, to keep a single noisy account from consuming an entire host's rate limit budget.
+//
+// State is kept in-process only, and is lost on restart.
+type AccountLimiter struct {
+	Config AccountLimiterConfig
+
+	windows *lru.Cache[string, *accountWindows]
+}
+
+type accountWindows struct {
+	perMinute *slidingwindow.Limiter
+	perHour   *slidingwindow.Limiter
+	strikes   *slidingwindow.Limiter
+
+	accepted    atomic.Int64
+	dropped     atomic.Int64
+	lastDropped atomic.Int64
+}
+
+// Point-in-time summary of the limiter state for a single account
+type AccountLimiterStatus struct {
+	PerMinuteLimit      int64      `json:"perMinuteLimit"`
+	PerHourLimit        int64      `json:"perHourLimit"`
+	QuarantineThreshold int64      `json:"quarantineThreshold"`
+	Accepted            int64      `json:"accepted"`
+	Dropped             int64      `json:"dropped"`
+	LastDropped         *time.Time `json:"lastDropped,omitempty"`
+}
+
+func NewAccountLimiter(config AccountLimiterConfig) *AccountLimiter {
+	if config.CacheSize <= 0 {
+		config.CacheSize = 500_000
+	}
+	// NOTE: discarded second argument is only an error if size is non-positive
+	windows, _ := lru.New[string, *accountWindows](config.CacheSize)
+	return &AccountLimiter{
+		Config:  config,
+		windows: windows,
+	}
+}
+
+func (al *AccountLimiter) getWindows(did string) *accountWindows {
+	w, ok := al.windows.Get(did)
+	if ok {
+		return w
+	}
+	newLimiter := func(size time.Duration, limit int64) *slidingwindow.Limiter {
+		if limit <= 0 {
+			return nil
+		}
+		lim, _ := slidingwindow.NewLimiter(size, limit, windowFunc)
+		return lim
+	}
+	w = &accountWindows{
+		perMinute: newLimiter(time.Minute, al.Config.PerMinute),
+		perHour:   newLimiter(time.Hour, al.Config.PerHour),
+		strikes:   newLimiter(time.Hour, al.Config.QuarantineThreshold),
+	}
+	// another goroutine may have added windows concurrently
+	if prev, found, _ := al.windows.PeekOrAdd(did, w); found {
+		return prev
+	}
+	return w
+}
+
+// Records an event for the account, and checks if it is within rate limits.
+//
+// If the event is not allowed, also returns whether the account has exceeded the quarantine threshold.
+func (al *AccountLimiter) Allow(did string) (allowed bool, quarantine bool) {
+	if al.Config.PerMinute <= 0 && al.Config.PerHour <= 0 {
+		return true, false
+	}
+	w := al.getWindows(did)
+
+	allowed = true
+	if w.perMinute != nil && !w.perMinute.Allow() {
+		allowed = false
+	}
+	if allowed && w.perHour != nil && !w.perHour.Allow() {
+		allowed = false
+	}
+
+	if allowed {
+		w.accepted.Add(1)
+		return true, false
+	}
+
+	w.dropped.Add(1)
+	w.lastDropped.Store(time.Now().UnixMilli())
+	if w.strikes != nil && !w.strikes.Allow() {
+		return false, true
+	}
+	return false, false
+}
+
+// Returns current counters for an account, or nil if the account is not being tracked.
+func (al *AccountLimiter) Status(did string) *AccountLimiterStatus {
+	w, ok := al.windows.Peek(did)
+	if !ok {
+		return nil
+	}
+	status := AccountLimiterStatus{
+		PerMinuteLimit:      al.Config.PerMinute,
+		PerHourLimit:        al.Config.PerHour,
+		QuarantineThreshold: al.Config.QuarantineThreshold,
+		Accepted:            w.accepted.Load(),
+		Dropped:             w.dropped.Load(),
+	}
+	if ms := w.lastDropped.Load(); ms > 0 {
+		t := time.UnixMilli(ms)
+		status.LastDropped = &t
+	}
+	return &status
+}
+
+// Forgets all state for the account, resetting limits and counters.
+func (al *AccountLimiter) Reset(did string) {
+	al.windows.Remove(did)
+}
@@ -0,0 +1,79 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	al := NewAccountLimiter(AccountLimiterConfig{
+		PerMinute:           5,
+		PerHour:             100,
+		QuarantineThreshold: 3,
+	})
+	noisy := "did:plc:noisy"
+	quiet := "did:plc:quiet"
+
+	assert.Nil(al.Status(noisy))
+
+	for range 5 {
+		allowed, _ := al.Allow(noisy)
+		assert.True(allowed)
+	}
+
+	// limits are per-account
+	allowed, _ := al.Allow(quiet)
+	assert.True(allowed)
+
+	// dropped events count towards quarantine threshold
+	for range 3 {
+		allowed, quarantine := al.Allow(noisy)
+		assert.False(allowed)
+		assert.False(quarantine)
+	}
+	allowed, quarantine := al.Allow(noisy)
+	assert.False(allowed)
+	assert.True(quarantine)
+
+	st := al.Status(noisy)
+	assert.NotNil(st)
+	assert.Equal(int64(5), st.Accepted)
+	assert.Equal(int64(4), st.Dropped)
+	assert.NotNil(st.LastDropped)
+
+	st = al.Status(quiet)
+	assert.Equal(int64(1), st.Accepted)
+	assert.Nil(st.LastDropped)
+
+	// reset clears state
+	al.Reset(noisy)
+	assert.Nil(al.Status(noisy))
+	allowed, _ = al.Allow(noisy)
+	assert.True(allowed)
+}
+
+func TestAccountLimiterDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	al := NewAccountLimiter(AccountLimiterConfig{})
+	for range 1000 {
+		allowed, quarantine := al.Allow("did:plc:abc123")
+		assert.True(allowed)
+		assert.False(quarantine)
+	}
+	// nothing is tracked when disabled
+	assert.Nil(al.Status("did:plc:abc123"))
+
+	// quarantine disabled, but limits enabled
+	al = NewAccountLimiter(AccountLimiterConfig{PerHour: 1})
+	allowed, _ := al.Allow("did:plc:abc123")
+	assert.True(allowed)
+	for range 10 {
+		allowed, quarantine := al.Allow("did:plc:abc123")
+		assert.False(allowed)
+		assert.False(quarantine)
+	}
+}
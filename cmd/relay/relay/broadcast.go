@@ -1,16 +1,22 @@
 package relay
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/cmd/relay/stream"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/eventmgr"
 
 	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
 	promclient "github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 )
@@ -19,7 +25,31 @@ type SocketConsumer struct {
 	UserAgent   string
 	RemoteAddr  string
 	ConnectedAt time.Time
+	Format      ConsumerFormat
 	EventsSent  promclient.Counter
+
+	// optional server-side filter; nil if consumer receives all events
+	Filter *ConsumerFilter
+
+	// counts of events (entirely) and commit ops skipped due to the filter
+	EventsFiltered atomic.Uint64
+	OpsFiltered    atomic.Uint64
+
+	// how the consumer is handled if it falls behind, and counts of dropped events and spills to playback
+	SlowPolicy eventmgr.SlowConsumerPolicy
+	SlowStats  eventmgr.SubscriberStats
+
+	// sequence number of the last event delivered to the consumer (including events skipped by the filter)
+	LastSeq atomic.Int64
+}
+
+// Number of events the consumer is behind the live firehose
+func (c *SocketConsumer) Lag(headSeq int64) int64 {
+	last := c.LastSeq.Load()
+	if last <= 0 || headSeq < last {
+		return 0
+	}
+	return headSeq - last
 }
 
 func (r *Relay) registerConsumer(c *SocketConsumer) uint64 {
@@ -49,7 +79,10 @@ func (r *Relay) cleanupConsumer(id uint64) {
 		"consumer_id", id,
 		"remote_addr", c.RemoteAddr,
 		"user_agent", c.UserAgent,
-		"events_sent", m.Counter.GetValue())
+		"events_sent", m.Counter.GetValue(),
+		"events_filtered", c.EventsFiltered.Load(),
+		"events_dropped", c.SlowStats.EventsDropped.Load(),
+		"spills", c.SlowStats.Spills.Load())
 
 	delete(r.consumers, id)
 }
@@ -59,8 +92,86 @@ var wsUpgrader = websocket.Upgrader{
 	WriteBufferSize: 10_000,
 }
 
+// Output encoding for firehose consumers
+type ConsumerFormat string
+
+const (
+	// the standard com.atproto.sync.subscribeRepos DAG-CBOR framing
+	ConsumerFormatCBOR = ConsumerFormat("cbor")
+	// JSON events, optionally zstd-compressed. See JSONEvent
+	ConsumerFormatJSON     = ConsumerFormat("json")
+	ConsumerFormatJSONZstd = ConsumerFormat("json+zstd")
+)
+
+// encodes a single event as a WebSocket message. returns nil payload if the event should be skipped
+type eventEncoder func(evt *stream.XRPCStreamEvent) (msgType int, payload []byte, err error)
+
+func encodeEventCBOR(evt *stream.XRPCStreamEvent) (int, []byte, error) {
+	if evt.Preserialized != nil {
+		return websocket.BinaryMessage, evt.Preserialized, nil
+	}
+	var buf bytes.Buffer
+	if err := evt.Serialize(&buf); err != nil {
+		return 0, nil, err
+	}
+	return websocket.BinaryMessage, buf.Bytes(), nil
+}
+
+// Name of the info frame sent to a consumer in place of an event which could not be encoded in the consumer's format
+const InfoEventSkipped = "EventSkipped"
+
+func skippedEventInfo(seq int64, err error) *stream.XRPCStreamEvent {
+	msg := fmt.Sprintf("event seq %d could not be encoded, and was skipped: %s", seq, err)
+	return &stream.XRPCStreamEvent{
+		RepoInfo: &comatproto.SyncSubscribeRepos_Info{
+			Name:    InfoEventSkipped,
+			Message: &msg,
+		},
+	}
+}
+
+func encodeEventJSON(evt *stream.XRPCStreamEvent) (int, []byte, error) {
+	b, err := MarshalEventJSON(evt)
+	return websocket.TextMessage, b, err
+}
+
 // Main HTTP request handler for clients connecting to the firehose (com.atproto.sync.subscribeRepos)
-func (r *Relay) HandleSubscribeRepos(resp http.ResponseWriter, req *http.Request, since *int64, realIP string) error {
+//
+// If filter is non-nil, events are filtered before serialization. Note that this means filtered commits are re-serialized per-consumer, instead of using the pre-serialized bytes.
+//
+// If policy is empty, the relay's configured SlowConsumerPolicy is used.
+func (r *Relay) HandleSubscribeRepos(resp http.ResponseWriter, req *http.Request, since *int64, realIP string, filter *ConsumerFilter, policy eventmgr.SlowConsumerPolicy) error {
+	return r.handleSubscribe(resp, req, since, realIP, filter, policy, ConsumerFormatCBOR, encodeEventCBOR)
+}
+
+// HTTP request handler for clients connecting to the JSON variant of the firehose.
+//
+// Each event is converted to a JSONEvent (with records decoded from CAR blocks) and sent as a text WebSocket message. If compress is true, each message is instead compressed with zstd and sent as a binary message. Cursor semantics are the same as for HandleSubscribeRepos.
+func (r *Relay) HandleSubscribeReposJSON(resp http.ResponseWriter, req *http.Request, since *int64, realIP string, filter *ConsumerFilter, policy eventmgr.SlowConsumerPolicy, compress bool) error {
+	if !compress {
+		return r.handleSubscribe(resp, req, since, realIP, filter, policy, ConsumerFormatJSON, encodeEventJSON)
+	}
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return fmt.Errorf("creating zstd encoder: %w", err)
+	}
+	defer enc.Close()
+
+	return r.handleSubscribe(resp, req, since, realIP, filter, policy, ConsumerFormatJSONZstd, func(evt *stream.XRPCStreamEvent) (int, []byte, error) {
+		b, err := MarshalEventJSON(evt)
+		if err != nil || b == nil {
+			return 0, nil, err
+		}
+		return websocket.BinaryMessage, enc.EncodeAll(b, nil), nil
+	})
+}
+
+func (r *Relay) handleSubscribe(resp http.ResponseWriter, req *http.Request, since *int64, realIP string, filter *ConsumerFilter, policy eventmgr.SlowConsumerPolicy, format ConsumerFormat, encode eventEncoder) error {
+
+	if policy == "" {
+		policy = r.Config.SlowConsumerPolicy
+	}
 
 	ctx, cancel := context.WithCancel(req.Context())
 	defer cancel()
@@ -131,31 +242,46 @@ func (r *Relay) HandleSubscribeRepos(resp http.ResponseWriter, req *http.Request
 
 	ident := realIP + "-" + req.UserAgent()
 
-	evts, cleanup, err := r.Events.Subscribe(ctx, ident, func(evt *stream.XRPCStreamEvent) bool { return true }, since)
-	if err != nil {
-		return err
-	}
-	defer cleanup()
-
 	// Keep track of the consumer for metrics and admin endpoints
 	consumer := SocketConsumer{
 		RemoteAddr:  realIP,
 		UserAgent:   req.UserAgent(),
 		ConnectedAt: time.Now(),
+		Format:      format,
+		Filter:      filter,
+		SlowPolicy:  policy,
 	}
 	sentCounter := eventsSentCounter.WithLabelValues(consumer.RemoteAddr, consumer.UserAgent)
 	consumer.EventsSent = sentCounter
 
+	evts, cleanup, err := r.Events.Subscribe(ctx, ident, func(evt *stream.XRPCStreamEvent) bool { return true }, since, &eventmgr.SubscribeOptions{
+		Policy: policy,
+		Stats:  &consumer.SlowStats,
+	})
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	consumerID := r.registerConsumer(&consumer)
 	defer r.cleanupConsumer(consumerID)
+	// labeled by consumer ID as well, so that multiple connections from the same client don't share a gauge. The series is deleted when the consumer disconnects, so the number of series is bounded by the number of connected consumers
+	lagLabels := []string{strconv.FormatUint(consumerID, 10), consumer.RemoteAddr, consumer.UserAgent}
+	lagGauge := consumerLagGauge.WithLabelValues(lagLabels...)
+	defer consumerLagGauge.DeleteLabelValues(lagLabels...)
 
 	logger := r.Logger.With(
 		"consumer_id", consumerID,
 		"remote_addr", consumer.RemoteAddr,
 		"user_agent", consumer.UserAgent,
+		"format", format,
 	)
 
-	logger.Info("new consumer", "cursor", since)
+	if filter != nil {
+		logger.Info("new consumer", "cursor", since, "slowPolicy", policy, "wantedCollections", len(filter.WantedCollections), "wantedDids", len(filter.WantedDIDs))
+	} else {
+		logger.Info("new consumer", "cursor", since, "slowPolicy", policy)
+	}
 
 	for {
 		select {
@@ -165,23 +291,40 @@ func (r *Relay) HandleSubscribeRepos(resp http.ResponseWriter, req *http.Request
 				return nil
 			}
 
-			wc, err := conn.NextWriter(websocket.BinaryMessage)
-			if err != nil {
-				logger.Error("failed to get next writer", "err", err)
-				return err
+			if seq := evt.Sequence(); seq > 0 {
+				consumer.LastSeq.Store(seq)
+				lagGauge.Set(float64(consumer.Lag(r.Events.CurrentSeq())))
 			}
 
-			if evt.Preserialized != nil {
-				_, err = wc.Write(evt.Preserialized)
-			} else {
-				err = evt.Serialize(wc)
+			if filter != nil {
+				var droppedOps int
+				evt, droppedOps = filter.Apply(evt)
+				if droppedOps > 0 {
+					consumer.OpsFiltered.Add(uint64(droppedOps))
+				}
+				if evt == nil {
+					consumer.EventsFiltered.Add(1)
+					continue
+				}
 			}
+
+			msgType, payload, err := encode(evt)
 			if err != nil {
-				return fmt.Errorf("failed to write event: %w", err)
+				// a single malformed event shouldn't disconnect the consumer. instead, the consumer is sent an #info frame in its place, so the gap in sequence numbers is explained
+				seq := evt.Sequence()
+				logger.Warn("skipping event which could not be encoded", "seq", seq, "err", err)
+				eventsEncodeFailedCounter.WithLabelValues(string(format)).Inc()
+				msgType, payload, err = encode(skippedEventInfo(seq, err))
+				if err != nil {
+					return fmt.Errorf("failed to encode #info frame for skipped event: %w", err)
+				}
+			}
+			if payload == nil {
+				continue
 			}
 
-			if err := wc.Close(); err != nil {
-				logger.Warn("failed to flush-close our event write", "err", err)
+			if err := conn.WriteMessage(msgType, payload); err != nil {
+				logger.Warn("failed to write event", "err", err)
 				return nil
 			}
 
@@ -201,25 +344,53 @@ type ConsumerInfo struct {
 	UserAgent      string    `json:"user_agent"`
 	EventsConsumed uint64    `json:"events_consumed"`
 	ConnectedAt    time.Time `json:"connected_at"`
+	Format         string    `json:"format"`
+
+	// filter configuration and stats; empty if consumer is not filtered
+	WantedCollections []string `json:"wanted_collections,omitempty"`
+	WantedDIDCount    int      `json:"wanted_did_count,omitempty"`
+	EventsFiltered    uint64   `json:"events_filtered"`
+	OpsFiltered       uint64   `json:"ops_filtered"`
+
+	// slow consumer handling
+	SlowPolicy    string `json:"slow_policy"`
+	LastSeq       int64  `json:"last_seq"`
+	Lag           int64  `json:"lag"`
+	EventsDropped uint64 `json:"events_dropped"`
+	Spills        uint64 `json:"spills"`
 }
 
 func (r *Relay) ListConsumers() []ConsumerInfo {
 	r.consumersLk.RLock()
 	defer r.consumersLk.RUnlock()
 
+	headSeq := r.Events.CurrentSeq()
 	info := make([]ConsumerInfo, 0, len(r.consumers))
 	for id, c := range r.consumers {
 		var m = &dto.Metric{}
 		if err := c.EventsSent.Write(m); err != nil {
 			continue
 		}
-		info = append(info, ConsumerInfo{
+		ci := ConsumerInfo{
 			ID:             id,
 			RemoteAddr:     c.RemoteAddr,
 			UserAgent:      c.UserAgent,
 			EventsConsumed: uint64(m.Counter.GetValue()),
 			ConnectedAt:    c.ConnectedAt,
-		})
+			Format:         string(c.Format),
+			EventsFiltered: c.EventsFiltered.Load(),
+			OpsFiltered:    c.OpsFiltered.Load(),
+			SlowPolicy:     string(c.SlowPolicy),
+			LastSeq:        c.LastSeq.Load(),
+			Lag:            c.Lag(headSeq),
+			EventsDropped:  c.SlowStats.EventsDropped.Load(),
+			Spills:         c.SlowStats.Spills.Load(),
+		}
+		if c.Filter != nil {
+			ci.WantedCollections = c.Filter.WantedCollections
+			ci.WantedDIDCount = len(c.Filter.WantedDIDs)
+		}
+		info = append(info, ci)
 	}
 	return info
 }
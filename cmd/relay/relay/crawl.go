@@ -51,7 +51,7 @@ func (r *Relay) SubscribeToHost(ctx context.Context, hostname string, noSSL, adm
 		return fmt.Errorf("cannot subscribe to banned pds")
 	}
 
-	return r.Slurper.Subscribe(&host)
+	return r.subscribeHost(&host)
 }
 
 // This function expects to be run when starting up, to re-connect to known active hosts
@@ -67,7 +67,7 @@ func (r *Relay) ResubscribeAllHosts(ctx context.Context) error {
 		logger.Info("re-subscribing to active host")
 		// make a copy of host
 		host := host
-		err := r.Slurper.Subscribe(&host)
+		err := r.subscribeHost(&host)
 		if err != nil {
 			logger.Warn("failed to re-subscribe to host", "err", err)
 		}
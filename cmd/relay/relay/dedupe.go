@@ -0,0 +1,66 @@
+package relay
+
+import (
+	"fmt"
+
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+
+	"github.com/hashicorp/golang-lru/v2"
+)
+
+// Tracks recently-processed events, so that the same event received from multiple upstream relays is only processed (and emitted) once.
+//
+// Commit and sync events are identified by (DID, rev). Identity and account events don't have a revision, so are identified by DID, timestamp, and content.
+type EventDeduper struct {
+	seen *lru.Cache[string, struct{}]
+}
+
+func NewEventDeduper(size int) *EventDeduper {
+	if size <= 0 {
+		size = 1_000_000
+	}
+	// NOTE: discarded second argument is only an error if size is non-positive
+	seen, _ := lru.New[string, struct{}](size)
+	return &EventDeduper{seen: seen}
+}
+
+// Atomically checks if an event has been seen, and if not marks it as seen. Returns true if the caller should go ahead and process the event.
+func (d *EventDeduper) Claim(key string) bool {
+	if key == "" {
+		return true
+	}
+	found, _ := d.seen.ContainsOrAdd(key, struct{}{})
+	return !found
+}
+
+// Removes the claim on an event, eg if processing failed, so that a copy from another upstream can be processed instead.
+func (d *EventDeduper) Release(key string) {
+	if key == "" {
+		return
+	}
+	d.seen.Remove(key)
+}
+
+// Returns the de-duplication key for an event. Returns an empty string for event types which are not de-duplicated.
+func dedupeKey(evt *stream.XRPCStreamEvent) string {
+	switch {
+	case evt.RepoCommit != nil:
+		return fmt.Sprintf("%s|commit|%s", evt.RepoCommit.Repo, evt.RepoCommit.Rev)
+	case evt.RepoSync != nil:
+		return fmt.Sprintf("%s|sync|%s", evt.RepoSync.Did, evt.RepoSync.Rev)
+	case evt.RepoIdentity != nil:
+		handle := ""
+		if evt.RepoIdentity.Handle != nil {
+			handle = *evt.RepoIdentity.Handle
+		}
+		return fmt.Sprintf("%s|identity|%s|%s", evt.RepoIdentity.Did, evt.RepoIdentity.Time, handle)
+	case evt.RepoAccount != nil:
+		status := ""
+		if evt.RepoAccount.Status != nil {
+			status = *evt.RepoAccount.Status
+		}
+		return fmt.Sprintf("%s|account|%s|%t|%s", evt.RepoAccount.Did, evt.RepoAccount.Time, evt.RepoAccount.Active, status)
+	default:
+		return ""
+	}
+}
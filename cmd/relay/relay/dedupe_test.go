@@ -0,0 +1,50 @@
+package relay
+
+import (
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventDeduper(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewEventDeduper(3)
+
+	commit := &stream.XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Repo: "did:plc:abc123", Rev: "3lbqb2ofs3s2b", Seq: 12}}
+	// same commit, with different sequence number (eg, from another relay)
+	commitCopy := &stream.XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Repo: "did:plc:abc123", Rev: "3lbqb2ofs3s2b", Seq: 9876}}
+	nextCommit := &stream.XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Repo: "did:plc:abc123", Rev: "3lbqb2ofs3s2c", Seq: 13}}
+	sync := &stream.XRPCStreamEvent{RepoSync: &comatproto.SyncSubscribeRepos_Sync{Did: "did:plc:abc123", Rev: "3lbqb2ofs3s2b"}}
+
+	assert.Equal(dedupeKey(commit), dedupeKey(commitCopy))
+	assert.NotEqual(dedupeKey(commit), dedupeKey(nextCommit))
+	assert.NotEqual(dedupeKey(commit), dedupeKey(sync))
+
+	assert.True(d.Claim(dedupeKey(commit)))
+	assert.False(d.Claim(dedupeKey(commitCopy)))
+	assert.True(d.Claim(dedupeKey(nextCommit)))
+	assert.True(d.Claim(dedupeKey(sync)))
+
+	// released claims can be claimed again
+	d.Release(dedupeKey(commit))
+	assert.True(d.Claim(dedupeKey(commitCopy)))
+
+	status := "takendown"
+	acctA := &stream.XRPCStreamEvent{RepoAccount: &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:abc123", Time: "2025-01-01T00:00:00Z", Active: false, Status: &status}}
+	acctB := &stream.XRPCStreamEvent{RepoAccount: &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:abc123", Time: "2025-01-01T00:00:00Z", Active: true}}
+	assert.NotEqual(dedupeKey(acctA), dedupeKey(acctB))
+
+	ident := &stream.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:abc123", Time: "2025-01-01T00:00:00Z"}}
+	assert.True(d.Claim(dedupeKey(ident)))
+	assert.False(d.Claim(dedupeKey(ident)))
+
+	// events without a key are never de-duplicated
+	info := &stream.XRPCStreamEvent{RepoInfo: &comatproto.SyncSubscribeRepos_Info{Name: "OutdatedCursor"}}
+	assert.Equal("", dedupeKey(info))
+	assert.True(d.Claim(dedupeKey(info)))
+	assert.True(d.Claim(dedupeKey(info)))
+}
@@ -56,11 +56,19 @@ func (r *Relay) findDomainBan(ctx context.Context, domain string) (bool, error)
 
 func (r *Relay) CreateDomainBan(ctx context.Context, domain string) error {
 	domainBan := models.DomainBan{Domain: domain}
-	return r.db.WithContext(ctx).Create(&domainBan).Error
+	if err := r.db.WithContext(ctx).Create(&domainBan).Error; err != nil {
+		return err
+	}
+	r.pdsHostCache.Purge()
+	return nil
 }
 
 func (r *Relay) RemoveDomainBan(ctx context.Context, domain string) error {
-	return r.db.WithContext(ctx).Delete(&models.DomainBan{}, "domain = ?", domain).Error
+	if err := r.db.WithContext(ctx).Delete(&models.DomainBan{}, "domain = ?", domain).Error; err != nil {
+		return err
+	}
+	r.pdsHostCache.Purge()
+	return nil
 }
 
 // returns all domain bans
@@ -8,6 +8,7 @@ var (
 	ErrHostNotFound        = errors.New("unknown host or PDS")
 	ErrHostInactive        = errors.New("no active connection to host")
 	ErrHostNotPDS          = errors.New("server is not a PDS")
+	ErrHostBanned          = errors.New("host is banned")
 	ErrNewHostsDisabled    = errors.New("new host subscriptions temporarily disabled")
 	ErrAccountNotFound     = errors.New("unknown account")
 	ErrAccountRepoNotFound = errors.New("repository state not available")
@@ -0,0 +1,167 @@
+package relay
+
+import (
+	"fmt"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+)
+
+const (
+	// upper bounds on the size of a single consumer's filter, to keep per-event filtering cheap
+	MaxWantedCollections = 100
+	MaxWantedDIDs        = 10_000
+)
+
+// Server-side filter for a single firehose consumer, configured via `wantedCollections` and `wantedDids` query parameters.
+//
+// Collections can be an exact NSID (`app.bsky.feed.post`), or a prefix ending in `.*` (`app.bsky.graph.*`). When collections are specified, commit ops for other collections are dropped, and commits with no remaining ops are dropped entirely. Other event types (sync, identity, account) are not filtered by collection.
+//
+// When DIDs are specified, all events for other accounts are dropped.
+type ConsumerFilter struct {
+	WantedCollections []string
+	WantedDIDs        []string
+
+	collections        map[string]bool
+	collectionPrefixes []string
+	dids               map[string]bool
+}
+
+// Parses and validates filter parameters. Returns a nil filter (and no error) if no parameters were provided.
+func ParseConsumerFilter(collections, dids []string) (*ConsumerFilter, error) {
+	if len(collections) == 0 && len(dids) == 0 {
+		return nil, nil
+	}
+	if len(collections) > MaxWantedCollections {
+		return nil, fmt.Errorf("too many wantedCollections (max %d)", MaxWantedCollections)
+	}
+	if len(dids) > MaxWantedDIDs {
+		return nil, fmt.Errorf("too many wantedDids (max %d)", MaxWantedDIDs)
+	}
+
+	f := ConsumerFilter{}
+	if len(collections) > 0 {
+		f.collections = make(map[string]bool, len(collections))
+	}
+	for _, raw := range collections {
+		if strings.HasSuffix(raw, ".*") {
+			prefix := strings.TrimSuffix(raw, "*")
+			// validate by parsing the prefix with a dummy name segment appended
+			if _, err := syntax.ParseNSID(prefix + "x"); err != nil {
+				return nil, fmt.Errorf("invalid wantedCollections prefix %q: %w", raw, err)
+			}
+			f.collectionPrefixes = append(f.collectionPrefixes, prefix)
+		} else {
+			nsid, err := syntax.ParseNSID(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid wantedCollections value %q: %w", raw, err)
+			}
+			f.collections[nsid.String()] = true
+		}
+		f.WantedCollections = append(f.WantedCollections, raw)
+	}
+
+	if len(dids) > 0 {
+		f.dids = make(map[string]bool, len(dids))
+	}
+	for _, raw := range dids {
+		did, err := syntax.ParseDID(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wantedDids value %q: %w", raw, err)
+		}
+		did = NormalizeDID(did)
+		f.dids[did.String()] = true
+		f.WantedDIDs = append(f.WantedDIDs, did.String())
+	}
+	return &f, nil
+}
+
+func (f *ConsumerFilter) matchCollection(collection string) bool {
+	if f.collections[collection] {
+		return true
+	}
+	for _, prefix := range f.collectionPrefixes {
+		if strings.HasPrefix(collection, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ConsumerFilter) matchDID(did string) bool {
+	if f.dids == nil {
+		return true
+	}
+	return f.dids[did]
+}
+
+// Applies the filter to a single event.
+//
+// Returns the event to send (which may be a filtered copy of the original, without any pre-serialized bytes), or nil if the event should be dropped entirely. Also returns the number of commit ops which were dropped.
+func (f *ConsumerFilter) Apply(evt *stream.XRPCStreamEvent) (*stream.XRPCStreamEvent, int) {
+	if f == nil {
+		return evt, 0
+	}
+
+	switch {
+	case evt.RepoCommit != nil:
+		if !f.matchDID(evt.RepoCommit.Repo) {
+			return nil, len(evt.RepoCommit.Ops)
+		}
+		commit, dropped := f.filterCommitOps(evt.RepoCommit)
+		if commit == nil {
+			return nil, dropped
+		}
+		if dropped == 0 {
+			return evt, 0
+		}
+		return &stream.XRPCStreamEvent{
+			RepoCommit: commit,
+			PrivUid:    evt.PrivUid,
+		}, dropped
+	case evt.RepoSync != nil:
+		if !f.matchDID(evt.RepoSync.Did) {
+			return nil, 0
+		}
+	case evt.RepoIdentity != nil:
+		if !f.matchDID(evt.RepoIdentity.Did) {
+			return nil, 0
+		}
+	case evt.RepoAccount != nil:
+		if !f.matchDID(evt.RepoAccount.Did) {
+			return nil, 0
+		}
+	}
+	// info and error frames are always passed through
+	return evt, 0
+}
+
+// Returns a shallow copy of the commit with only matching ops (or the original commit if all ops matched), and the count of dropped ops. Returns nil if no ops matched.
+//
+// NOTE: the 'blocks' CAR slice is passed through unmodified, so consumers can still verify the commit signature and read the records for kept ops. But MST inversion requires the complete list of ops, so it can not be verified on filtered commits.
+func (f *ConsumerFilter) filterCommitOps(commit *comatproto.SyncSubscribeRepos_Commit) (*comatproto.SyncSubscribeRepos_Commit, int) {
+	if f.collections == nil && f.collectionPrefixes == nil {
+		return commit, 0
+	}
+
+	var kept []*comatproto.SyncSubscribeRepos_RepoOp
+	for _, op := range commit.Ops {
+		collection, _, _ := strings.Cut(op.Path, "/")
+		if f.matchCollection(collection) {
+			kept = append(kept, op)
+		}
+	}
+
+	dropped := len(commit.Ops) - len(kept)
+	if len(kept) == 0 {
+		return nil, dropped
+	}
+	if dropped == 0 {
+		return commit, 0
+	}
+	out := *commit
+	out.Ops = kept
+	return &out, dropped
+}
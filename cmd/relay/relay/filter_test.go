@@ -0,0 +1,104 @@
+package relay
+
+import (
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConsumerFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := ParseConsumerFilter(nil, nil)
+	assert.NoError(err)
+	assert.Nil(f)
+
+	_, err = ParseConsumerFilter([]string{"not-an-nsid"}, nil)
+	assert.Error(err)
+
+	_, err = ParseConsumerFilter(nil, []string{"did:bad"})
+	assert.Error(err)
+
+	_, err = ParseConsumerFilter([]string{"*"}, nil)
+	assert.Error(err)
+
+	f, err = ParseConsumerFilter([]string{"app.bsky.feed.post", "app.bsky.graph.*"}, []string{"did:plc:ABC123"})
+	assert.NoError(err)
+	assert.Equal([]string{"did:plc:abc123"}, f.WantedDIDs)
+	assert.True(f.matchCollection("app.bsky.feed.post"))
+	assert.True(f.matchCollection("app.bsky.graph.follow"))
+	assert.False(f.matchCollection("app.bsky.feed.like"))
+	assert.False(f.matchCollection("app.bsky.graphx.follow"))
+}
+
+func TestConsumerFilterApply(t *testing.T) {
+	assert := assert.New(t)
+
+	commit := func(did string, paths ...string) *stream.XRPCStreamEvent {
+		c := &comatproto.SyncSubscribeRepos_Commit{Repo: did, Seq: 123}
+		for _, p := range paths {
+			c.Ops = append(c.Ops, &comatproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: p})
+		}
+		return &stream.XRPCStreamEvent{RepoCommit: c, Preserialized: []byte("dummy")}
+	}
+
+	// nil filter passes everything through
+	var nilFilter *ConsumerFilter
+	evt := commit("did:plc:abc123", "app.bsky.feed.like/3l3qo2vuowo2b")
+	out, dropped := nilFilter.Apply(evt)
+	assert.Equal(evt, out)
+	assert.Equal(0, dropped)
+
+	f, err := ParseConsumerFilter([]string{"app.bsky.feed.post"}, nil)
+	assert.NoError(err)
+
+	// all ops match: original event (and pre-serialized bytes) is re-used
+	evt = commit("did:plc:abc123", "app.bsky.feed.post/3l3qo2vuowo2b")
+	out, dropped = f.Apply(evt)
+	assert.Equal(evt, out)
+	assert.Equal(0, dropped)
+
+	// partial match: filtered copy, without pre-serialized bytes
+	evt = commit("did:plc:abc123", "app.bsky.feed.post/3l3qo2vuowo2b", "app.bsky.feed.like/3l3qo2vuowo2c")
+	out, dropped = f.Apply(evt)
+	assert.NotNil(out)
+	assert.Equal(1, dropped)
+	assert.Nil(out.Preserialized)
+	assert.Equal(1, len(out.RepoCommit.Ops))
+	assert.Equal(int64(123), out.RepoCommit.Seq)
+	assert.Equal(2, len(evt.RepoCommit.Ops))
+
+	// no match: event dropped
+	evt = commit("did:plc:abc123", "app.bsky.feed.like/3l3qo2vuowo2c")
+	out, dropped = f.Apply(evt)
+	assert.Nil(out)
+	assert.Equal(1, dropped)
+
+	// collection filter does not apply to non-commit events
+	acct := &stream.XRPCStreamEvent{RepoAccount: &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:abc123", Active: true}}
+	out, _ = f.Apply(acct)
+	assert.Equal(acct, out)
+
+	f, err = ParseConsumerFilter(nil, []string{"did:plc:abc123"})
+	assert.NoError(err)
+
+	evt = commit("did:plc:abc123", "app.bsky.feed.like/3l3qo2vuowo2c")
+	out, _ = f.Apply(evt)
+	assert.Equal(evt, out)
+
+	evt = commit("did:plc:other", "app.bsky.feed.like/3l3qo2vuowo2c")
+	out, dropped = f.Apply(evt)
+	assert.Nil(out)
+	assert.Equal(1, dropped)
+
+	ident := &stream.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:other"}}
+	out, _ = f.Apply(ident)
+	assert.Nil(out)
+
+	info := &stream.XRPCStreamEvent{RepoInfo: &comatproto.SyncSubscribeRepos_Info{Name: "OutdatedCursor"}}
+	out, _ = f.Apply(info)
+	assert.Equal(info, out)
+}
@@ -72,7 +72,12 @@ func (r *Relay) ListHosts(ctx context.Context, cursor int64, limit int, everActi
 }
 
 func (r *Relay) UpdateHostStatus(ctx context.Context, hostID uint64, status models.HostStatus) error {
-	return r.db.WithContext(ctx).Model(models.Host{}).Where("id = ?", hostID).Update("status", status).Error
+	if err := r.db.WithContext(ctx).Model(models.Host{}).Where("id = ?", hostID).Update("status", status).Error; err != nil {
+		return err
+	}
+	// host bans (and un-bans) apply to events relayed from upstream relays right away
+	r.pdsHostCache.Purge()
+	return nil
 }
 
 func (r *Relay) UpdateHostAccountLimit(ctx context.Context, hostID uint64, accountLimit int64) error {
@@ -1,9 +1,12 @@
 package relay
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
@@ -19,6 +22,8 @@ type HostChecker interface {
 	// host should be a URL, including scheme, hostname (and optional port), but no path segment
 	CheckHost(ctx context.Context, host string) error
 	FetchAccountStatus(ctx context.Context, ident *identity.Identity) (string, error)
+	// fetches full repo export (CAR file) from the account's PDS. caller must close the returned reader
+	FetchRepo(ctx context.Context, ident *identity.Identity) (io.ReadCloser, error)
 }
 
 var _ HostChecker = (*HostClient)(nil)
@@ -81,15 +86,46 @@ func (hc *HostClient) FetchAccountStatus(ctx context.Context, ident *identity.Id
 	}
 }
 
+func (hc *HostClient) FetchRepo(ctx context.Context, ident *identity.Identity) (io.ReadCloser, error) {
+	pdsEndpoint := ident.PDSEndpoint()
+	if pdsEndpoint == "" {
+		return nil, fmt.Errorf("account does not declare a PDS: %s", ident.DID)
+	}
+
+	u := fmt.Sprintf("%s/xrpc/com.atproto.sync.getRepo?did=%s", pdsEndpoint, url.QueryEscape(ident.DID.String()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", hc.UserAgent)
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+
+	// repo exports can be large; the regular client timeout is too short
+	client := *hc.Client
+	client.Timeout = 5 * time.Minute
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching repo: HTTP status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
 type MockHostChecker struct {
 	Hosts    map[string]bool
 	Accounts map[string]string
+	// repo CAR files, by DID
+	Repos map[string][]byte
 }
 
 func NewMockHostChecker() *MockHostChecker {
 	return &MockHostChecker{
 		Hosts:    make(map[string]bool),
 		Accounts: make(map[string]string),
+		Repos:    make(map[string][]byte),
 	}
 }
 
@@ -108,3 +144,11 @@ func (hc *MockHostChecker) FetchAccountStatus(ctx context.Context, ident *identi
 	}
 	return status, nil
 }
+
+func (hc *MockHostChecker) FetchRepo(ctx context.Context, ident *identity.Identity) (io.ReadCloser, error) {
+	b, ok := hc.Repos[ident.DID.String()]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
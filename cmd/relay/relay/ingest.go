@@ -19,7 +19,7 @@ import (
 // This callback function gets called by Slurper on every upstream repo stream message from any host.
 //
 // Messages are processed in-order for a single account on a single host; but may be concurrent or out-of-order for the same account *across* hosts (eg, during account migration or a conflict)
-func (r *Relay) processRepoEvent(ctx context.Context, evt *stream.XRPCStreamEvent, hostname string, hostID uint64) error {
+func (r *Relay) processRepoEvent(ctx context.Context, evt *stream.XRPCStreamEvent, hostname string, hostID uint64) (err error) {
 	ctx, span := tracer.Start(ctx, "processRepoEvent")
 	defer span.End()
 
@@ -30,6 +30,25 @@ func (r *Relay) processRepoEvent(ctx context.Context, evt *stream.XRPCStreamEven
 
 	EventsReceivedCounter.WithLabelValues(hostname).Add(1)
 
+	// when following upstream relays, the same event may arrive from multiple sources (including directly from the PDS)
+	if r.dedupeEnabled.Load() {
+		key := dedupeKey(evt)
+		if !r.dedupe.Claim(key) {
+			eventsDeduplicatedCounter.WithLabelValues(hostname).Add(1)
+			return nil
+		}
+		defer func() {
+			// allow a copy of the event from another source to be processed
+			if err != nil {
+				r.dedupe.Release(key)
+			}
+		}()
+	}
+
+	defer func() {
+		r.AccountEvents.Record(evt, hostname, err)
+	}()
+
 	switch {
 	case evt.RepoCommit != nil:
 		repoCommitsReceivedCounter.WithLabelValues(hostname).Add(1)
@@ -60,6 +79,10 @@ func (r *Relay) preProcessEvent(ctx context.Context, didStr string, hostname str
 	// TODO: add a test case for non-normalized DID
 	did = NormalizeDID(did)
 
+	if r.isUpstreamRelay(hostID) {
+		return r.preProcessRelayEvent(ctx, did, logger)
+	}
+
 	acc, err := r.GetAccount(ctx, did)
 	if err != nil {
 		if !errors.Is(err, ErrAccountNotFound) {
@@ -89,6 +112,11 @@ func (r *Relay) preProcessEvent(ctx context.Context, didStr string, hostname str
 	return acc, ident, nil
 }
 
+// drop reason for events on accounts which are not active, for AccountEventTracker
+func inactiveDropReason(acc *models.Account) string {
+	return fmt.Sprintf("account not active (status %s, upstream status %s)", acc.Status, acc.UpstreamStatus)
+}
+
 func (r *Relay) processCommitEvent(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit, hostname string, hostID uint64) error {
 	logger := r.Logger.With("did", evt.Repo, "seq", evt.Seq, "host", hostname, "eventType", "commit", "rev", evt.Rev)
 	logger.Debug("relay got commit event")
@@ -99,7 +127,21 @@ func (r *Relay) processCommitEvent(ctx context.Context, evt *comatproto.SyncSubs
 	}
 
 	if !acc.IsActive() {
+		// commits for accounts awaiting resync (eg, after being rate-limited) still count against the per-account limits, so that a noisy account can reach the quarantine threshold
+		if acc.Status == models.AccountStatusDesynchronized {
+			if allowed, quarantine := r.AccountLimiter.Allow(acc.DID); !allowed {
+				accountEventsRateLimitedCounter.WithLabelValues(hostname).Add(1)
+				if quarantine {
+					if err := r.QuarantineAccount(ctx, acc, "exceeded per-account event rate limits"); err != nil {
+						logger.Error("failed to quarantine account", "err", err)
+					}
+					r.AccountEvents.RecordDrop(evt.Repo, "commit", hostname, evt.Seq, "rate-limited (account quarantined)")
+					return nil
+				}
+			}
+		}
 		logger.Info("dropping commit message for non-active account", "status", acc.Status, "upstreamStatus", acc.UpstreamStatus)
+		r.AccountEvents.RecordDrop(evt.Repo, "commit", hostname, evt.Seq, inactiveDropReason(acc))
 		return nil
 	}
 
@@ -117,10 +159,32 @@ func (r *Relay) processCommitEvent(ctx context.Context, evt *comatproto.SyncSubs
 	if prevRepo != nil && prevRepo.Rev != "" && evt.Rev != "" {
 		if evt.Rev <= prevRepo.Rev {
 			logger.Warn("dropping commit with old rev", "prevRev", prevRepo.Rev)
+			r.AccountEvents.RecordDrop(evt.Repo, "commit", hostname, evt.Seq, fmt.Sprintf("old or duplicate rev (previous rev %s)", prevRepo.Rev))
 			return nil
 		}
 	}
 
+	// rate limits are checked after the stale rev check, so that replayed events don't count against the account
+	if allowed, quarantine := r.AccountLimiter.Allow(acc.DID); !allowed {
+		accountEventsRateLimitedCounter.WithLabelValues(hostname).Add(1)
+		if quarantine {
+			if err := r.QuarantineAccount(ctx, acc, "exceeded per-account event rate limits"); err != nil {
+				logger.Error("failed to quarantine account", "err", err)
+			}
+			r.AccountEvents.RecordDrop(evt.Repo, "commit", hostname, evt.Seq, "rate-limited (account quarantined)")
+			return nil
+		}
+		logger.Debug("dropping commit message for rate-limited account")
+		r.AccountEvents.RecordDrop(evt.Repo, "commit", hostname, evt.Seq, "rate-limited")
+		// the dropped commit breaks the commit chain for downstream consumers. mark the account desynchronized right away (so further commits are dropped cheaply), and resync once the rate limit window has passed
+		if r.resyncEnabled.Load() {
+			if err := r.requestResyncAt(ctx, acc, "commits dropped by per-account rate limit", time.Now().Add(time.Minute)); err != nil {
+				logger.Error("failed to request repo resync", "err", err)
+			}
+		}
+		return nil
+	}
+
 	// most commit validation happens in this method. Note that is handles lenient/strict modes.
 	newRepo, err := r.VerifyRepoCommit(ctx, evt, ident, prevRepo, hostname)
 	if err != nil {
@@ -128,11 +192,28 @@ func (r *Relay) processCommitEvent(ctx context.Context, evt *comatproto.SyncSubs
 		return err
 	}
 
+	// if the commit doesn't chain from the previous repo state, we have missed something; drop the commit and resync the full repo
+	if r.resyncEnabled.Load() && commitChainBroken(evt, prevRepo) {
+		commitChainBreaksCounter.WithLabelValues(hostname).Inc()
+		logger.Warn("commit chain broken, requesting repo resync", "prevData", evt.PrevData, "prevRepo.CommitDataCID", prevRepo.CommitDataCID)
+		if err := r.RequestResync(ctx, acc, "commit chain broken (prevData mismatch)"); err != nil {
+			logger.Error("failed to request repo resync", "err", err)
+		}
+		r.AccountEvents.RecordDrop(evt.Repo, "commit", hostname, evt.Seq, "commit chain broken (resync requested)")
+		return nil
+	}
+
 	err = r.UpsertAccountRepo(ctx, acc.UID, syntax.TID(newRepo.Rev), newRepo.CommitCID, newRepo.CommitDataCID)
 	if err != nil {
 		return fmt.Errorf("failed to upsert account repo (%s): %w", acc.DID, err)
 	}
 
+	if r.RepoStore != nil {
+		if err := r.RepoStore.ApplyCommit(ctx, acc.UID, evt, newRepo); err != nil {
+			logger.Warn("failed to apply commit to repo snapshot", "err", err)
+		}
+	}
+
 	// emit the event
 	// TODO: is this copy important?
 	commitCopy := *evt
@@ -159,6 +240,7 @@ func (r *Relay) processSyncEvent(ctx context.Context, evt *comatproto.SyncSubscr
 
 	if !acc.IsActive() {
 		logger.Info("dropping sync message for non-active account", "status", acc.Status, "upstreamStatus", acc.UpstreamStatus)
+		r.AccountEvents.RecordDrop(evt.Did, "sync", hostname, evt.Seq, inactiveDropReason(acc))
 		return nil
 	}
 
@@ -178,6 +260,13 @@ func (r *Relay) processSyncEvent(ctx context.Context, evt *comatproto.SyncSubscr
 		return fmt.Errorf("failed to upsert account repo (%s): %w", acc.DID, err)
 	}
 
+	// a #sync event resets repo state, so any local snapshot is no longer valid
+	if r.RepoStore != nil {
+		if err := r.RepoStore.Delete(ctx, acc.UID); err != nil {
+			logger.Warn("failed to drop repo snapshot", "err", err)
+		}
+	}
+
 	// emit the event
 	evtCopy := *evt
 	err = r.Events.AddEvent(ctx, &stream.XRPCStreamEvent{
@@ -259,6 +348,12 @@ func (r *Relay) processAccountEvent(ctx context.Context, evt *comatproto.SyncSub
 		acc.UpstreamStatus = newStatus
 	}
 
+	if newStatus == models.AccountStatusDeleted && r.RepoStore != nil {
+		if err := r.RepoStore.Delete(ctx, acc.UID); err != nil {
+			logger.Warn("failed to drop repo snapshot", "err", err)
+		}
+	}
+
 	// emit the event
 	err = r.Events.AddEvent(ctx, &stream.XRPCStreamEvent{
 		RepoAccount: &comatproto.SyncSubscribeRepos_Account{
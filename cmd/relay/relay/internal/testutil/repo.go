@@ -0,0 +1,105 @@
+// Helpers shared by the relay and repostore tests.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/repo/mst"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+)
+
+// Records full block CIDs as written (the underlying blockstore only indexes by multihash)
+type TrackingBlockstore struct {
+	blockstore.Blockstore
+	CIDs []cid.Cid
+}
+
+func (tb *TrackingBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	tb.CIDs = append(tb.CIDs, blk.Cid())
+	return tb.Blockstore.Put(ctx, blk)
+}
+
+// Incrementally builds a signed repo, and exports it as CAR bytes
+type Repo struct {
+	DID string
+	Key crypto.PrivateKey
+
+	bs    *TrackingBlockstore
+	tree  mst.Tree
+	clock syntax.TIDClock
+}
+
+// Creates an empty repo. If key is nil, a new signing key is generated.
+func NewRepo(t *testing.T, did string, key crypto.PrivateKey) *Repo {
+	if key == nil {
+		var err error
+		key, err = crypto.GeneratePrivateKeyK256()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Repo{
+		DID:   did,
+		Key:   key,
+		bs:    &TrackingBlockstore{Blockstore: blockstore.NewBlockstore(datastore.NewMapDatastore())},
+		tree:  mst.NewEmptyTree(),
+		clock: syntax.NewTIDClock(0),
+	}
+}
+
+func (tr *Repo) put(t *testing.T, b []byte) cid.Cid {
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum(b)
+	assert.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(b, c)
+	assert.NoError(t, err)
+	assert.NoError(t, tr.bs.Put(context.Background(), blk))
+	return c
+}
+
+// Adds (or replaces) an app.bsky.feed.post record
+func (tr *Repo) AddRecord(t *testing.T, rkey, text string) {
+	b, err := data.MarshalCBOR(map[string]any{"$type": "app.bsky.feed.post", "text": text})
+	assert.NoError(t, err)
+	_, err = tr.tree.Insert([]byte("app.bsky.feed.post/"+rkey), tr.put(t, b))
+	assert.NoError(t, err)
+}
+
+// Signs a new commit of the current tree. The returned CAR has the commit as root, and includes every block written so far (a superset of the repo).
+func (tr *Repo) Commit(t *testing.T) (cid.Cid, *repo.Commit, []byte) {
+	ctx := context.Background()
+	root, err := tr.tree.WriteDiffBlocks(ctx, tr.bs)
+	assert.NoError(t, err)
+	commit := repo.Commit{
+		DID:     tr.DID,
+		Version: repo.ATPROTO_REPO_VERSION,
+		Data:    *root,
+		Rev:     tr.clock.Next().String(),
+	}
+	assert.NoError(t, commit.Sign(tr.Key))
+	var buf bytes.Buffer
+	assert.NoError(t, commit.MarshalCBOR(&buf))
+	c := tr.put(t, buf.Bytes())
+
+	var out bytes.Buffer
+	assert.NoError(t, car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{c}, Version: 1}, &out))
+	for _, k := range tr.bs.CIDs {
+		blk, err := tr.bs.Get(ctx, k)
+		assert.NoError(t, err)
+		assert.NoError(t, carutil.LdWrite(&out, k.Bytes(), blk.RawData()))
+	}
+	return c, &commit, out.Bytes()
+}
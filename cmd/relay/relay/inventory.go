@@ -0,0 +1,279 @@
+package relay
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+
+	"gorm.io/gorm"
+)
+
+// Output format for inventory exports
+type InventoryFormat string
+
+const (
+	// newline-delimited JSON, one object per line, distinguished by the "type" field
+	InventoryFormatNDJSON = InventoryFormat("ndjson")
+	// CSV with a single header row. Header and signature lines start with '#', and should be treated as comments by CSV readers
+	InventoryFormatCSV = InventoryFormat("csv")
+)
+
+func ParseInventoryFormat(s string) (InventoryFormat, error) {
+	switch f := InventoryFormat(s); f {
+	case InventoryFormatNDJSON, InventoryFormatCSV:
+		return f, nil
+	case "":
+		return InventoryFormatNDJSON, nil
+	default:
+		return "", fmt.Errorf("unknown inventory format: %s", s)
+	}
+}
+
+// version of the inventory export format
+const inventoryVersion = 1
+
+// number of rows fetched from the database at a time
+const inventoryPageSize = 1000
+
+// First line of an inventory export
+type InventoryHeader struct {
+	Type      string `json:"type"` // "inventory"
+	Version   int    `json:"version"`
+	CreatedAt string `json:"createdAt"`
+
+	// did:key of the signing key; empty if the export is not signed
+	Key string `json:"key,omitempty"`
+}
+
+type InventoryHost struct {
+	Type         string `json:"type"` // "host"
+	ID           uint64 `json:"id"`
+	Hostname     string `json:"hostname"`
+	Status       string `json:"status"`
+	Relay        bool   `json:"relay"`
+	Trusted      bool   `json:"trusted"`
+	LastSeq      int64  `json:"lastSeq"`
+	AccountCount int64  `json:"accountCount"`
+}
+
+type InventoryAccount struct {
+	Type           string `json:"type"` // "account"
+	UID            uint64 `json:"uid"`
+	DID            string `json:"did"`
+	HostID         uint64 `json:"hostID"`
+	Hostname       string `json:"hostname"`
+	Status         string `json:"status"`
+	UpstreamStatus string `json:"upstreamStatus"`
+
+	// empty if no commit has been seen for the account
+	Rev       string `json:"rev,omitempty"`
+	CommitCID string `json:"commitCID,omitempty"`
+}
+
+// Last line of an inventory export. This line itself is not included in the digest.
+//
+// The signature is over the lowercase hex-encoded SHA-256 digest (as a string) of all preceding bytes of the export, using the standard atproto HashAndSign scheme, and is encoded as base64url (no padding).
+type InventorySignature struct {
+	Type   string `json:"type"` // "signature"
+	SHA256 string `json:"sha256"`
+	Key    string `json:"key,omitempty"`
+	Sig    string `json:"sig,omitempty"`
+}
+
+var inventoryCSVColumns = []string{"record", "id", "did", "hostname", "host_id", "status", "upstream_status", "rev", "commit_cid", "relay", "trusted", "last_seq", "account_count"}
+
+type inventoryWriter struct {
+	out    io.Writer
+	hasher hash.Hash
+	json   *json.Encoder
+	csv    *csv.Writer
+}
+
+func newInventoryWriter(w io.Writer, format InventoryFormat) *inventoryWriter {
+	iw := &inventoryWriter{
+		out:    w,
+		hasher: sha256.New(),
+	}
+	body := io.MultiWriter(w, iw.hasher)
+	switch format {
+	case InventoryFormatCSV:
+		iw.csv = csv.NewWriter(body)
+	default:
+		iw.json = json.NewEncoder(body)
+	}
+	return iw
+}
+
+func (iw *inventoryWriter) header(h *InventoryHeader) error {
+	if iw.csv != nil {
+		if err := iw.csv.Write([]string{"#inventory", strconv.Itoa(h.Version), h.CreatedAt, h.Key}); err != nil {
+			return err
+		}
+		return iw.csv.Write(inventoryCSVColumns)
+	}
+	return iw.json.Encode(h)
+}
+
+func (iw *inventoryWriter) host(h *InventoryHost) error {
+	if iw.csv != nil {
+		return iw.csv.Write([]string{"host", strconv.FormatUint(h.ID, 10), "", h.Hostname, strconv.FormatUint(h.ID, 10), h.Status, "", "", "", strconv.FormatBool(h.Relay), strconv.FormatBool(h.Trusted), strconv.FormatInt(h.LastSeq, 10), strconv.FormatInt(h.AccountCount, 10)})
+	}
+	return iw.json.Encode(h)
+}
+
+func (iw *inventoryWriter) account(a *InventoryAccount) error {
+	if iw.csv != nil {
+		return iw.csv.Write([]string{"account", strconv.FormatUint(a.UID, 10), a.DID, a.Hostname, strconv.FormatUint(a.HostID, 10), a.Status, a.UpstreamStatus, a.Rev, a.CommitCID, "", "", "", ""})
+	}
+	return iw.json.Encode(a)
+}
+
+// flushes the body, and writes the signature line (which is not included in the digest)
+func (iw *inventoryWriter) finish(signer crypto.PrivateKey, key string) error {
+	if iw.csv != nil {
+		iw.csv.Flush()
+		if err := iw.csv.Error(); err != nil {
+			return err
+		}
+	}
+
+	trailer := InventorySignature{
+		Type:   "signature",
+		SHA256: hex.EncodeToString(iw.hasher.Sum(nil)),
+	}
+	if signer != nil {
+		sig, err := signer.HashAndSign([]byte(trailer.SHA256))
+		if err != nil {
+			return fmt.Errorf("signing inventory: %w", err)
+		}
+		trailer.Key = key
+		trailer.Sig = base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	if iw.csv != nil {
+		cw := csv.NewWriter(iw.out)
+		if err := cw.Write([]string{"#signature", trailer.SHA256, trailer.Key, trailer.Sig}); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return json.NewEncoder(iw.out).Encode(&trailer)
+}
+
+type inventoryAccountRow struct {
+	UID            uint64               `gorm:"column:uid"`
+	DID            string               `gorm:"column:did"`
+	HostID         uint64               `gorm:"column:host_id"`
+	Status         models.AccountStatus `gorm:"column:status"`
+	UpstreamStatus models.AccountStatus `gorm:"column:upstream_status"`
+	Rev            *string              `gorm:"column:rev"`
+	CommitCID      *string              `gorm:"column:commit_cid"`
+}
+
+// Writes a snapshot of all hosts and accounts to `w`. Hosts are written first (ordered by ID), then accounts (ordered by UID), followed by a trailing signature line.
+//
+// The snapshot is read within a single read-only transaction, so it is consistent even though it is paginated. If `signer` is nil, the trailer contains only the digest.
+func (r *Relay) ExportInventory(ctx context.Context, w io.Writer, format InventoryFormat, signer crypto.PrivateKey) error {
+
+	var key string
+	if signer != nil {
+		pub, err := signer.PublicKey()
+		if err != nil {
+			return err
+		}
+		key = pub.DIDKey()
+	}
+
+	iw := newInventoryWriter(w, format)
+	if err := iw.header(&InventoryHeader{
+		Type:      "inventory",
+		Version:   inventoryVersion,
+		CreatedAt: syntax.DatetimeNow().String(),
+		Key:       key,
+	}); err != nil {
+		return err
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		hostnames := make(map[uint64]string)
+		cursor := int64(0)
+		for {
+			// same pagination as ListHosts, but including hosts which have never been active
+			hosts := []*models.Host{}
+			if err := tx.Model(&models.Host{}).Where("id > ?", cursor).Order("id").Limit(inventoryPageSize).Find(&hosts).Error; err != nil {
+				return err
+			}
+			for _, h := range hosts {
+				hostnames[h.ID] = h.Hostname
+				if err := iw.host(&InventoryHost{
+					Type:         "host",
+					ID:           h.ID,
+					Hostname:     h.Hostname,
+					Status:       string(h.Status),
+					Relay:        h.Relay,
+					Trusted:      h.Trusted,
+					LastSeq:      h.LastSeq,
+					AccountCount: h.AccountCount,
+				}); err != nil {
+					return err
+				}
+			}
+			if len(hosts) < inventoryPageSize {
+				break
+			}
+			cursor = int64(hosts[len(hosts)-1].ID)
+		}
+
+		cursor = 0
+		for {
+			// like ListAccounts, but all accounts regardless of status, and joined with repo state
+			rows := []inventoryAccountRow{}
+			if err := tx.Raw("SELECT account.uid, account.did, account.host_id, account.status, account.upstream_status, account_repo.rev, account_repo.commit_cid FROM account LEFT JOIN account_repo ON account_repo.uid = account.uid WHERE account.uid > ? ORDER BY account.uid LIMIT ?", cursor, inventoryPageSize).Scan(&rows).Error; err != nil {
+				return err
+			}
+			for _, row := range rows {
+				acc := InventoryAccount{
+					Type:           "account",
+					UID:            row.UID,
+					DID:            row.DID,
+					HostID:         row.HostID,
+					Hostname:       hostnames[row.HostID],
+					Status:         string(row.Status),
+					UpstreamStatus: string(row.UpstreamStatus),
+				}
+				if row.Rev != nil {
+					acc.Rev = *row.Rev
+				}
+				if row.CommitCID != nil {
+					acc.CommitCID = *row.CommitCID
+				}
+				if err := iw.account(&acc); err != nil {
+					return err
+				}
+			}
+			if len(rows) < inventoryPageSize {
+				break
+			}
+			cursor = int64(rows[len(rows)-1].UID)
+		}
+		return nil
+	}, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return err
+	}
+
+	return iw.finish(signer, key)
+}
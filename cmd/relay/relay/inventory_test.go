@@ -0,0 +1,120 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testInventoryRelay(t *testing.T) *Relay {
+	db, err := cliutil.SetupDatabase("sqlite://:memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Relay{db: db}
+	if err := r.MigrateDatabase(); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := []models.Host{
+		{ID: 1, Hostname: "pds.example.com", Status: models.HostStatusActive, LastSeq: 100, AccountCount: 2},
+		{ID: 2, Hostname: "relay.example.com", Status: models.HostStatusActive, Relay: true, LastSeq: -1},
+	}
+	accounts := []models.Account{
+		{UID: 1, DID: "did:plc:one", HostID: 1, Status: models.AccountStatusActive, UpstreamStatus: models.AccountStatusActive},
+		{UID: 2, DID: "did:plc:two", HostID: 1, Status: models.AccountStatusTakendown, UpstreamStatus: models.AccountStatusActive},
+	}
+	assert.NoError(t, db.Create(&hosts).Error)
+	assert.NoError(t, db.Create(&accounts).Error)
+	assert.NoError(t, r.UpsertAccountRepo(context.Background(), 1, "3lbdw7ubypk2z", "bafyreicommit", "bafyreidata"))
+	return r
+}
+
+// splits an export in to the signed body and the trailing signature line
+func splitInventory(t *testing.T, export []byte) ([]byte, []byte) {
+	trimmed := bytes.TrimSuffix(export, []byte("\n"))
+	idx := bytes.LastIndexByte(trimmed, '\n')
+	if idx < 0 {
+		t.Fatal("export has no signature line")
+	}
+	return export[:idx+1], trimmed[idx+1:]
+}
+
+func TestExportInventoryNDJSON(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	r := testInventoryRelay(t)
+
+	priv, err := crypto.GeneratePrivateKeyP256()
+	assert.NoError(err)
+	pub, err := priv.PublicKey()
+	assert.NoError(err)
+
+	var buf bytes.Buffer
+	assert.NoError(r.ExportInventory(ctx, &buf, InventoryFormatNDJSON, priv))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 6)
+
+	var hdr InventoryHeader
+	assert.NoError(json.Unmarshal([]byte(lines[0]), &hdr))
+	assert.Equal(pub.DIDKey(), hdr.Key)
+
+	var host InventoryHost
+	assert.NoError(json.Unmarshal([]byte(lines[2]), &host))
+	assert.Equal("relay.example.com", host.Hostname)
+	assert.True(host.Relay)
+
+	var acc InventoryAccount
+	assert.NoError(json.Unmarshal([]byte(lines[3]), &acc))
+	assert.Equal("did:plc:one", acc.DID)
+	assert.Equal("pds.example.com", acc.Hostname)
+	assert.Equal("bafyreicommit", acc.CommitCID)
+	var takendown InventoryAccount
+	assert.NoError(json.Unmarshal([]byte(lines[4]), &takendown))
+	assert.Equal("takendown", takendown.Status)
+	assert.Empty(takendown.Rev)
+
+	body, trailer := splitInventory(t, buf.Bytes())
+	var sig InventorySignature
+	assert.NoError(json.Unmarshal(trailer, &sig))
+	digest := sha256.Sum256(body)
+	assert.Equal(hex.EncodeToString(digest[:]), sig.SHA256)
+	sigBytes, err := base64.RawURLEncoding.DecodeString(sig.Sig)
+	assert.NoError(err)
+	assert.NoError(pub.HashAndVerify([]byte(sig.SHA256), sigBytes))
+}
+
+func TestExportInventoryCSV(t *testing.T) {
+	assert := assert.New(t)
+	r := testInventoryRelay(t)
+
+	var buf bytes.Buffer
+	assert.NoError(r.ExportInventory(context.Background(), &buf, InventoryFormatCSV, nil))
+
+	cr := csv.NewReader(bytes.NewReader(buf.Bytes()))
+	cr.Comment = '#'
+	rows, err := cr.ReadAll()
+	assert.NoError(err)
+	assert.Len(rows, 5)
+	assert.Equal(inventoryCSVColumns, rows[0])
+	assert.Equal("host", rows[1][0])
+	assert.Equal([]string{"account", "1", "did:plc:one", "pds.example.com", "1", "active", "active", "3lbdw7ubypk2z", "bafyreicommit", "", "", "", ""}, rows[3])
+
+	// unsigned exports still include the digest
+	body, trailer := splitInventory(t, buf.Bytes())
+	digest := sha256.Sum256(body)
+	assert.Equal("#signature,"+hex.EncodeToString(digest[:])+",,", string(trailer))
+}
@@ -0,0 +1,189 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+)
+
+// "jetstream-style" JSON representation of a firehose event, with records decoded from CAR blocks.
+//
+// The `seq` field is the relay sequence number, and has the same cursor semantics as the CBOR firehose.
+type JSONEvent struct {
+	Seq  int64  `json:"seq,omitempty"`
+	DID  string `json:"did,omitempty"`
+	Time string `json:"time,omitempty"`
+	// one of: commit, sync, identity, account, info, error
+	Kind string `json:"kind"`
+
+	Commit   *JSONCommit                             `json:"commit,omitempty"`
+	Sync     *JSONSync                               `json:"sync,omitempty"`
+	Identity *comatproto.SyncSubscribeRepos_Identity `json:"identity,omitempty"`
+	Account  *comatproto.SyncSubscribeRepos_Account  `json:"account,omitempty"`
+	Info     *comatproto.SyncSubscribeRepos_Info     `json:"info,omitempty"`
+	Error    *JSONError                              `json:"error,omitempty"`
+}
+
+type JSONCommit struct {
+	Rev   string  `json:"rev"`
+	Since *string `json:"since,omitempty"`
+	// CID of the commit object
+	CID string         `json:"cid"`
+	Ops []JSONCommitOp `json:"ops"`
+}
+
+type JSONCommitOp struct {
+	// one of: create, update, delete
+	Action     string `json:"action"`
+	Collection string `json:"collection"`
+	RKey       string `json:"rkey"`
+	CID        string `json:"cid,omitempty"`
+	// decoded record data; nil for deletions, or if the record block was missing or could not be parsed
+	Record map[string]any `json:"record,omitempty"`
+
+	// set if the op could not be converted: the raw repo path is in Path (and Collection and RKey are empty), and Error describes the problem
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type JSONSync struct {
+	Rev string `json:"rev"`
+}
+
+type JSONError struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+}
+
+// Converts a firehose event to JSON representation. Records in commit events are decoded from the CAR slice in `blocks`.
+//
+// Problems with individual commit ops (eg, an invalid repo path, or a record which can't be decoded) are reported on the op, instead of failing the whole event.
+//
+// Returns nil (and no error) for event types which have no JSON representation.
+func EventToJSON(evt *stream.XRPCStreamEvent) (*JSONEvent, error) {
+	switch {
+	case evt.RepoCommit != nil:
+		return &JSONEvent{
+			Seq:    evt.RepoCommit.Seq,
+			DID:    evt.RepoCommit.Repo,
+			Time:   evt.RepoCommit.Time,
+			Kind:   "commit",
+			Commit: commitToJSON(evt.RepoCommit),
+		}, nil
+	case evt.RepoSync != nil:
+		return &JSONEvent{
+			Seq:  evt.RepoSync.Seq,
+			DID:  evt.RepoSync.Did,
+			Time: evt.RepoSync.Time,
+			Kind: "sync",
+			Sync: &JSONSync{Rev: evt.RepoSync.Rev},
+		}, nil
+	case evt.RepoIdentity != nil:
+		return &JSONEvent{
+			Seq:      evt.RepoIdentity.Seq,
+			DID:      evt.RepoIdentity.Did,
+			Time:     evt.RepoIdentity.Time,
+			Kind:     "identity",
+			Identity: evt.RepoIdentity,
+		}, nil
+	case evt.RepoAccount != nil:
+		return &JSONEvent{
+			Seq:     evt.RepoAccount.Seq,
+			DID:     evt.RepoAccount.Did,
+			Time:    evt.RepoAccount.Time,
+			Kind:    "account",
+			Account: evt.RepoAccount,
+		}, nil
+	case evt.RepoInfo != nil:
+		return &JSONEvent{
+			Kind: "info",
+			Info: evt.RepoInfo,
+		}, nil
+	case evt.Error != nil:
+		return &JSONEvent{
+			Kind:  "error",
+			Error: &JSONError{Error: evt.Error.Error, Message: evt.Error.Message},
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Serializes an event as JSON bytes. See EventToJSON.
+func MarshalEventJSON(evt *stream.XRPCStreamEvent) ([]byte, error) {
+	je, err := EventToJSON(evt)
+	if err != nil || je == nil {
+		return nil, err
+	}
+	return json.Marshal(je)
+}
+
+func commitToJSON(evt *comatproto.SyncSubscribeRepos_Commit) *JSONCommit {
+	out := JSONCommit{
+		Rev:   evt.Rev,
+		Since: evt.Since,
+		CID:   evt.Commit.String(),
+		Ops:   make([]JSONCommitOp, 0, len(evt.Ops)),
+	}
+
+	var blks map[cid.Cid][]byte
+	var blksErr error
+	if len(evt.Blocks) > 0 {
+		blks, blksErr = readCARBlocks(evt.Blocks)
+	}
+
+	for _, op := range evt.Ops {
+		jop := JSONCommitOp{
+			Action: op.Action,
+		}
+		collection, rkey, err := syntax.ParseRepoPath(op.Path)
+		if err != nil {
+			jop.Path = op.Path
+			jop.Error = fmt.Sprintf("invalid repo op path: %s", err)
+		} else {
+			jop.Collection = collection.String()
+			jop.RKey = rkey.String()
+		}
+		if op.Cid != nil {
+			jop.CID = op.Cid.String()
+			if raw, ok := blks[cid.Cid(*op.Cid)]; ok {
+				rec, err := data.UnmarshalCBOR(raw)
+				if err == nil {
+					jop.Record = rec
+				}
+			} else if blksErr != nil && jop.Error == "" {
+				jop.Error = fmt.Sprintf("reading commit blocks: %s", blksErr)
+			}
+		}
+		out.Ops = append(out.Ops, jop)
+	}
+	return &out
+}
+
+func readCARBlocks(b []byte) (map[cid.Cid][]byte, error) {
+	cr, err := car.NewCarReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	blks := make(map[cid.Cid][]byte)
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		blks[blk.Cid()] = blk.RawData()
+	}
+	return blks, nil
+}
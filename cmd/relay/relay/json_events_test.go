@@ -0,0 +1,99 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventToJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	rec := map[string]any{
+		"$type":     "app.bsky.feed.post",
+		"text":      "hello world",
+		"createdAt": "2024-01-01T00:00:00.000Z",
+	}
+	recBytes, err := data.MarshalCBOR(rec)
+	assert.NoError(err)
+	recCID, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum(recBytes)
+	assert.NoError(err)
+
+	var buf bytes.Buffer
+	assert.NoError(car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{recCID}, Version: 1}, &buf))
+	assert.NoError(carutil.LdWrite(&buf, recCID.Bytes(), recBytes))
+
+	evt := &stream.XRPCStreamEvent{
+		RepoCommit: &comatproto.SyncSubscribeRepos_Commit{
+			Repo:   "did:plc:abc123",
+			Rev:    "3l3qo2vuowo2b",
+			Seq:    123,
+			Time:   "2024-01-01T00:00:00.000Z",
+			Commit: lexutil.LexLink(recCID),
+			Blocks: buf.Bytes(),
+			Ops: []*comatproto.SyncSubscribeRepos_RepoOp{
+				{Action: "create", Path: "app.bsky.feed.post/3l3qo2vuowo2b", Cid: (*lexutil.LexLink)(&recCID)},
+				{Action: "delete", Path: "app.bsky.feed.like/3l3qo2vuowo2c"},
+			},
+		},
+	}
+
+	je, err := EventToJSON(evt)
+	assert.NoError(err)
+	assert.Equal("commit", je.Kind)
+	assert.Equal(int64(123), je.Seq)
+	assert.Equal("did:plc:abc123", je.DID)
+	assert.Equal(2, len(je.Commit.Ops))
+	assert.Equal("app.bsky.feed.post", je.Commit.Ops[0].Collection)
+	assert.Equal(recCID.String(), je.Commit.Ops[0].CID)
+	assert.Equal("hello world", je.Commit.Ops[0].Record["text"])
+	assert.Equal("delete", je.Commit.Ops[1].Action)
+	assert.Nil(je.Commit.Ops[1].Record)
+
+	b, err := MarshalEventJSON(evt)
+	assert.NoError(err)
+	var generic map[string]any
+	assert.NoError(json.Unmarshal(b, &generic))
+	assert.Equal("commit", generic["kind"])
+
+	// a bad op is flagged, and the rest of the commit is still converted
+	evt.RepoCommit.Ops = append(evt.RepoCommit.Ops, &comatproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: "not-a-path", Cid: (*lexutil.LexLink)(&recCID)})
+	je, err = EventToJSON(evt)
+	assert.NoError(err)
+	assert.Equal(3, len(je.Commit.Ops))
+	assert.Equal("hello world", je.Commit.Ops[0].Record["text"])
+	assert.Empty(je.Commit.Ops[0].Error)
+	assert.Equal("not-a-path", je.Commit.Ops[2].Path)
+	assert.Empty(je.Commit.Ops[2].Collection)
+	assert.NotEmpty(je.Commit.Ops[2].Error)
+
+	// unreadable blocks only affect records
+	evt.RepoCommit.Blocks = []byte("garbage")
+	je, err = EventToJSON(evt)
+	assert.NoError(err)
+	assert.Equal(3, len(je.Commit.Ops))
+	assert.Nil(je.Commit.Ops[0].Record)
+	assert.NotEmpty(je.Commit.Ops[0].Error)
+
+	handle := "handle.example.com"
+	je, err = EventToJSON(&stream.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:abc123", Seq: 124, Handle: &handle}})
+	assert.NoError(err)
+	assert.Equal("identity", je.Kind)
+	assert.Equal(int64(124), je.Seq)
+
+	je, err = EventToJSON(&stream.XRPCStreamEvent{Error: &stream.ErrorFrame{Error: "ConsumerTooSlow"}})
+	assert.NoError(err)
+	assert.Equal("error", je.Kind)
+	assert.Equal("ConsumerTooSlow", je.Error.Error)
+}
@@ -31,11 +31,46 @@ var repoSyncReceivedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "The total number of sync events received",
 }, []string{"pds"})
 
+var eventsDeduplicatedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_deduplicated_counter",
+	Help: "The total number of events dropped because they were already received from another upstream",
+}, []string{"pds"})
+
+var accountEventsRateLimitedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "relay_account_events_rate_limited",
+	Help: "The total number of events dropped due to per-account rate limits",
+}, []string{"pds"})
+
+var accountsQuarantinedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "relay_accounts_quarantined",
+	Help: "The total number of accounts automatically quarantined for exceeding rate limits",
+})
+
+var commitChainBreaksCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "relay_commit_chain_breaks",
+	Help: "The total number of commit messages which did not chain from the previous repo state (prevData mismatch)",
+}, []string{"pds"})
+
+var resyncAttemptsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "relay_resync_attempts",
+	Help: "The total number of full repo resync attempts",
+}, []string{"result"})
+
 var eventsSentCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "events_sent_counter",
 	Help: "The total number of events sent to consumers",
 }, []string{"remote_addr", "user_agent"})
 
+var eventsEncodeFailedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "relay_events_encode_failed",
+	Help: "The total number of events skipped for a consumer because they could not be encoded in the consumer's format",
+}, []string{"format"})
+
+var consumerLagGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "consumer_lag_events",
+	Help: "Number of events between the most recently broadcast event and the last event delivered to each consumer",
+}, []string{"consumer_id", "remote_addr", "user_agent"})
+
 /* NOTE: not implemented in this version of relay
 var externalUserCreationAttempts = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "relay_external_user_creation_attempts",
@@ -24,7 +24,7 @@ func (h *Host) SubscribeReposURL() string {
 
 func (a *Account) AccountStatus() AccountStatus {
 	if a.Status != AccountStatusActive {
-		if a.Status == AccountStatusHostThrottled {
+		if a.Status == AccountStatusHostThrottled || a.Status == AccountStatusQuarantined {
 			return AccountStatusThrottled
 		}
 		return a.Status
@@ -29,3 +29,19 @@ func TestHostURLs(t *testing.T) {
 	assert.Equal("http://localhost:4321", lh.BaseURL())
 	assert.Equal("ws://localhost:4321/xrpc/com.atproto.sync.subscribeRepos", lh.SubscribeReposURL())
 }
+
+func TestAccountStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	acc := Account{
+		Status:         AccountStatusQuarantined,
+		UpstreamStatus: AccountStatusActive,
+	}
+	assert.False(acc.IsActive())
+	assert.Equal(AccountStatusThrottled, acc.AccountStatus())
+	assert.Equal("throttled", *acc.StatusField())
+
+	acc.Status = AccountStatusActive
+	assert.True(acc.IsActive())
+	assert.Nil(acc.StatusField())
+}
@@ -41,6 +41,9 @@ type Host struct {
 	// indicates this is a highly trusted host (PDS), and different rate limits apply
 	Trusted bool `gorm:"column:trusted;default:false" json:"trusted"`
 
+	// indicates this host is an upstream relay (not a PDS). Events are accepted for accounts on any PDS, and are de-duplicated against other upstreams
+	Relay bool `gorm:"column:relay;default:false" json:"relay"`
+
 	Status HostStatus `gorm:"column:status;default:active" json:"status"`
 
 	// the last sequence number persisted for this host. updated periodically, and at shutdown. negative number indicates no sequence recorded
@@ -68,6 +71,9 @@ var (
 	AccountStatusThrottled      = AccountStatus("throttled")
 	AccountStatusHostThrottled  = AccountStatus("host-throttled")
 
+	// local status for accounts which repeatedly exceeded per-account event rate limits. Publicly reported as "throttled"
+	AccountStatusQuarantined = AccountStatus("quarantined")
+
 	// generic "not active, but not known" status
 	AccountStatusInactive = AccountStatus("inactive")
 )
@@ -102,3 +108,70 @@ type AccountRepo struct {
 func (AccountRepo) TableName() string {
 	return "account_repo"
 }
+
+// Records why and when an account was automatically quarantined. Rows are removed when the quarantine is released.
+type AccountQuarantine struct {
+	// references Account.UID, but not set up as a foreign key
+	UID uint64 `gorm:"column:uid;primarykey" json:"uid"`
+	DID string `gorm:"column:did;not null" json:"did"`
+
+	// CreatedAt is automatically managed by gorm (by convention)
+	CreatedAt time.Time `json:"createdAt"`
+
+	Reason string `gorm:"column:reason" json:"reason"`
+
+	// number of events dropped due to rate limits in the window leading up to quarantine
+	DroppedEvents int64 `gorm:"column:dropped_events" json:"droppedEvents"`
+}
+
+func (AccountQuarantine) TableName() string {
+	return "account_quarantine"
+}
+
+// Tracks an account which needs a full repo resync from its PDS (eg, because the commit chain broke). Rows are removed when resync succeeds, or is no longer needed. After too many failed attempts, rows are "parked": no further attempts are made until resync is requested again.
+type AccountResync struct {
+	// references Account.UID, but not set up as a foreign key
+	UID uint64 `gorm:"column:uid;primarykey" json:"uid"`
+	DID string `gorm:"column:did;not null" json:"did"`
+
+	// these fields are automatically managed by gorm (by convention)
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Reason        string    `gorm:"column:reason" json:"reason"`
+	Attempts      int       `gorm:"column:attempts;not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time `gorm:"column:next_attempt_at;index" json:"nextAttemptAt"`
+	LastError     string    `gorm:"column:last_error" json:"lastError,omitempty"`
+	Parked        bool      `gorm:"column:parked;not null;default:false" json:"parked"`
+}
+
+func (AccountResync) TableName() string {
+	return "account_resync"
+}
+
+// Metadata about a locally-stored full repository snapshot. Only used if the relay is configured to persist repos; the blocks themselves are stored separately (not in the SQL database).
+type RepoSnapshot struct {
+	// references Account.UID, but not set up as a foreign key
+	UID uint64 `gorm:"column:uid;primarykey" json:"uid"`
+
+	// these fields are automatically managed by gorm (by convention)
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Rev           string `gorm:"column:rev;not null" json:"rev"`
+	CommitCID     string `gorm:"column:commit_cid;not null" json:"commitCID"`
+	CommitDataCID string `gorm:"column:commit_data_cid;not null" json:"commitDataCID"`
+
+	// total size of block data as of last compaction
+	StoredBytes int64 `gorm:"column:stored_bytes;not null;default:0" json:"storedBytes"`
+
+	// size of block data written since last compaction (some of which may be garbage)
+	DirtyBytes int64 `gorm:"column:dirty_bytes;not null;default:0" json:"dirtyBytes"`
+
+	// used for least-recently-used eviction. updated when snapshot is served
+	LastAccessedAt time.Time `gorm:"column:last_accessed_at;index" json:"lastAccessedAt"`
+}
+
+func (RepoSnapshot) TableName() string {
+	return "repo_snapshot"
+}
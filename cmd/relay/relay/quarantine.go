@@ -0,0 +1,85 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrAccountNotQuarantined = errors.New("account is not quarantined")
+
+// Marks an account as quarantined (a local status), which causes further events from the account to be dropped until an admin releases it. An `#account` event is emitted.
+//
+// Accounts which are "desynchronized" (awaiting a resync) can also be quarantined, and the pending resync is then skipped. Accounts with any other local status than "active" (eg, takedowns) are not modified.
+func (r *Relay) QuarantineAccount(ctx context.Context, acc *models.Account, reason string) error {
+	if acc.Status != models.AccountStatusActive && acc.Status != models.AccountStatusDesynchronized {
+		return nil
+	}
+
+	row := models.AccountQuarantine{
+		UID:    acc.UID,
+		DID:    acc.DID,
+		Reason: reason,
+	}
+	if st := r.AccountLimiter.Status(acc.DID); st != nil {
+		row.DroppedEvents = st.Dropped
+	}
+	if err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+		return err
+	}
+
+	if err := r.UpdateAccountLocalStatus(ctx, syntax.DID(acc.DID), models.AccountStatusQuarantined, true); err != nil {
+		return err
+	}
+	accountsQuarantinedCounter.Inc()
+	r.Logger.Warn("quarantined account", "did", acc.DID, "reason", reason, "droppedEvents", row.DroppedEvents)
+	return nil
+}
+
+// Returns quarantine metadata for the account. Returns ErrAccountNotQuarantined if there is none.
+func (r *Relay) GetAccountQuarantine(ctx context.Context, uid uint64) (*models.AccountQuarantine, error) {
+	var row models.AccountQuarantine
+	if err := r.db.WithContext(ctx).First(&row, uid).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccountNotQuarantined
+		}
+		return nil, err
+	}
+	return &row, nil
+}
+
+// Lists quarantined accounts, ordered by UID ascending
+func (r *Relay) ListAccountQuarantines(ctx context.Context, cursor int64, limit int) ([]*models.AccountQuarantine, error) {
+	rows := []*models.AccountQuarantine{}
+	if err := r.db.WithContext(ctx).Model(&models.AccountQuarantine{}).Where("uid > ?", cursor).Order("uid").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Releases a quarantined account back to "active" status (emitting an `#account` event), and resets its rate limit state.
+func (r *Relay) ReleaseAccountQuarantine(ctx context.Context, did syntax.DID) error {
+	acc, err := r.GetAccount(ctx, did)
+	if err != nil {
+		return err
+	}
+	if acc.Status != models.AccountStatusQuarantined {
+		return fmt.Errorf("%w: status is %s", ErrAccountNotQuarantined, acc.Status)
+	}
+
+	if err := r.UpdateAccountLocalStatus(ctx, did, models.AccountStatusActive, true); err != nil {
+		return err
+	}
+	if err := r.db.WithContext(ctx).Delete(&models.AccountQuarantine{}, acc.UID).Error; err != nil {
+		return err
+	}
+	r.AccountLimiter.Reset(acc.DID)
+	r.Logger.Info("released account from quarantine", "did", did)
+	return nil
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/repostore"
 	"github.com/bluesky-social/indigo/cmd/relay/stream/eventmgr"
 
 	"github.com/RussellLuo/slidingwindow"
@@ -35,6 +37,31 @@ type Relay struct {
 	accountCache *lru.Cache[string, *models.Account]
 
 	HostPerDayLimiter *slidingwindow.Limiter
+
+	// Per-account event rate limits (in addition to per-host limits in Slurper)
+	AccountLimiter *AccountLimiter
+	AccountEvents  *AccountEventTracker
+
+	// Optional local storage of full repo snapshots. nil if not enabled
+	RepoStore *repostore.RepoStore
+
+	// bounds concurrent repo snapshot fetches from upstream hosts
+	snapshotFetchLimiter chan struct{}
+
+	// IDs of subscribed hosts which are upstream relays (not PDS instances)
+	upstreamRelays sync.Map
+
+	// hostname to host ID and ban status, for PDS hosts of accounts seen via upstream relays. Purged when host or domain bans change
+	pdsHostCache *lru.Cache[string, pdsHostEntry]
+
+	// resync subsystem state; see resync.go
+	resyncEnabled  atomic.Bool
+	resyncNotify   chan struct{}
+	resyncInflight sync.Map
+
+	// events are only de-duplicated once any upstream relay has been subscribed
+	dedupeEnabled atomic.Bool
+	dedupe        *EventDeduper
 }
 
 type RelayConfig struct {
@@ -46,6 +73,26 @@ type RelayConfig struct {
 	TrustedDomains        []string
 	HostPerDayLimit       int64
 
+	// Per-account #commit event rate limits; zero disables (the default). Accounts with too many events dropped get quarantined
+	AccountPerMinuteLimit      int64
+	AccountPerHourLimit        int64
+	AccountQuarantineThreshold int64
+
+	// Number of concurrent workers fetching full repos to resync accounts whose commit chain broke
+	ResyncWorkers int
+
+	// Maximum size of a repo CAR file fetched for resync
+	ResyncMaxRepoBytes int64
+
+	// Number of failed resync attempts before an account is parked (left desynchronized, with no further attempts); zero means retry forever
+	ResyncMaxAttempts int
+
+	// Number of recent events remembered for de-duplication across upstream relays
+	DedupeCacheSize int
+
+	// How to handle firehose consumers which fall behind, if they don't request a specific policy
+	SlowConsumerPolicy eventmgr.SlowConsumerPolicy
+
 	// If true, skip validation that messages for a given account (DID) are coming from the expected upstream host (PDS). Currently only used in tests; might be used for intermediate relays in the future.
 	SkipAccountHostCheck bool
 }
@@ -58,6 +105,11 @@ func DefaultRelayConfig() *RelayConfig {
 		TrustedRepoLimit:   10_000_000,
 		ConcurrencyPerHost: 40,
 		HostPerDayLimit:    50,
+		DedupeCacheSize:    1_000_000,
+		SlowConsumerPolicy: eventmgr.SlowConsumerDisconnect,
+		ResyncWorkers:      4,
+		ResyncMaxRepoBytes: 512 * 1024 * 1024,
+		ResyncMaxAttempts:  10,
 	}
 }
 
@@ -68,6 +120,7 @@ func NewRelay(db *gorm.DB, evtman *eventmgr.EventManager, dir identity.Directory
 	}
 
 	uc, _ := lru.New[string, *models.Account](2_000_000)
+	phc, _ := lru.New[string, pdsHostEntry](100_000)
 
 	hc := NewHostClient(config.UserAgent)
 
@@ -85,8 +138,21 @@ func NewRelay(db *gorm.DB, evtman *eventmgr.EventManager, dir identity.Directory
 		consumers:   make(map[uint64]*SocketConsumer),
 
 		accountCache: uc,
+		pdsHostCache: phc,
 
 		HostPerDayLimiter: perDayLimiter(config.HostPerDayLimit),
+		AccountLimiter: NewAccountLimiter(AccountLimiterConfig{
+			PerMinute:           config.AccountPerMinuteLimit,
+			PerHour:             config.AccountPerHourLimit,
+			QuarantineThreshold: config.AccountQuarantineThreshold,
+		}),
+		AccountEvents: NewAccountEventTracker(0),
+
+		snapshotFetchLimiter: make(chan struct{}, 8),
+
+		dedupe: NewEventDeduper(config.DedupeCacheSize),
+
+		resyncNotify: make(chan struct{}, 1),
 	}
 
 	if err := r.MigrateDatabase(); err != nil {
@@ -122,6 +188,12 @@ func (r *Relay) MigrateDatabase() error {
 	if err := r.db.AutoMigrate(models.AccountRepo{}); err != nil {
 		return err
 	}
+	if err := r.db.AutoMigrate(models.AccountQuarantine{}); err != nil {
+		return err
+	}
+	if err := r.db.AutoMigrate(models.AccountResync{}); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -0,0 +1,245 @@
+package repostore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/repo/mst"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+type blockGetFunc func(c cid.Cid) ([]byte, error)
+
+// Visits every block in a repo: the commit object, all MST nodes, and all record blocks. Returns an error if any block is missing.
+func walkRepo(commitCID cid.Cid, get blockGetFunc, visit func(c cid.Cid, b []byte) error) error {
+	b, err := get(commitCID)
+	if err != nil {
+		return err
+	}
+	var commit repo.Commit
+	if err := commit.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("parsing commit block: %w", err)
+	}
+	if err := visit(commitCID, b); err != nil {
+		return err
+	}
+	return walkMST(commit.Data, get, visit)
+}
+
+func walkMST(node cid.Cid, get blockGetFunc, visit func(c cid.Cid, b []byte) error) error {
+	b, err := get(node)
+	if err != nil {
+		return err
+	}
+	nd, err := mst.NodeDataFromCBOR(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("parsing MST node: %w", err)
+	}
+	if err := visit(node, b); err != nil {
+		return err
+	}
+	if nd.Left != nil {
+		if err := walkMST(*nd.Left, get, visit); err != nil {
+			return err
+		}
+	}
+	for _, e := range nd.Entries {
+		rec, err := get(e.Value)
+		if err != nil {
+			return err
+		}
+		if err := visit(e.Value, rec); err != nil {
+			return err
+		}
+		if e.Right != nil {
+			if err := walkMST(*e.Right, get, visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Finds the record CID for a key, visiting all MST nodes on the path from the root (which together form a proof of inclusion or exclusion). Returns nil CID if key is not in tree.
+func findMSTKey(root cid.Cid, key []byte, get blockGetFunc, visit func(c cid.Cid, b []byte) error) (*cid.Cid, error) {
+	node := root
+	for {
+		b, err := get(node)
+		if err != nil {
+			return nil, err
+		}
+		nd, err := mst.NodeDataFromCBOR(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("parsing MST node: %w", err)
+		}
+		if err := visit(node, b); err != nil {
+			return nil, err
+		}
+
+		// the subtree which would contain the key, if it isn't an entry in this node
+		next := nd.Left
+		var prevKey []byte
+		for _, e := range nd.Entries {
+			if int(e.PrefixLen) > len(prevKey) {
+				return nil, fmt.Errorf("invalid MST entry prefix length")
+			}
+			entryKey := append(bytes.Clone(prevKey[:e.PrefixLen]), e.KeySuffix...)
+			cmp := bytes.Compare(key, entryKey)
+			if cmp == 0 {
+				val := e.Value
+				return &val, nil
+			}
+			if cmp < 0 {
+				break
+			}
+			next = e.Right
+			prevKey = entryKey
+		}
+		if next == nil {
+			return nil, nil
+		}
+		node = *next
+	}
+}
+
+func writeCARHeader(w io.Writer, roots []cid.Cid) error {
+	return car.WriteHeader(&car.CarHeader{Roots: roots, Version: 1}, w)
+}
+
+func writeCARBlock(w io.Writer, c cid.Cid, b []byte) error {
+	return carutil.LdWrite(w, c.Bytes(), b)
+}
+
+// Writes the full repo snapshot for an account as a CAR file.
+//
+// Reads from a consistent point-in-time view of storage, so concurrent commits do not interfere. If the snapshot turns out to be incomplete (missing blocks), it is dropped and an error is returned; but note that partial output may already have been written.
+func (rs *RepoStore) WriteRepoCAR(ctx context.Context, uid uint64, w io.Writer) error {
+	snap, err := rs.GetSnapshot(ctx, uid)
+	if err != nil {
+		return err
+	}
+	commitCID, err := cid.Decode(snap.CommitCID)
+	if err != nil {
+		return err
+	}
+
+	kvSnap := rs.kv.NewSnapshot()
+	defer kvSnap.Close()
+
+	if err := writeCARHeader(w, []cid.Cid{commitCID}); err != nil {
+		return err
+	}
+	err = walkRepo(commitCID, rs.blockGetter(kvSnap, uid), func(c cid.Cid, b []byte) error {
+		return writeCARBlock(w, c, b)
+	})
+	if err != nil {
+		// a concurrent commit could have replaced the snapshot; only drop if it is still the same commit
+		if latest, lerr := rs.GetSnapshot(ctx, uid); lerr == nil && latest.CommitCID == snap.CommitCID {
+			rs.logger.Warn("dropping incomplete repo snapshot", "uid", uid, "err", err)
+			_ = rs.Delete(ctx, uid)
+		}
+		return err
+	}
+	rs.touch(ctx, uid)
+	repoStoreServed.WithLabelValues("getRepo").Inc()
+	return nil
+}
+
+// Writes a CAR file containing the commit object, MST proof nodes, and record block for a single record. Returns ErrRecordNotFound if the record does not exist, before writing any output.
+func (rs *RepoStore) WriteRecordCAR(ctx context.Context, uid uint64, collection syntax.NSID, rkey syntax.RecordKey, w io.Writer) error {
+	snap, err := rs.GetSnapshot(ctx, uid)
+	if err != nil {
+		return err
+	}
+	commitCID, err := cid.Decode(snap.CommitCID)
+	if err != nil {
+		return err
+	}
+	dataCID, err := cid.Decode(snap.CommitDataCID)
+	if err != nil {
+		return err
+	}
+
+	kvSnap := rs.kv.NewSnapshot()
+	defer kvSnap.Close()
+	get := rs.blockGetter(kvSnap, uid)
+
+	// collect blocks in memory first, so that not-found can be reported as an error
+	var cids []cid.Cid
+	var blks [][]byte
+	collect := func(c cid.Cid, b []byte) error {
+		cids = append(cids, c)
+		blks = append(blks, b)
+		return nil
+	}
+
+	commitBytes, err := get(commitCID)
+	if err != nil {
+		return err
+	}
+	_ = collect(commitCID, commitBytes)
+
+	recCID, err := findMSTKey(dataCID, []byte(collection.String()+"/"+rkey.String()), get, collect)
+	if err != nil {
+		return err
+	}
+	if recCID == nil {
+		return ErrRecordNotFound
+	}
+	recBytes, err := get(*recCID)
+	if err != nil {
+		return err
+	}
+	_ = collect(*recCID, recBytes)
+
+	if err := writeCARHeader(w, []cid.Cid{commitCID}); err != nil {
+		return err
+	}
+	for i, c := range cids {
+		if err := writeCARBlock(w, c, blks[i]); err != nil {
+			return err
+		}
+	}
+	rs.touch(ctx, uid)
+	repoStoreServed.WithLabelValues("getRecord").Inc()
+	return nil
+}
+
+// Writes a CAR file (with no roots) containing the requested blocks. Returns ErrBlockNotFound if any are missing, before writing any output.
+func (rs *RepoStore) WriteBlocksCAR(ctx context.Context, uid uint64, cids []cid.Cid, w io.Writer) error {
+	if _, err := rs.GetSnapshot(ctx, uid); err != nil {
+		return err
+	}
+
+	kvSnap := rs.kv.NewSnapshot()
+	defer kvSnap.Close()
+	get := rs.blockGetter(kvSnap, uid)
+
+	blks := make([][]byte, len(cids))
+	for i, c := range cids {
+		b, err := get(c)
+		if err != nil {
+			return err
+		}
+		blks[i] = b
+	}
+
+	if err := writeCARHeader(w, []cid.Cid{}); err != nil {
+		return err
+	}
+	for i, c := range cids {
+		if err := writeCARBlock(w, c, blks[i]); err != nil {
+			return err
+		}
+	}
+	rs.touch(ctx, uid)
+	repoStoreServed.WithLabelValues("getBlocks").Inc()
+	return nil
+}
@@ -0,0 +1,36 @@
+package repostore
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var repoStoreBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "relay_repo_store_bytes",
+	Help: "Approximate total size of stored repo snapshot blocks",
+})
+
+var repoStoreImports = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "relay_repo_store_imports",
+	Help: "Number of full repo snapshots imported",
+})
+
+var repoStoreStale = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "relay_repo_store_stale",
+	Help: "Number of repo snapshots dropped because commit chain broke",
+})
+
+var repoStoreCompactions = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "relay_repo_store_compactions",
+	Help: "Number of repo snapshot compactions (garbage collection)",
+})
+
+var repoStoreEvictions = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "relay_repo_store_evictions",
+	Help: "Number of repo snapshots evicted to stay under storage budget",
+})
+
+var repoStoreServed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "relay_repo_store_served",
+	Help: "Number of sync API requests served from local repo snapshots",
+}, []string{"endpoint"})
@@ -0,0 +1,533 @@
+package repostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("repo snapshot not available")
+	ErrSnapshotStale    = errors.New("repo snapshot out of sync with commit")
+	ErrBlockNotFound    = errors.New("block not found in repo snapshot")
+	ErrRecordNotFound   = errors.New("record not found in repo snapshot")
+	ErrRepoTooLarge     = errors.New("repo exceeds snapshot size limit")
+	ErrSnapshotOutdated = errors.New("repo snapshot is older than current repo rev")
+)
+
+// Stores the latest full repository blocks for accounts, so that the relay can serve `getRepo`, `getRecord`, and `getBlocks` without redirecting to the account's PDS.
+//
+// Snapshots are created by importing a full repo CAR file (eg, fetched from the PDS), and are then kept up to date by applying each subsequent verified `#commit` message. If the commit chain breaks (`prevData` mismatch), the snapshot is dropped. Blocks are stored in a local pebble database, and snapshot metadata in the relay SQL database (`models.RepoSnapshot`).
+//
+// Blocks which are no longer part of the repo tree are garbage collected by periodic per-repo compaction. If total storage exceeds the configured budget, least-recently-accessed snapshots are evicted.
+type RepoStore struct {
+	db     *gorm.DB
+	kv     *pebble.DB
+	config RepoStoreConfig
+	logger *slog.Logger
+
+	// total of StoredBytes + DirtyBytes across all snapshots
+	totalBytes atomic.Int64
+
+	// UIDs of accounts which have a snapshot, so that commits for other accounts can skip the database entirely
+	present sync.Map
+
+	// striped locks, to serialize writes to a single repo
+	locks [256]sync.Mutex
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+type RepoStoreConfig struct {
+	// local directory for pebble database
+	Dir string
+
+	// target upper bound on total block storage. least-recently-accessed snapshots are evicted when exceeded
+	MaxBytes int64
+
+	// upper bound on the size of a single repo CAR file which will be imported
+	MaxRepoBytes int64
+
+	// how often to run compaction and eviction
+	MaintenancePeriod time.Duration
+}
+
+func DefaultRepoStoreConfig() *RepoStoreConfig {
+	return &RepoStoreConfig{
+		MaxBytes:          100 * 1024 * 1024 * 1024, // 100 GiB
+		MaxRepoBytes:      512 * 1024 * 1024,        // 512 MiB
+		MaintenancePeriod: time.Minute,
+	}
+}
+
+func NewRepoStore(db *gorm.DB, config *RepoStoreConfig) (*RepoStore, error) {
+	if config == nil {
+		config = DefaultRepoStoreConfig()
+	}
+	if config.Dir == "" {
+		return nil, fmt.Errorf("repo store directory not configured")
+	}
+
+	if err := db.AutoMigrate(models.RepoSnapshot{}); err != nil {
+		return nil, err
+	}
+
+	kv, err := pebble.Open(config.Dir, &pebble.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("opening repo store (%s): %w", config.Dir, err)
+	}
+
+	rs := &RepoStore{
+		db:       db,
+		kv:       kv,
+		config:   *config,
+		logger:   slog.Default().With("system", "repostore"),
+		shutdown: make(chan struct{}),
+	}
+
+	var total int64
+	if err := db.Model(&models.RepoSnapshot{}).Select("COALESCE(SUM(stored_bytes + dirty_bytes), 0)").Scan(&total).Error; err != nil {
+		kv.Close()
+		return nil, err
+	}
+	rs.totalBytes.Store(total)
+	repoStoreBytes.Set(float64(total))
+
+	var uids []uint64
+	if err := db.Model(&models.RepoSnapshot{}).Pluck("uid", &uids).Error; err != nil {
+		kv.Close()
+		return nil, err
+	}
+	for _, uid := range uids {
+		rs.present.Store(uid, true)
+	}
+
+	if config.MaintenancePeriod > 0 {
+		rs.wg.Add(1)
+		go rs.maintenanceLoop()
+	}
+	return rs, nil
+}
+
+func (rs *RepoStore) Shutdown() error {
+	close(rs.shutdown)
+	rs.wg.Wait()
+	return rs.kv.Close()
+}
+
+// Total bytes of block storage currently in use (approximately)
+func (rs *RepoStore) TotalBytes() int64 {
+	return rs.totalBytes.Load()
+}
+
+func (rs *RepoStore) lock(uid uint64) func() {
+	lk := &rs.locks[uid%uint64(len(rs.locks))]
+	lk.Lock()
+	return lk.Unlock
+}
+
+// all block keys are: 'b' + UID (8 bytes, big-endian) + CID bytes
+func uidPrefix(uid uint64) []byte {
+	key := make([]byte, 9)
+	key[0] = 'b'
+	binary.BigEndian.PutUint64(key[1:], uid)
+	return key
+}
+
+func blockKey(uid uint64, c cid.Cid) []byte {
+	return append(uidPrefix(uid), c.Bytes()...)
+}
+
+func uidBounds(uid uint64) ([]byte, []byte) {
+	return uidPrefix(uid), uidPrefix(uid + 1)
+}
+
+// Fast (in-memory) check whether the account has a snapshot.
+func (rs *RepoStore) HasSnapshot(uid uint64) bool {
+	_, ok := rs.present.Load(uid)
+	return ok
+}
+
+// Returns snapshot metadata, or ErrSnapshotNotFound
+func (rs *RepoStore) GetSnapshot(ctx context.Context, uid uint64) (*models.RepoSnapshot, error) {
+	var snap models.RepoSnapshot
+	if err := rs.db.WithContext(ctx).First(&snap, uid).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSnapshotNotFound
+		}
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Imports a full repo CAR file as the snapshot for an account, replacing any existing snapshot.
+//
+// The `verify` callback is called with the parsed commit object before anything is written; it should check the signature and DID. The full MST and all records are verified to be present in the CAR file.
+//
+// If `minRev` is not nil, it is called while holding the lock for the repo (which serializes with ApplyCommit), and repos with an older rev than it returns (eg, the current AccountRepo rev) are rejected with ErrSnapshotOutdated. Returns the parsed commit.
+func (rs *RepoStore) ImportCAR(ctx context.Context, uid uint64, r io.Reader, minRev func(context.Context) (string, error), verify func(*repo.Commit) error) (*repo.Commit, error) {
+	cr, err := car.NewCarReader(io.LimitReader(r, rs.config.MaxRepoBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if cr.Header.Version != 1 {
+		return nil, fmt.Errorf("unsupported CAR file version: %d", cr.Header.Version)
+	}
+	if len(cr.Header.Roots) < 1 {
+		return nil, repo.ErrNoRoot
+	}
+	commitCID := cr.Header.Roots[0]
+
+	blks := make(map[cid.Cid][]byte)
+	var size int64
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			if size >= rs.config.MaxRepoBytes {
+				return nil, ErrRepoTooLarge
+			}
+			return nil, err
+		}
+		size += int64(len(blk.RawData()))
+		if size > rs.config.MaxRepoBytes {
+			return nil, ErrRepoTooLarge
+		}
+		blks[blk.Cid()] = blk.RawData()
+	}
+
+	get := func(c cid.Cid) ([]byte, error) {
+		b, ok := blks[c]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, c)
+		}
+		return b, nil
+	}
+
+	commitBytes, err := get(commitCID)
+	if err != nil {
+		return nil, err
+	}
+	var commit repo.Commit
+	if err := commit.UnmarshalCBOR(bytes.NewReader(commitBytes)); err != nil {
+		return nil, fmt.Errorf("parsing commit block: %w", err)
+	}
+	if err := commit.VerifyStructure(); err != nil {
+		return nil, err
+	}
+	if verify != nil {
+		if err := verify(&commit); err != nil {
+			return nil, err
+		}
+	}
+
+	// only write blocks which are actually reachable from the commit. this also verifies that the repo is complete
+	batch := rs.kv.NewBatch()
+	defer batch.Close()
+	var stored int64
+	err = walkRepo(commitCID, get, func(c cid.Cid, b []byte) error {
+		stored += int64(len(b))
+		return batch.Set(blockKey(uid, c), b, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking repo tree: %w", err)
+	}
+
+	unlock := rs.lock(uid)
+	defer unlock()
+
+	if minRev != nil {
+		rev, err := minRev(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if rev != "" && commit.Rev < rev {
+			return nil, fmt.Errorf("%w: %s < %s", ErrSnapshotOutdated, commit.Rev, rev)
+		}
+	}
+
+	if err := rs.deleteLocked(ctx, uid); err != nil {
+		return nil, err
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	snap := models.RepoSnapshot{
+		UID:            uid,
+		Rev:            commit.Rev,
+		CommitCID:      commitCID.String(),
+		CommitDataCID:  commit.Data.String(),
+		StoredBytes:    stored,
+		LastAccessedAt: now,
+	}
+	if err := rs.db.WithContext(ctx).Create(&snap).Error; err != nil {
+		return nil, err
+	}
+	rs.present.Store(uid, true)
+	rs.addBytes(stored)
+	repoStoreImports.Inc()
+	return &commit, nil
+}
+
+// Applies a (previously verified) commit message to an existing snapshot. If there is no snapshot for the account, this is a no-op.
+//
+// `newRepo` is the result of verifying the commit (see `Relay.VerifyRepoCommit`). If the commit does not chain from the current snapshot state, the snapshot is dropped and ErrSnapshotStale is returned.
+func (rs *RepoStore) ApplyCommit(ctx context.Context, uid uint64, evt *comatproto.SyncSubscribeRepos_Commit, newRepo *models.AccountRepo) error {
+	// this is called for every commit on the firehose, and most accounts don't have a snapshot
+	if !rs.HasSnapshot(uid) {
+		return nil
+	}
+
+	unlock := rs.lock(uid)
+	defer unlock()
+
+	snap, err := rs.GetSnapshot(ctx, uid)
+	if err != nil {
+		if errors.Is(err, ErrSnapshotNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if evt.PrevData == nil || evt.PrevData.String() != snap.CommitDataCID || newRepo.Rev <= snap.Rev {
+		repoStoreStale.Inc()
+		if err := rs.deleteLocked(ctx, uid); err != nil {
+			return err
+		}
+		return ErrSnapshotStale
+	}
+
+	cr, err := car.NewCarReader(bytes.NewReader(evt.Blocks))
+	if err != nil {
+		return err
+	}
+	batch := rs.kv.NewBatch()
+	defer batch.Close()
+	var written int64
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		written += int64(len(blk.RawData()))
+		if err := batch.Set(blockKey(uid, blk.Cid()), blk.RawData(), nil); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(pebble.NoSync); err != nil {
+		return err
+	}
+
+	err = rs.db.WithContext(ctx).Model(&models.RepoSnapshot{}).Where("uid = ?", uid).Updates(map[string]any{
+		"rev":             newRepo.Rev,
+		"commit_cid":      newRepo.CommitCID,
+		"commit_data_cid": newRepo.CommitDataCID,
+		"dirty_bytes":     gorm.Expr("dirty_bytes + ?", written),
+	}).Error
+	if err != nil {
+		return err
+	}
+	rs.addBytes(written)
+	return nil
+}
+
+// Removes any snapshot for the account. Not an error if there is no snapshot.
+func (rs *RepoStore) Delete(ctx context.Context, uid uint64) error {
+	unlock := rs.lock(uid)
+	defer unlock()
+	return rs.deleteLocked(ctx, uid)
+}
+
+func (rs *RepoStore) deleteLocked(ctx context.Context, uid uint64) error {
+	snap, err := rs.GetSnapshot(ctx, uid)
+	if err != nil {
+		if errors.Is(err, ErrSnapshotNotFound) {
+			return nil
+		}
+		return err
+	}
+	lower, upper := uidBounds(uid)
+	if err := rs.kv.DeleteRange(lower, upper, pebble.Sync); err != nil {
+		return err
+	}
+	if err := rs.db.WithContext(ctx).Delete(&models.RepoSnapshot{}, uid).Error; err != nil {
+		return err
+	}
+	rs.present.Delete(uid)
+	rs.addBytes(-(snap.StoredBytes + snap.DirtyBytes))
+	return nil
+}
+
+func (rs *RepoStore) addBytes(delta int64) {
+	repoStoreBytes.Set(float64(rs.totalBytes.Add(delta)))
+}
+
+// Re-walks the repo tree from the current commit, and deletes any unreachable blocks.
+//
+// If any blocks are missing from the tree, the snapshot is dropped.
+func (rs *RepoStore) Compact(ctx context.Context, uid uint64) error {
+	unlock := rs.lock(uid)
+	defer unlock()
+
+	snap, err := rs.GetSnapshot(ctx, uid)
+	if err != nil {
+		return err
+	}
+	commitCID, err := cid.Decode(snap.CommitCID)
+	if err != nil {
+		return err
+	}
+
+	reachable := make(map[string]bool)
+	var stored int64
+	err = walkRepo(commitCID, rs.blockGetter(rs.kv, uid), func(c cid.Cid, b []byte) error {
+		reachable[c.KeyString()] = true
+		stored += int64(len(b))
+		return nil
+	})
+	if err != nil {
+		rs.logger.Warn("dropping incomplete repo snapshot", "uid", uid, "err", err)
+		return rs.deleteLocked(ctx, uid)
+	}
+
+	lower, upper := uidBounds(uid)
+	iter, err := rs.kv.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return err
+	}
+	batch := rs.kv.NewBatch()
+	defer batch.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if !reachable[string(key[len(lower):])] {
+			if err := batch.Delete(bytes.Clone(key), nil); err != nil {
+				iter.Close()
+				return err
+			}
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+
+	err = rs.db.WithContext(ctx).Model(&models.RepoSnapshot{}).Where("uid = ?", uid).Updates(map[string]any{
+		"stored_bytes": stored,
+		"dirty_bytes":  0,
+	}).Error
+	if err != nil {
+		return err
+	}
+	rs.addBytes(stored - (snap.StoredBytes + snap.DirtyBytes))
+	repoStoreCompactions.Inc()
+	return nil
+}
+
+type pebbleReader interface {
+	Get(key []byte) ([]byte, io.Closer, error)
+}
+
+func (rs *RepoStore) blockGetter(r pebbleReader, uid uint64) func(cid.Cid) ([]byte, error) {
+	return func(c cid.Cid) ([]byte, error) {
+		val, closer, err := r.Get(blockKey(uid, c))
+		if err != nil {
+			if errors.Is(err, pebble.ErrNotFound) {
+				return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, c)
+			}
+			return nil, err
+		}
+		defer closer.Close()
+		return bytes.Clone(val), nil
+	}
+}
+
+func (rs *RepoStore) touch(ctx context.Context, uid uint64) {
+	err := rs.db.WithContext(ctx).Model(&models.RepoSnapshot{}).Where("uid = ?", uid).UpdateColumn("last_accessed_at", time.Now()).Error
+	if err != nil {
+		rs.logger.Warn("failed to update snapshot access time", "uid", uid, "err", err)
+	}
+}
+
+func (rs *RepoStore) maintenanceLoop() {
+	defer rs.wg.Done()
+	ticker := time.NewTicker(rs.config.MaintenancePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rs.shutdown:
+			return
+		case <-ticker.C:
+			ctx := context.Background()
+			if err := rs.compactDirty(ctx); err != nil {
+				rs.logger.Error("repo snapshot compaction failed", "err", err)
+			}
+			if err := rs.evict(ctx); err != nil {
+				rs.logger.Error("repo snapshot eviction failed", "err", err)
+			}
+		}
+	}
+}
+
+// compacts snapshots where garbage may be a significant fraction of storage
+func (rs *RepoStore) compactDirty(ctx context.Context) error {
+	var uids []uint64
+	err := rs.db.WithContext(ctx).Model(&models.RepoSnapshot{}).Where("dirty_bytes > 65536 AND dirty_bytes > stored_bytes / 4").Order("dirty_bytes DESC").Limit(100).Pluck("uid", &uids).Error
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if err := rs.Compact(ctx, uid); err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+			rs.logger.Warn("failed to compact repo snapshot", "uid", uid, "err", err)
+		}
+	}
+	return nil
+}
+
+// evicts least-recently-accessed snapshots until under storage budget
+func (rs *RepoStore) evict(ctx context.Context) error {
+	for rs.TotalBytes() > rs.config.MaxBytes {
+		var uids []uint64
+		if err := rs.db.WithContext(ctx).Model(&models.RepoSnapshot{}).Order("last_accessed_at ASC").Limit(100).Pluck("uid", &uids).Error; err != nil {
+			return err
+		}
+		if len(uids) == 0 {
+			return nil
+		}
+		for _, uid := range uids {
+			if rs.TotalBytes() <= rs.config.MaxBytes {
+				break
+			}
+			if err := rs.Delete(ctx, uid); err != nil {
+				return err
+			}
+			repoStoreEvictions.Inc()
+		}
+	}
+	return nil
+}
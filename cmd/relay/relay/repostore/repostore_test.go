@@ -0,0 +1,206 @@
+package repostore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/internal/testutil"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T) *RepoStore {
+	db, err := cliutil.SetupDatabase("sqlite://:memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultRepoStoreConfig()
+	config.Dir = t.TempDir()
+	config.MaintenancePeriod = 0
+	rs, err := NewRepoStore(db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rs.Shutdown() })
+	return rs
+}
+
+// minRev callback for ImportCAR which returns a fixed rev
+func staticRev(rev string) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) { return rev, nil }
+}
+
+func TestRepoStoreImportAndServe(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	rs := testStore(t)
+	uid := uint64(7)
+
+	tr := testutil.NewRepo(t, "did:plc:abc123", nil)
+	for i := range 20 {
+		tr.AddRecord(t, fmt.Sprintf("3l3qo2vuowo%02d", i), fmt.Sprintf("post %d", i))
+	}
+	commitCID, commit, carBytes := tr.Commit(t)
+
+	_, err := rs.GetSnapshot(ctx, uid)
+	assert.ErrorIs(err, ErrSnapshotNotFound)
+	assert.False(rs.HasSnapshot(uid))
+
+	// verify callback can reject import
+	_, err = rs.ImportCAR(ctx, uid, bytes.NewReader(carBytes), nil, func(c *repo.Commit) error { return fmt.Errorf("nope") })
+	assert.Error(err)
+	assert.Equal(int64(0), rs.TotalBytes())
+
+	// repos older than the current repo rev are rejected
+	_, err = rs.ImportCAR(ctx, uid, bytes.NewReader(carBytes), staticRev("zzzzzzzzzzzzz"), nil)
+	assert.ErrorIs(err, ErrSnapshotOutdated)
+	assert.False(rs.HasSnapshot(uid))
+
+	out, err := rs.ImportCAR(ctx, uid, bytes.NewReader(carBytes), staticRev(commit.Rev), nil)
+	assert.NoError(err)
+	assert.Equal(commit.Rev, out.Rev)
+	assert.True(rs.TotalBytes() > 0)
+	assert.True(rs.HasSnapshot(uid))
+
+	snap, err := rs.GetSnapshot(ctx, uid)
+	assert.NoError(err)
+	assert.Equal(commitCID.String(), snap.CommitCID)
+
+	// full repo export round-trips
+	var buf bytes.Buffer
+	assert.NoError(rs.WriteRepoCAR(ctx, uid, &buf))
+	_, loaded, err := repo.LoadRepoFromCAR(ctx, &buf)
+	assert.NoError(err)
+	recBytes, _, err := loaded.GetRecordBytes(ctx, syntax.NSID("app.bsky.feed.post"), syntax.RecordKey("3l3qo2vuowo05"))
+	assert.NoError(err)
+	rec, err := data.UnmarshalCBOR(recBytes)
+	assert.NoError(err)
+	assert.Equal("post 5", rec["text"])
+
+	// single record, with proof
+	buf.Reset()
+	assert.NoError(rs.WriteRecordCAR(ctx, uid, syntax.NSID("app.bsky.feed.post"), syntax.RecordKey("3l3qo2vuowo11"), &buf))
+	_, proof, err := repo.LoadRepoFromCAR(ctx, &buf)
+	assert.NoError(err)
+	_, _, err = proof.GetRecordBytes(ctx, syntax.NSID("app.bsky.feed.post"), syntax.RecordKey("3l3qo2vuowo11"))
+	assert.NoError(err)
+
+	err = rs.WriteRecordCAR(ctx, uid, syntax.NSID("app.bsky.feed.post"), syntax.RecordKey("3l3qo2vuowo99"), &buf)
+	assert.ErrorIs(err, ErrRecordNotFound)
+
+	// blocks
+	buf.Reset()
+	assert.NoError(rs.WriteBlocksCAR(ctx, uid, []cid.Cid{commitCID, commit.Data}, &buf))
+	badCID, _ := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum([]byte("dummy"))
+	assert.ErrorIs(rs.WriteBlocksCAR(ctx, uid, []cid.Cid{badCID}, &buf), ErrBlockNotFound)
+
+	assert.NoError(rs.Delete(ctx, uid))
+	assert.Equal(int64(0), rs.TotalBytes())
+	_, err = rs.GetSnapshot(ctx, uid)
+	assert.ErrorIs(err, ErrSnapshotNotFound)
+	assert.False(rs.HasSnapshot(uid))
+}
+
+func TestRepoStoreApplyCommit(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	rs := testStore(t)
+	uid := uint64(7)
+
+	tr := testutil.NewRepo(t, "did:plc:abc123", nil)
+	tr.AddRecord(t, "3l3qo2vuowo2b", "first")
+	_, commit, carBytes := tr.Commit(t)
+	_, err := rs.ImportCAR(ctx, uid, bytes.NewReader(carBytes), nil, nil)
+	assert.NoError(err)
+
+	// apply a commit which adds a record
+	prevData := lexutil.LexLink(commit.Data)
+	tr.AddRecord(t, "3l3qo2vuowo2c", "second")
+	commitCID, commit, carBytes := tr.Commit(t)
+	evt := &comatproto.SyncSubscribeRepos_Commit{
+		Repo:     tr.DID,
+		Rev:      commit.Rev,
+		Commit:   lexutil.LexLink(commitCID),
+		PrevData: &prevData,
+		Blocks:   carBytes,
+		Time:     syntax.DatetimeNow().String(),
+	}
+	newRepo := &models.AccountRepo{Rev: commit.Rev, CommitCID: commitCID.String(), CommitDataCID: commit.Data.String()}
+	assert.NoError(rs.ApplyCommit(ctx, uid, evt, newRepo))
+
+	snap, err := rs.GetSnapshot(ctx, uid)
+	assert.NoError(err)
+	assert.Equal(commit.Rev, snap.Rev)
+	assert.True(snap.DirtyBytes > 0)
+
+	// compaction removes garbage (the old commit block, at least)
+	before := rs.TotalBytes()
+	assert.NoError(rs.Compact(ctx, uid))
+	assert.True(rs.TotalBytes() < before)
+	snap, err = rs.GetSnapshot(ctx, uid)
+	assert.NoError(err)
+	assert.Equal(int64(0), snap.DirtyBytes)
+
+	var buf bytes.Buffer
+	assert.NoError(rs.WriteRepoCAR(ctx, uid, &buf))
+	_, loaded, err := repo.LoadRepoFromCAR(ctx, &buf)
+	assert.NoError(err)
+	_, _, err = loaded.GetRecordBytes(ctx, syntax.NSID("app.bsky.feed.post"), syntax.RecordKey("3l3qo2vuowo2c"))
+	assert.NoError(err)
+
+	// commit which does not chain from current state drops the snapshot
+	tr.AddRecord(t, "3l3qo2vuowo2d", "third")
+	commitCID, commit, carBytes = tr.Commit(t)
+	evt.Rev = commit.Rev
+	evt.Commit = lexutil.LexLink(commitCID)
+	evt.Blocks = carBytes
+	newRepo = &models.AccountRepo{Rev: commit.Rev, CommitCID: commitCID.String(), CommitDataCID: commit.Data.String()}
+	assert.ErrorIs(rs.ApplyCommit(ctx, uid, evt, newRepo), ErrSnapshotStale)
+	_, err = rs.GetSnapshot(ctx, uid)
+	assert.ErrorIs(err, ErrSnapshotNotFound)
+	assert.Equal(int64(0), rs.TotalBytes())
+
+	// no-op for accounts without a snapshot
+	assert.NoError(rs.ApplyCommit(ctx, uid+1, evt, newRepo))
+}
+
+func TestRepoStoreEviction(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	rs := testStore(t)
+
+	for uid := uint64(1); uid <= 3; uid++ {
+		tr := testutil.NewRepo(t, "did:plc:abc123", nil)
+		tr.AddRecord(t, "3l3qo2vuowo2b", fmt.Sprintf("repo %d", uid))
+		_, _, carBytes := tr.Commit(t)
+		_, err := rs.ImportCAR(ctx, uid, bytes.NewReader(carBytes), nil, nil)
+		assert.NoError(err)
+		time.Sleep(2 * time.Millisecond)
+	}
+	// access the first repo, so it is most recently used
+	var buf bytes.Buffer
+	assert.NoError(rs.WriteRepoCAR(ctx, 1, &buf))
+
+	// budget for approximately one repo
+	rs.config.MaxBytes = rs.TotalBytes() / 3
+	assert.NoError(rs.evict(ctx))
+
+	_, err := rs.GetSnapshot(ctx, 1)
+	assert.NoError(err)
+	_, err = rs.GetSnapshot(ctx, 2)
+	assert.ErrorIs(err, ErrSnapshotNotFound)
+	_, err = rs.GetSnapshot(ctx, 3)
+	assert.ErrorIs(err, ErrSnapshotNotFound)
+}
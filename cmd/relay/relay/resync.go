@@ -0,0 +1,343 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	resyncPollPeriod  = 10 * time.Second
+	resyncBatchSize   = 100
+	resyncMaxBackoff  = 6 * time.Hour
+	resyncBaseBackoff = time.Minute
+	resyncTimeout     = 10 * time.Minute
+)
+
+// Starts the resync subsystem: a dispatcher which reads due resync requests from the database, and a bounded pool of workers which process them. Runs until the context is cancelled.
+//
+// Until this is called, broken commit chains are only logged (not acted on).
+func (r *Relay) StartResyncWorkers(ctx context.Context) {
+	if r.Config.ResyncWorkers <= 0 {
+		return
+	}
+	queue := make(chan *models.AccountResync)
+	for range r.Config.ResyncWorkers {
+		go r.resyncWorker(ctx, queue)
+	}
+	go r.resyncDispatchLoop(ctx, queue)
+	r.resyncEnabled.Store(true)
+	r.Logger.Info("started repo resync workers", "workers", r.Config.ResyncWorkers)
+}
+
+// Queues an account for full repo resync, and marks it as "desynchronized" (emitting an `#account` event). While desynchronized, `#commit` events for the account are dropped.
+//
+// Accounts with any local status other than "active" are queued, but their status is not modified.
+func (r *Relay) RequestResync(ctx context.Context, acc *models.Account, reason string) error {
+	return r.requestResyncAt(ctx, acc, reason, time.Now())
+}
+
+// Same as RequestResync, but the first resync attempt is not made before the given time.
+func (r *Relay) requestResyncAt(ctx context.Context, acc *models.Account, reason string, at time.Time) error {
+	row := models.AccountResync{
+		UID:           acc.UID,
+		DID:           acc.DID,
+		Reason:        reason,
+		NextAttemptAt: at,
+	}
+	// an existing request is left alone, unless it was parked, in which case it is restarted
+	onConflict := clause.OnConflict{
+		Columns: []clause.Column{{Name: "uid"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"reason":          reason,
+			"attempts":        0,
+			"next_attempt_at": at,
+			"parked":          false,
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{clause.Eq{Column: clause.Column{Table: "account_resync", Name: "parked"}, Value: true}}},
+	}
+	if err := r.db.WithContext(ctx).Clauses(onConflict).Create(&row).Error; err != nil {
+		return err
+	}
+
+	if acc.Status == models.AccountStatusActive {
+		if err := r.UpdateAccountLocalStatus(ctx, syntax.DID(acc.DID), models.AccountStatusDesynchronized, true); err != nil {
+			return err
+		}
+	}
+
+	// nudge the dispatcher, without blocking
+	select {
+	case r.resyncNotify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Returns pending resync state for the account, or nil if there is none.
+func (r *Relay) GetAccountResync(ctx context.Context, uid uint64) (*models.AccountResync, error) {
+	var row models.AccountResync
+	if err := r.db.WithContext(ctx).First(&row, uid).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &row, nil
+}
+
+func (r *Relay) resyncDispatchLoop(ctx context.Context, queue chan<- *models.AccountResync) {
+	ticker := time.NewTicker(resyncPollPeriod)
+	defer ticker.Stop()
+	for {
+		var rows []*models.AccountResync
+		if err := r.db.WithContext(ctx).Where("parked = ? AND next_attempt_at <= ?", false, time.Now()).Order("next_attempt_at").Limit(resyncBatchSize).Find(&rows).Error; err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			r.Logger.Error("failed to read resync queue", "err", err)
+		}
+		for _, row := range rows {
+			// skip accounts which are already being worked on
+			if _, busy := r.resyncInflight.LoadOrStore(row.UID, true); busy {
+				continue
+			}
+			select {
+			case queue <- row:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.resyncNotify:
+		}
+	}
+}
+
+func (r *Relay) resyncWorker(ctx context.Context, queue <-chan *models.AccountResync) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case row := <-queue:
+			r.processResync(ctx, row)
+			r.resyncInflight.Delete(row.UID)
+		}
+	}
+}
+
+// Attempts a single resync, and either clears the request (on success) or schedules a retry with backoff.
+func (r *Relay) processResync(ctx context.Context, row *models.AccountResync) {
+	logger := r.Logger.With("did", row.DID, "attempt", row.Attempts+1)
+
+	attemptCtx, cancel := context.WithTimeout(ctx, resyncTimeout)
+	defer cancel()
+
+	err := r.resyncAccount(attemptCtx, row)
+	if err == nil {
+		resyncAttemptsCounter.WithLabelValues("success").Inc()
+		if err := r.db.WithContext(ctx).Delete(&models.AccountResync{}, row.UID).Error; err != nil {
+			logger.Error("failed to clear resync request", "err", err)
+		}
+		return
+	}
+
+	attempts := row.Attempts + 1
+	updates := map[string]any{
+		"attempts":   attempts,
+		"last_error": err.Error(),
+	}
+	if r.Config.ResyncMaxAttempts > 0 && attempts >= r.Config.ResyncMaxAttempts {
+		// the account stays desynchronized (commits dropped) until resync is requested again, eg by a #sync or admin action
+		resyncAttemptsCounter.WithLabelValues("parked").Inc()
+		logger.Error("repo resync failed too many times, giving up", "err", err)
+		updates["parked"] = true
+	} else {
+		resyncAttemptsCounter.WithLabelValues("failure").Inc()
+		backoff := resyncBackoff(attempts)
+		logger.Warn("repo resync failed", "err", err, "retryIn", backoff)
+		updates["next_attempt_at"] = time.Now().Add(backoff)
+	}
+	if err := r.db.WithContext(ctx).Model(&models.AccountResync{}).Where("uid = ?", row.UID).Updates(updates).Error; err != nil {
+		logger.Error("failed to update resync request", "err", err)
+	}
+}
+
+// exponential backoff, with jitter, capped at resyncMaxBackoff
+func resyncBackoff(attempts int) time.Duration {
+	d := resyncMaxBackoff
+	if attempts < 16 {
+		d = min(resyncBaseBackoff<<(attempts-1), resyncMaxBackoff)
+	}
+	return d + time.Duration(rand.Int63n(int64(d/10)+1))
+}
+
+// Fetches the full repo for an account from its PDS, verifies it, updates repo state, and emits a `#sync` event. On success, the account is returned to "active" status if it was "desynchronized".
+func (r *Relay) resyncAccount(ctx context.Context, row *models.AccountResync) error {
+	did := syntax.DID(row.DID)
+	logger := r.Logger.With("did", did)
+
+	acc, err := r.GetAccount(ctx, did)
+	if err != nil {
+		return err
+	}
+	if acc.Status != models.AccountStatusDesynchronized {
+		// eg, account was taken down or otherwise changed status after resync was requested
+		logger.Info("skipping resync for account which is no longer desynchronized", "status", acc.Status)
+		return nil
+	}
+
+	ident, err := r.Dir.LookupDID(ctx, did)
+	if err != nil {
+		return fmt.Errorf("account identity resolution: %w", err)
+	}
+
+	body, err := r.HostChecker.FetchRepo(ctx, ident)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// spool the export to a temporary file, instead of holding the raw bytes in memory alongside the parsed repo and the snapshot import
+	f, err := os.CreateTemp("", "relay-resync-*.car")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	n, err := io.Copy(f, io.LimitReader(body, r.Config.ResyncMaxRepoBytes+1))
+	if err != nil {
+		return fmt.Errorf("reading repo export: %w", err)
+	}
+	if n > r.Config.ResyncMaxRepoBytes {
+		return fmt.Errorf("repo export exceeds size limit (%d bytes)", r.Config.ResyncMaxRepoBytes)
+	}
+
+	commit, commitCID, commitBlock, err := r.verifyRepoExport(ctx, f, ident)
+	if err != nil {
+		return err
+	}
+
+	prevRepo, err := r.GetAccountRepo(ctx, acc.UID)
+	if err != nil && !errors.Is(err, ErrAccountRepoNotFound) {
+		return err
+	}
+	if prevRepo != nil && commit.Rev < prevRepo.Rev {
+		return fmt.Errorf("%w: fetched repo rev %s is older than last known rev %s", ErrRevSequence, commit.Rev, prevRepo.Rev)
+	}
+
+	// #sync events carry a CAR file containing only the commit block
+	var syncBlocks bytes.Buffer
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{commitCID}, Version: 1}, &syncBlocks); err != nil {
+		return err
+	}
+	if err := carutil.LdWrite(&syncBlocks, commitCID.Bytes(), commitBlock); err != nil {
+		return err
+	}
+
+	// the snapshot is imported before repo state is updated, so that it is checked against the previously stored rev
+	if r.RepoStore != nil {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := r.RepoStore.ImportCAR(ctx, acc.UID, bufio.NewReader(f), r.currentRepoRev(acc.UID), nil); err != nil {
+			logger.Warn("failed to import resynced repo snapshot", "err", err)
+		}
+	}
+
+	if err := r.UpsertAccountRepo(ctx, acc.UID, syntax.TID(commit.Rev), commitCID.String(), commit.Data.String()); err != nil {
+		return fmt.Errorf("failed to upsert account repo (%s): %w", acc.DID, err)
+	}
+
+	err = r.Events.AddEvent(ctx, &stream.XRPCStreamEvent{
+		RepoSync: &comatproto.SyncSubscribeRepos_Sync{
+			Did:    acc.DID,
+			Rev:    commit.Rev,
+			Blocks: syncBlocks.Bytes(),
+			Time:   syntax.DatetimeNow().String(),
+		},
+		PrivUid: acc.UID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to broadcast #sync event: %w", err)
+	}
+
+	if err := r.UpdateAccountLocalStatus(ctx, did, models.AccountStatusActive, true); err != nil {
+		return err
+	}
+	logger.Info("resynced account repo", "rev", commit.Rev)
+	return nil
+}
+
+// Parses and fully verifies a repo CAR export, read from the start of the file: commit signature and fields, complete MST, and all record blocks present. Returns the commit object, and CID and raw bytes of the commit block.
+func (r *Relay) verifyRepoExport(ctx context.Context, f io.ReadSeeker, ident *identity.Identity) (*repo.Commit, cid.Cid, []byte, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, cid.Undef, nil, err
+	}
+	hdr, err := car.ReadHeader(bufio.NewReader(f))
+	if err != nil {
+		return nil, cid.Undef, nil, err
+	}
+	if len(hdr.Roots) < 1 {
+		return nil, cid.Undef, nil, repo.ErrNoRoot
+	}
+	commitCID := hdr.Roots[0]
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, cid.Undef, nil, err
+	}
+	commit, repoObj, err := repo.LoadRepoFromCAR(ctx, bufio.NewReader(f))
+	if err != nil {
+		return nil, cid.Undef, nil, err
+	}
+	if commit.DID != ident.DID.String() {
+		return nil, cid.Undef, nil, fmt.Errorf("mismatched repo commit DID field: %s", commit.DID)
+	}
+	if err := r.VerifyCommitObject(ctx, commit, ident, ""); err != nil {
+		return nil, cid.Undef, nil, err
+	}
+
+	if !repoObj.MST.IsEmpty() && repoObj.MST.IsPartial() {
+		return nil, cid.Undef, nil, fmt.Errorf("repo export has incomplete MST")
+	}
+	err = repoObj.MST.Walk(func(key []byte, val cid.Cid) error {
+		if _, err := repoObj.RecordStore.Get(ctx, val); err != nil {
+			return fmt.Errorf("repo export missing record block (%s): %w", string(key), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, cid.Undef, nil, err
+	}
+
+	blk, err := repoObj.RecordStore.Get(ctx, commitCID)
+	if err != nil {
+		return nil, cid.Undef, nil, err
+	}
+	return commit, commitCID, blk.RawData(), nil
+}
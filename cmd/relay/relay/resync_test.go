@@ -0,0 +1,288 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/internal/testutil"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/eventmgr"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/stretchr/testify/assert"
+)
+
+// in-memory event persister, which records all events
+type testPersister struct {
+	lk     sync.Mutex
+	events []*stream.XRPCStreamEvent
+}
+
+func (p *testPersister) Persist(ctx context.Context, e *stream.XRPCStreamEvent) error {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.events = append(p.events, e)
+	return nil
+}
+
+func (p *testPersister) Events() []*stream.XRPCStreamEvent {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return append([]*stream.XRPCStreamEvent{}, p.events...)
+}
+
+func (p *testPersister) Playback(ctx context.Context, since int64, cb func(*stream.XRPCStreamEvent) error) error {
+	return nil
+}
+func (p *testPersister) TakeDownRepo(ctx context.Context, uid uint64) error { return nil }
+func (p *testPersister) Flush(context.Context) error                        { return nil }
+func (p *testPersister) Shutdown(context.Context) error                     { return nil }
+func (p *testPersister) SetEventBroadcaster(func(*stream.XRPCStreamEvent))  {}
+
+type testResyncEnv struct {
+	relay     *Relay
+	hc        *MockHostChecker
+	persister *testPersister
+	key       crypto.PrivateKey
+	acc       *models.Account
+}
+
+func newTestResyncEnv(t *testing.T) *testResyncEnv {
+	db, err := cliutil.SetupDatabase("sqlite://:memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := syntax.DID("did:plc:abc111")
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:    did,
+		Handle: syntax.Handle("alice.example.com"),
+		Keys:   map[string]identity.Key{"atproto": {Type: "Multikey", PublicKeyMultibase: pub.Multibase()}},
+		Services: map[string]identity.Service{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: "https://pds.example.com"},
+		},
+	})
+
+	persister := &testPersister{}
+	config := DefaultRelayConfig()
+	config.ResyncWorkers = 1
+	config.ResyncMaxAttempts = 2
+	r, err := NewRelay(db, eventmgr.NewEventManager(persister), &dir, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := NewMockHostChecker()
+	r.HostChecker = hc
+
+	acc := models.Account{UID: 1, DID: did.String(), HostID: 1, Status: models.AccountStatusActive, UpstreamStatus: models.AccountStatusActive}
+	assert.NoError(t, db.Create(&models.Host{ID: 1, Hostname: "pds.example.com", Status: models.HostStatusActive}).Error)
+	assert.NoError(t, db.Create(&acc).Error)
+	return &testResyncEnv{relay: r, hc: hc, persister: persister, key: key, acc: &acc}
+}
+
+// builds a signed repo export with a couple records, returning the commit and CAR bytes
+func (env *testResyncEnv) repoExport(t *testing.T) (*repo.Commit, cid.Cid, []byte) {
+	tr := testutil.NewRepo(t, env.acc.DID, env.key)
+	for _, rkey := range []string{"3lbdw7ubypk2a", "3lbdw7ubypk2b"} {
+		tr.AddRecord(t, rkey, "hello "+rkey)
+	}
+	commitCID, commit, carBytes := tr.Commit(t)
+	return commit, commitCID, carBytes
+}
+
+func TestCommitChainBroken(t *testing.T) {
+	assert := assert.New(t)
+
+	dataCID, err := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	assert.NoError(err)
+	prevRepo := &models.AccountRepo{
+		Rev:           "3lbpxwyjzey2n",
+		CommitDataCID: dataCID.String(),
+	}
+	ld := lexutil.LexLink(dataCID)
+
+	evt := &comatproto.SyncSubscribeRepos_Commit{PrevData: &ld}
+	assert.False(commitChainBroken(evt, prevRepo))
+
+	// unknown previous state, or missing prevData, can't be checked
+	assert.False(commitChainBroken(evt, nil))
+	assert.False(commitChainBroken(&comatproto.SyncSubscribeRepos_Commit{}, prevRepo))
+
+	prevRepo.CommitDataCID = "bafyreihsj2b2d7b4uwhmd4n6wi5p6ozoqt3ekqpn3pfhazkv3lbxpykhoq"
+	assert.True(commitChainBroken(evt, prevRepo))
+}
+
+func TestResyncBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	d := resyncBackoff(1)
+	assert.GreaterOrEqual(d, time.Minute)
+	assert.Less(d, 2*time.Minute)
+
+	d = resyncBackoff(4)
+	assert.GreaterOrEqual(d, 8*time.Minute)
+	assert.Less(d, 16*time.Minute)
+
+	// capped, even for very large attempt counts
+	for _, attempts := range []int{10, 20, 100} {
+		d = resyncBackoff(attempts)
+		assert.GreaterOrEqual(d, resyncMaxBackoff)
+		assert.LessOrEqual(d, resyncMaxBackoff+resyncMaxBackoff/10)
+	}
+}
+
+func TestResyncWorker(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	env := newTestResyncEnv(t)
+	r := env.relay
+
+	commit, commitCID, carBytes := env.repoExport(t)
+	env.hc.Repos[env.acc.DID] = carBytes
+
+	assert.NoError(r.RequestResync(ctx, env.acc, "test"))
+	acc, err := r.GetAccount(ctx, syntax.DID(env.acc.DID))
+	assert.NoError(err)
+	assert.Equal(models.AccountStatusDesynchronized, acc.Status)
+
+	r.StartResyncWorkers(ctx)
+	assert.Eventually(func() bool {
+		row, err := r.GetAccountResync(ctx, env.acc.UID)
+		return err == nil && row == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	acc, err = r.GetAccount(ctx, syntax.DID(env.acc.DID))
+	assert.NoError(err)
+	assert.Equal(models.AccountStatusActive, acc.Status)
+
+	accRepo, err := r.GetAccountRepo(ctx, env.acc.UID)
+	assert.NoError(err)
+	assert.Equal(commit.Rev, accRepo.Rev)
+	assert.Equal(commitCID.String(), accRepo.CommitCID)
+	assert.Equal(commit.Data.String(), accRepo.CommitDataCID)
+
+	// #account (desynchronized), #sync, #account (active)
+	evts := env.persister.Events()
+	assert.Len(evts, 3)
+	if len(evts) == 3 {
+		assert.NotNil(evts[0].RepoAccount)
+		assert.False(evts[0].RepoAccount.Active)
+		sync := evts[1].RepoSync
+		assert.NotNil(sync)
+		if sync != nil {
+			assert.Equal(env.acc.DID, sync.Did)
+			assert.Equal(commit.Rev, sync.Rev)
+			cr, err := car.NewCarReader(bytes.NewReader(sync.Blocks))
+			assert.NoError(err)
+			assert.Equal([]cid.Cid{commitCID}, cr.Header.Roots)
+		}
+		assert.NotNil(evts[2].RepoAccount)
+		assert.True(evts[2].RepoAccount.Active)
+	}
+}
+
+func TestResyncParked(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	env := newTestResyncEnv(t)
+	r := env.relay
+
+	// no repo export available, so every attempt fails
+	assert.NoError(r.RequestResync(ctx, env.acc, "test"))
+	row, err := r.GetAccountResync(ctx, env.acc.UID)
+	assert.NoError(err)
+	r.processResync(ctx, row)
+
+	row, err = r.GetAccountResync(ctx, env.acc.UID)
+	assert.NoError(err)
+	assert.Equal(1, row.Attempts)
+	assert.False(row.Parked)
+	assert.NotEmpty(row.LastError)
+	assert.True(row.NextAttemptAt.After(time.Now()))
+
+	// parked after ResyncMaxAttempts failures
+	r.processResync(ctx, row)
+	row, err = r.GetAccountResync(ctx, env.acc.UID)
+	assert.NoError(err)
+	assert.Equal(2, row.Attempts)
+	assert.True(row.Parked)
+	acc, err := r.GetAccount(ctx, syntax.DID(env.acc.DID))
+	assert.NoError(err)
+	assert.Equal(models.AccountStatusDesynchronized, acc.Status)
+
+	// requesting resync again restarts the request
+	assert.NoError(r.RequestResync(ctx, acc, "retry"))
+	row, err = r.GetAccountResync(ctx, env.acc.UID)
+	assert.NoError(err)
+	assert.Equal(0, row.Attempts)
+	assert.False(row.Parked)
+	assert.Equal("retry", row.Reason)
+
+	// but an active request is left alone
+	assert.NoError(r.RequestResync(ctx, acc, "again"))
+	row, err = r.GetAccountResync(ctx, env.acc.UID)
+	assert.NoError(err)
+	assert.Equal("retry", row.Reason)
+}
+
+func TestRateLimitQuarantineWithResync(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	env := newTestResyncEnv(t)
+	r := env.relay
+	r.AccountLimiter = NewAccountLimiter(AccountLimiterConfig{PerMinute: 1, QuarantineThreshold: 2})
+	// resync enabled, but without workers, so the account stays desynchronized
+	r.resyncEnabled.Store(true)
+
+	commit := func() {
+		evt := &comatproto.SyncSubscribeRepos_Commit{
+			Repo: env.acc.DID,
+			Rev:  syntax.NewTIDNow(0).String(),
+			Time: syntax.DatetimeNow().String(),
+		}
+		// the allowed commit fails verification; that doesn't matter here
+		_ = r.processCommitEvent(ctx, evt, "pds.example.com", 1)
+	}
+
+	// first rate-limited commit marks the account desynchronized
+	commit()
+	commit()
+	acc, err := r.GetAccount(ctx, syntax.DID(env.acc.DID))
+	assert.NoError(err)
+	assert.Equal(models.AccountStatusDesynchronized, acc.Status)
+
+	// further commits are dropped, but still count as strikes
+	commit()
+	acc, err = r.GetAccount(ctx, syntax.DID(env.acc.DID))
+	assert.NoError(err)
+	assert.Equal(models.AccountStatusDesynchronized, acc.Status)
+	commit()
+	acc, err = r.GetAccount(ctx, syntax.DID(env.acc.DID))
+	assert.NoError(err)
+	assert.Equal(models.AccountStatusQuarantined, acc.Status)
+
+	q, err := r.GetAccountQuarantine(ctx, env.acc.UID)
+	assert.NoError(err)
+	assert.Equal(int64(3), q.DroppedEvents)
+}
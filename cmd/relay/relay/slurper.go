@@ -329,7 +329,8 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.Host,
 			time.Sleep(sleepForBackoff(backoff))
 			backoff++
 
-			if backoff > 15 {
+			// upstream relays are explicitly configured, so keep retrying (other upstreams cover in the meanwhile)
+			if backoff > 15 && !host.Relay {
 				logger.Warn("host does not appear to be online, disabling for now")
 				if err := s.Config.PersistHostStatusCallback(ctx, sub.HostID, models.HostStatusOffline); err != nil {
 					logger.Error("failed to update host status", "err", err)
@@ -340,9 +341,9 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.Host,
 			continue
 		}
 
-		// check if we connected to a relay (eg, this indigo relay, or rainbow) and drop if so
+		// check if we connected to a relay (eg, this indigo relay, or rainbow) and drop if so, unless it was configured as an upstream relay
 		serverHdr := resp.Header.Get("Server")
-		if strings.Contains(serverHdr, "atproto-relay") {
+		if strings.Contains(serverHdr, "atproto-relay") && !host.Relay {
 			logger.Warn("subscribed host is atproto relay of some kind, banning", "header", "Server", "value", serverHdr, "url", u)
 			if err := s.Config.PersistHostStatusCallback(ctx, sub.HostID, models.HostStatusBanned); err != nil {
 				logger.Error("failed to update host status", "err", err)
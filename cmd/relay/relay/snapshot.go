@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+)
+
+// Fetches a full repo export from the account's PDS, verifies it, and imports it to the local repo store (replacing any existing snapshot).
+//
+// This can be an expensive operation (up to the repo store size limit), so it is only triggered by admins, not by public requests. The number of concurrent fetches is limited. Returns an error if the repo store is not enabled.
+func (r *Relay) FetchRepoSnapshot(ctx context.Context, acc *models.Account) error {
+	if r.RepoStore == nil {
+		return fmt.Errorf("repo store not enabled")
+	}
+	did := syntax.DID(acc.DID)
+	logger := r.Logger.With("did", did)
+
+	select {
+	case r.snapshotFetchLimiter <- struct{}{}:
+		defer func() { <-r.snapshotFetchLimiter }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ident, err := r.Dir.LookupDID(ctx, did)
+	if err != nil {
+		return fmt.Errorf("account identity resolution: %w", err)
+	}
+
+	body, err := r.HostChecker.FetchRepo(ctx, ident)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	commit, err := r.RepoStore.ImportCAR(ctx, acc.UID, body, r.currentRepoRev(acc.UID), func(commit *repo.Commit) error {
+		if commit.DID != did.String() {
+			return fmt.Errorf("mismatched repo commit DID field: %s", commit.DID)
+		}
+		return r.VerifyCommitObject(ctx, commit, ident, "")
+	})
+	if err != nil {
+		logger.Warn("failed to import repo snapshot", "err", err)
+		return err
+	}
+	logger.Info("imported repo snapshot", "rev", commit.Rev)
+	return nil
+}
+
+// Returns a `minRev` callback for RepoStore.ImportCAR, which reads the current AccountRepo rev: snapshots must not roll back the repo state which has been verified from the firehose. This is evaluated under the snapshot lock, so it includes any commit applied concurrently by ingest.
+func (r *Relay) currentRepoRev(uid uint64) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		prevRepo, err := r.GetAccountRepo(ctx, uid)
+		if err != nil {
+			if errors.Is(err, ErrAccountRepoNotFound) {
+				return "", nil
+			}
+			return "", err
+		}
+		return prevRepo.Rev, nil
+	}
+}
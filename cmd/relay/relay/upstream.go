@@ -0,0 +1,181 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+)
+
+// Configures a trusted upstream relay, and subscribes to its firehose. The relay host is created if it doesn't exist; an existing host is marked as a relay.
+//
+// Multiple upstream relays can be subscribed at the same time. Each has a separate cursor (just like any other host), and events are de-duplicated across them. If one upstream goes down, events continue to flow from the others; when it comes back, it resumes from its own cursor.
+func (r *Relay) AddUpstreamRelay(ctx context.Context, raw string) error {
+	hostname, noSSL, err := ParseHostname(raw)
+	if err != nil {
+		return fmt.Errorf("invalid upstream relay: %w", err)
+	}
+
+	host, err := r.GetHost(ctx, hostname)
+	if err != nil && !errors.Is(err, ErrHostNotFound) {
+		return err
+	}
+	if host == nil {
+		host = &models.Host{
+			Hostname:     hostname,
+			NoSSL:        noSSL,
+			Status:       models.HostStatusActive,
+			Trusted:      true,
+			Relay:        true,
+			AccountLimit: r.Config.TrustedRepoLimit,
+		}
+		if err := r.db.WithContext(ctx).Create(host).Error; err != nil {
+			return err
+		}
+		r.Logger.Info("adding new upstream relay", "hostname", hostname, "noSSL", noSSL)
+	} else if !host.Relay || !host.Trusted {
+		if err := r.db.WithContext(ctx).Model(models.Host{}).Where("id = ?", host.ID).Updates(map[string]any{"relay": true, "trusted": true}).Error; err != nil {
+			return err
+		}
+		host.Relay = true
+		host.Trusted = true
+		r.Logger.Info("marked existing host as upstream relay", "hostname", hostname)
+	}
+
+	if host.Status == models.HostStatusBanned {
+		return fmt.Errorf("upstream relay is banned: %s", hostname)
+	}
+	if r.Slurper.CheckIfSubscribed(hostname) {
+		return nil
+	}
+	return r.subscribeHost(host)
+}
+
+// Starts a subscription to the host via the Slurper, remembering whether the host is an upstream relay.
+func (r *Relay) subscribeHost(host *models.Host) error {
+	if host.Relay {
+		r.upstreamRelays.Store(host.ID, true)
+		r.dedupeEnabled.Store(true)
+	}
+	return r.Slurper.Subscribe(host)
+}
+
+func (r *Relay) isUpstreamRelay(hostID uint64) bool {
+	_, ok := r.upstreamRelays.Load(hostID)
+	return ok
+}
+
+// Variant of preProcessEvent for events received from an upstream relay, instead of directly from a PDS.
+//
+// The account is associated with its current PDS host (based on identity resolution), not the relay it was received from. If the PDS host isn't known yet, a host row is created, but is not subscribed to.
+func (r *Relay) preProcessRelayEvent(ctx context.Context, did syntax.DID, logger *slog.Logger) (*models.Account, *identity.Identity, error) {
+
+	ident, err := r.Dir.LookupDID(ctx, did)
+	if err != nil {
+		return nil, nil, fmt.Errorf("account identity resolution: %w", err)
+	}
+	pdsHostID, pdsHostname, err := r.ensurePDSHost(ctx, ident)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	acc, err := r.GetAccount(ctx, did)
+	if err != nil {
+		if !errors.Is(err, ErrAccountNotFound) {
+			return nil, nil, fmt.Errorf("fetching account: %w", err)
+		}
+		acc, err = r.CreateAccountHost(ctx, did, pdsHostID, pdsHostname)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// keeps the account's host up to date when accounts migrate between PDS instances
+	if err := r.EnsureAccountHost(ctx, acc, pdsHostID, pdsHostname); err != nil {
+		return nil, nil, err
+	}
+
+	if !acc.IsActive() {
+		return acc, nil, nil
+	}
+	logger.Debug("processing event from upstream relay", "pdsHost", pdsHostname)
+	return acc, ident, nil
+}
+
+// cached state of a PDS host, for events received via upstream relays
+type pdsHostEntry struct {
+	ID     uint64
+	Banned bool
+}
+
+// Finds the host ID for the account's declared PDS, creating a host row if necessary. Returns the host ID and normalized hostname.
+//
+// Hosts discovered via an upstream relay are created in the "idle" state, so they are not subscribed to directly, with the same account limit as any other new host. Returns an error wrapping ErrHostBanned if the host, or its domain, has been banned locally, so that upstream relays can't be used to bypass host bans.
+func (r *Relay) ensurePDSHost(ctx context.Context, ident *identity.Identity) (uint64, string, error) {
+	pdsEndpoint := ident.PDSEndpoint()
+	if pdsEndpoint == "" {
+		return 0, "", fmt.Errorf("account has no declared PDS: %s", ident.DID)
+	}
+	hostname, noSSL, err := ParseHostname(pdsEndpoint)
+	if err != nil {
+		return 0, "", fmt.Errorf("account PDS endpoint invalid: %s", pdsEndpoint)
+	}
+
+	if entry, ok := r.pdsHostCache.Get(hostname); ok {
+		if entry.Banned {
+			return 0, "", fmt.Errorf("%w: %s", ErrHostBanned, hostname)
+		}
+		return entry.ID, hostname, nil
+	}
+
+	domainBanned, err := r.DomainIsBanned(ctx, hostname)
+	if err != nil {
+		return 0, "", err
+	}
+
+	host, err := r.GetHost(ctx, hostname)
+	if err != nil && !errors.Is(err, ErrHostNotFound) {
+		return 0, "", err
+	}
+	if host == nil {
+		if domainBanned {
+			r.pdsHostCache.Add(hostname, pdsHostEntry{Banned: true})
+			return 0, "", fmt.Errorf("%w: %s", ErrHostBanned, hostname)
+		}
+		accountLimit := r.Config.DefaultRepoLimit
+		trusted := IsTrustedHostname(hostname, r.Config.TrustedDomains)
+		if trusted {
+			accountLimit = r.Config.TrustedRepoLimit
+		}
+		host = &models.Host{
+			Hostname:     hostname,
+			NoSSL:        noSSL,
+			Status:       models.HostStatusIdle,
+			Trusted:      trusted,
+			AccountLimit: accountLimit,
+		}
+		if err := r.db.WithContext(ctx).Create(host).Error; err != nil {
+			// another event may have created the same host concurrently
+			existing, gerr := r.GetHost(ctx, hostname)
+			if gerr != nil {
+				return 0, "", err
+			}
+			host = existing
+		} else {
+			r.Logger.Info("discovered new host via upstream relay", "hostname", hostname)
+		}
+	}
+	entry := pdsHostEntry{
+		ID:     host.ID,
+		Banned: domainBanned || host.Status == models.HostStatusBanned,
+	}
+	r.pdsHostCache.Add(hostname, entry)
+	if entry.Banned {
+		return 0, "", fmt.Errorf("%w: %s", ErrHostBanned, hostname)
+	}
+	return host.ID, hostname, nil
+}
@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnsurePDSHost(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	env := newTestResyncEnv(t)
+	r := env.relay
+
+	ident := func(did, endpoint string) *identity.Identity {
+		return &identity.Identity{
+			DID:      syntax.DID(did),
+			Services: map[string]identity.Service{"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: endpoint}},
+		}
+	}
+
+	// discovered hosts get the default account limit
+	hostID, hostname, err := r.ensurePDSHost(ctx, ident("did:plc:abc222", "https://new.example.com"))
+	assert.NoError(err)
+	assert.Equal("new.example.com", hostname)
+	host, err := r.GetHostByID(ctx, hostID)
+	assert.NoError(err)
+	assert.Equal(models.HostStatusIdle, host.Status)
+	assert.Equal(r.Config.DefaultRepoLimit, host.AccountLimit)
+
+	// existing host, banned after being cached
+	_, _, err = r.ensurePDSHost(ctx, ident("did:plc:abc111", "https://pds.example.com"))
+	assert.NoError(err)
+	assert.NoError(r.UpdateHostStatus(ctx, 1, models.HostStatusBanned))
+	_, _, err = r.ensurePDSHost(ctx, ident("did:plc:abc111", "https://pds.example.com"))
+	assert.ErrorIs(err, ErrHostBanned)
+	_, _, err = r.preProcessRelayEvent(ctx, syntax.DID("did:plc:abc111"), r.Logger)
+	assert.ErrorIs(err, ErrHostBanned)
+
+	// new host on a banned domain is not created
+	assert.NoError(r.CreateDomainBan(ctx, "banned.example.com"))
+	_, _, err = r.ensurePDSHost(ctx, ident("did:plc:abc333", "https://pds.banned.example.com"))
+	assert.ErrorIs(err, ErrHostBanned)
+	_, err = r.GetHost(ctx, "pds.banned.example.com")
+	assert.ErrorIs(err, ErrHostNotFound)
+}
@@ -138,7 +138,7 @@ func (r *Relay) VerifyCommitMessageStrict(ctx context.Context, evt *comatproto.S
 		return fmt.Errorf("missing prevData field")
 	}
 	if prevRepo != nil {
-		if evt.PrevData.String() != prevRepo.CommitDataCID {
+		if commitChainBroken(evt, prevRepo) {
 			logger.Warn("commit with miss-matching prevData", "prevData", evt.PrevData, "prevRepo.CommitDataCID", prevRepo.CommitDataCID)
 		}
 		if evt.Since != nil && *evt.Since != prevRepo.Rev {
@@ -206,3 +206,8 @@ func (r *Relay) VerifyRepoSync(ctx context.Context, evt *comatproto.SyncSubscrib
 	}
 	return &resp, nil
 }
+
+// Checks if a commit message fails to chain from the previous known repo state (`prevData` doesn't match). Returns false if the previous state is unknown, or the message doesn't include `prevData`.
+func commitChainBroken(evt *comatproto.SyncSubscribeRepos_Commit, prevRepo *models.AccountRepo) bool {
+	return prevRepo != nil && evt.PrevData != nil && evt.PrevData.String() != prevRepo.CommitDataCID
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/cmd/relay/relay"
 	"github.com/bluesky-social/indigo/util/svcutil"
 
@@ -41,6 +42,9 @@ type ServiceConfig struct {
 
 	// if true, allows non-SSL hosts to be added via public requestCrawl
 	AllowInsecureHosts bool
+
+	// optional key used to sign host and account inventory exports
+	InventorySigningKey crypto.PrivateKey
 }
 
 func DefaultServiceConfig() *ServiceConfig {
@@ -121,7 +125,7 @@ func (svc *Service) startWithListener(listen net.Listener) error {
 			}
 		default:
 			sendHeader := true
-			if c.Path() == "/xrpc/com.atproto.sync.subscribeRepos" {
+			if c.Path() == "/xrpc/com.atproto.sync.subscribeRepos" || c.Path() == "/json/subscribeRepos" {
 				sendHeader = false
 			}
 
@@ -150,10 +154,15 @@ func (svc *Service) startWithListener(listen net.Listener) error {
 	e.GET("/xrpc/com.atproto.sync.listHosts", svc.HandleComAtprotoSyncListHosts)
 	e.GET("/xrpc/com.atproto.sync.getHostStatus", svc.HandleComAtprotoSyncGetHostStatus)
 	e.GET("/xrpc/com.atproto.sync.listRepos", svc.HandleComAtprotoSyncListRepos)
-	e.GET("/xrpc/com.atproto.sync.getRepo", svc.HandleComAtprotoSyncGetRepo) // just returns 3xx redirect to source PDS
+	e.GET("/xrpc/com.atproto.sync.getRepo", svc.HandleComAtprotoSyncGetRepo)     // returns 3xx redirect to source PDS, unless repo store is enabled
+	e.GET("/xrpc/com.atproto.sync.getRecord", svc.HandleComAtprotoSyncGetRecord) // same
+	e.GET("/xrpc/com.atproto.sync.getBlocks", svc.HandleComAtprotoSyncGetBlocks) // same
 	e.GET("/xrpc/com.atproto.sync.getRepoStatus", svc.HandleComAtprotoSyncGetRepoStatus)
 	e.GET("/xrpc/com.atproto.sync.getLatestCommit", svc.HandleComAtprotoSyncGetLatestCommit)
 
+	// non-standard JSON variant of the firehose
+	e.GET("/json/subscribeRepos", svc.HandleSubscribeReposJSON)
+
 	admin := e.Group("/admin", svc.checkAdminAuth)
 
 	// Slurper-related Admin API
@@ -173,6 +182,12 @@ func (svc *Service) startWithListener(listen net.Listener) error {
 	admin.GET("/repo/takedowns", svc.handleAdminListRepoTakeDowns) // NOTE: unused
 	admin.POST("/repo/takeDown", svc.handleAdminTakeDownRepo)
 	admin.POST("/repo/reverseTakedown", svc.handleAdminReverseTakedown)
+	admin.GET("/repo/quarantined", svc.handleAdminListQuarantines)
+	admin.GET("/repo/quarantine", svc.handleAdminGetQuarantine)
+	admin.POST("/repo/releaseQuarantine", svc.handleAdminReleaseQuarantine)
+	admin.GET("/repo/inspect", svc.handleAdminInspectRepo)
+	admin.POST("/repo/fetchSnapshot", svc.handleAdminFetchRepoSnapshot)
+	admin.POST("/repo/resync", svc.handleAdminRequestResync)
 
 	// Host-related Admin API
 	admin.GET("/pds/list", svc.handleListHosts)
@@ -185,6 +200,9 @@ func (svc *Service) startWithListener(listen net.Listener) error {
 	// Consumer-related Admin API
 	admin.GET("/consumers/list", svc.handleAdminListConsumers)
 
+	// Inventory export (hosts and accounts)
+	admin.GET("/inventory/export", svc.handleAdminExportInventory)
+
 	// In order to support booting on random ports in tests, we need to tell the
 	// Echo instance it's already got a port, and then use its StartServer
 	// method to re-use that listener.
@@ -204,6 +222,12 @@ func (svc *Service) Shutdown() []error {
 		errs = append(errs, err)
 	}
 
+	if svc.relay.RepoStore != nil {
+		if err := svc.relay.RepoStore.Shutdown(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	return errs
 }
 
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bluesky-social/indigo/cmd/relay/stream"
@@ -22,6 +23,9 @@ type EventManager struct {
 
 	persister persist.EventPersistence
 
+	// sequence number of the most recently broadcast event
+	headSeq atomic.Int64
+
 	log *slog.Logger
 }
 
@@ -42,6 +46,11 @@ func (em *EventManager) Shutdown(ctx context.Context) error {
 	return em.persister.Shutdown(ctx)
 }
 
+// Returns the sequence number of the most recently broadcast event, or zero if none have been broadcast since startup
+func (em *EventManager) CurrentSeq() int64 {
+	return em.headSeq.Load()
+}
+
 // broadcastEvent is the target for EventPersistence.SetEventBroadcaster()
 func (em *EventManager) broadcastEvent(evt *stream.XRPCStreamEvent) {
 	// the main thing we do is send it out, so MarshalCBOR once
@@ -51,6 +60,10 @@ func (em *EventManager) broadcastEvent(evt *stream.XRPCStreamEvent) {
 		return
 	}
 
+	if seq := evt.Sequence(); seq > 0 {
+		em.headSeq.Store(seq)
+	}
+
 	em.subsLk.Lock()
 	defer em.subsLk.Unlock()
 
@@ -62,41 +75,117 @@ func (em *EventManager) broadcastEvent(evt *stream.XRPCStreamEvent) {
 	for _, s := range em.subs {
 		if s.filter(evt) {
 			s.enqueuedCounter.Inc()
-			select {
-			case s.outgoing <- evt:
-				// sent evt on this subscriber's chan! yay!
-			case <-s.done:
-				// this subscriber is closing, quickly do nothing
+			switch s.policy {
+			case SlowConsumerDrop:
+				em.sendOrDrop(s, evt)
+			case SlowConsumerSpill:
+				em.sendOrSpill(s, evt)
 			default:
-				// filter out all future messages that would be
-				// sent to this subscriber, but wait for it to
-				// actually be removed by the correct bit of
-				// code
-				s.filter = func(*stream.XRPCStreamEvent) bool { return false }
-
-				em.log.Warn("dropping slow consumer due to event overflow", "bufferSize", len(s.outgoing), "ident", s.ident)
-				go func(torem *Subscriber) {
-					torem.lk.Lock()
-					if !torem.cleanedUp {
-						select {
-						case torem.outgoing <- &stream.XRPCStreamEvent{
-							Error: &stream.ErrorFrame{
-								Error: "ConsumerTooSlow",
-							},
-						}:
-						case <-time.After(time.Second * 5):
-							em.log.Warn("failed to send error frame to backed up consumer", "ident", torem.ident)
-						}
-					}
-					torem.lk.Unlock()
-					torem.cleanup()
-				}(s)
+				em.sendOrDisconnect(s, evt)
 			}
 			s.broadcastCounter.Inc()
 		}
 	}
 }
 
+// NOTE: these send helpers are called with subsLk held
+
+func (em *EventManager) sendOrDisconnect(s *Subscriber, evt *stream.XRPCStreamEvent) {
+	select {
+	case s.outgoing <- evt:
+		// sent evt on this subscriber's chan! yay!
+	case <-s.done:
+		// this subscriber is closing, quickly do nothing
+	default:
+		// filter out all future messages that would be
+		// sent to this subscriber, but wait for it to
+		// actually be removed by the correct bit of
+		// code
+		s.filter = func(*stream.XRPCStreamEvent) bool { return false }
+
+		em.log.Warn("dropping slow consumer due to event overflow", "bufferSize", len(s.outgoing), "ident", s.ident)
+		go func(torem *Subscriber) {
+			torem.lk.Lock()
+			if !torem.cleanedUp {
+				select {
+				case torem.outgoing <- &stream.XRPCStreamEvent{
+					Error: &stream.ErrorFrame{
+						Error: "ConsumerTooSlow",
+					},
+				}:
+				case <-time.After(time.Second * 5):
+					em.log.Warn("failed to send error frame to backed up consumer", "ident", torem.ident)
+				}
+			}
+			torem.lk.Unlock()
+			torem.cleanup()
+		}(s)
+	}
+}
+
+func (em *EventManager) sendOrDrop(s *Subscriber, evt *stream.XRPCStreamEvent) {
+	seq := evt.Sequence()
+
+	// report any previously skipped events before resuming
+	if s.dropEnd > 0 {
+		select {
+		case s.outgoing <- droppedInfoFrame(s.dropStart, s.dropEnd):
+			s.dropStart, s.dropEnd = 0, 0
+		case <-s.done:
+			return
+		default:
+			// still no room; extend the skipped range
+			s.dropEnd = seq
+			s.recordDropped()
+			return
+		}
+	}
+
+	select {
+	case s.outgoing <- evt:
+	case <-s.done:
+	default:
+		if s.dropStart == 0 {
+			em.log.Warn("skipping events for slow consumer", "bufferSize", len(s.outgoing), "ident", s.ident, "seq", seq)
+			s.dropStart = seq
+		}
+		s.dropEnd = seq
+		s.recordDropped()
+	}
+}
+
+func (em *EventManager) sendOrSpill(s *Subscriber, evt *stream.XRPCStreamEvent) {
+	if s.spilled {
+		// will be sent from persisted events instead
+		s.recordDropped()
+		return
+	}
+
+	select {
+	case s.outgoing <- evt:
+	case <-s.done:
+	default:
+		em.log.Warn("slow consumer falling back to playback from persisted events", "bufferSize", len(s.outgoing), "ident", s.ident, "seq", evt.Sequence())
+		s.spilled = true
+		s.recordDropped()
+		if s.stats != nil {
+			s.stats.Spills.Add(1)
+		}
+		subscriberSpills.WithLabelValues(s.ident).Inc()
+		select {
+		case s.spill <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Subscriber) recordDropped() {
+	s.droppedCounter.Inc()
+	if s.stats != nil {
+		s.stats.EventsDropped.Add(1)
+	}
+}
+
 func (em *EventManager) persistAndSendEvent(ctx context.Context, evt *stream.XRPCStreamEvent) {
 	// TODO: can cut 5-10% off of disk persister benchmarks by making this function
 	// accept a uid. The lookup inside the persister is notably expensive (despite
@@ -114,11 +203,21 @@ func (em *EventManager) AddEvent(ctx context.Context, ev *stream.XRPCStreamEvent
 	return nil
 }
 
-func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func(*stream.XRPCStreamEvent) bool, since *int64) (<-chan *stream.XRPCStreamEvent, func(), error) {
+func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func(*stream.XRPCStreamEvent) bool, since *int64, opts *SubscribeOptions) (<-chan *stream.XRPCStreamEvent, func(), error) {
 	// TODO: the only known filters are 'true' and 'false', replace the function pointer with a bool
 	if filter == nil {
 		filter = func(*stream.XRPCStreamEvent) bool { return true }
 	}
+	if opts == nil {
+		opts = &SubscribeOptions{}
+	}
+	policy := opts.Policy
+	if policy == "" {
+		policy = SlowConsumerDisconnect
+	}
+	if _, err := ParseSlowConsumerPolicy(string(policy)); err != nil {
+		return nil, nil, err
+	}
 
 	done := make(chan struct{})
 	sub := &Subscriber{
@@ -126,8 +225,12 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 		outgoing:         make(chan *stream.XRPCStreamEvent, em.bufferSize),
 		filter:           filter,
 		done:             done,
+		policy:           policy,
+		stats:            opts.Stats,
+		spill:            make(chan struct{}, 1),
 		enqueuedCounter:  eventsEnqueued.WithLabelValues(ident),
 		broadcastCounter: eventsBroadcast.WithLabelValues(ident),
+		droppedCounter:   eventsDropped.WithLabelValues(ident),
 	}
 
 	sub.cleanup = sync.OnceFunc(func() {
@@ -139,42 +242,38 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 		sub.cleanedUp = true
 	})
 
-	if since == nil {
+	// "spill" subscribers always need a goroutine between the live buffer and the consumer, to switch over to playback
+	if since == nil && policy != SlowConsumerSpill {
 		em.addSubscriber(sub)
 		return sub.outgoing, sub.cleanup, nil
 	}
 
 	out := make(chan *stream.XRPCStreamEvent, em.crossoverBufferSize)
 
+	if since == nil {
+		// no playback needed; start buffering live events immediately
+		em.addSubscriber(sub)
+	}
+
 	go func() {
-		lastSeq := *since
-		// run playback to get through *most* of the events, getting our current cursor close to realtime
-		if err := em.persister.Playback(ctx, *since, func(e *stream.XRPCStreamEvent) error {
-			select {
-			case <-done:
-				return ErrPlaybackShutdown
-			case out <- e:
-				seq := SequenceForEvent(e)
-				if seq > 0 {
-					lastSeq = seq
-				}
-				return nil
-			}
-		}); err != nil {
-			if errors.Is(err, ErrPlaybackShutdown) {
-				em.log.Warn("events playback", "err", err)
-			} else {
-				em.log.Error("events playback", "err", err)
+		var lastSeq int64
+		var ok bool
+		if since != nil {
+			var err error
+			// run playback to get through *most* of the events, getting our current cursor close to realtime
+			lastSeq, err = em.playbackTo(ctx, sub, out, *since)
+			if err != nil {
+				// TODO: send an error frame or something?
+				// NOTE: not doing em.rmSubscriber(sub) here because it hasn't been added yet
+				close(out)
+				return
 			}
-
-			// TODO: send an error frame or something?
-			// NOTE: not doing em.rmSubscriber(sub) here because it hasn't been added yet
-			close(out)
-			return
 		}
 
 		// now, start buffering events from the live stream
-		em.addSubscriber(sub)
+		if since != nil {
+			em.addSubscriber(sub)
+		}
 
 		// ensure that we clean up any return paths from here out, after having added the subscriber. Note that `out` is not `sub.output`, so needs to be closed separately.
 		defer func() {
@@ -182,32 +281,60 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 			em.rmSubscriber(sub)
 		}()
 
-		first := <-sub.outgoing
-
-		// run playback again to get us to the events that have started buffering
-		if err := em.persister.Playback(ctx, lastSeq, func(e *stream.XRPCStreamEvent) error {
-			seq := SequenceForEvent(e)
-			if seq > SequenceForEvent(first) {
-				return ErrCaughtUp
-			}
-
-			select {
-			case <-done:
-				return ErrPlaybackShutdown
-			case out <- e:
-				return nil
-			}
-		}); err != nil {
-			if !errors.Is(err, ErrCaughtUp) {
-				em.log.Error("events playback", "err", err)
+		if since != nil {
+			if lastSeq, ok = em.crossover(ctx, sub, out, lastSeq); !ok {
 				return
 			}
 		}
 
 		// now that we are caught up, just copy events from the channel over
-		for evt := range sub.outgoing {
+		for {
 			select {
-			case out <- evt:
+			case evt, ok := <-sub.outgoing:
+				if !ok {
+					return
+				}
+				select {
+				case out <- evt:
+				case <-done:
+					return
+				}
+				if seq := evt.Sequence(); seq > 0 {
+					lastSeq = seq
+				}
+			case <-sub.spill:
+				// the live stream is no longer being buffered for us. flush what is already buffered, then catch up from persisted events
+				for drained := false; !drained; {
+					select {
+					case evt, ok := <-sub.outgoing:
+						if !ok {
+							return
+						}
+						select {
+						case out <- evt:
+						case <-done:
+							return
+						}
+						if seq := evt.Sequence(); seq > 0 {
+							lastSeq = seq
+						}
+					default:
+						drained = true
+					}
+				}
+
+				var err error
+				if lastSeq, err = em.playbackTo(ctx, sub, out, lastSeq); err != nil {
+					return
+				}
+
+				em.subsLk.Lock()
+				sub.spilled = false
+				em.subsLk.Unlock()
+
+				if lastSeq, ok = em.crossover(ctx, sub, out, lastSeq); !ok {
+					return
+				}
 			case <-done:
 				return
 			}
@@ -217,6 +344,85 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 	return out, sub.cleanup, nil
 }
 
+// Sends persisted events after `since` to `out`, until playback reaches the end of persisted events. Returns the last sequence number sent.
+func (em *EventManager) playbackTo(ctx context.Context, sub *Subscriber, out chan<- *stream.XRPCStreamEvent, since int64) (int64, error) {
+	lastSeq := since
+	err := em.persister.Playback(ctx, since, func(e *stream.XRPCStreamEvent) error {
+		select {
+		case <-sub.done:
+			return ErrPlaybackShutdown
+		case out <- e:
+			seq := SequenceForEvent(e)
+			if seq > 0 {
+				lastSeq = seq
+			}
+			return nil
+		}
+	})
+	if err != nil {
+		if errors.Is(err, ErrPlaybackShutdown) {
+			em.log.Warn("events playback", "err", err)
+		} else {
+			em.log.Error("events playback", "err", err)
+		}
+	}
+	return lastSeq, err
+}
+
+// Bridges the gap between playback (which ended at `lastSeq`) and the live events buffered for an already-added subscriber. Waits for the first buffered event, then plays back persisted events up to and including it. Returns the new last sequence number, and false if the subscriber should shut down.
+func (em *EventManager) crossover(ctx context.Context, sub *Subscriber, out chan<- *stream.XRPCStreamEvent, lastSeq int64) (int64, bool) {
+	var first *stream.XRPCStreamEvent
+	var firstSeq int64
+	for firstSeq <= 0 {
+		var ok bool
+		first, ok = <-sub.outgoing
+		if !ok {
+			return lastSeq, false
+		}
+		firstSeq = SequenceForEvent(first)
+		if firstSeq <= 0 {
+			// info frames don't have a sequence number, and don't need to be ordered against playback
+			select {
+			case out <- first:
+			case <-sub.done:
+				return lastSeq, false
+			}
+		}
+	}
+
+	// run playback again to get us to the events that have started buffering
+	if err := em.persister.Playback(ctx, lastSeq, func(e *stream.XRPCStreamEvent) error {
+		seq := SequenceForEvent(e)
+		if seq > firstSeq {
+			return ErrCaughtUp
+		}
+
+		select {
+		case <-sub.done:
+			return ErrPlaybackShutdown
+		case out <- e:
+			lastSeq = seq
+			return nil
+		}
+	}); err != nil {
+		if !errors.Is(err, ErrCaughtUp) {
+			em.log.Error("events playback", "err", err)
+			return lastSeq, false
+		}
+	}
+
+	// in case playback didn't include the first buffered event
+	if lastSeq < firstSeq {
+		select {
+		case out <- first:
+			lastSeq = firstSeq
+		case <-sub.done:
+			return lastSeq, false
+		}
+	}
+	return lastSeq, true
+}
+
 func (em *EventManager) rmSubscriber(sub *Subscriber) {
 	em.subsLk.Lock()
 	defer em.subsLk.Unlock()
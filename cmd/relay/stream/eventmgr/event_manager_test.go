@@ -0,0 +1,129 @@
+package eventmgr
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/persist/pebblepersist"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEventManager(t *testing.T) *EventManager {
+	opts := pebblepersist.DefaultPebblePersistOptions()
+	opts.DbPath = filepath.Join(t.TempDir(), "pebble")
+	opts.GCPeriod = 0
+	pp, err := pebblepersist.NewPebblePersistence(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	em := NewEventManager(pp)
+	em.bufferSize = 4
+	em.crossoverBufferSize = 2
+	t.Cleanup(func() { em.Shutdown(context.Background()) })
+	return em
+}
+
+func addEvents(t *testing.T, em *EventManager, count int) {
+	for range count {
+		evt := &stream.XRPCStreamEvent{
+			RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:example:1", Time: syntax.DatetimeNow().String()},
+			PrivUid:      1,
+		}
+		if err := em.AddEvent(context.Background(), evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func nextEvent(t *testing.T, evts <-chan *stream.XRPCStreamEvent) *stream.XRPCStreamEvent {
+	select {
+	case evt, ok := <-evts:
+		if !ok {
+			t.Fatal("event stream closed")
+		}
+		return evt
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	assert := assert.New(t)
+	em := testEventManager(t)
+
+	evts, cleanup, err := em.Subscribe(context.Background(), "test", nil, nil, nil)
+	assert.NoError(err)
+	defer cleanup()
+
+	addEvents(t, em, 10)
+	for i := range 4 {
+		assert.Equal(int64(i+1), nextEvent(t, evts).Sequence())
+	}
+	evt := nextEvent(t, evts)
+	assert.NotNil(evt.Error)
+	assert.Equal("ConsumerTooSlow", evt.Error.Error)
+}
+
+func TestSlowConsumerDrop(t *testing.T) {
+	assert := assert.New(t)
+	em := testEventManager(t)
+
+	var stats SubscriberStats
+	evts, cleanup, err := em.Subscribe(context.Background(), "test", nil, nil, &SubscribeOptions{Policy: SlowConsumerDrop, Stats: &stats})
+	assert.NoError(err)
+	defer cleanup()
+
+	addEvents(t, em, 10)
+	assert.Equal(uint64(6), stats.EventsDropped.Load())
+	for i := range 4 {
+		assert.Equal(int64(i+1), nextEvent(t, evts).Sequence())
+	}
+
+	// skipped range is reported before the next event
+	addEvents(t, em, 1)
+	evt := nextEvent(t, evts)
+	assert.NotNil(evt.RepoInfo)
+	assert.Equal(InfoEventsDropped, evt.RepoInfo.Name)
+	assert.Contains(*evt.RepoInfo.Message, "seq 5 through 10")
+	assert.Equal(int64(11), nextEvent(t, evts).Sequence())
+	assert.Equal(uint64(0), stats.Spills.Load())
+	assert.Equal(int64(11), em.CurrentSeq())
+}
+
+func TestSlowConsumerSpill(t *testing.T) {
+	assert := assert.New(t)
+	em := testEventManager(t)
+
+	for _, since := range []*int64{nil, new(int64)} {
+		var stats SubscriberStats
+		evts, cleanup, err := em.Subscribe(context.Background(), "test", nil, since, &SubscribeOptions{Policy: SlowConsumerSpill, Stats: &stats})
+		assert.NoError(err)
+
+		// when starting from a cursor, existing events are played back first
+		head := em.CurrentSeq()
+		for i := int64(0); since != nil && i < head; i++ {
+			assert.Equal(i+1, nextEvent(t, evts).Sequence())
+		}
+
+		// no events are lost or re-ordered, even though far more than the buffer size were broadcast
+		addEvents(t, em, 20)
+		for i := range int64(20) {
+			assert.Equal(head+i+1, nextEvent(t, evts).Sequence())
+		}
+		if since == nil {
+			assert.Equal(uint64(1), stats.Spills.Load())
+		}
+
+		// live events resume after catching up
+		addEvents(t, em, 1)
+		assert.Equal(head+21, nextEvent(t, evts).Sequence())
+		cleanup()
+	}
+}
@@ -14,3 +14,13 @@ var eventsBroadcast = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_events_broadcast_total",
 	Help: "Total number of events broadcast to subscribers",
 }, []string{"pool"})
+
+var eventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_events_dropped_slow_consumer_total",
+	Help: "Total number of live events not delivered to slow subscribers (skipped, or to be replayed from persistence)",
+}, []string{"pool"})
+
+var subscriberSpills = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_subscriber_spills_total",
+	Help: "Total number of times a slow subscriber switched to catching up from persisted events",
+}, []string{"pool"})
@@ -1,13 +1,65 @@
 package eventmgr
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/cmd/relay/stream"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// How the event manager handles a subscriber whose outgoing buffer is full
+type SlowConsumerPolicy string
+
+const (
+	// send a ConsumerTooSlow error frame and disconnect the subscriber. This is the default
+	SlowConsumerDisconnect = SlowConsumerPolicy("disconnect")
+	// skip events until there is room in the buffer, then send an info frame with the skipped sequence range
+	SlowConsumerDrop = SlowConsumerPolicy("drop")
+	// stop buffering live events, and catch up from persisted events once the subscriber has drained its buffer
+	SlowConsumerSpill = SlowConsumerPolicy("spill")
+)
+
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch p := SlowConsumerPolicy(s); p {
+	case SlowConsumerDisconnect, SlowConsumerDrop, SlowConsumerSpill:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy: %s", s)
+	}
+}
+
+// Name of the info frame sent to subscribers with the "drop" policy, after events were skipped
+const InfoEventsDropped = "EventsDropped"
+
+func droppedInfoFrame(start, end int64) *stream.XRPCStreamEvent {
+	msg := fmt.Sprintf("consumer too slow, skipped events seq %d through %d", start, end)
+	return &stream.XRPCStreamEvent{
+		RepoInfo: &comatproto.SyncSubscribeRepos_Info{
+			Name:    InfoEventsDropped,
+			Message: &msg,
+		},
+	}
+}
+
+type SubscribeOptions struct {
+	// defaults to SlowConsumerDisconnect
+	Policy SlowConsumerPolicy
+
+	// optional; if non-nil, updated as events are dropped or spilled for this subscriber
+	Stats *SubscriberStats
+}
+
+type SubscriberStats struct {
+	// number of live events not delivered from the buffer (with the "drop" policy, these are skipped entirely)
+	EventsDropped atomic.Uint64
+	// number of times the subscriber fell behind and switched to catching up from persisted events
+	Spills atomic.Uint64
+}
+
 type Subscriber struct {
 	outgoing chan *stream.XRPCStreamEvent
 
@@ -20,7 +72,20 @@ type Subscriber struct {
 	lk        sync.Mutex
 	cleanedUp bool
 
+	policy SlowConsumerPolicy
+	stats  *SubscriberStats
+
+	// "drop" policy: range of sequence numbers skipped and not yet reported. protected by EventManager.subsLk
+	dropStart int64
+	dropEnd   int64
+
+	// "spill" policy: set when live events are no longer being buffered. protected by EventManager.subsLk
+	spilled bool
+	// signaled (non-blocking) when the subscriber first spills
+	spill chan struct{}
+
 	ident            string
 	enqueuedCounter  prometheus.Counter
 	broadcastCounter prometheus.Counter
+	droppedCounter   prometheus.Counter
 }
@@ -0,0 +1,23 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("archived segment not found")
+
+// Long-term storage for event log segments which have aged out of local disk.
+//
+// Segments are opaque, immutable blobs identified by name (eg, "evts-12345"). They are written once when archived, and are never modified (takedowns are applied at read time by the caller).
+type SegmentArchive interface {
+	// Implementations may read the data more than once (eg, to compute a checksum before uploading), so it must be seekable
+	Put(ctx context.Context, name string, data io.ReadSeeker) error
+
+	// Returns ErrNotFound if the segment does not exist
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// Deleting a segment which does not exist is not an error
+	Delete(ctx context.Context, name string) error
+}
@@ -0,0 +1,246 @@
+package archive
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type S3Config struct {
+	// Base URL of the S3-compatible service, eg "https://s3.us-east-1.amazonaws.com" or "http://localhost:9000"
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	// Optional prefix for object keys, eg "relay-archive/"
+	Prefix string
+
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Optional; defaults to an HTTP client with a generous timeout
+	Client *http.Client
+}
+
+// Stores segments as objects in an S3-compatible bucket (AWS S3, MinIO, R2, etc), using path-style URLs and AWS Signature Version 4 auth.
+type S3Archive struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+
+	// for testing
+	now func() time.Time
+}
+
+var _ SegmentArchive = (*S3Archive)(nil)
+
+func NewS3Archive(config S3Config) (*S3Archive, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, fmt.Errorf("S3 archive requires endpoint and bucket")
+	}
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint scheme: %s", u.Scheme)
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Minute}
+	}
+	return &S3Archive{
+		config:   config,
+		endpoint: u,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+func (a *S3Archive) objectURL(name string) *url.URL {
+	u := *a.endpoint
+	key := a.config.Prefix + name
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + a.config.Bucket + "/" + key
+	u.RawPath = strings.TrimSuffix(u.RawPath, "/") + "/" + uriEncode(a.config.Bucket, false) + "/" + uriEncode(key, false)
+	return &u
+}
+
+func (a *S3Archive) do(ctx context.Context, method, name string, body io.ReadSeeker) (*http.Response, error) {
+	// the payload is signed, so the body is read once to hash it, and again (streamed) to send it
+	payloadHash := sha256Hex(nil)
+	var size int64
+	if body != nil {
+		h := sha256.New()
+		n, err := io.Copy(h, body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		payloadHash = hex.EncodeToString(h.Sum(nil))
+		size = n
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.objectURL(name).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = io.NopCloser(body)
+	}
+	req.ContentLength = size
+	signRequestV4(req, payloadHash, a.config.AccessKeyID, a.config.SecretAccessKey, a.config.Region, a.now())
+	return a.client.Do(req)
+}
+
+func (a *S3Archive) Put(ctx context.Context, name string, data io.ReadSeeker) error {
+	resp, err := a.do(ctx, http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (a *S3Archive) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := a.do(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (a *S3Archive) Delete(ctx context.Context, name string) error {
+	resp, err := a.do(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 returns 204 even if the object didn't exist; some compatible services return 404
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func s3Error(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 request failed (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+// Adds AWS Signature Version 4 headers (for the "s3" service) to the request, given the hex SHA-256 of the body. Signs the host header, plus any content-type, range, and x-amz-* headers already set.
+func signRequestV4(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vals := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(vals, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := q[k]
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// URI-encodes a string as required by SigV4: everything except unreserved characters is percent-encoded. Slashes are left alone unless `encodeSlash` is true.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/cmd/relay/stream"
 	"github.com/bluesky-social/indigo/cmd/relay/stream/persist"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/persist/archive"
 	arc "github.com/hashicorp/golang-lru/arc/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -31,6 +32,10 @@ type DiskPersistence struct {
 	writeBufferSize int
 	retention       time.Duration
 
+	// optional remote tier for segments aged out of local disk
+	archive          archive.SegmentArchive
+	archiveRetention time.Duration
+
 	meta *gorm.DB
 
 	broadcast func(*stream.XRPCStreamEvent)
@@ -79,6 +84,12 @@ type DiskPersistOptions struct {
 	WriteBufferSize int
 	Retention       time.Duration
 
+	// If set, log files older than Retention are uploaded here (instead of being deleted), and remain available for playback
+	Archive archive.SegmentArchive
+
+	// Total playback history to keep when Archive is set (including the local Retention period). Zero means archived segments are kept forever
+	ArchiveRetention time.Duration
+
 	// starting sequence number to use (if there is no existing persisted data)
 	InitialSeq int64
 
@@ -115,7 +126,7 @@ func NewDiskPersistence(primaryDir, archiveDir string, db *gorm.DB, opts *DiskPe
 		return nil, fmt.Errorf("failed to create did cache: %w", err)
 	}
 
-	if err := db.AutoMigrate(&LogFileRef{}); err != nil {
+	if err := db.AutoMigrate(&LogFileRef{}, &UserAction{}); err != nil {
 		return nil, fmt.Errorf("failed to set up database: %w", err)
 	}
 
@@ -136,21 +147,23 @@ func NewDiskPersistence(primaryDir, archiveDir string, db *gorm.DB, opts *DiskPe
 	}
 
 	dp := &DiskPersistence{
-		meta:            db,
-		primaryDir:      primaryDir,
-		archiveDir:      archiveDir,
-		buffers:         bufpool,
-		retention:       opts.Retention,
-		writers:         wrpool,
-		uidCache:        uidCache,
-		didCache:        didCache,
-		eventsPerFile:   opts.EventsPerFile,
-		scratch:         make([]byte, headerSize),
-		outbuf:          new(bytes.Buffer),
-		writeBufferSize: opts.WriteBufferSize,
-		shutdown:        make(chan struct{}),
-		log:             opts.Logger,
-		initialSeq:      opts.InitialSeq,
+		meta:             db,
+		primaryDir:       primaryDir,
+		archiveDir:       archiveDir,
+		buffers:          bufpool,
+		retention:        opts.Retention,
+		archive:          opts.Archive,
+		archiveRetention: opts.ArchiveRetention,
+		writers:          wrpool,
+		uidCache:         uidCache,
+		didCache:         didCache,
+		eventsPerFile:    opts.EventsPerFile,
+		scratch:          make([]byte, headerSize),
+		outbuf:           new(bytes.Buffer),
+		writeBufferSize:  opts.WriteBufferSize,
+		shutdown:         make(chan struct{}),
+		log:              opts.Logger,
+		initialSeq:       opts.InitialSeq,
 	}
 	if dp.log == nil {
 		dp.log = slog.Default().With("system", "diskpersist")
@@ -257,7 +270,7 @@ func (dp *DiskPersistence) swapLog(ctx context.Context) error {
 	return nil
 }
 
-func scanForLastSeq(fi *os.File, end int64) (int64, error) {
+func scanForLastSeq(fi io.ReadSeeker, end int64) (int64, error) {
 	scratch := make([]byte, headerSize)
 
 	var lastSeq int64 = -1
@@ -411,6 +424,11 @@ var filesGarbageCollected = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "Number of files collected during garbage collection",
 }, []string{})
 
+var filesArchived = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_garbage_collections_files_archived",
+	Help: "Number of files moved to the remote archive during garbage collection",
+}, []string{})
+
 func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 	garbageCollectionsExecuted.WithLabelValues().Inc()
 
@@ -422,15 +440,15 @@ func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 		garbageCollectionErrors.WithLabelValues().Add(float64(len(errs)))
 	}()
 
-	if err := dp.meta.WithContext(ctx).Find(&refs, "created_at < ?", time.Now().Add(-dp.retention)).Error; err != nil {
+	if err := dp.meta.WithContext(ctx).Find(&refs, "created_at < ? AND archived = ?", time.Now().Add(-dp.retention), false).Error; err != nil {
 		return []error{err}
 	}
 
 	oldRefsFound := len(refs)
 	refsDeleted := 0
 	filesDeleted := 0
+	archived := 0
 
-	// In the future if we want to support Archiving, we could do that here instead of deleting
 	for _, r := range refs {
 		dp.lk.Lock()
 		currentLogfile := dp.logfi.Name()
@@ -442,6 +460,16 @@ func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 			continue
 		}
 
+		// if there is an archive tier, move the file there instead of deleting
+		if dp.archive != nil {
+			if err := dp.archiveLogFile(ctx, &r); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			archived++
+			continue
+		}
+
 		// Delete the ref in the database to prevent playback from finding it
 		if err := dp.meta.WithContext(ctx).Delete(&r).Error; err != nil {
 			errs = append(errs, err)
@@ -457,11 +485,50 @@ func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 		filesDeleted++
 	}
 
+	// expire archived files which are past the total retention period
+	if dp.archive != nil && dp.archiveRetention > 0 {
+		var archivedRefs []LogFileRef
+		if err := dp.meta.WithContext(ctx).Find(&archivedRefs, "created_at < ? AND archived = ?", time.Now().Add(-dp.archiveRetention), true).Error; err != nil {
+			errs = append(errs, err)
+		}
+		for _, r := range archivedRefs {
+			if err := dp.meta.WithContext(ctx).Delete(&r).Error; err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			refsDeleted++
+
+			if err := dp.archive.Delete(ctx, r.Path); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			filesDeleted++
+		}
+	}
+
+	// takedown records are only needed while older archived files remain
+	if dp.archive != nil {
+		var oldest LogFileRef
+		if err := dp.meta.WithContext(ctx).Order("seq_start asc").Limit(1).Find(&oldest, "archived = ?", true).Error; err != nil {
+			errs = append(errs, err)
+		} else {
+			q := dp.meta.WithContext(ctx).Where("takedown = ?", true)
+			if oldest.ID != 0 {
+				q = q.Where("seq <= ?", oldest.SeqStart)
+			}
+			if err := q.Delete(&UserAction{}).Error; err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
 	refsGarbageCollected.WithLabelValues().Add(float64(refsDeleted))
 	filesGarbageCollected.WithLabelValues().Add(float64(filesDeleted))
+	filesArchived.WithLabelValues().Add(float64(archived))
 
 	dp.log.Info("garbage collection complete",
 		"filesDeleted", filesDeleted,
+		"filesArchived", archived,
 		"refsDeleted", refsDeleted,
 		"oldRefsFound", oldRefsFound,
 	)
@@ -469,6 +536,45 @@ func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 	return errs
 }
 
+// Uploads a local log file to the archive tier, marks it as archived, and removes the local copy.
+func (dp *DiskPersistence) archiveLogFile(ctx context.Context, r *LogFileRef) error {
+	localPath := filepath.Join(dp.primaryDir, r.Path)
+	fi, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("opening log file for archival: %w", err)
+	}
+	err = dp.archive.Put(ctx, r.Path, fi)
+	fi.Close()
+	if err != nil {
+		return fmt.Errorf("archiving log file %s: %w", r.Path, err)
+	}
+	// once the ref is marked as archived, playback will read from the archive instead of local disk
+	if err := dp.meta.WithContext(ctx).Model(r).Update("archived", true).Error; err != nil {
+		return err
+	}
+	if err := os.Remove(localPath); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Opens a log file for reading, either from local disk or the archive tier. Archived files are streamed, not buffered; the returned bool is true if the file is being read from the archive.
+func (dp *DiskPersistence) openLogFile(ctx context.Context, lf LogFileRef) (io.ReadCloser, bool, error) {
+	if !lf.Archived || dp.archive == nil {
+		fi, err := os.OpenFile(filepath.Join(dp.primaryDir, lf.Path), os.O_RDONLY, 0)
+		// the file may have been archived since the ref was read
+		if err == nil || !errors.Is(err, os.ErrNotExist) || dp.archive == nil {
+			return fi, false, err
+		}
+	}
+
+	rc, err := dp.archive.Get(ctx, lf.Path)
+	if err != nil {
+		return nil, false, err
+	}
+	return rc, true, nil
+}
+
 func (dp *DiskPersistence) doPersist(ctx context.Context, pjob persistJob) error {
 	seq := dp.curSeq
 	dp.curSeq++
@@ -704,7 +810,7 @@ func (dp *DiskPersistence) Playback(ctx context.Context, since int64, cb func(*s
 
 func (dp *DiskPersistence) PlaybackLogfiles(ctx context.Context, since int64, cb func(*stream.XRPCStreamEvent) error, logFiles []LogFileRef) (*int64, error) {
 	for i, lf := range logFiles {
-		lastSeq, err := dp.readEventsFrom(ctx, since, filepath.Join(dp.primaryDir, lf.Path), cb)
+		lastSeq, err := dp.readEventsFrom(ctx, since, lf, cb)
 		if err != nil {
 			return nil, err
 		}
@@ -728,14 +834,26 @@ func postDoNotEmit(flags uint32) bool {
 	return false
 }
 
-func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn string, cb func(*stream.XRPCStreamEvent) error) (*int64, error) {
-	fi, err := os.OpenFile(fn, os.O_RDONLY, 0)
+func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, lf LogFileRef, cb func(*stream.XRPCStreamEvent) error) (*int64, error) {
+	fn := lf.Path
+	fi, archived, err := dp.openLogFile(ctx, lf)
 	if err != nil {
 		return nil, err
 	}
+	defer fi.Close()
 
-	if since != 0 {
-		lastSeq, err := scanForLastSeq(fi, since)
+	// archived files are never modified, so takedowns are applied while reading them
+	var takedowns map[uint64]int64
+	if archived {
+		takedowns, err = dp.archiveTakedowns(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// local files can seek to the cursor; archived files are streamed, so events up to the cursor are skipped while reading
+	if rs, ok := fi.(io.ReadSeeker); ok && since != 0 {
+		lastSeq, err := scanForLastSeq(rs, since)
 		if err != nil {
 			return nil, err
 		}
@@ -766,8 +884,8 @@ func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn s
 
 		lastSeq = h.Seq
 
-		if postDoNotEmit(h.Flags) {
-			// event taken down, skip
+		if h.Seq <= since || postDoNotEmit(h.Flags) || h.Seq < takedowns[h.Usr] {
+			// before the cursor, or event taken down, skip
 			_, err := io.CopyN(io.Discard, bufr, h.Len64()) // would be really nice if the buffered reader had a 'skip' method that does a seek under the hood
 			if err != nil {
 				return nil, fmt.Errorf("failed while skipping event (seq: %d, fn: %q): %w", h.Seq, fn, err)
@@ -825,17 +943,26 @@ type UserAction struct {
 	Usr      int64
 	RebaseAt int64
 	Takedown bool
+
+	// for takedowns: events for the account before this seq are hidden when reading archived log files
+	Seq int64
 }
 
+// Log files on local disk are modified in place to take down an account's events. Archived log files are not modified (that would mean downloading and re-uploading every segment); instead the takedown is recorded, and applied whenever archived files are read.
 func (dp *DiskPersistence) TakeDownRepo(ctx context.Context, uid uint64) error {
-	/*
-		if err := p.meta.Create(&UserAction{
-			Usr:      uid,
+	if dp.archive != nil {
+		// recorded first, so that it also covers files which are archived while local files are being updated
+		dp.lk.Lock()
+		seq := dp.curSeq
+		dp.lk.Unlock()
+		if err := dp.meta.WithContext(ctx).Create(&UserAction{
+			Usr:      int64(uid),
 			Takedown: true,
+			Seq:      seq,
 		}).Error; err != nil {
 			return err
 		}
-	*/
+	}
 
 	return dp.forEachShardWithUserEvents(ctx, uid, func(ctx context.Context, fn string) error {
 		if err := dp.deleteEventsForUser(ctx, uid, fn); err != nil {
@@ -846,6 +973,21 @@ func (dp *DiskPersistence) TakeDownRepo(ctx context.Context, uid uint64) error {
 	})
 }
 
+// Returns the seq before which each taken-down account's events are hidden in archived log files, by account UID.
+func (dp *DiskPersistence) archiveTakedowns(ctx context.Context) (map[uint64]int64, error) {
+	var actions []UserAction
+	if err := dp.meta.WithContext(ctx).Find(&actions, "takedown = ?", true).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[uint64]int64, len(actions))
+	for _, a := range actions {
+		if a.Seq > out[uint64(a.Usr)] {
+			out[uint64(a.Usr)] = a.Seq
+		}
+	}
+	return out, nil
+}
+
 func (dp *DiskPersistence) forEachShardWithUserEvents(ctx context.Context, uid uint64, cb func(context.Context, string) error) error {
 	var refs []LogFileRef
 	if err := dp.meta.Order("created_at desc").Find(&refs).Error; err != nil {
@@ -853,6 +995,11 @@ func (dp *DiskPersistence) forEachShardWithUserEvents(ctx context.Context, uid u
 	}
 
 	for _, r := range refs {
+		// takedowns are applied to files in the archive tier at read time
+		if r.Archived && dp.archive != nil {
+			continue
+		}
+
 		mhas, err := dp.refMaybeHasUserEvents(ctx, uid, r)
 		if err != nil {
 			return err
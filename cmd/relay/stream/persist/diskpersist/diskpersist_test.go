@@ -0,0 +1,164 @@
+package diskpersist
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/persist/archive"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memArchive struct {
+	lk       sync.Mutex
+	segments map[string][]byte
+}
+
+func (m *memArchive) Put(ctx context.Context, name string, data io.ReadSeeker) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.segments[name] = b
+	return nil
+}
+
+func (m *memArchive) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	data, ok := m.segments[name]
+	if !ok {
+		return nil, archive.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memArchive) Delete(ctx context.Context, name string) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	delete(m.segments, name)
+	return nil
+}
+
+// UIDs are the numeric suffix of "did:example:<n>"
+type testUids struct{}
+
+func (testUids) DidToUid(ctx context.Context, did string) (uint64, error) {
+	var uid uint64
+	_, err := fmt.Sscanf(did, "did:example:%d", &uid)
+	return uid, err
+}
+
+func playbackSeqs(t *testing.T, dp *DiskPersistence, since int64) []int64 {
+	var seqs []int64
+	err := dp.Playback(context.Background(), since, func(evt *stream.XRPCStreamEvent) error {
+		seqs = append(seqs, evt.Sequence())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seqs
+}
+
+func TestDiskPersistArchive(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db, err := cliutil.SetupDatabase("sqlite://:memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arc := &memArchive{segments: make(map[string][]byte)}
+	dir := t.TempDir()
+
+	opts := DefaultDiskPersistOptions()
+	opts.EventsPerFile = 10
+	opts.Retention = time.Nanosecond
+	opts.Archive = arc
+	dp, err := NewDiskPersistence(dir, "", db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dp.Shutdown(ctx)
+	dp.SetUidSource(testUids{})
+	dp.SetEventBroadcaster(func(*stream.XRPCStreamEvent) {})
+
+	for i := range 35 {
+		evt := &stream.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+			Did:  fmt.Sprintf("did:example:%d", 1+i%2),
+			Time: syntax.DatetimeNow().String(),
+		}}
+		assert.NoError(dp.Persist(ctx, evt))
+	}
+	assert.NoError(dp.Flush(ctx))
+
+	// all but the current log file get moved to the archive
+	assert.Empty(dp.garbageCollect(ctx))
+	assert.Len(arc.segments, 3)
+	assert.Contains(arc.segments, "evts-11")
+	_, err = os.Stat(filepath.Join(dir, "evts-11"))
+	assert.True(os.IsNotExist(err))
+
+	// playback spans archived and local files
+	seqs := playbackSeqs(t, dp, 0)
+	assert.Len(seqs, 35)
+	assert.Equal(int64(1), seqs[0])
+	assert.Equal(int64(35), seqs[34])
+	seqs = playbackSeqs(t, dp, 15)
+	assert.Len(seqs, 20)
+	assert.Equal(int64(16), seqs[0])
+
+	// takedowns apply to archived segments, without re-writing them
+	before := bytes.Clone(arc.segments["evts-11"])
+	assert.NoError(dp.TakeDownRepo(ctx, 2))
+	assert.Equal(before, arc.segments["evts-11"])
+	seqs = playbackSeqs(t, dp, 0)
+	assert.Len(seqs, 18)
+	for _, seq := range seqs {
+		assert.Equal(int64(1), seq%2)
+	}
+	seqs = playbackSeqs(t, dp, 15)
+	assert.Len(seqs, 10)
+	assert.Equal(int64(17), seqs[0])
+
+	// later events for the account are not hidden once archived
+	for range 10 {
+		evt := &stream.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+			Did:  "did:example:2",
+			Time: syntax.DatetimeNow().String(),
+		}}
+		assert.NoError(dp.Persist(ctx, evt))
+	}
+	assert.NoError(dp.Flush(ctx))
+	assert.Empty(dp.garbageCollect(ctx))
+	assert.Contains(arc.segments, "evts-31")
+	seqs = playbackSeqs(t, dp, 0)
+	assert.Len(seqs, 28)
+	assert.Equal(int64(36), seqs[18])
+
+	// archived segments expire after the total retention period
+	dp.archiveRetention = time.Nanosecond
+	assert.Empty(dp.garbageCollect(ctx))
+	assert.Empty(arc.segments)
+	seqs = playbackSeqs(t, dp, 0)
+	assert.Equal([]int64{41, 42, 43, 44, 45}, seqs)
+
+	// along with the takedown records which applied to them
+	var actions []UserAction
+	assert.NoError(db.Find(&actions).Error)
+	assert.Empty(actions)
+}
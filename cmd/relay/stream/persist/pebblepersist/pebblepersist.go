@@ -0,0 +1,373 @@
+package pebblepersist
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/persist"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Stores the firehose playback window in a pebble database. This is an alternative to diskpersist, mirroring `events/pebblepersist`, but which also assigns sequence numbers and supports takedowns (as the relay requires).
+//
+// Keys are the 8-byte big-endian sequence number. Values are a fixed-size header (timestamp and account UID), followed by the serialized stream frame. Taken-down events are replaced with just the header.
+type PebblePersist struct {
+	db   *pebble.DB
+	opts PebblePersistOptions
+
+	broadcast func(*stream.XRPCStreamEvent)
+	uids      UidSource
+
+	// protects sequence assignment, so that events are written and broadcast in order
+	lk     sync.Mutex
+	curSeq int64
+
+	shutdown chan struct{}
+	log      *slog.Logger
+}
+
+var _ persist.EventPersistence = (*PebblePersist)(nil)
+
+type PebblePersistOptions struct {
+	// path where pebble will create a directory full of files
+	DbPath string
+
+	// events older than this are garbage collected
+	Retention time.Duration
+
+	// how often to run garbage collection. zero disables background GC
+	GCPeriod time.Duration
+
+	// starting sequence number to use (if there is no existing persisted data)
+	InitialSeq int64
+
+	Logger *slog.Logger
+}
+
+func DefaultPebblePersistOptions() *PebblePersistOptions {
+	return &PebblePersistOptions{
+		Retention:  time.Hour * 24 * 3, // 3 days
+		GCPeriod:   time.Minute * 10,
+		InitialSeq: 1,
+	}
+}
+
+type UidSource interface {
+	DidToUid(ctx context.Context, did string) (uint64, error)
+}
+
+// size of value header: millisecond timestamp, and account UID
+const valueHeaderSize = 8 + 8
+
+func NewPebblePersistence(opts *PebblePersistOptions) (*PebblePersist, error) {
+	if opts == nil {
+		opts = DefaultPebblePersistOptions()
+	}
+	if opts.InitialSeq <= 0 {
+		return nil, fmt.Errorf("negative or zero initial seq: %d", opts.InitialSeq)
+	}
+
+	db, err := pebble.Open(opts.DbPath, &pebble.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", opts.DbPath, err)
+	}
+
+	pp := &PebblePersist{
+		db:       db,
+		opts:     *opts,
+		shutdown: make(chan struct{}),
+		log:      opts.Logger,
+	}
+	if pp.log == nil {
+		pp.log = slog.Default().With("system", "pebblepersist")
+	}
+
+	lastSeq, err := pp.lastSeq()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if lastSeq > 0 {
+		pp.curSeq = lastSeq + 1
+	} else {
+		pp.curSeq = opts.InitialSeq
+	}
+	pp.log.Info("loaded seq", "seq", pp.curSeq)
+
+	if opts.GCPeriod > 0 {
+		go pp.garbageCollectRoutine()
+	}
+
+	return pp, nil
+}
+
+func (pp *PebblePersist) SetUidSource(uids UidSource) {
+	pp.uids = uids
+}
+
+func (pp *PebblePersist) SetEventBroadcaster(broadcast func(*stream.XRPCStreamEvent)) {
+	pp.broadcast = broadcast
+}
+
+func seqKey(seq int64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(seq))
+	return key[:]
+}
+
+// returns the last persisted sequence number, or zero if there is none
+func (pp *PebblePersist) lastSeq() (int64, error) {
+	iter, err := pp.db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	if !iter.Last() {
+		return 0, iter.Error()
+	}
+	return int64(binary.BigEndian.Uint64(iter.Key())), nil
+}
+
+func (pp *PebblePersist) uidForEvent(ctx context.Context, evt *stream.XRPCStreamEvent) (uint64, error) {
+	if evt.PrivUid != 0 {
+		return evt.PrivUid, nil
+	}
+	var did string
+	switch {
+	case evt.RepoCommit != nil:
+		did = evt.RepoCommit.Repo
+	case evt.RepoSync != nil:
+		did = evt.RepoSync.Did
+	case evt.RepoIdentity != nil:
+		did = evt.RepoIdentity.Did
+	case evt.RepoAccount != nil:
+		did = evt.RepoAccount.Did
+	}
+	if pp.uids == nil {
+		return 0, fmt.Errorf("PebblePersist has no UID resolver registered")
+	}
+	return pp.uids.DidToUid(ctx, did)
+}
+
+// Persist implements persist.EventPersistence
+// Persist may mutate contents of evt (assigning the sequence number)
+func (pp *PebblePersist) Persist(ctx context.Context, evt *stream.XRPCStreamEvent) error {
+	switch {
+	case evt.RepoCommit != nil, evt.RepoSync != nil, evt.RepoIdentity != nil, evt.RepoAccount != nil:
+	default:
+		// only these event types get persisted
+		return nil
+	}
+
+	uid, err := pp.uidForEvent(ctx, evt)
+	if err != nil {
+		return err
+	}
+
+	pp.lk.Lock()
+	defer pp.lk.Unlock()
+
+	seq := pp.curSeq
+	switch {
+	case evt.RepoCommit != nil:
+		evt.RepoCommit.Seq = seq
+	case evt.RepoSync != nil:
+		evt.RepoSync.Seq = seq
+	case evt.RepoIdentity != nil:
+		evt.RepoIdentity.Seq = seq
+	case evt.RepoAccount != nil:
+		evt.RepoAccount.Seq = seq
+	}
+
+	// any existing serialization would have the wrong seq
+	evt.Preserialized = nil
+	if err := evt.Preserialize(); err != nil {
+		return err
+	}
+
+	val := make([]byte, valueHeaderSize+len(evt.Preserialized))
+	binary.BigEndian.PutUint64(val[0:8], uint64(time.Now().UnixMilli()))
+	binary.BigEndian.PutUint64(val[8:16], uid)
+	copy(val[valueHeaderSize:], evt.Preserialized)
+
+	// NOTE: not synced to disk on every write; recent events may be lost on a machine crash (but not a process crash)
+	if err := pp.db.Set(seqKey(seq), val, pebble.NoSync); err != nil {
+		return err
+	}
+	pp.curSeq++
+
+	pp.broadcast(evt)
+	return nil
+}
+
+func eventFromValue(seq int64, val []byte) (*stream.XRPCStreamEvent, error) {
+	if len(val) < valueHeaderSize {
+		return nil, fmt.Errorf("invalid persisted event (seq %d)", seq)
+	}
+	blob := val[valueHeaderSize:]
+	evt := new(stream.XRPCStreamEvent)
+	if err := evt.Deserialize(bytes.NewReader(blob)); err != nil {
+		return nil, fmt.Errorf("decoding persisted event (seq %d): %w", seq, err)
+	}
+	evt.Preserialized = bytes.Clone(blob)
+	return evt, nil
+}
+
+// Playback implements persist.EventPersistence. Events with sequence number strictly greater than `since` are passed to the callback, in order.
+func (pp *PebblePersist) Playback(ctx context.Context, since int64, cb func(*stream.XRPCStreamEvent) error) error {
+	// iterators are point-in-time snapshots, so repeat a few times to pick up events written during playback. but not unboundedly.
+	for range 10 {
+		lastSeq, err := pp.playbackFrom(ctx, since, cb)
+		if err != nil {
+			return err
+		}
+		if lastSeq == since {
+			break
+		}
+		since = lastSeq
+	}
+	return nil
+}
+
+func (pp *PebblePersist) playbackFrom(ctx context.Context, since int64, cb func(*stream.XRPCStreamEvent) error) (int64, error) {
+	iter, err := pp.db.NewIterWithContext(ctx, &pebble.IterOptions{LowerBound: seqKey(since + 1)})
+	if err != nil {
+		return since, err
+	}
+	defer iter.Close()
+
+	lastSeq := since
+	for iter.First(); iter.Valid(); iter.Next() {
+		seq := int64(binary.BigEndian.Uint64(iter.Key()))
+		val, err := iter.ValueAndErr()
+		if err != nil {
+			return lastSeq, err
+		}
+		lastSeq = seq
+		if len(val) == valueHeaderSize {
+			// event taken down, skip
+			continue
+		}
+		evt, err := eventFromValue(seq, val)
+		if err != nil {
+			return lastSeq, err
+		}
+		if err := cb(evt); err != nil {
+			return lastSeq, err
+		}
+	}
+	return lastSeq, iter.Error()
+}
+
+// TakeDownRepo implements persist.EventPersistence. Event data for the account is deleted, leaving just the header (so that sequence numbers are not re-used).
+func (pp *PebblePersist) TakeDownRepo(ctx context.Context, uid uint64) error {
+	iter, err := pp.db.NewIterWithContext(ctx, &pebble.IterOptions{})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	// TODO: this scans the full playback window; could keep a per-account index if takedowns become frequent
+	batch := pp.db.NewBatch()
+	defer batch.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		val, err := iter.ValueAndErr()
+		if err != nil {
+			return err
+		}
+		if len(val) > valueHeaderSize && binary.BigEndian.Uint64(val[8:16]) == uid {
+			if err := batch.Set(bytes.Clone(iter.Key()), bytes.Clone(val[:valueHeaderSize]), nil); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func (pp *PebblePersist) Flush(context.Context) error {
+	return pp.db.Flush()
+}
+
+func (pp *PebblePersist) Shutdown(context.Context) error {
+	close(pp.shutdown)
+	pp.lk.Lock()
+	defer pp.lk.Unlock()
+	return pp.db.Close()
+}
+
+func (pp *PebblePersist) garbageCollectRoutine() {
+	t := time.NewTicker(pp.opts.GCPeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-pp.shutdown:
+			return
+		case <-t.C:
+			if err := pp.GarbageCollect(context.Background()); err != nil {
+				pp.log.Error("garbage collection error", "err", err)
+			}
+		}
+	}
+}
+
+// Deletes events older than the retention period.
+func (pp *PebblePersist) GarbageCollect(ctx context.Context) error {
+	expired := time.Now().Add(-pp.opts.Retention).UnixMilli()
+
+	iter, err := pp.db.NewIterWithContext(ctx, &pebble.IterOptions{})
+	if err != nil {
+		return err
+	}
+
+	// events are in timestamp order, so scan until the first un-expired event
+	var cutoff []byte
+	count := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		val, err := iter.ValueAndErr()
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		if len(val) >= valueHeaderSize && int64(binary.BigEndian.Uint64(val[0:8])) > expired {
+			cutoff = bytes.Clone(iter.Key())
+			break
+		}
+		count++
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return nil
+	}
+	if cutoff == nil {
+		// everything is expired, but keep the most recent event so the sequence number is preserved across restarts
+		last, err := pp.lastSeq()
+		if err != nil {
+			return err
+		}
+		cutoff = seqKey(last)
+		count--
+	}
+
+	if err := pp.db.DeleteRange(seqKey(0), cutoff, pebble.Sync); err != nil {
+		return err
+	}
+	if err := pp.db.Compact(seqKey(0), cutoff, true); err != nil {
+		pp.log.Warn("compaction after garbage collection failed", "err", err)
+	}
+	pp.log.Info("garbage collection complete", "eventsDeleted", count)
+	return nil
+}
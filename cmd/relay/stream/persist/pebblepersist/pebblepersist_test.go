@@ -0,0 +1,105 @@
+package pebblepersist
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func playbackSeqs(t *testing.T, pp *PebblePersist, since int64) []int64 {
+	var seqs []int64
+	err := pp.Playback(context.Background(), since, func(evt *stream.XRPCStreamEvent) error {
+		seqs = append(seqs, evt.Sequence())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seqs
+}
+
+func openTestPersister(t *testing.T, path string, initialSeq int64) *PebblePersist {
+	opts := DefaultPebblePersistOptions()
+	opts.DbPath = path
+	opts.GCPeriod = 0
+	opts.InitialSeq = initialSeq
+	pp, err := NewPebblePersistence(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp.SetEventBroadcaster(func(*stream.XRPCStreamEvent) {})
+	return pp
+}
+
+func TestPebblePersist(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "pebble")
+
+	pp := openTestPersister(t, path, 100)
+
+	var broadcast []int64
+	pp.SetEventBroadcaster(func(evt *stream.XRPCStreamEvent) {
+		broadcast = append(broadcast, evt.Sequence())
+	})
+
+	for i := range 20 {
+		evt := &stream.XRPCStreamEvent{
+			RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+				Did:  fmt.Sprintf("did:example:%d", 1+i%2),
+				Time: syntax.DatetimeNow().String(),
+			},
+			PrivUid: uint64(1 + i%2),
+		}
+		assert.NoError(pp.Persist(ctx, evt))
+	}
+	// events without UID are resolved, or fail
+	err := pp.Persist(ctx, &stream.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:example:3"}})
+	assert.Error(err)
+
+	assert.Len(broadcast, 20)
+	assert.Equal(int64(100), broadcast[0])
+	assert.Equal(int64(119), broadcast[19])
+
+	seqs := playbackSeqs(t, pp, 0)
+	assert.Len(seqs, 20)
+	seqs = playbackSeqs(t, pp, 109)
+	assert.Len(seqs, 10)
+	assert.Equal(int64(110), seqs[0])
+
+	// taken down events are skipped in playback
+	assert.NoError(pp.TakeDownRepo(ctx, 2))
+	seqs = playbackSeqs(t, pp, 0)
+	assert.Len(seqs, 10)
+	for _, seq := range seqs {
+		assert.Equal(int64(0), seq%2)
+	}
+
+	// nothing is expired yet
+	assert.NoError(pp.GarbageCollect(ctx))
+	assert.Len(playbackSeqs(t, pp, 0), 10)
+
+	// sequence continues after re-opening, even if everything was expired (and the last event was taken down)
+	pp.opts.Retention = -time.Minute
+	assert.NoError(pp.GarbageCollect(ctx))
+	assert.Empty(playbackSeqs(t, pp, 0))
+	assert.NoError(pp.Shutdown(ctx))
+
+	pp = openTestPersister(t, path, 1)
+	defer pp.Shutdown(ctx)
+	evt := &stream.XRPCStreamEvent{
+		RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:example:1", Time: syntax.DatetimeNow().String()},
+		PrivUid:      1,
+	}
+	assert.NoError(pp.Persist(ctx, evt))
+	assert.Equal(int64(120), evt.Sequence())
+	assert.Equal([]int64{120}, playbackSeqs(t, pp, 0))
+}
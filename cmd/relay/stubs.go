@@ -4,31 +4,81 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/cmd/relay/relay"
+	"github.com/bluesky-social/indigo/cmd/relay/stream/eventmgr"
 	"github.com/bluesky-social/indigo/xrpc"
 
+	"github.com/ipfs/go-cid"
 	"github.com/labstack/echo/v4"
 	"go.opentelemetry.io/otel"
 )
 
-func (s *Service) HandleComAtprotoSyncSubscribeRepos(c echo.Context) error {
-
+// parses query parameters shared by the CBOR and JSON firehose endpoints
+func parseSubscribeParams(c echo.Context) (*int64, *relay.ConsumerFilter, eventmgr.SlowConsumerPolicy, error) {
 	cursorQuery := c.QueryParam("cursor")
 
 	var cursor *int64
 	if cursorQuery != "" {
 		cval, err := strconv.ParseInt(cursorQuery, 10, 64)
 		if err != nil || cval < 0 {
-			return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: fmt.Sprintf("cursor parameter invalid: %s", cursorQuery)})
+			return nil, nil, "", fmt.Errorf("cursor parameter invalid: %s", cursorQuery)
 		}
 		cursor = &cval
 	}
 
+	// optional server-side filtering (non-standard query parameters)
+	params := c.QueryParams()
+	filter, err := relay.ParseConsumerFilter(params["wantedCollections"], params["wantedDids"])
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var policy eventmgr.SlowConsumerPolicy
+	if p := c.QueryParam("slowConsumerPolicy"); p != "" {
+		policy, err = eventmgr.ParseSlowConsumerPolicy(p)
+		if err != nil {
+			return nil, nil, "", err
+		}
+	}
+	return cursor, filter, policy, nil
+}
+
+func (s *Service) HandleComAtprotoSyncSubscribeRepos(c echo.Context) error {
+
+	cursor, filter, policy, err := parseSubscribeParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: err.Error()})
+	}
+
+	// pass off HTTP connection to the WebSocket handler
+	return s.relay.HandleSubscribeRepos(c.Response(), c.Request(), cursor, c.RealIP(), filter, policy)
+}
+
+// "jetstream-style" JSON variant of subscribeRepos. Not a Lexicon endpoint.
+func (s *Service) HandleSubscribeReposJSON(c echo.Context) error {
+
+	cursor, filter, policy, err := parseSubscribeParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: err.Error()})
+	}
+
+	compress := false
+	if c.QueryParam("compress") != "" {
+		compress, err = strconv.ParseBool(c.QueryParam("compress"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: fmt.Sprintf("compress parameter invalid: %s", c.QueryParam("compress"))})
+		}
+	}
+	if strings.ToLower(c.Request().Header.Get("Socket-Encoding")) == "zstd" {
+		compress = true
+	}
+
 	// pass off HTTP connection to the WebSocket handler
-	return s.relay.HandleSubscribeRepos(c.Response(), c.Request(), cursor, c.RealIP())
+	return s.relay.HandleSubscribeReposJSON(c.Response(), c.Request(), cursor, c.RealIP(), filter, policy, compress)
 }
 
 func (s *Service) HandleComAtprotoSyncRequestCrawl(c echo.Context) error {
@@ -122,11 +172,11 @@ func (s *Service) HandleComAtprotoSyncListRepos(c echo.Context) error {
 	return c.JSON(200, out)
 }
 
-// does a simple HTTP redirect to getRepo on the account's PDS.
+// if the relay has a local repo store enabled, serves the repo directly. otherwise, or if the repo is not available locally, does a simple HTTP redirect to getRepo on the account's PDS.
 //
-// NOTE: currently does not check account status locally; a takendown account will still redirect. this saves a database lookup.
+// NOTE: when redirecting, does not check account status locally; a takendown account will still redirect. this saves a database lookup.
 func (s *Service) HandleComAtprotoSyncGetRepo(c echo.Context) error {
-	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoSyncGetRepo")
+	_, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoSyncGetRepo")
 	defer span.End()
 
 	didQuery := c.QueryParam("did")
@@ -136,6 +186,67 @@ func (s *Service) HandleComAtprotoSyncGetRepo(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: fmt.Sprintf("missing or invalid DID parameter: %s", err)})
 	}
 
+	// local snapshots are only of the full repo; partial ("since") exports are redirected
+	if s.relay.RepoStore != nil && c.QueryParam("since") == "" {
+		return s.handleComAtprotoSyncGetRepo(c, did)
+	}
+	return s.redirectToPDS(c, did)
+}
+
+func (s *Service) HandleComAtprotoSyncGetRecord(c echo.Context) error {
+	_, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoSyncGetRecord")
+	defer span.End()
+
+	did, err := syntax.ParseDID(c.QueryParam("did"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: fmt.Sprintf("missing or invalid DID parameter: %s", err)})
+	}
+	collection, err := syntax.ParseNSID(c.QueryParam("collection"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: fmt.Sprintf("missing or invalid collection parameter: %s", err)})
+	}
+	rkey, err := syntax.ParseRecordKey(c.QueryParam("rkey"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: fmt.Sprintf("missing or invalid rkey parameter: %s", err)})
+	}
+
+	if s.relay.RepoStore != nil {
+		return s.handleComAtprotoSyncGetRecord(c, did, collection, rkey)
+	}
+	return s.redirectToPDS(c, did)
+}
+
+func (s *Service) HandleComAtprotoSyncGetBlocks(c echo.Context) error {
+	_, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoSyncGetBlocks")
+	defer span.End()
+
+	did, err := syntax.ParseDID(c.QueryParam("did"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: fmt.Sprintf("missing or invalid DID parameter: %s", err)})
+	}
+
+	cidQuery := c.QueryParams()["cids"]
+	if len(cidQuery) == 0 || len(cidQuery) > 1000 {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: "cids parameter missing or too many values"})
+	}
+	cids := make([]cid.Cid, len(cidQuery))
+	for i, raw := range cidQuery {
+		cids[i], err = cid.Decode(raw)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: fmt.Sprintf("invalid CID parameter: %s", raw)})
+		}
+	}
+
+	if s.relay.RepoStore != nil {
+		return s.handleComAtprotoSyncGetBlocks(c, did, cids)
+	}
+	return s.redirectToPDS(c, did)
+}
+
+// does a simple HTTP redirect to the same endpoint (path and query) on the account's PDS.
+func (s *Service) redirectToPDS(c echo.Context, did syntax.DID) error {
+	ctx := c.Request().Context()
+
 	ident, err := s.relay.Dir.LookupDID(ctx, did)
 	if err != nil {
 		// TODO: could handle lookup errors more granularly
@@ -24,7 +24,7 @@ type SimpleRelay struct {
 }
 
 func (sr *SimpleRelay) handleSubscribeRepos(w http.ResponseWriter, r *http.Request) {
-	err := sr.Relay.HandleSubscribeRepos(w, r, nil, "0.0.0.0")
+	err := sr.Relay.HandleSubscribeRepos(w, r, nil, "0.0.0.0", nil, "")
 	if err != nil {
 		slog.Error("subscribeRepos", "err", err)
 	}